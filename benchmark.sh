@@ -1,2 +1,9 @@
+# start the server first, e.g. `go run main.go`, `go run main.go -io-model epoll` or `go run main.go -exec-model shards`
 i="GET,SET,INCR,DECR,LPUSH,RPUSH,LPOP,RPOP,SADD,HSET,SPOP,MSET"
-redis-benchmark -c 50 -n 200000 -t "$i" -q -p 6379
\ No newline at end of file
+# one command per round trip
+redis-benchmark -c 50 -n 200000 -t "$i" -q -p 6379
+# pipelined, 16 commands per round trip; replies are flushed once per batch
+redis-benchmark -c 50 -n 2000000 -P 16 -t "$i" -q -p 6379
+
+# in-process comparison of the io and exec models: throughput and memory per idle connection
+go test ./server -run XXX -bench . -benchtime 200000x
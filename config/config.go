@@ -22,6 +22,43 @@ var (
 	defaultLogLevel string = "info"
 	defaultSegNum   int    = 100
 	defaultDbNum    int    = 16
+
+	defaultProtoMaxBulkLen        int64 = 512 * 1024 * 1024
+	defaultMaxMultiBulkLen        int64 = 1024 * 1024
+	defaultClientQueryBufferLimit int64 = 1024 * 1024 * 1024
+
+	defaultIoModel    string = "goroutine"
+	defaultEventLoops int    = 0 // one per CPU
+
+	defaultExecModel string = "locks"
+	defaultShards    int    = 0 // one per CPU
+
+	defaultListMaxListpackSize int = -2 // 8kb per quicklist node
+	defaultListCompressDepth   int = 0  // no compression
+
+	defaultHashMaxListpackEntries int = 128
+	defaultHashMaxListpackValue   int = 64
+	defaultSetMaxIntsetEntries    int = 512
+	defaultSetMaxListpackEntries  int = 128
+	defaultSetMaxListpackValue    int = 64
+	defaultHllSparseMaxBytes      int = 3000
+
+	defaultLazyfreeLazyUserDel   bool = false
+	defaultLazyfreeLazyUserFlush bool = false
+	defaultLazyfreeLazyExpire    bool = false
+	defaultLazyfreeLazyServerDel bool = false
+)
+
+// io models
+const (
+	IoModelGoroutine = "goroutine" // a goroutine per connection
+	IoModelEpoll     = "epoll"     // a few event loops serve all connections, linux only
+)
+
+// execution models
+const (
+	ExecModelLocks  = "locks"  // commands run on the connection's goroutine, under per-key locks
+	ExecModelShards = "shards" // keys are split into shards, each running its commands on one goroutine without locks
 )
 
 type Config struct {
@@ -32,6 +69,37 @@ type Config struct {
 	LogLevel   string
 	SegNum     int // segment number
 	DbNum      int
+
+	ProtoMaxBulkLen        int64 // max size of a single bulk string
+	MaxMultiBulkLen        int64 // max number of elements in a request array
+	ClientQueryBufferLimit int64 // max bytes buffered for a single request
+
+	IoModel    string // goroutine or epoll
+	EventLoops int    // number of event loops in epoll mode; <= 0 means one per CPU
+
+	ExecModel string // locks or shards
+	Shards    int    // number of shards in shards mode; <= 0 means one per CPU
+
+	ListMaxListpackSize int // entries per quicklist node if positive, -1..-5 limit nodes to 4kb..64kb
+	ListCompressDepth   int // quicklist nodes kept uncompressed at each end of a list, 0 disables compression
+
+	// small hashes and sets are packed into a listpack, and integer sets into an intset,
+	// until they hold more entries or longer values than these
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	SetMaxIntsetEntries    int
+	SetMaxListpackEntries  int
+	SetMaxListpackValue    int
+
+	// a HyperLogLog turns dense once its sparse representation is longer than this
+	HllSparseMaxBytes int
+
+	// free big values on a background goroutine when they are deleted by DEL, by FLUSHDB/FLUSHALL without SYNC or ASYNC,
+	// by expiry, or implicitly by the server, e.g. when SET or RENAME overwrites a key
+	LazyfreeLazyUserDel   bool
+	LazyfreeLazyUserFlush bool
+	LazyfreeLazyExpire    bool
+	LazyfreeLazyServerDel bool
 }
 
 type CfgError struct {
@@ -48,8 +116,27 @@ func initFlag(conf *Config) {
 	flag.IntVar(&(conf.Port), "port", defaultPort, "Set a server prot to listen")
 	flag.StringVar(&(conf.LogDir), "logdir", defaultLogDir, "Set a log directory")
 	flag.StringVar(&(conf.LogLevel), "loglevel", defaultLogLevel, "Set a log level")
-	flag.IntVar(&(conf.SegNum), "segnum", defaultSegNum, "Set segment number for a cache database")
+	flag.IntVar(&(conf.SegNum), "segnum", defaultSegNum, "Set initial segment number for a cache database, the table resizes with the number of keys")
 	flag.IntVar(&(conf.DbNum), "dbnum", defaultDbNum, "Set database number for cache storage")
+	flag.Int64Var(&(conf.ProtoMaxBulkLen), "proto-max-bulk-len", defaultProtoMaxBulkLen, "Set max size of a bulk string in bytes")
+	flag.Int64Var(&(conf.MaxMultiBulkLen), "max-multibulk-len", defaultMaxMultiBulkLen, "Set max number of elements in a request")
+	flag.Int64Var(&(conf.ClientQueryBufferLimit), "client-query-buffer-limit", defaultClientQueryBufferLimit, "Set max bytes buffered for a client request")
+	flag.StringVar(&(conf.IoModel), "io-model", defaultIoModel, "Set how connections are served: goroutine or epoll (linux only)")
+	flag.IntVar(&(conf.EventLoops), "event-loops", defaultEventLoops, "Set number of event loops for epoll io-model, 0 means one per CPU")
+	flag.StringVar(&(conf.ExecModel), "exec-model", defaultExecModel, "Set how commands are executed: locks or shards")
+	flag.IntVar(&(conf.Shards), "shards", defaultShards, "Set number of shards for shards exec-model, 0 means one per CPU")
+	flag.IntVar(&(conf.ListMaxListpackSize), "list-max-listpack-size", defaultListMaxListpackSize, "Set max entries of a list node if positive, or -1..-5 for 4kb..64kb")
+	flag.IntVar(&(conf.ListCompressDepth), "list-compress-depth", defaultListCompressDepth, "Set number of list nodes kept uncompressed at each end, 0 disables compression")
+	flag.IntVar(&(conf.HashMaxListpackEntries), "hash-max-listpack-entries", defaultHashMaxListpackEntries, "Set max fields of a hash in listpack encoding")
+	flag.IntVar(&(conf.HashMaxListpackValue), "hash-max-listpack-value", defaultHashMaxListpackValue, "Set max field or value length of a hash in listpack encoding")
+	flag.IntVar(&(conf.SetMaxIntsetEntries), "set-max-intset-entries", defaultSetMaxIntsetEntries, "Set max members of an integer set in intset encoding")
+	flag.IntVar(&(conf.SetMaxListpackEntries), "set-max-listpack-entries", defaultSetMaxListpackEntries, "Set max members of a set in listpack encoding")
+	flag.IntVar(&(conf.SetMaxListpackValue), "set-max-listpack-value", defaultSetMaxListpackValue, "Set max member length of a set in listpack encoding")
+	flag.IntVar(&(conf.HllSparseMaxBytes), "hll-sparse-max-bytes", defaultHllSparseMaxBytes, "Set max bytes of a HyperLogLog in sparse encoding")
+	flag.BoolVar(&(conf.LazyfreeLazyUserDel), "lazyfree-lazy-user-del", defaultLazyfreeLazyUserDel, "Free big values deleted by DEL in the background, like UNLINK")
+	flag.BoolVar(&(conf.LazyfreeLazyUserFlush), "lazyfree-lazy-user-flush", defaultLazyfreeLazyUserFlush, "Flush in the background on FLUSHDB and FLUSHALL without SYNC or ASYNC")
+	flag.BoolVar(&(conf.LazyfreeLazyExpire), "lazyfree-lazy-expire", defaultLazyfreeLazyExpire, "Free big values of expired keys in the background")
+	flag.BoolVar(&(conf.LazyfreeLazyServerDel), "lazyfree-lazy-server-del", defaultLazyfreeLazyServerDel, "Free big values overwritten by commands like SET or RENAME in the background")
 }
 
 func Init() (*Config, error) {
@@ -60,6 +147,31 @@ func Init() (*Config, error) {
 		LogLevel: defaultLogLevel,
 		SegNum:   defaultSegNum,
 		DbNum:    defaultDbNum,
+
+		ProtoMaxBulkLen:        defaultProtoMaxBulkLen,
+		MaxMultiBulkLen:        defaultMaxMultiBulkLen,
+		ClientQueryBufferLimit: defaultClientQueryBufferLimit,
+
+		IoModel:    defaultIoModel,
+		EventLoops: defaultEventLoops,
+
+		ExecModel: defaultExecModel,
+		Shards:    defaultShards,
+
+		ListMaxListpackSize: defaultListMaxListpackSize,
+		ListCompressDepth:   defaultListCompressDepth,
+
+		HashMaxListpackEntries: defaultHashMaxListpackEntries,
+		HashMaxListpackValue:   defaultHashMaxListpackValue,
+		SetMaxIntsetEntries:    defaultSetMaxIntsetEntries,
+		SetMaxListpackEntries:  defaultSetMaxListpackEntries,
+		SetMaxListpackValue:    defaultSetMaxListpackValue,
+		HllSparseMaxBytes:      defaultHllSparseMaxBytes,
+
+		LazyfreeLazyUserDel:   defaultLazyfreeLazyUserDel,
+		LazyfreeLazyUserFlush: defaultLazyfreeLazyUserFlush,
+		LazyfreeLazyExpire:    defaultLazyfreeLazyExpire,
+		LazyfreeLazyServerDel: defaultLazyfreeLazyServerDel,
 	}
 
 	initFlag(_conf)
@@ -75,6 +187,15 @@ func Init() (*Config, error) {
 			return nil, err
 		}
 	}
+	if err := checkIoModel(_conf.IoModel); err != nil {
+		return nil, err
+	}
+	if err := checkExecModel(_conf.ExecModel); err != nil {
+		return nil, err
+	}
+	if err := checkListEncoding(_conf.ListMaxListpackSize, _conf.ListCompressDepth); err != nil {
+		return nil, err
+	}
 
 	Conf = _conf
 	return Conf, nil
@@ -93,12 +214,15 @@ func (conf *Config) ParseConfFile() error {
 	for {
 		line, ioErr := fileReader.ReadString('\n')
 		if ioErr != nil && ioErr != io.EOF {
-			return err
+			return ioErr
 		}
 
 		argvs := strings.Fields(line)
 
 		if len(argvs) == 0 {
+			if ioErr == io.EOF {
+				break
+			}
 			continue
 		}
 
@@ -127,6 +251,101 @@ func (conf *Config) ParseConfFile() error {
 			if err != nil {
 				return err
 			}
+		case "proto-max-bulk-len":
+			conf.ProtoMaxBulkLen, err = parseMemory(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "max-multibulk-len":
+			conf.MaxMultiBulkLen, err = strconv.ParseInt(argvs[1], 10, 64)
+			if err != nil {
+				return err
+			}
+		case "client-query-buffer-limit":
+			conf.ClientQueryBufferLimit, err = parseMemory(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "io-model":
+			conf.IoModel = strings.ToLower(argvs[1])
+			if err = checkIoModel(conf.IoModel); err != nil {
+				return err
+			}
+		case "event-loops":
+			conf.EventLoops, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "exec-model":
+			conf.ExecModel = strings.ToLower(argvs[1])
+			if err = checkExecModel(conf.ExecModel); err != nil {
+				return err
+			}
+		case "shards":
+			conf.Shards, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "list-max-listpack-size":
+			conf.ListMaxListpackSize, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "list-compress-depth":
+			conf.ListCompressDepth, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "hll-sparse-max-bytes":
+			conf.HllSparseMaxBytes, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "hash-max-listpack-entries":
+			conf.HashMaxListpackEntries, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "hash-max-listpack-value":
+			conf.HashMaxListpackValue, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "set-max-intset-entries":
+			conf.SetMaxIntsetEntries, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "set-max-listpack-entries":
+			conf.SetMaxListpackEntries, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "set-max-listpack-value":
+			conf.SetMaxListpackValue, err = strconv.Atoi(argvs[1])
+			if err != nil {
+				return err
+			}
+		case "lazyfree-lazy-user-del":
+			conf.LazyfreeLazyUserDel, err = parseYesNo(argvs[0], argvs[1])
+			if err != nil {
+				return err
+			}
+		case "lazyfree-lazy-user-flush":
+			conf.LazyfreeLazyUserFlush, err = parseYesNo(argvs[0], argvs[1])
+			if err != nil {
+				return err
+			}
+		case "lazyfree-lazy-expire":
+			conf.LazyfreeLazyExpire, err = parseYesNo(argvs[0], argvs[1])
+			if err != nil {
+				return err
+			}
+		case "lazyfree-lazy-server-del":
+			conf.LazyfreeLazyServerDel, err = parseYesNo(argvs[0], argvs[1])
+			if err != nil {
+				return err
+			}
 		}
 
 		if ioErr == io.EOF {
@@ -135,3 +354,66 @@ func (conf *Config) ParseConfFile() error {
 	}
 	return nil
 }
+
+func checkIoModel(model string) error {
+	if model != IoModelGoroutine && model != IoModelEpoll {
+		return &CfgError{message: fmt.Sprintf("io-model %s is invalid, expect %s or %s", model, IoModelGoroutine, IoModelEpoll)}
+	}
+	return nil
+}
+
+func checkExecModel(model string) error {
+	if model != ExecModelLocks && model != ExecModelShards {
+		return &CfgError{message: fmt.Sprintf("exec-model %s is invalid, expect %s or %s", model, ExecModelLocks, ExecModelShards)}
+	}
+	return nil
+}
+
+func checkListEncoding(size, depth int) error {
+	if size == 0 || size < -5 {
+		return &CfgError{message: fmt.Sprintf("list-max-listpack-size %d is invalid, expect a positive number or -1..-5", size)}
+	}
+	if depth < 0 {
+		return &CfgError{message: fmt.Sprintf("list-compress-depth %d is invalid, expect 0 or more", depth)}
+	}
+	return nil
+}
+
+// parseYesNo converts a redis.conf boolean, yes or no, of the option name.
+func parseYesNo(name, s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, &CfgError{message: fmt.Sprintf("%s %s is invalid, expect yes or no", name, s)}
+}
+
+// parseMemory converts a memory size like "1gb" or "512mb" into bytes.
+// As in redis.conf, "k" means 1000 and "kb" means 1024, and so on.
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+
+	s = strings.ToLower(s)
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			mul = unit.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, &CfgError{message: fmt.Sprintf("memory size %s is invalid", s)}
+	}
+	return n * mul, nil
+}
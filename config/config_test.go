@@ -18,13 +18,65 @@ func TestConfig_ParseConfFile(t *testing.T) {
 	if cfg.Port != 6399 {
 		t.Error(fmt.Sprintf("cfg.Port == %d, expect 6399", cfg.Port))
 	}
-	if cfg.LogDir != "/log" {
-		t.Error(fmt.Sprintf("cfg.LogDir == %s, expect /log", cfg.LogDir))
+	if cfg.LogDir != "/tmp" {
+		t.Error(fmt.Sprintf("cfg.LogDir == %s, expect /tmp", cfg.LogDir))
 	}
 	if cfg.LogLevel != "info" {
 		t.Error(fmt.Sprintf("cfg.LogLevel == %s, expect info", cfg.LogLevel))
 	}
-	if cfg.SegNum != 16 {
-		t.Error(fmt.Sprintf("cfg.SegNum == %d, expect 16", cfg.SegNum))
+	if cfg.SegNum != 1024 {
+		t.Error(fmt.Sprintf("cfg.SegNum == %d, expect 1024", cfg.SegNum))
+	}
+	if cfg.ProtoMaxBulkLen != 1024*1024 {
+		t.Error(fmt.Sprintf("cfg.ProtoMaxBulkLen == %d, expect %d", cfg.ProtoMaxBulkLen, 1024*1024))
+	}
+	if cfg.MaxMultiBulkLen != 1024 {
+		t.Error(fmt.Sprintf("cfg.MaxMultiBulkLen == %d, expect 1024", cfg.MaxMultiBulkLen))
+	}
+	if cfg.ClientQueryBufferLimit != 64*1024*1024 {
+		t.Error(fmt.Sprintf("cfg.ClientQueryBufferLimit == %d, expect %d", cfg.ClientQueryBufferLimit, 64*1024*1024))
+	}
+	if cfg.IoModel != IoModelEpoll {
+		t.Error(fmt.Sprintf("cfg.IoModel == %s, expect epoll", cfg.IoModel))
+	}
+	if cfg.EventLoops != 4 {
+		t.Error(fmt.Sprintf("cfg.EventLoops == %d, expect 4", cfg.EventLoops))
+	}
+	if cfg.ExecModel != ExecModelShards {
+		t.Error(fmt.Sprintf("cfg.ExecModel == %s, expect shards", cfg.ExecModel))
+	}
+	if cfg.Shards != 8 {
+		t.Error(fmt.Sprintf("cfg.Shards == %d, expect 8", cfg.Shards))
+	}
+	if cfg.ListMaxListpackSize != 128 {
+		t.Error(fmt.Sprintf("cfg.ListMaxListpackSize == %d, expect 128", cfg.ListMaxListpackSize))
+	}
+	if cfg.ListCompressDepth != 1 {
+		t.Error(fmt.Sprintf("cfg.ListCompressDepth == %d, expect 1", cfg.ListCompressDepth))
+	}
+	if cfg.HashMaxListpackEntries != 64 {
+		t.Error(fmt.Sprintf("cfg.HashMaxListpackEntries == %d, expect 64", cfg.HashMaxListpackEntries))
+	}
+	if cfg.SetMaxIntsetEntries != 256 {
+		t.Error(fmt.Sprintf("cfg.SetMaxIntsetEntries == %d, expect 256", cfg.SetMaxIntsetEntries))
+	}
+	if cfg.HllSparseMaxBytes != 1000 {
+		t.Error(fmt.Sprintf("cfg.HllSparseMaxBytes == %d, expect 1000", cfg.HllSparseMaxBytes))
+	}
+	if !cfg.LazyfreeLazyUserDel || cfg.LazyfreeLazyExpire {
+		t.Error(fmt.Sprintf("cfg.LazyfreeLazyUserDel == %t, cfg.LazyfreeLazyExpire == %t, expect true, false", cfg.LazyfreeLazyUserDel, cfg.LazyfreeLazyExpire))
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{"100": 100, "1k": 1000, "1kb": 1024, "2mb": 2 * 1024 * 1024, "1gb": 1024 * 1024 * 1024, "1G": 1000 * 1000 * 1000}
+	for s, expect := range cases {
+		n, err := parseMemory(s)
+		if err != nil || n != expect {
+			t.Error(fmt.Sprintf("parseMemory(%s) == %d, expect %d", s, n, expect))
+		}
+	}
+	if _, err := parseMemory("-1mb"); err == nil {
+		t.Error("parseMemory(-1mb) should fail")
 	}
 }
@@ -15,6 +15,12 @@ func init() {
 	memdb.RegisterHashCommands()
 	memdb.RegisterListCommands()
 	memdb.RegisterSetCommands()
+	memdb.RegisterZSetCommands()
+	memdb.RegisterHyperLogLogCommands()
+	memdb.RegisterGeoCommands()
+	memdb.RegisterStreamCommands()
+	memdb.RegisterMemoryCommands()
+	memdb.RegisterServerCommands()
 }
 
 func main() {
@@ -0,0 +1,673 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"gRedis/config"
+	"gRedis/resp"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Bitmaps are plain strings, bit 0 being the most significant bit of the first byte, as in redis.
+// SETBIT writes the string in place, so commands returning a string reply with a copy.
+
+// maxStringSize is the longest string SETBIT may grow, as proto-max-bulk-len.
+func maxStringSize() int64 {
+	if config.Conf == nil || config.Conf.ProtoMaxBulkLen <= 0 {
+		return 512 * 1024 * 1024
+	}
+	return config.Conf.ProtoMaxBulkLen
+}
+
+func parseBitOffset(arg []byte) (int64, resp.RedisData) {
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset>>3 >= maxStringSize() {
+		return 0, resp.NewErrorReply(resp.ErrPrefix, "bit offset is not an integer or out of range")
+	}
+	return offset, nil
+}
+
+// lookupString returns the string at key, or nil if it doesn't exist. The caller holds the key's lock.
+func (db *MemDb) lookupString(key string, write bool) ([]byte, resp.RedisData) {
+	var val any
+	var ok bool
+	if write {
+		val, ok = db.lookupKeyWrite(key)
+	} else {
+		val, ok = db.lookupKeyRead(key)
+	}
+	if !ok {
+		return nil, nil
+	}
+	v, ok := val.([]byte)
+	if !ok {
+		return nil, resp.NewWrongTypeError()
+	}
+	return v, nil
+}
+
+func setBitString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	offset, errReply := parseBitOffset(cmd[2])
+	if errReply != nil {
+		return errReply
+	}
+	if len(cmd[3]) != 1 || (cmd[3][0] != '0' && cmd[3][0] != '1') {
+		return resp.NewErrorReply(resp.ErrPrefix, "bit is not an integer or out of range")
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, errReply := db.lookupString(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	byteIdx := int(offset >> 3)
+	exists := v != nil
+	if byteIdx >= len(v) {
+		// zero-extend; append keeps spare capacity for the next bits
+		v = append(v, make([]byte, byteIdx+1-len(v))...)
+	}
+
+	mask := byte(0x80) >> (offset & 7)
+	old := 0
+	if v[byteIdx]&mask != 0 {
+		old = 1
+	}
+	if cmd[3][0] == '1' {
+		v[byteIdx] |= mask
+	} else {
+		v[byteIdx] &^= mask
+	}
+	if exists {
+		// keep the object, and its access counters, when the string only changed in place
+		obj, _ := db.dict.Get(key)
+		obj.(*Object).Value = v
+	} else {
+		db.setKey(key, v)
+	}
+	return resp.NewInteger(int64(old))
+}
+
+func getBitString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	offset, errReply := parseBitOffset(cmd[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	v, errReply := db.lookupString(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if offset>>3 >= int64(len(v)) || v[offset>>3]&(0x80>>(offset&7)) == 0 {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(1)
+}
+
+// bitRange parses the optional start, end and BYTE|BIT arguments of BITCOUNT and BITPOS
+// into an inclusive range of bits of a string of n bytes, clamped as redis does.
+// empty is set when the range holds no bit.
+func bitRange(args [][]byte, n int) (start, end int64, endGiven, empty bool, errReply resp.RedisData) {
+	isBit := false
+	if len(args) == 3 {
+		switch strings.ToLower(string(args[2])) {
+		case "bit":
+			isBit = true
+		case "byte":
+		default:
+			return 0, 0, false, false, resp.NewSyntaxError()
+		}
+	}
+	total := int64(n)
+	if isBit {
+		total *= 8
+	}
+
+	start, end = 0, total-1
+	var err error
+	if len(args) > 0 {
+		if start, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+			return 0, 0, false, false, resp.NewNotIntegerError()
+		}
+	}
+	if len(args) > 1 {
+		if end, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			return 0, 0, false, false, resp.NewNotIntegerError()
+		}
+		endGiven = true
+	}
+
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return 0, 0, endGiven, true, nil
+	}
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+	return start, end, endGiven, false, nil
+}
+
+// popcount returns the number of bits set in v from bit start to bit end, inclusive.
+func popcount(v []byte, start, end int64) int64 {
+	first, last := start>>3, end>>3
+	headMask := byte(0xff) >> (start & 7)
+	tailMask := byte(0xff) << (7 - end&7)
+	if first == last {
+		return int64(bits.OnesCount8(v[first] & headMask & tailMask))
+	}
+
+	count := bits.OnesCount8(v[first]&headMask) + bits.OnesCount8(v[last]&tailMask)
+	p := v[first+1 : last]
+	for ; len(p) >= 8; p = p[8:] {
+		count += bits.OnesCount64(binary.LittleEndian.Uint64(p))
+	}
+	for _, b := range p {
+		count += bits.OnesCount8(b)
+	}
+	return int64(count)
+}
+
+// bitpos returns the position of the first bit set to bit in v between bits start and end, inclusive, or -1.
+func bitpos(v []byte, bit byte, start, end int64) int64 {
+	// bytes made only of the bits not searched for are skipped whole
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for pos := start; pos <= end; {
+		b := v[pos>>3]
+		if pos&7 == 0 && pos+7 <= end && b == skip {
+			pos += 8
+			continue
+		}
+		if (b>>(7-pos&7))&1 == bit {
+			return pos
+		}
+		pos++
+	}
+	return -1
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func bitCountString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	if len(cmd) == 3 || len(cmd) > 5 {
+		return resp.NewSyntaxError()
+	}
+
+	key := string(cmd[1])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	v, errReply := db.lookupString(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	start, end, _, empty, errReply := bitRange(cmd[2:], len(v))
+	if errReply != nil {
+		return errReply
+	}
+	if empty || len(v) == 0 {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(popcount(v, start, end))
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func bitPosString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 || len(cmd) > 6 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	if len(cmd[2]) != 1 || (cmd[2][0] != '0' && cmd[2][0] != '1') {
+		return resp.NewErrorReply(resp.ErrPrefix, "The bit argument must be 1 or 0.")
+	}
+	bit := cmd[2][0] - '0'
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	v, errReply := db.lookupString(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	start, end, endGiven, empty, errReply := bitRange(cmd[3:], len(v))
+	if errReply != nil {
+		return errReply
+	}
+	if v == nil {
+		// a missing key is an empty string: its first clear bit is 0, it has no set bit
+		return resp.NewInteger(-int64(bit))
+	}
+	if empty {
+		return resp.NewInteger(-1)
+	}
+	pos := bitpos(v, bit, start, end)
+	if pos == -1 && bit == 0 && !endGiven {
+		// without an explicit end, the string is considered padded with zeros on the right
+		pos = end + 1
+	}
+	return resp.NewInteger(pos)
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func bitOpString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	op := strings.ToLower(string(cmd[1]))
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(cmd) != 4 {
+			return resp.NewErrorReply(resp.ErrPrefix, "BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return resp.NewSyntaxError()
+	}
+
+	dest := string(cmd[2])
+	keys := make([]string, 0, len(cmd)-2)
+	for _, k := range cmd[2:] {
+		keys = append(keys, string(k))
+	}
+	db.locks.MLock(keys)
+	defer db.locks.MUnLock(keys)
+
+	srcs := make([][]byte, 0, len(keys)-1)
+	maxLen := 0
+	for _, key := range keys[1:] {
+		v, errReply := db.lookupString(key, false)
+		if errReply != nil {
+			return errReply
+		}
+		srcs = append(srcs, v)
+		if len(v) > maxLen {
+			maxLen = len(v)
+		}
+	}
+
+	if maxLen == 0 {
+		db.deleteKey(dest, lazyfreeServerDel())
+		return resp.NewInteger(0)
+	}
+
+	// a new string: dest may be one of the sources, and shorter sources count as zero-padded
+	res := make([]byte, maxLen)
+	copy(res, srcs[0])
+	switch op {
+	case "not":
+		for i := range res {
+			res[i] = ^res[i]
+		}
+	case "and":
+		for _, src := range srcs[1:] {
+			for i := range res {
+				if i < len(src) {
+					res[i] &= src[i]
+				} else {
+					res[i] = 0
+				}
+			}
+		}
+	case "or":
+		for _, src := range srcs[1:] {
+			for i, b := range src {
+				res[i] |= b
+			}
+		}
+	case "xor":
+		for _, src := range srcs[1:] {
+			for i, b := range src {
+				res[i] ^= b
+			}
+		}
+	}
+	db.DeleteExpire(dest)
+	db.setKey(dest, res)
+	return resp.NewInteger(int64(maxLen))
+}
+
+// bitfield overflow behaviors
+const (
+	bitfieldWrap = iota
+	bitfieldSat
+	bitfieldFail
+)
+
+type bitfieldOp struct {
+	write    bool  // SET or INCRBY
+	incr     bool  // INCRBY
+	signed   bool  // iN rather than uN
+	bits     int64 // 1..64 signed, 1..63 unsigned
+	offset   int64
+	value    int64 // the value of SET, or the increment
+	overflow int
+}
+
+func getUnsignedBitfield(v []byte, offset, bits int64) uint64 {
+	var value uint64
+	for i := int64(0); i < bits; i++ {
+		pos := offset + i
+		bit := uint64(0)
+		if pos>>3 < int64(len(v)) {
+			bit = uint64(v[pos>>3]>>(7-pos&7)) & 1
+		}
+		value = value<<1 | bit
+	}
+	return value
+}
+
+func getSignedBitfield(v []byte, offset, bits int64) int64 {
+	value := getUnsignedBitfield(v, offset, bits)
+	// sign-extend
+	if bits < 64 && value&(1<<(bits-1)) != 0 {
+		value |= ^uint64(0) << bits
+	}
+	return int64(value)
+}
+
+// setBitfield writes the bits low bits of value at offset; v is long enough.
+func setBitfield(v []byte, offset, bits int64, value uint64) {
+	for i := int64(0); i < bits; i++ {
+		pos := offset + i
+		mask := byte(0x80) >> (pos & 7)
+		if value&(1<<(bits-1-i)) != 0 {
+			v[pos>>3] |= mask
+		} else {
+			v[pos>>3] &^= mask
+		}
+	}
+}
+
+// checkUnsignedBitfieldOverflow reports whether value+incr overflows an unsigned field of bits bits,
+// and the value to store instead for WRAP or SAT, as redis's checkUnsignedBitfieldOverflow.
+func checkUnsignedBitfieldOverflow(value uint64, incr int64, bits int64, overflow int) (limit uint64, overflowed bool) {
+	max := uint64(1)<<bits - 1
+	maxIncr := int64(max - value)
+	minIncr := -int64(value)
+
+	if value > max || incr > maxIncr {
+		if overflow == bitfieldSat {
+			return max, true
+		}
+	} else if incr < 0 && incr < minIncr {
+		if overflow == bitfieldSat {
+			return 0, true
+		}
+	} else {
+		return 0, false
+	}
+	return (value + uint64(incr)) & max, true
+}
+
+// checkSignedBitfieldOverflow is checkUnsignedBitfieldOverflow for signed fields.
+func checkSignedBitfieldOverflow(value int64, incr int64, bits int64, overflow int) (limit int64, overflowed bool) {
+	max := int64(math.MaxInt64)
+	if bits < 64 {
+		max = int64(1)<<(bits-1) - 1
+	}
+	min := -max - 1
+	maxIncr := max - value
+	minIncr := min - value
+
+	if value > max || (bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		if overflow == bitfieldSat {
+			return max, true
+		}
+	} else if value < min || (bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		if overflow == bitfieldSat {
+			return min, true
+		}
+	} else {
+		return 0, false
+	}
+
+	// wrap: keep the low bits, sign-extended
+	c := uint64(value) + uint64(incr)
+	if bits < 64 {
+		if c&(1<<(bits-1)) != 0 {
+			c |= ^uint64(0) << bits
+		} else {
+			c &^= ^uint64(0) << bits
+		}
+	}
+	return int64(c), true
+}
+
+// parseBitfieldType parses i1..i64 or u1..u63.
+func parseBitfieldType(arg []byte) (signed bool, bits int64, errReply resp.RedisData) {
+	errReply = resp.NewErrorReply(resp.ErrPrefix, "Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(arg) < 2 {
+		return false, 0, errReply
+	}
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, errReply
+	}
+	bits, err := strconv.ParseInt(string(arg[1:]), 10, 64)
+	if err != nil || bits < 1 || (signed && bits > 64) || (!signed && bits > 63) {
+		return false, 0, errReply
+	}
+	return signed, bits, nil
+}
+
+// parseBitfieldOffset parses an offset in bits, or #N for the Nth field of bits bits.
+func parseBitfieldOffset(arg []byte, bits int64) (int64, resp.RedisData) {
+	errReply := resp.NewErrorReply(resp.ErrPrefix, "bit offset is not an integer or out of range")
+	multiplier := int64(1)
+	if len(arg) > 0 && arg[0] == '#' {
+		multiplier = bits
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > math.MaxInt64/multiplier {
+		return 0, errReply
+	}
+	offset *= multiplier
+	if (offset+bits-1)>>3 >= maxStringSize() {
+		return 0, errReply
+	}
+	return offset, nil
+}
+
+// parseBitfieldOps parses the subcommands of BITFIELD; readOnly only accepts GET, for BITFIELD_RO.
+func parseBitfieldOps(args [][]byte, readOnly bool) ([]bitfieldOp, resp.RedisData) {
+	var ops []bitfieldOp
+	overflow := bitfieldWrap
+	for i := 0; i < len(args); {
+		sub := strings.ToLower(string(args[i]))
+		if readOnly && sub != "get" {
+			return nil, resp.NewErrorReply(resp.ErrPrefix, "BITFIELD_RO only supports the GET subcommand")
+		}
+		switch sub {
+		case "overflow":
+			if i+1 >= len(args) {
+				return nil, resp.NewSyntaxError()
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "wrap":
+				overflow = bitfieldWrap
+			case "sat":
+				overflow = bitfieldSat
+			case "fail":
+				overflow = bitfieldFail
+			default:
+				return nil, resp.NewErrorReply(resp.ErrPrefix, "Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		case "get", "set", "incrby":
+		default:
+			return nil, resp.NewSyntaxError()
+		}
+
+		nargs := 3
+		if sub == "get" {
+			nargs = 2
+		}
+		if i+nargs >= len(args) {
+			return nil, resp.NewSyntaxError()
+		}
+		op := bitfieldOp{write: sub != "get", incr: sub == "incrby", overflow: overflow}
+		var errReply resp.RedisData
+		if op.signed, op.bits, errReply = parseBitfieldType(args[i+1]); errReply != nil {
+			return nil, errReply
+		}
+		if op.offset, errReply = parseBitfieldOffset(args[i+2], op.bits); errReply != nil {
+			return nil, errReply
+		}
+		if op.write {
+			v, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, resp.NewNotIntegerError()
+			}
+			op.value = v
+		}
+		ops = append(ops, op)
+		i += nargs + 1
+	}
+	return ops, nil
+}
+
+// execBitfield runs op on v, long enough for a write, and returns its reply.
+func execBitfield(v []byte, op bitfieldOp) resp.RedisData {
+	if !op.write {
+		if op.signed {
+			return resp.NewInteger(getSignedBitfield(v, op.offset, op.bits))
+		}
+		return resp.NewInteger(int64(getUnsignedBitfield(v, op.offset, op.bits)))
+	}
+
+	var oldVal, newVal int64
+	var overflowed bool
+	if op.signed {
+		oldVal = getSignedBitfield(v, op.offset, op.bits)
+		value, incr := op.value, int64(0)
+		if op.incr {
+			value, incr = oldVal, op.value
+		}
+		newVal = value + incr
+		var limit int64
+		if limit, overflowed = checkSignedBitfieldOverflow(value, incr, op.bits, op.overflow); overflowed {
+			newVal = limit
+		}
+	} else {
+		old := getUnsignedBitfield(v, op.offset, op.bits)
+		oldVal = int64(old)
+		value, incr := uint64(op.value), int64(0)
+		if op.incr {
+			value, incr = old, op.value
+		}
+		newVal = int64(value + uint64(incr))
+		var limit uint64
+		if limit, overflowed = checkUnsignedBitfieldOverflow(value, incr, op.bits, op.overflow); overflowed {
+			newVal = int64(limit)
+		}
+	}
+	if overflowed && op.overflow == bitfieldFail {
+		return resp.NewBulkString(nil)
+	}
+	setBitfield(v, op.offset, op.bits, uint64(newVal))
+	if op.incr {
+		return resp.NewInteger(newVal)
+	}
+	return resp.NewInteger(oldVal)
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+func bitFieldString(db *MemDb, cmd [][]byte) resp.RedisData {
+	return bitfield(db, cmd, false)
+}
+
+// BITFIELD_RO key [GET type offset] ...
+func bitFieldRoString(db *MemDb, cmd [][]byte) resp.RedisData {
+	return bitfield(db, cmd, true)
+}
+
+func bitfield(db *MemDb, cmd [][]byte, readOnly bool) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	ops, errReply := parseBitfieldOps(cmd[2:], readOnly)
+	if errReply != nil {
+		return errReply
+	}
+	// the string is grown once, to hold the farthest field written
+	size := -1
+	for _, op := range ops {
+		if end := int((op.offset + op.bits - 1) >> 3); op.write && end >= size {
+			size = end + 1
+		}
+	}
+
+	var v []byte
+	if size < 0 {
+		db.locks.RLock(key)
+		defer db.locks.RUnLock(key)
+		if v, errReply = db.lookupString(key, false); errReply != nil {
+			return errReply
+		}
+	} else {
+		db.locks.Lock(key)
+		defer db.locks.UnLock(key)
+		if v, errReply = db.lookupString(key, true); errReply != nil {
+			return errReply
+		}
+		exists := v != nil
+		if size > len(v) {
+			v = append(v, make([]byte, size-len(v))...)
+		}
+		if exists {
+			obj, _ := db.dict.Get(key)
+			obj.(*Object).Value = v
+		} else {
+			db.setKey(key, v)
+		}
+	}
+
+	res := make([]resp.RedisData, 0, len(ops))
+	for _, op := range ops {
+		res = append(res, execBitfield(v, op))
+	}
+	return resp.NewArray(res)
+}
@@ -0,0 +1,144 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	// SETBIT zero-extends, bit 0 is the high bit of the first byte
+	for _, c := range []struct{ args, want string }{
+		{"setbit b 7 1", ":0\r\n"},
+		{"setbit b 7 1", ":1\r\n"},
+		{"get b", "$1\r\n\x01\r\n"},
+		{"setbit b 100 1", ":0\r\n"},
+		{"strlen b", ":13\r\n"},
+		{"getbit b 100", ":1\r\n"},
+		{"getbit b 99", ":0\r\n"},
+		{"getbit b 100000", ":0\r\n"},
+		{"getbit nosuchkey 0", ":0\r\n"},
+		{"setbit b 100 0", ":1\r\n"},
+		{"bitcount b", ":1\r\n"},
+
+		// bit operations work on any string, and string commands on bitmaps
+		{"set s foobar", "+OK\r\n"},
+		{"bitcount s", ":26\r\n"},
+		{"bitcount s 0 0", ":4\r\n"},
+		{"bitcount s 1 1", ":6\r\n"},
+		{"bitcount s 1 1 byte", ":6\r\n"},
+		{"bitcount s 5 30 bit", ":17\r\n"},
+		{"bitcount s -2 -1", ":7\r\n"},
+		{"bitcount s 3 1", ":0\r\n"},
+		{"bitcount nosuchkey", ":0\r\n"},
+		{"setbit s 7 1", ":0\r\n"},
+		{"getrange s 0 0", "$1\r\ng\r\n"},
+		{"append s !", ":7\r\n"},
+		{"getbit s 55", ":1\r\n"},
+		{"setrange s 0 \xff", ":7\r\n"},
+		{"getbit s 0", ":1\r\n"},
+
+		{"set p \xff\xf0\x00", "+OK\r\n"},
+		{"bitpos p 0", ":12\r\n"},
+		{"bitpos p 1 2", ":-1\r\n"},
+		{"bitpos p 1 -3", ":0\r\n"},
+		{"bitpos p 0 1 1", ":12\r\n"},
+		{"bitpos p 1 7 15 bit", ":7\r\n"},
+		{"bitpos p 0 7 11 bit", ":-1\r\n"},
+		{"set ones \xff\xff", "+OK\r\n"},
+		{"bitpos ones 0", ":16\r\n"},
+		{"bitpos ones 0 0 -1", ":-1\r\n"},
+		{"bitpos nosuchkey 0", ":0\r\n"},
+		{"bitpos nosuchkey 1", ":-1\r\n"},
+
+		// BITOP pads shorter sources with zeros
+		{"set x \x0f\xff", "+OK\r\n"},
+		{"set y \xf0", "+OK\r\n"},
+		{"bitop and d x y", ":2\r\n"},
+		{"get d", "$2\r\n\x00\x00\r\n"},
+		{"bitop or d x y", ":2\r\n"},
+		{"get d", "$2\r\n\xff\xff\r\n"},
+		{"bitop xor d x y nosuchkey", ":2\r\n"},
+		{"get d", "$2\r\n\xff\xff\r\n"},
+		{"bitop not d y", ":1\r\n"},
+		{"get d", "$1\r\n\x0f\r\n"},
+		{"bitop not x x", ":2\r\n"},
+		{"get x", "$2\r\n\xf0\x00\r\n"},
+		{"bitop or d nosuchkey", ":0\r\n"},
+		{"exists d", ":0\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+
+	exec(db, "rpush", "list", "a")
+	for _, args := range []string{
+		"setbit b -1 1", "setbit b 4294967296000 1", "setbit b 0 2", "getbit b x",
+		"bitcount b 0", "bitcount b 0 1 word", "bitpos b 2", "bitpos b 1 x",
+		"bitop nand d b", "bitop not d b s",
+	} {
+		if r := exec(db, strings.Fields(args)...); !strings.HasPrefix(r, "-ERR") {
+			t.Error(fmt.Sprintf("%s == %q, expect an error", args, r))
+		}
+	}
+	for _, args := range []string{"setbit list 0 1", "getbit list 0", "bitcount list", "bitop and d b list"} {
+		if r := exec(db, strings.Fields(args)...); !strings.HasPrefix(r, "-WRONGTYPE") {
+			t.Error(fmt.Sprintf("%s == %q, expect WRONGTYPE", args, r))
+		}
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	for _, c := range []struct{ args, want string }{
+		{"bitfield k incrby i5 100 1 get u4 0", "*2\r\n:1\r\n:0\r\n"},
+		{"strlen k", ":14\r\n"},
+		{"bitfield k set i8 0 -100 get i8 0 get u8 0", "*3\r\n:0\r\n:-100\r\n:156\r\n"},
+		{"bitfield k set u8 #1 255 get u4 8 get u4 #3", "*3\r\n:0\r\n:15\r\n:15\r\n"},
+		{"getbit k 8", ":1\r\n"},
+
+		// overflow behaviors apply to the operations after them
+		{"bitfield c incrby u2 100 1 overflow sat incrby u2 102 1", "*2\r\n:1\r\n:1\r\n"},
+		{"bitfield c incrby u2 100 1 overflow sat incrby u2 102 1", "*2\r\n:2\r\n:2\r\n"},
+		{"bitfield c incrby u2 100 1 overflow sat incrby u2 102 1", "*2\r\n:3\r\n:3\r\n"},
+		{"bitfield c incrby u2 100 1 overflow sat incrby u2 102 1", "*2\r\n:0\r\n:3\r\n"},
+		{"bitfield c overflow fail incrby u2 102 1 get u2 102", "*2\r\n$-1\r\n:3\r\n"},
+		{"bitfield c overflow sat incrby u2 102 -10", "*1\r\n:0\r\n"},
+		{"bitfield c overflow sat set u2 102 -1", "*1\r\n:0\r\n"},
+		{"bitfield c get u2 102", "*1\r\n:3\r\n"},
+		{"bitfield s set i4 0 7 incrby i4 0 1 overflow sat incrby i4 4 -100", "*3\r\n:0\r\n:-8\r\n:-8\r\n"},
+		{"bitfield w set i64 0 9223372036854775807 incrby i64 0 1", "*2\r\n:0\r\n:-9223372036854775808\r\n"},
+		{"bitfield w overflow sat incrby i64 0 -1", "*1\r\n:-9223372036854775808\r\n"},
+		{"bitfield w overflow fail incrby i64 0 -1 get i64 0", "*2\r\n$-1\r\n:-9223372036854775808\r\n"},
+		{"bitfield u set u63 1 9223372036854775807 get u63 1 get i1 1", "*3\r\n:0\r\n:9223372036854775807\r\n:-1\r\n"},
+
+		{"bitfield_ro k get u8 8", "*1\r\n:255\r\n"},
+		{"bitfield_ro nosuchkey get i16 1000", "*1\r\n:0\r\n"},
+		{"bitfield nosuchkey", "*0\r\n"},
+		{"exists nosuchkey", ":0\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+
+	exec(db, "rpush", "list", "a")
+	for _, args := range []string{
+		"bitfield k get u64 0", "bitfield k get i65 0", "bitfield k get x8 0", "bitfield k get i8 -1",
+		"bitfield k get i8 #x", "bitfield k set i8 0", "bitfield k set i8 0 x", "bitfield k overflow up",
+		"bitfield k nosuchop", "bitfield_ro k set u8 0 1", "bitfield_ro k incrby u8 0 1",
+	} {
+		if r := exec(db, strings.Fields(args)...); !strings.HasPrefix(r, "-ERR") {
+			t.Error(fmt.Sprintf("%s == %q, expect an error", args, r))
+		}
+	}
+	if r := exec(db, "bitfield", "list", "get", "u8", "0"); !strings.HasPrefix(r, "-WRONGTYPE") {
+		t.Error(fmt.Sprintf("BITFIELD of a list == %q", r))
+	}
+}
@@ -0,0 +1,143 @@
+package memdb
+
+import (
+	"gRedis/resp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Blocking commands, like XREAD BLOCK, can't wait in their executor: it holds key locks, or runs on a shard.
+// An executor finding nothing to reply registers on its keys while it still holds their locks,
+// and replies a *BlockedReply; the server waits on it holding nothing, and runs the command again
+// once a write signals one of the keys. Registering under the locks, no write can slip in unnoticed.
+
+// BlockedReply is the reply of a command waiting for its keys.
+// Replying it as is replies as if the wait timed out, for callers that can't wait.
+type BlockedReply struct {
+	cmd      [][]byte // the command to run again, owning its arguments
+	timeout  resp.RedisData
+	deadline time.Time // zero to wait forever
+	ready    chan struct{}
+	db       *MemDb
+	keys     []string
+	once     sync.Once
+}
+
+// blockingKeys holds the commands waiting for each key of a database.
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[string]map[*BlockedReply]struct{}
+	n       atomic.Int64 // registrations, so signaling an unwatched key doesn't take mu
+}
+
+// block registers a wait for any of keys, until timeout if positive, replying timeoutReply then.
+// cmd must be a copy the reply owns. The caller holds the locks of keys.
+func (db *MemDb) block(keys []string, timeout time.Duration, cmd [][]byte, timeoutReply resp.RedisData) *BlockedReply {
+	b := &BlockedReply{cmd: cmd, timeout: timeoutReply, ready: make(chan struct{}, 1), db: db, keys: keys}
+	if timeout > 0 {
+		b.deadline = time.Now().Add(timeout)
+	}
+	bk := &db.blocking
+	bk.mu.Lock()
+	if bk.waiters == nil {
+		bk.waiters = make(map[string]map[*BlockedReply]struct{})
+	}
+	for _, key := range keys {
+		w, ok := bk.waiters[key]
+		if !ok {
+			w = make(map[*BlockedReply]struct{})
+			bk.waiters[key] = w
+		}
+		if _, ok := w[b]; !ok {
+			w[b] = struct{}{}
+			bk.n.Add(1)
+		}
+	}
+	bk.mu.Unlock()
+	return b
+}
+
+// signalKeyReady wakes the commands waiting for key. The caller holds the lock of key.
+func (db *MemDb) signalKeyReady(key string) {
+	bk := &db.blocking
+	if bk.n.Load() == 0 {
+		return
+	}
+	bk.mu.Lock()
+	for b := range bk.waiters[key] {
+		select {
+		case b.ready <- struct{}{}:
+		default:
+		}
+	}
+	bk.mu.Unlock()
+}
+
+// unblock removes the registrations of b.
+func (b *BlockedReply) unblock() {
+	b.once.Do(func() {
+		bk := &b.db.blocking
+		bk.mu.Lock()
+		for _, key := range b.keys {
+			if w, ok := bk.waiters[key]; ok {
+				delete(w, b)
+				bk.n.Add(-1)
+				if len(w) == 0 {
+					delete(bk.waiters, key)
+				}
+			}
+		}
+		bk.mu.Unlock()
+	})
+}
+
+// Wait waits for a key of b to be signaled, then runs b's command again in its database with exec, until it replies
+// something else than a BlockedReply, the deadline passes or cancel is closed; it returns the reply.
+func (b *BlockedReply) Wait(exec func(db *MemDb, cmd [][]byte) resp.RedisData, cancel <-chan struct{}) resp.RedisData {
+	var expired <-chan time.Time
+	if !b.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(b.deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-b.ready:
+		case <-expired:
+			b.unblock()
+			return b.timeout
+		case <-cancel:
+			b.unblock()
+			return b.timeout
+		}
+		b.unblock()
+		reply := exec(b.db, b.cmd)
+		next, ok := reply.(*BlockedReply)
+		if !ok {
+			return reply
+		}
+		// another client took what woke us, wait again until the first deadline
+		next.deadline = b.deadline
+		b = next
+	}
+}
+
+func (b *BlockedReply) GetBytesData() []byte {
+	b.unblock()
+	return b.timeout.GetBytesData()
+}
+
+func (b *BlockedReply) ToRedisFormat() []byte {
+	b.unblock()
+	return b.timeout.ToRedisFormat()
+}
+
+func (b *BlockedReply) AppendTo(dst []byte) []byte {
+	b.unblock()
+	return b.timeout.AppendTo(dst)
+}
+
+func (b *BlockedReply) String() string {
+	return b.timeout.String()
+}
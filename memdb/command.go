@@ -1,18 +1,87 @@
 package memdb
 
-import "gRedis/resp"
+import (
+	"gRedis/resp"
+	"strings"
+)
 
 var CmdTable = make(map[string]*command)
 
 // 返回客户端一个redis data类型
+// cmd aliases the connection's read buffer and is only valid during the call,
+// so executors must copy any argument they keep, e.g. with bytes.Clone.
 type cmdExecutor func(db *MemDb, cmd [][]byte) resp.RedisData
 
+// special firstKey values
+const (
+	noKeys  = 0  // the command doesn't access a keyspace, e.g. PING
+	allKeys = -1 // the command works on the whole keyspace, e.g. KEYS
+)
+
+// As in redis's command table, the keys of a command are at firstKey, firstKey+keyStep, ... up to lastKey,
+// and a negative lastKey counts from the end, -1 being the last argument.
 type command struct {
 	Executor cmdExecutor
+	firstKey int
+	lastKey  int
+	keyStep  int
+
+	// subcommands of a container command like MEMORY, by lower-case name; each has its own key spec
+	subcommands map[string]*command
 }
 
-func RegisterCommand(cmdName string, executor cmdExecutor) {
+func RegisterCommand(cmdName string, executor cmdExecutor, firstKey, lastKey, keyStep int) {
 	CmdTable[cmdName] = &command{
 		Executor: executor,
+		firstKey: firstKey,
+		lastKey:  lastKey,
+		keyStep:  keyStep,
+	}
+}
+
+// RegisterSubcommand registers cmdName subName, e.g. MEMORY USAGE; key positions count from cmdName.
+func RegisterSubcommand(cmdName, subName string, executor cmdExecutor, firstKey, lastKey, keyStep int) {
+	parent, ok := CmdTable[cmdName]
+	if !ok {
+		parent = &command{subcommands: make(map[string]*command)}
+		CmdTable[cmdName] = parent
+	}
+	parent.subcommands[subName] = &command{
+		Executor: executor,
+		firstKey: firstKey,
+		lastKey:  lastKey,
+		keyStep:  keyStep,
+	}
+}
+
+// Subcommand returns the subcommand cmd calls, or c itself if c has no subcommands.
+// It returns an error reply for a missing or unknown subcommand.
+func (c *command) Subcommand(cmd [][]byte) (*command, resp.RedisData) {
+	if c.subcommands == nil {
+		return c, nil
+	}
+	if len(cmd) < 2 {
+		return nil, resp.NewWrongArgsError(cmd[0])
+	}
+	sub, ok := c.subcommands[strings.ToLower(string(cmd[1]))]
+	if !ok {
+		return nil, resp.NewErrorReplyf(resp.ErrPrefix, "unknown subcommand '%s'. Try %s HELP.", cmd[1], strings.ToUpper(string(cmd[0])))
+	}
+	return sub, nil
+}
+
+// keyRange returns the bounds of the key arguments in cmd, keys being cmd[first], cmd[first+step], ... cmd[last].
+// It returns last < first if cmd has no key.
+func (c *command) keyRange(cmd [][]byte) (first, last, step int) {
+	if c.firstKey <= 0 {
+		return 0, -1, 1
+	}
+	last = c.lastKey
+	if last < 0 {
+		last += len(cmd)
+	}
+	if last >= len(cmd) {
+		last = len(cmd) - 1
 	}
+	return c.firstKey, last, c.keyStep
 }
@@ -2,111 +2,313 @@ package memdb
 
 import (
 	"gRedis/util"
-	"sync"
+	"math/rand"
+	"sync/atomic"
 )
 
-const MaxSegSize int = int(1<<32 - 1) // max signed int32
+const (
+	MaxSegSize int = 1 << 30 // max number of segments, a power of two
 
+	// the table grows when segments hold more keys than this on average,
+	// and shrinks when they hold less than 1/8 of it
+	segLoadFactor = 64
+)
+
+// ConcurrentMap is a hash table split into segments, each a Go map.
+// The number of segments is a power of two and follows the number of keys:
+// like redis's dict, the table is resized online by moving one segment at a time to a new table,
+// so no operation waits for the whole table to be rehashed.
+// A resize also builds fresh Go maps, which returns the memory left behind by deleted keys.
+//
+// Segments have no locks of their own: a key is guarded by its stripe in locks.
+// Callers hold the key's lock, a read lock for Get and the write lock for Set and Delete,
+// or, with shards, run on the shard owning the key.
+// There are at least as many segments as stripes, both powers of two,
+// so all keys of a segment share a stripe, in the old and in the new table.
 type ConcurrentMap struct {
-	table []*segment
-	size  int // table size
-	count int // key counts
+	state    atomic.Pointer[mapState]
+	count    atomic.Int64 // key counts
+	locks    *LocksManager
+	minSize  int         // the table never shrinks below this
+	resizing atomic.Bool // only one rehash runs at a time
+}
+
+// mapState is replaced as a whole, so a reader always sees a consistent pair of tables.
+type mapState struct {
+	old *table // the table being rehashed, nil if no rehash is running
+	cur *table
+}
+
+type table struct {
+	segs []*segment
+	mask uint32 // len(segs) - 1
 }
 
 type segment struct {
-	ht   map[string]any // hash table
-	rwMu *sync.RWMutex  //  The lock can be held by an arbitrary number of readers or a single writer.
+	ht    map[string]any // hash table
+	moved bool           // the keys were moved to a newer table; look there instead
 }
 
-func NewConcurrentMap(size int) *ConcurrentMap {
-	if size <= 0 || size >= MaxSegSize {
-		size = MaxSegSize
+func NewConcurrentMap(size int, locks *LocksManager) *ConcurrentMap {
+	size = tableSize(size)
+	if size < locks.Size() {
+		size = locks.Size()
 	}
+	m := &ConcurrentMap{locks: locks, minSize: size}
+	m.state.Store(&mapState{cur: newTable(size)})
+	return m
+}
 
-	m := &ConcurrentMap{
-		table: make([]*segment, size),
-		size:  size,
-		count: 0,
+// tableSize rounds size up to a power of two.
+func tableSize(size int) int {
+	if size <= 0 || size >= MaxSegSize {
+		return MaxSegSize
 	}
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return n
+}
 
-	for i := 0; i < size; i++ {
-		m.table[i] = &segment{ht: make(map[string]any), rwMu: &sync.RWMutex{}}
+func newTable(size int) *table {
+	t := &table{segs: make([]*segment, size), mask: uint32(size - 1)}
+	for i := range t.segs {
+		t.segs[i] = &segment{ht: make(map[string]any)}
 	}
+	return t
+}
 
-	return m
+func (t *table) segment(hash uint32) *segment {
+	return t.segs[hash&t.mask]
 }
 
-func (m *ConcurrentMap) getKeyPos(key string) int {
-	hash := util.Hash(key)
-	return hash % m.size
+// segmentFor returns the segment holding key; the caller holds the key's stripe lock.
+// While rehashing, a key lives in the old table until its segment is moved.
+// Segments are moved under the stripe lock after the new state is published,
+// so a moved segment always means a newer state can be loaded.
+func (m *ConcurrentMap) segmentFor(key string) *segment {
+	hash := util.Hash32(key)
+	for {
+		st := m.state.Load()
+		if st.old != nil {
+			if seg := st.old.segment(hash); !seg.moved {
+				return seg
+			}
+		}
+		if seg := st.cur.segment(hash); !seg.moved {
+			return seg
+		}
+	}
 }
 
 // 设置int输出而不是bool 是为了记录新增key数量
 func (m *ConcurrentMap) Set(key string, value any) int {
-	added := 0
-	pos := m.getKeyPos(key)
-
-	segment := m.table[pos]
-	segment.rwMu.Lock()
-	defer segment.rwMu.Unlock()
-
-	if _, ok := segment.ht[key]; !ok {
-		added = 1
-		m.count++
+	segment := m.segmentFor(key)
+	if _, ok := segment.ht[key]; ok {
+		segment.ht[key] = value
+		return 0
 	}
 	segment.ht[key] = value
-	return added
+	m.count.Add(1)
+	m.checkResize()
+	return 1
 }
 
 func (m *ConcurrentMap) Delete(key string) int {
-	pos := m.getKeyPos(key)
-
-	segment := m.table[pos]
-	segment.rwMu.Lock()
-	defer segment.rwMu.Unlock()
-
-	if _, ok := segment.ht[key]; ok {
-		delete(segment.ht, key)
-		m.count--
-		return 1
+	segment := m.segmentFor(key)
+	if _, ok := segment.ht[key]; !ok {
+		return 0
 	}
-
-	return 0
+	delete(segment.ht, key)
+	m.count.Add(-1)
+	m.checkResize()
+	return 1
 }
 
 func (m *ConcurrentMap) Get(key string) (any, bool) {
-	pos := m.getKeyPos(key)
-	segment := m.table[pos]
-	segment.rwMu.RLock()
-	defer segment.rwMu.RUnlock()
-
-	value, ok := segment.ht[key]
+	value, ok := m.segmentFor(key).ht[key]
 	return value, ok
 }
 
+// Size returns the number of segments.
 func (m *ConcurrentMap) Size() int {
-	return m.size
+	return len(m.state.Load().cur.segs)
 }
 
 func (m *ConcurrentMap) Count() int {
-	return m.count
+	return int(m.count.Load())
+}
+
+// Rehashing reports whether segments are being moved to a resized table.
+func (m *ConcurrentMap) Rehashing() bool {
+	return m.state.Load().old != nil
+}
+
+// Clear drops all keys and returns how many there were.
+// It locks every stripe itself, so it's safe next to any other caller, other Clears included;
+// with shards, the caller runs with every shard paused.
+func (m *ConcurrentMap) Clear() int {
+	m.locks.LockAll()
+	defer m.locks.UnLockAll()
+	return m.clear()
 }
 
-func (m *ConcurrentMap) Clear() {
-	*m = *NewConcurrentMap(m.size) // 这里改的是指针
+// clear is Clear for a caller holding every stripe, e.g. to clear dict and expires together.
+func (m *ConcurrentMap) clear() int {
+	// a running rehash sees the new state and stops
+	m.state.Store(&mapState{cur: newTable(m.minSize)})
+	return int(m.count.Swap(0))
+}
+
+// RandomKey returns a key picked uniformly among all keys, false if there is none.
+// The caller holds every stripe, a read lock is enough.
+func (m *ConcurrentMap) RandomKey() (string, bool) {
+	count := m.Count()
+	if count == 0 {
+		return "", false
+	}
+	// segments hold different numbers of keys, so pick the key's rank and find its segment
+	rank := rand.Intn(count)
+	st := m.state.Load()
+	for _, t := range []*table{st.old, st.cur} {
+		if t == nil {
+			continue
+		}
+		for _, seg := range t.segs {
+			if seg.moved {
+				continue
+			}
+			if rank >= len(seg.ht) {
+				rank -= len(seg.ht)
+				continue
+			}
+			for key := range seg.ht {
+				if rank == 0 {
+					return key, true
+				}
+				rank--
+			}
+		}
+	}
+	return "", false
+}
+
+// swap exchanges the keys of m and other, as SWAPDB does.
+// The caller holds every stripe of both maps, whose stripes are as many.
+func (m *ConcurrentMap) swap(other *ConcurrentMap) {
+	// a rehash of either map sees the new state and stops; the rest of its work is done here,
+	// as nothing would finish it otherwise
+	st, otherSt := finishRehash(m.state.Load()), finishRehash(other.state.Load())
+	m.state.Store(otherSt)
+	other.state.Store(st)
+	count := m.count.Load()
+	m.count.Store(other.count.Load())
+	other.count.Store(count)
+	m.checkResize()
+	other.checkResize()
+}
+
+// finishRehash moves the segments of st a rehash hasn't moved yet, and returns the resulting state.
+// The caller holds every stripe, so the rehash goroutine can't be moving one meanwhile.
+func finishRehash(st *mapState) *mapState {
+	if st.old == nil {
+		return st
+	}
+	for _, seg := range st.old.segs {
+		if seg.moved {
+			continue
+		}
+		for key, value := range seg.ht {
+			st.cur.segment(util.Hash32(key)).ht[key] = value
+		}
+		seg.ht = nil
+		seg.moved = true
+	}
+	return &mapState{cur: st.cur}
 }
 
 // 这里拿到的keys有可能有过期的，需要lazy deletion
+// Keys locks one stripe at a time; every key is seen exactly once, since keys only move within their stripe.
 func (m *ConcurrentMap) Keys() []string {
-	keys := make([]string, m.count)
-	k := 0
-	for i := range m.table {
-		m.table[i].rwMu.Lock()
-		for key := range m.table[i].ht {
-			keys[k] = key
-			k++
+	keys := make([]string, 0, m.Count())
+	stripes := m.locks.Size()
+	for pos := 0; pos < stripes; pos++ {
+		m.locks.rlockStripe(pos)
+		st := m.state.Load()
+		for _, t := range []*table{st.old, st.cur} {
+			if t == nil {
+				continue
+			}
+			// the segments of stripe pos
+			for i := pos; i < len(t.segs); i += stripes {
+				if seg := t.segs[i]; !seg.moved {
+					for key := range seg.ht {
+						keys = append(keys, key)
+					}
+				}
+			}
 		}
-		m.table[i].rwMu.Unlock()
+		m.locks.runlockStripe(pos)
 	}
 	return keys
 }
+
+// checkResize starts a rehash in the background when the load is out of bounds.
+func (m *ConcurrentMap) checkResize() {
+	st := m.state.Load()
+	if st.old != nil {
+		return
+	}
+	size := len(st.cur.segs)
+	if m.targetSize(size, m.Count()) != size && m.resizing.CompareAndSwap(false, true) {
+		go m.resize()
+	}
+}
+
+// targetSize returns the number of segments for count keys, starting from size.
+func (m *ConcurrentMap) targetSize(size int, count int) int {
+	for size < MaxSegSize && count > size*segLoadFactor {
+		size <<= 1
+	}
+	for size > m.minSize && count < size*segLoadFactor/8 {
+		size >>= 1
+	}
+	return size
+}
+
+// resize moves the keys to a table sized for the current count, one segment at a time.
+func (m *ConcurrentMap) resize() {
+	defer func() {
+		m.resizing.Store(false)
+		// keys may have come or gone during the rehash
+		m.checkResize()
+	}()
+
+	st := m.state.Load()
+	size := m.targetSize(len(st.cur.segs), m.Count())
+	if size == len(st.cur.segs) {
+		return
+	}
+	next := &mapState{old: st.cur, cur: newTable(size)}
+	if !m.state.CompareAndSwap(st, next) {
+		return
+	}
+
+	stripeMask := m.locks.mask
+	for i, seg := range next.old.segs {
+		release := m.locks.acquireStripe(int(uint32(i) & stripeMask))
+		// Clear replaced the tables
+		if m.state.Load() != next {
+			release()
+			return
+		}
+		for key, value := range seg.ht {
+			next.cur.segment(util.Hash32(key)).ht[key] = value
+		}
+		seg.ht = nil
+		seg.moved = true
+		release()
+	}
+	m.state.CompareAndSwap(next, &mapState{cur: next.cur})
+}
@@ -0,0 +1,225 @@
+package memdb
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitRehash waits for background rehashing to settle.
+func waitRehash(t *testing.T, m *ConcurrentMap) {
+	deadline := time.Now().Add(5 * time.Second)
+	for m.resizing.Load() || m.Rehashing() {
+		if time.Now().After(deadline) {
+			t.Fatal("rehash doesn't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// lockedMap locks a key's stripe around each operation, like MemDb executors do.
+type lockedMap struct {
+	*ConcurrentMap
+}
+
+func newLockedMap(size int, stripes int) lockedMap {
+	return lockedMap{NewConcurrentMap(size, NewLocksManager(stripes))}
+}
+
+func (m lockedMap) Set(key string, value any) int {
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+	return m.ConcurrentMap.Set(key, value)
+}
+
+func (m lockedMap) Delete(key string) int {
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+	return m.ConcurrentMap.Delete(key)
+}
+
+func (m lockedMap) Get(key string) (any, bool) {
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	return m.ConcurrentMap.Get(key)
+}
+
+func TestConcurrentMapResize(t *testing.T) {
+	m := newLockedMap(3, 1)
+	if m.Size() != 4 {
+		t.Errorf("Size() == %d, expect 4", m.Size())
+	}
+
+	n := 20000
+	for i := 0; i < n; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+	waitRehash(t, m.ConcurrentMap)
+	if m.Count() != n || m.Size() < n/segLoadFactor {
+		t.Errorf("after grow Count() == %d, Size() == %d", m.Count(), m.Size())
+	}
+	for i := 0; i < n; i++ {
+		if v, ok := m.Get(strconv.Itoa(i)); !ok || v.(int) != i {
+			t.Fatalf("Get(%d) == %v, %v", i, v, ok)
+		}
+	}
+	if keys := m.Keys(); len(keys) != n {
+		t.Errorf("len(Keys()) == %d, expect %d", len(keys), n)
+	}
+
+	// a mass delete shrinks the table back
+	for i := 0; i < n-10; i++ {
+		m.Delete(strconv.Itoa(i))
+	}
+	waitRehash(t, m.ConcurrentMap)
+	if m.Count() != 10 || m.Size() != 4 {
+		t.Errorf("after shrink Count() == %d, Size() == %d", m.Count(), m.Size())
+	}
+	for i := n - 10; i < n; i++ {
+		if _, ok := m.Get(strconv.Itoa(i)); !ok {
+			t.Errorf("Get(%d) lost after shrink", i)
+		}
+	}
+
+	m.Clear()
+	if m.Count() != 0 || len(m.Keys()) != 0 {
+		t.Errorf("after Clear Count() == %d", m.Count())
+	}
+
+	// there are never fewer segments than stripes
+	if m := NewConcurrentMap(2, NewLocksManager(100)); m.Size() != 128 {
+		t.Errorf("Size() == %d, expect 128", m.Size())
+	}
+}
+
+func TestConcurrentMapRehashWhileWriting(t *testing.T) {
+	m := newLockedMap(1, 1)
+	workers, n := 8, 5000
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			prefix := strconv.Itoa(w) + ":"
+			for i := 0; i < n; i++ {
+				m.Set(prefix+strconv.Itoa(i), i)
+				// every other key is deleted again, so the table grows and shrinks meanwhile
+				if i%2 == 1 {
+					m.Delete(prefix + strconv.Itoa(i-1))
+				}
+				if _, ok := m.Get(prefix + strconv.Itoa(i)); !ok {
+					t.Errorf("Get(%s%d) lost", prefix, i)
+					return
+				}
+			}
+		}(w)
+	}
+	// iterate while segments move: no key is seen twice
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			seen := make(map[string]bool)
+			for _, key := range m.Keys() {
+				if seen[key] {
+					t.Errorf("Keys() returns %s twice", key)
+					return
+				}
+				seen[key] = true
+			}
+		}
+	}()
+	wg.Wait()
+
+	waitRehash(t, m.ConcurrentMap)
+	if m.Count() != workers*n/2 || len(m.Keys()) != workers*n/2 {
+		t.Errorf("Count() == %d, len(Keys()) == %d, expect %d", m.Count(), len(m.Keys()), workers*n/2)
+	}
+}
+
+func TestConcurrentMapClearWhileWriting(t *testing.T) {
+	m := newLockedMap(1, 4)
+	workers, n := 4, 5000
+
+	var wg sync.WaitGroup
+	var cleared atomic.Int64
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			prefix := strconv.Itoa(w) + ":"
+			for i := 0; i < n; i++ {
+				m.Set(prefix+strconv.Itoa(i), i)
+			}
+		}(w)
+		// Clears race with each other, with writers and with the rehash
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				cleared.Add(int64(m.Clear()))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every key set was either cleared or is still there
+	waitRehash(t, m.ConcurrentMap)
+	if left := int64(len(m.Keys())); left != int64(m.Count()) || left+cleared.Load() != int64(workers*n) {
+		t.Errorf("%d keys left, %d cleared, expect %d in all", left, cleared.Load(), workers*n)
+	}
+}
+
+func TestConcurrentMapSwapWhileRehashing(t *testing.T) {
+	a, b := newLockedMap(1, 4), newLockedMap(1, 4)
+	n := 20000
+
+	var wg sync.WaitGroup
+	keys := make([]string, 0, 2*n)
+	for i := 0; i < 2*n; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	for w, m := range []lockedMap{a, b} {
+		wg.Add(1)
+		go func(m lockedMap, keys []string) {
+			defer wg.Done()
+			// both tables grow while being swapped
+			for i, key := range keys {
+				m.Set(key, i)
+			}
+		}(m, keys[w*n:(w+1)*n])
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			a.locks.LockAll()
+			b.locks.LockAll()
+			a.swap(b.ConcurrentMap)
+			b.locks.UnLockAll()
+			a.locks.UnLockAll()
+		}
+	}()
+	wg.Wait()
+
+	// no rehash is left half done, and every key is found in one of the maps
+	waitRehash(t, a.ConcurrentMap)
+	waitRehash(t, b.ConcurrentMap)
+	for _, m := range []lockedMap{a, b} {
+		if len(m.Keys()) != m.Count() {
+			t.Errorf("len(Keys()) == %d, Count() == %d", len(m.Keys()), m.Count())
+		}
+	}
+	if a.Count()+b.Count() != 2*n {
+		t.Errorf("%d + %d keys, expect %d", a.Count(), b.Count(), 2*n)
+	}
+	for _, key := range keys {
+		_, inA := a.Get(key)
+		_, inB := b.Get(key)
+		if !inA && !inB {
+			t.Fatalf("Get(%s) lost", key)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package memdb
+
+import (
+	"sync"
+	"time"
+)
+
+// Hash fields with a TTL expire lazily, as lookupObject removes them before a write, and actively:
+// while a database holds hashes with such fields, a background goroutine samples them
+// every fieldExpireInterval and removes their expired fields, deleting the hashes left empty.
+
+const (
+	fieldExpireInterval = 100 * time.Millisecond
+	fieldExpireSample   = 20 // hashes visited per cycle
+)
+
+// fieldExpiry holds the keys of a database which may hold a hash with fields to expire.
+// A key is added when it gets such a hash, and removed by the cycle once it no longer has one.
+type fieldExpiry struct {
+	mu      sync.Mutex
+	keys    map[string]struct{}
+	running bool // the cycle goroutine is running
+}
+
+// trackFieldExpiry registers key if v is a hash with fields to expire. The caller holds the key's write lock.
+func (db *MemDb) trackFieldExpiry(key string, v any) {
+	if h, ok := v.(*Hash); !ok || h.expires == nil {
+		return
+	}
+	fe := &db.fieldExpiry
+	fe.mu.Lock()
+	if fe.keys == nil {
+		fe.keys = make(map[string]struct{})
+	}
+	fe.keys[key] = struct{}{}
+	if !fe.running {
+		fe.running = true
+		go db.expireFields()
+	}
+	fe.mu.Unlock()
+}
+
+// expireFields runs the active expire cycle until no key is left to watch.
+func (db *MemDb) expireFields() {
+	fe := &db.fieldExpiry
+	sample := make([]string, 0, fieldExpireSample)
+	for {
+		time.Sleep(fieldExpireInterval)
+
+		sample = sample[:0]
+		fe.mu.Lock()
+		if len(fe.keys) == 0 {
+			fe.running = false
+			fe.mu.Unlock()
+			return
+		}
+		// map iteration starts at a random key
+		for key := range fe.keys {
+			if len(sample) == fieldExpireSample {
+				break
+			}
+			sample = append(sample, key)
+		}
+		fe.mu.Unlock()
+
+		for _, key := range sample {
+			db.expireHashFields(key)
+		}
+	}
+}
+
+// expireHashFields removes the expired fields of the hash at key, if any, and stops watching the key
+// once it has no field to expire.
+func (db *MemDb) expireHashFields(key string) {
+	release := db.locks.acquireStripe(db.locks.GetKeyPos(key))
+	defer release()
+
+	// a write lookup removes the expired fields, and the key if they were the last ones
+	obj, ok := db.lookupObject(key, lookupWrite|lookupNoTouch)
+	if ok {
+		if h, isHash := obj.Value.(*Hash); isHash && h.expires != nil {
+			return
+		}
+	}
+	fe := &db.fieldExpiry
+	fe.mu.Lock()
+	delete(fe.keys, key)
+	fe.mu.Unlock()
+}
+
+// swapFieldExpiry swaps the watched keys of two databases, as SWAPDB swaps their keys.
+// The caller holds every lock of both.
+func swapFieldExpiry(a, b *MemDb) {
+	a.fieldExpiry.mu.Lock()
+	b.fieldExpiry.mu.Lock()
+	a.fieldExpiry.keys, b.fieldExpiry.keys = b.fieldExpiry.keys, a.fieldExpiry.keys
+	for _, db := range []*MemDb{a, b} {
+		if fe := &db.fieldExpiry; len(fe.keys) > 0 && !fe.running {
+			fe.running = true
+			go db.expireFields()
+		}
+	}
+	b.fieldExpiry.mu.Unlock()
+	a.fieldExpiry.mu.Unlock()
+}
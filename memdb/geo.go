@@ -0,0 +1,627 @@
+package memdb
+
+import (
+	"gRedis/resp"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The GEO commands keep locations in sorted sets, scored by their geohash (see geohash.go),
+// so ZREM removes a location and DUMP/RESTORE move them to and from redis.
+
+// parseLongLat parses a longitude and latitude, checking they're within the limits of geohashes.
+func parseLongLat(longArg, latArg []byte) (long, lat float64, errReply resp.RedisData) {
+	long, err := strconv.ParseFloat(string(longArg), 64)
+	if err != nil {
+		return 0, 0, resp.NewNotFloatError()
+	}
+	lat, err = strconv.ParseFloat(string(latArg), 64)
+	if err != nil {
+		return 0, 0, resp.NewNotFloatError()
+	}
+	if !(long >= geoLongMin && long <= geoLongMax && lat >= geoLatMin && lat <= geoLatMax) {
+		return 0, 0, resp.NewErrorReplyf(resp.ErrPrefix, "invalid longitude,latitude pair %f,%f", long, lat)
+	}
+	return long, lat, nil
+}
+
+// parseGeoUnit returns the meters in a unit.
+func parseGeoUnit(arg []byte) (float64, resp.RedisData) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, resp.NewErrorReply(resp.ErrPrefix, "unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// formatGeoCoord formats a coordinate as redis does, with 17 decimals less the trailing zeros.
+func formatGeoCoord(v float64) []byte {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return []byte(s)
+}
+
+// formatGeoDistance formats a distance in meters in a unit, to 4 decimals as redis does.
+func formatGeoDistance(meters, conversion float64) []byte {
+	return strconv.AppendFloat(nil, meters/conversion, 'f', 4, 64)
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func geoAddGeo(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	nx, xx, ch := false, false, false
+	i := 2
+options:
+	for ; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+			ch = true
+		default:
+			break options
+		}
+	}
+	if (len(cmd)-i)%3 != 0 || i == len(cmd) || (nx && xx) {
+		return resp.NewSyntaxError()
+	}
+
+	members := make([]string, 0, (len(cmd)-i)/3)
+	scores := make([]float64, 0, (len(cmd)-i)/3)
+	for ; i < len(cmd); i += 3 {
+		long, lat, errReply := parseLongLat(cmd[i], cmd[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		members = append(members, string(cmd[i+2]))
+		scores = append(scores, geoEncodeScore(long, lat))
+	}
+
+	key := string(cmd[1])
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	z, errReply := db.lookupZSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		if xx {
+			return resp.NewInteger(0)
+		}
+		z = NewZSet()
+		db.setKey(key, z)
+	}
+
+	added, updated := 0, 0
+	for j, member := range members {
+		old, ok := z.Score(member)
+		switch {
+		case ok && !nx:
+			if old != scores[j] {
+				z.Add(member, scores[j])
+				updated++
+			}
+		case !ok && !xx:
+			added += z.Add(member, scores[j])
+		}
+	}
+	if ch {
+		return resp.NewInteger(int64(added + updated))
+	}
+	return resp.NewInteger(int64(added))
+}
+
+// GEOPOS key [member ...]
+func geoPosGeo(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	z, errReply := db.lookupZSet(key, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if z == nil {
+			res = append(res, resp.NewArray(nil))
+			continue
+		}
+		score, ok := z.Score(string(member))
+		if !ok {
+			res = append(res, resp.NewArray(nil))
+			continue
+		}
+		long, lat := geoDecodeScore(score)
+		res = append(res, resp.NewArray([]resp.RedisData{
+			resp.NewBulkString(formatGeoCoord(long)),
+			resp.NewBulkString(formatGeoCoord(lat)),
+		}))
+	}
+	return resp.NewArray(res)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func geoDistGeo(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	if len(cmd) > 5 {
+		return resp.NewSyntaxError()
+	}
+	conversion := 1.0
+	if len(cmd) == 5 {
+		var errReply resp.RedisData
+		if conversion, errReply = parseGeoUnit(cmd[4]); errReply != nil {
+			return errReply
+		}
+	}
+
+	key := string(cmd[1])
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	z, errReply := db.lookupZSet(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return resp.NewBulkString(nil)
+	}
+	score1, ok1 := z.Score(string(cmd[2]))
+	score2, ok2 := z.Score(string(cmd[3]))
+	if !ok1 || !ok2 {
+		return resp.NewBulkString(nil)
+	}
+	long1, lat1 := geoDecodeScore(score1)
+	long2, lat2 := geoDecodeScore(score2)
+	return resp.NewBulkString(formatGeoDistance(geoDistance(long1, lat1, long2, lat2), conversion))
+}
+
+// GEOHASH key [member ...]
+func geoHashGeo(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	z, errReply := db.lookupZSet(key, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if z == nil {
+			res = append(res, resp.NewBulkString(nil))
+			continue
+		}
+		score, ok := z.Score(string(member))
+		if !ok {
+			res = append(res, resp.NewBulkString(nil))
+			continue
+		}
+		res = append(res, resp.NewBulkString(geohashString(score)))
+	}
+	return resp.NewArray(res)
+}
+
+// geoShape is the area GEOSEARCH looks in: a circle, or a box whose sides follow the meridians and parallels,
+// centered on a location. Sizes are in meters.
+type geoShape struct {
+	long, lat     float64
+	box           bool
+	radius        float64
+	width, height float64
+}
+
+// contains returns the distance of a location from the center, and whether the location is within the shape.
+func (s *geoShape) contains(long, lat float64) (float64, bool) {
+	if !s.box {
+		dist := geoDistance(s.long, s.lat, long, lat)
+		return dist, dist <= s.radius
+	}
+	// the latitude distance is the cheaper, check it first
+	if geoLatDistance(lat, s.lat) > s.height/2 {
+		return 0, false
+	}
+	if geoDistance(long, lat, s.long, lat) > s.width/2 {
+		return 0, false
+	}
+	return geoDistance(s.long, s.lat, long, lat), true
+}
+
+// bounds returns the longitudes and latitudes bounding the shape, as redis computes them.
+// ok is false when the shape reaches past a pole, or all around it, where no bounds hold.
+func (s *geoShape) bounds() (minLong, minLat, maxLong, maxLat float64, ok bool) {
+	width, height := s.radius, s.radius
+	if s.box {
+		width, height = s.width/2, s.height/2
+	}
+	latDelta := radDeg(height / earthRadius)
+	polarLat := s.lat + latDelta
+	if s.lat < 0 {
+		polarLat = s.lat - latDelta
+	}
+	if polarLat > geoLatMax || polarLat < geoLatMin {
+		return 0, 0, 0, 0, false
+	}
+	// a degree of longitude shrinks towards the poles, so the polar side of the shape spans the most
+	longDelta := radDeg(width / earthRadius / math.Cos(degRad(polarLat)))
+	if s.box {
+		// the sides are measured along great circles, which reach further than the parallels,
+		// so redis misses locations near the corners of big boxes
+		x := math.Sin(width/earthRadius/2) / math.Cos(degRad(polarLat))
+		if x >= 1 {
+			return 0, 0, 0, 0, false
+		}
+		longDelta = math.Max(longDelta, radDeg(2*math.Asin(x)))
+	}
+	return s.long - longDelta, s.lat - latDelta, s.long + longDelta, s.lat + latDelta, true
+}
+
+// cells returns the geohash cells covering the shape: the cell of its center and those of its neighbors
+// it reaches into, the cells big enough that the shape doesn't reach past them.
+func (s *geoShape) cells() []geoHashBits {
+	radius := s.radius
+	if s.box {
+		radius = math.Sqrt(s.width/2*s.width/2 + s.height/2*s.height/2)
+	}
+	minLong, minLat, maxLong, maxLat, ok := s.bounds()
+	if !ok {
+		// the cell of step 0 is the whole world; redis would miss locations there
+		return []geoHashBits{{0, 0}}
+	}
+
+	// center, north, south, east, west, north east, north west, south east, south west
+	moves := [9][2]int{{0, 0}, {0, 1}, {0, -1}, {1, 0}, {-1, 0}, {1, 1}, {-1, 1}, {1, -1}, {-1, -1}}
+	var cells [9]geoHashBits
+	var area geoArea
+	around := func(step uint) {
+		hash, _ := geohashEncode(geoLongRange, geoLatRange, s.long, s.lat, step)
+		for i, m := range moves {
+			cells[i] = geoMove(hash, m[0], m[1])
+		}
+		area = geohashDecode(geoLongRange, geoLatRange, hash)
+	}
+	step := geoEstimateStep(radius, s.lat)
+	around(step)
+
+	// near the edge of its cell, the shape may reach past the neighbors: use bigger cells until they cover it.
+	// Neighbors wrap around: those across the antimeridian are compared unwrapped, as the bounds then reach past ±180,
+	// and those across a pole don't extend the cells, as nothing lies beyond
+	covered := func() bool {
+		north := geohashDecode(geoLongRange, geoLatRange, cells[1])
+		south := geohashDecode(geoLongRange, geoLatRange, cells[2])
+		east := geohashDecode(geoLongRange, geoLatRange, cells[3])
+		west := geohashDecode(geoLongRange, geoLatRange, cells[4])
+		eastMax, westMin := east.long.max, west.long.min
+		if eastMax <= area.long.max {
+			eastMax += 360
+		}
+		if westMin >= area.long.min {
+			westMin -= 360
+		}
+		return math.Max(north.lat.max, area.lat.max) >= maxLat && math.Min(south.lat.min, area.lat.min) <= minLat &&
+			eastMax >= maxLong && westMin <= minLong
+	}
+	for step > 1 && !covered() {
+		step--
+		around(step)
+	}
+
+	// skip the neighbors the shape doesn't reach
+	var skip [9]bool
+	if step >= 2 {
+		if area.lat.min < minLat {
+			skip[2], skip[7], skip[8] = true, true, true
+		}
+		if area.lat.max > maxLat {
+			skip[1], skip[5], skip[6] = true, true, true
+		}
+		if area.long.min < minLong {
+			skip[4], skip[6], skip[8] = true, true, true
+		}
+		if area.long.max > maxLong {
+			skip[3], skip[5], skip[7] = true, true, true
+		}
+	}
+
+	res := make([]geoHashBits, 0, len(cells))
+next:
+	for i, cell := range cells {
+		if skip[i] {
+			continue
+		}
+		// big cells wrap around to the same neighbors
+		for _, seen := range res {
+			if seen == cell {
+				continue next
+			}
+		}
+		res = append(res, cell)
+	}
+	return res
+}
+
+// geoPoint is a location GEOSEARCH found.
+type geoPoint struct {
+	member    string
+	score     float64
+	long, lat float64
+	dist      float64 // meters from the center of the search
+}
+
+// search returns the members of z within s, up to limit of them if limit > 0, in no particular order.
+func (s *geoShape) search(z *ZSet, limit int) []geoPoint {
+	var points []geoPoint
+	for _, cell := range s.cells() {
+		// the scores of the locations in a cell share its bits as prefix
+		shift := 2 * (geoStepMax - cell.step)
+		min, max := float64(cell.bits<<shift), float64((cell.bits+1)<<shift)
+		for n := z.seek(min); n != nil && n.score < max; n = n.next[0] {
+			if limit > 0 && len(points) >= limit {
+				return points
+			}
+			long, lat := geoDecodeScore(n.score)
+			if dist, ok := s.contains(long, lat); ok {
+				points = append(points, geoPoint{n.member, n.score, long, lat, dist})
+			}
+		}
+	}
+	return points
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func geoSearchGeo(db *MemDb, cmd [][]byte) resp.RedisData {
+	return geoSearch(db, cmd, false)
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+func geoSearchStoreGeo(db *MemDb, cmd [][]byte) resp.RedisData {
+	return geoSearch(db, cmd, true)
+}
+
+func geoSearch(db *MemDb, cmd [][]byte, store bool) resp.RedisData {
+	base := 2
+	if store {
+		base = 3
+	}
+	if len(cmd) < base+1 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	var shape geoShape
+	var fromMember []byte
+	fromLonLat, byRadius, byBox := false, false, false
+	withCoord, withDist, withHash, storeDist, anyOne := false, false, false, false, false
+	sortDir, count := 0, int64(0) // sortDir is 1 for ASC, -1 for DESC
+	conversion := 1.0
+	for i := base; i < len(cmd); i++ {
+		remaining := len(cmd) - i - 1
+		switch arg := strings.ToLower(string(cmd[i])); {
+		case arg == "withcoord" && !store:
+			withCoord = true
+		case arg == "withdist" && !store:
+			withDist = true
+		case arg == "withhash" && !store:
+			withHash = true
+		case arg == "storedist" && store:
+			storeDist = true
+		case arg == "any":
+			anyOne = true
+		case arg == "asc":
+			sortDir = 1
+		case arg == "desc":
+			sortDir = -1
+		case arg == "count" && remaining >= 1:
+			var err error
+			if count, err = strconv.ParseInt(string(cmd[i+1]), 10, 64); err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if count <= 0 {
+				return resp.NewErrorReply(resp.ErrPrefix, "COUNT must be > 0")
+			}
+			i++
+		case arg == "frommember" && remaining >= 1:
+			if fromMember != nil || fromLonLat {
+				return resp.NewSyntaxError()
+			}
+			fromMember = cmd[i+1]
+			i++
+		case arg == "fromlonlat" && remaining >= 2:
+			if fromMember != nil || fromLonLat {
+				return resp.NewSyntaxError()
+			}
+			var errReply resp.RedisData
+			if shape.long, shape.lat, errReply = parseLongLat(cmd[i+1], cmd[i+2]); errReply != nil {
+				return errReply
+			}
+			fromLonLat = true
+			i += 2
+		case arg == "byradius" && remaining >= 2:
+			if byRadius || byBox {
+				return resp.NewSyntaxError()
+			}
+			radius, err := strconv.ParseFloat(string(cmd[i+1]), 64)
+			if err != nil {
+				return resp.NewErrorReply(resp.ErrPrefix, "need numeric radius")
+			}
+			if radius < 0 {
+				return resp.NewErrorReply(resp.ErrPrefix, "radius cannot be negative")
+			}
+			var errReply resp.RedisData
+			if conversion, errReply = parseGeoUnit(cmd[i+2]); errReply != nil {
+				return errReply
+			}
+			shape.radius = radius * conversion
+			byRadius = true
+			i += 2
+		case arg == "bybox" && remaining >= 3:
+			if byRadius || byBox {
+				return resp.NewSyntaxError()
+			}
+			width, err := strconv.ParseFloat(string(cmd[i+1]), 64)
+			if err != nil {
+				return resp.NewErrorReply(resp.ErrPrefix, "need numeric width")
+			}
+			height, err := strconv.ParseFloat(string(cmd[i+2]), 64)
+			if err != nil {
+				return resp.NewErrorReply(resp.ErrPrefix, "need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return resp.NewErrorReply(resp.ErrPrefix, "height or width cannot be negative")
+			}
+			var errReply resp.RedisData
+			if conversion, errReply = parseGeoUnit(cmd[i+3]); errReply != nil {
+				return errReply
+			}
+			shape.box, shape.width, shape.height = true, width*conversion, height*conversion
+			byBox = true
+			i += 3
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+	if fromMember == nil && !fromLonLat {
+		return resp.NewErrorReplyf(resp.ErrPrefix, "exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmd[0])
+	}
+	if !byRadius && !byBox {
+		return resp.NewErrorReplyf(resp.ErrPrefix, "exactly one of BYRADIUS and BYBOX can be specified for %s", cmd[0])
+	}
+	if anyOne && count == 0 {
+		return resp.NewErrorReply(resp.ErrPrefix, "the ANY argument requires COUNT argument")
+	}
+	// the first COUNT locations are the closest, unless any will do
+	if count > 0 && sortDir == 0 && !anyOne {
+		sortDir = 1
+	}
+
+	dest, key := "", string(cmd[1])
+	if store {
+		dest, key = string(cmd[1]), string(cmd[2])
+		keys := []string{dest, key}
+		db.locks.MLock(keys)
+		defer db.locks.MUnLock(keys)
+	} else {
+		db.locks.RLock(key)
+		defer db.locks.RUnLock(key)
+	}
+
+	z, errReply := db.lookupZSet(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		if store {
+			db.deleteKey(dest, lazyfreeServerDel())
+			return resp.NewInteger(0)
+		}
+		return resp.NewArray([]resp.RedisData{})
+	}
+	if fromMember != nil {
+		score, ok := z.Score(string(fromMember))
+		if !ok {
+			return resp.NewErrorReply(resp.ErrPrefix, "could not decode requested zset member")
+		}
+		shape.long, shape.lat = geoDecodeScore(score)
+	}
+
+	limit := 0
+	if anyOne {
+		limit = int(count)
+	}
+	points := shape.search(z, limit)
+	if sortDir != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if sortDir > 0 {
+				return points[i].dist < points[j].dist
+			}
+			return points[i].dist > points[j].dist
+		})
+	}
+	if count > 0 && int64(len(points)) > count {
+		points = points[:count]
+	}
+
+	if store {
+		if len(points) == 0 {
+			db.deleteKey(dest, lazyfreeServerDel())
+			return resp.NewInteger(0)
+		}
+		res := NewZSet()
+		for _, p := range points {
+			score := p.score
+			if storeDist {
+				score = p.dist / conversion
+			}
+			res.Add(p.member, score)
+		}
+		_, existed := db.lookupKeyWrite(dest)
+		db.setKey(dest, res)
+		if existed {
+			db.DeleteExpire(dest)
+		}
+		return resp.NewInteger(int64(len(points)))
+	}
+
+	res := make([]resp.RedisData, 0, len(points))
+	for _, p := range points {
+		if !withDist && !withHash && !withCoord {
+			res = append(res, resp.NewBulkString([]byte(p.member)))
+			continue
+		}
+		item := []resp.RedisData{resp.NewBulkString([]byte(p.member))}
+		if withDist {
+			item = append(item, resp.NewBulkString(formatGeoDistance(p.dist, conversion)))
+		}
+		if withHash {
+			item = append(item, resp.NewInteger(int64(p.score)))
+		}
+		if withCoord {
+			item = append(item, resp.NewArray([]resp.RedisData{
+				resp.NewBulkString(formatGeoCoord(p.long)),
+				resp.NewBulkString(formatGeoCoord(p.lat)),
+			}))
+		}
+		res = append(res, resp.NewArray(item))
+	}
+	return resp.NewArray(res)
+}
+
+func RegisterGeoCommands() {
+	RegisterCommand("geoadd", geoAddGeo, 1, 1, 1)
+	RegisterCommand("geodist", geoDistGeo, 1, 1, 1)
+	RegisterCommand("geohash", geoHashGeo, 1, 1, 1)
+	RegisterCommand("geopos", geoPosGeo, 1, 1, 1)
+	RegisterCommand("geosearch", geoSearchGeo, 1, 1, 1)
+	RegisterCommand("geosearchstore", geoSearchStoreGeo, 1, 2, 1)
+}
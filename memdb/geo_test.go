@@ -0,0 +1,187 @@
+package memdb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func geoCommands() (*MemDb, func(args ...string) string) {
+	dbs, exec := keyCommands(1)
+	RegisterZSetCommands()
+	RegisterGeoCommands()
+	return dbs[0], func(args ...string) string { return exec(dbs[0], args...) }
+}
+
+// bulks is the reply of strings, nil for "".
+func bulks(strs ...string) string {
+	s := "*" + strconv.Itoa(len(strs)) + "\r\n"
+	for _, str := range strs {
+		if str == "" {
+			s += "$-1\r\n"
+			continue
+		}
+		s += "$" + strconv.Itoa(len(str)) + "\r\n" + str + "\r\n"
+	}
+	return s
+}
+
+// The expected replies are those of the examples in redis's documentation.
+func TestGeo(t *testing.T) {
+	_, exec := geoCommands()
+
+	palermo := bulks("13.36138933897018433", "38.11555639549629859")
+	catania := bulks("15.08726745843887329", "37.50266842333162032")
+	edge1 := bulks("12.7584877610206604", "38.78813451624225195")
+	edge2 := bulks("17.24151045083999634", "38.78813451624225195")
+	for _, c := range []struct{ args, want string }{
+		{"geoadd Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", ":2\r\n"},
+		{"type Sicily", "+zset\r\n"},
+		{"zscore Sicily Palermo", "$16\r\n3479099956230698\r\n"},
+		{"geodist Sicily Palermo Catania", "$11\r\n166274.1516\r\n"},
+		{"geodist Sicily Palermo Catania km", "$8\r\n166.2742\r\n"},
+		{"geodist Sicily Palermo Catania MI", "$8\r\n103.3182\r\n"},
+		{"geodist Sicily Foo Bar", "$-1\r\n"},
+		{"geohash Sicily Palermo Catania nosuchmember", bulks("sqc8b49rny0", "sqdtr74hyu0", "")},
+		{"geopos Sicily Palermo Catania NonExisting", "*3\r\n" + palermo + catania + "*-1\r\n"},
+		{"geopos nosuchkey a", "*1\r\n*-1\r\n"},
+
+		{"geoadd Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2", ":2\r\n"},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC", bulks("Catania", "Palermo")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHCOORD WITHDIST", "*4\r\n" +
+			"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n" + catania +
+			"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n" + palermo +
+			"*3\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n" + edge2 +
+			"*3\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n" + edge1},
+		{"geosearch Sicily FROMMEMBER Palermo BYRADIUS 200 km DESC COUNT 2", bulks("Catania", "edge1")},
+		{"geosearch Sicily FROMMEMBER Palermo BYRADIUS 1 m WITHHASH", "*1\r\n*2\r\n$7\r\nPalermo\r\n:3479099956230698\r\n"},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 10 km", "*0\r\n"},
+		{"geosearch nosuchkey FROMLONLAT 15 37 BYRADIUS 10 km", "*0\r\n"},
+
+		{"geosearchstore key1 Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3", ":3\r\n"},
+		{"geosearch key1 FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHDIST WITHHASH", "*3\r\n" +
+			"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n:3479447370796909\r\n" +
+			"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n:3479099956230698\r\n" +
+			"*3\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n:3481342659049484\r\n"},
+		{"geosearchstore key2 Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3 STOREDIST", ":3\r\n"},
+		{"zscore key2 Catania", "$18\r\n56.441257870158204\r\n"},
+		{"geosearchstore key2 Sicily FROMLONLAT 15 37 BYRADIUS 10 km", ":0\r\n"},
+		{"exists key2", ":0\r\n"},
+
+		// NX, XX and CH
+		{"geoadd Sicily NX 13 38 Palermo 14 38 Messina", ":1\r\n"},
+		{"geoadd Sicily XX CH 13 38 Palermo 14 38 Enna", ":1\r\n"},
+		{"geoadd Sicily CH 13 38 Palermo 13.5 38 Messina", ":1\r\n"},
+		{"geoadd new XX 13 38 Palermo", ":0\r\n"},
+		{"exists new", ":0\r\n"},
+		{"zrem Sicily Messina Enna", ":1\r\n"},
+		{"zcard Sicily", ":4\r\n"},
+		{"zrem key1 Catania Palermo edge2", ":3\r\n"},
+		{"exists key1", ":0\r\n"},
+	} {
+		if r := exec(strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+
+	exec("set", "str", "s")
+	for _, args := range []string{
+		"geoadd k 1 2", "geoadd k NX XX 1 2 m", "geoadd k 181 0 m", "geoadd k 0 86 m", "geoadd k x 0 m",
+		"geodist Sicily Palermo Catania yards", "geodist Sicily Palermo Catania km x",
+		"geosearch Sicily BYRADIUS 1 km", "geosearch Sicily FROMLONLAT 15 37",
+		"geosearch Sicily FROMLONLAT 15 37 FROMMEMBER Palermo BYRADIUS 1 km",
+		"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 1 km BYBOX 1 1 km",
+		"geosearch Sicily FROMLONLAT 15 37 BYRADIUS -1 km", "geosearch Sicily FROMLONLAT 15 37 BYBOX 1 -1 km",
+		"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 1 km COUNT 0", "geosearch Sicily FROMLONLAT 15 37 BYRADIUS 1 km ANY",
+		"geosearch Sicily FROMMEMBER nosuchmember BYRADIUS 1 km", "geosearch Sicily FROMLONLAT 15 37 BYRADIUS 1 km STOREDIST",
+		"geosearchstore d Sicily FROMLONLAT 15 37 BYRADIUS 1 km WITHDIST",
+	} {
+		if r := exec(strings.Fields(args)...); !strings.HasPrefix(r, "-ERR") {
+			t.Error(fmt.Sprintf("%s == %q, expect an error", args, r))
+		}
+	}
+	for _, args := range []string{"geoadd str 1 2 m", "geopos str m", "geosearch str FROMLONLAT 1 2 BYRADIUS 1 m", "zcard str"} {
+		if r := exec(strings.Fields(args)...); !strings.HasPrefix(r, "-WRONGTYPE") {
+			t.Error(fmt.Sprintf("%s == %q, expect WRONGTYPE", args, r))
+		}
+	}
+	if r := exec("object", "encoding", "Sicily"); r != "$8\r\nskiplist\r\n" {
+		t.Error(fmt.Sprintf("OBJECT ENCODING == %q", r))
+	}
+}
+
+// checkGeoSearch checks the search of s in z against a scan of every location.
+func checkGeoSearch(t *testing.T, z *ZSet, s geoShape) {
+	t.Helper()
+	var want []string
+	z.scan(func(member string, score float64) bool {
+		if _, ok := s.contains(geoDecodeScore(score)); ok {
+			want = append(want, member)
+		}
+		return true
+	})
+	var got []string
+	for _, p := range s.search(z, 0) {
+		got = append(got, p.member)
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatal(fmt.Sprintf("search of %+v found %d locations, expect %d", s, len(got), len(want)))
+	}
+}
+
+// TestGeoSearchCells checks the searches against a scan of every location, around the world.
+func TestGeoSearchCells(t *testing.T) {
+	// seeded, so a failure can be reproduced
+	rng := rand.New(rand.NewSource(1))
+	z := NewZSet()
+	for i := 0; i < 10000; i++ {
+		long := rng.Float64()*360 - 180
+		lat := rng.Float64()*(2*geoLatMax) - geoLatMax
+		z.Add(strconv.Itoa(i), geoEncodeScore(long, lat))
+	}
+
+	for i := 0; i < 1000; i++ {
+		s := geoShape{long: rng.Float64()*360 - 180, lat: rng.Float64()*170 - 85}
+		size := math.Pow(10, 2+rng.Float64()*5) // 100m to 10000km
+		if i%2 == 0 {
+			s.radius = size
+		} else {
+			s.box, s.width, s.height = true, size, size*(0.5+rng.Float64())
+		}
+		checkGeoSearch(t, z, s)
+	}
+
+	// a box near the pole reaching across the antimeridian, past the cells around its center
+	z = NewZSet()
+	for long := -180.0; long < 180; long += 0.5 {
+		for lat := 70.0; lat <= 85; lat += 0.25 {
+			z.Add(strconv.FormatFloat(long, 'f', -1, 64)+","+strconv.FormatFloat(lat, 'f', -1, 64), geoEncodeScore(long, lat))
+		}
+	}
+	checkGeoSearch(t, z, geoShape{long: 91.609, lat: 79.417, box: true, width: 2018000, height: 1186000})
+	checkGeoSearch(t, z, geoShape{long: -91.609, lat: 79.417, box: true, width: 2018000, height: 1186000})
+	checkGeoSearch(t, z, geoShape{long: 179.5, lat: 80, radius: 300000})
+
+	// locations and centers right on the edges of the map are within their cells
+	z = NewZSet()
+	edges := [][2]float64{{180, 0}, {-180, 0}, {179.9, 0}, {-179.9, 0}, {180, geoLatMax}, {-180, geoLatMin},
+		{10, geoLatMax}, {10, geoLatMin}, {10, 85.05}, {180, -45}}
+	for _, e := range edges {
+		hash, _ := geohashEncode(geoLongRange, geoLatRange, e[0], e[1], geoStepMax)
+		if area := geohashDecode(geoLongRange, geoLatRange, hash); hash.bits >= 1<<(2*geoStepMax) || e[0] < area.long.min || e[0] > area.long.max ||
+			e[1] < area.lat.min || e[1] > area.lat.max {
+			t.Error(fmt.Sprintf("%v is encoded in the cell %+v", e, area))
+		}
+		z.Add(fmt.Sprint(e), geoEncodeScore(e[0], e[1]))
+	}
+	for _, e := range edges {
+		checkGeoSearch(t, z, geoShape{long: e[0], lat: e[1], radius: 50000})
+		checkGeoSearch(t, z, geoShape{long: e[0], lat: e[1], box: true, width: 100000, height: 100000})
+	}
+}
@@ -0,0 +1,196 @@
+package memdb
+
+import "math"
+
+// Locations are stored as redis stores them: sorted set members scored by a 52-bit geohash,
+// 26 bits of latitude and 26 of longitude interleaved, so that nearby locations have nearby scores.
+// Latitudes are limited to those of Web Mercator, as in redis.
+// A geohash cell of step n is the 2n-bit prefix of the hashes of the locations it contains,
+// so a cell covers a range of scores; searching an area walks the cells covering it.
+
+const (
+	geoStepMax = 26
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLongMin = -180
+	geoLongMax = 180
+
+	earthRadius = 6372797.560856                     // meters, as redis
+	mercatorMax = 20037726.37                        // half the circumference of the earth in Web Mercator, meters
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz" // the base32 of standard geohashes
+)
+
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+type geoRange struct {
+	min, max float64
+}
+
+// geoArea is the cell of a geohash.
+type geoArea struct {
+	long, lat geoRange
+}
+
+var (
+	geoLongRange = geoRange{geoLongMin, geoLongMax}
+	geoLatRange  = geoRange{geoLatMin, geoLatMax}
+)
+
+// interleave64 spreads the bits of x over the even bits of the result, and those of y over the odd bits.
+func interleave64(x, y uint32) uint64 {
+	spread := func(v uint64) uint64 {
+		v = (v | v<<16) & 0x0000ffff0000ffff
+		v = (v | v<<8) & 0x00ff00ff00ff00ff
+		v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+		v = (v | v<<2) & 0x3333333333333333
+		return (v | v<<1) & 0x5555555555555555
+	}
+	return spread(uint64(x)) | spread(uint64(y))<<1
+}
+
+// deinterleave64 undoes interleave64.
+func deinterleave64(v uint64) (x, y uint32) {
+	squash := func(v uint64) uint32 {
+		v &= 0x5555555555555555
+		v = (v | v>>1) & 0x3333333333333333
+		v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+		v = (v | v>>4) & 0x00ff00ff00ff00ff
+		v = (v | v>>8) & 0x0000ffff0000ffff
+		return uint32(v | v>>16)
+	}
+	return squash(v), squash(v >> 1)
+}
+
+// geohashEncode returns the geohash of step bits per coordinate of a location, or false if it's out of range.
+func geohashEncode(longRange, latRange geoRange, long, lat float64, step uint) (geoHashBits, bool) {
+	if !(long >= geoLongMin && long <= geoLongMax && lat >= geoLatMin && lat <= geoLatMax) ||
+		long < longRange.min || long > longRange.max || lat < latRange.min || lat > latRange.max {
+		return geoHashBits{}, false
+	}
+	cells := float64(uint64(1) << step)
+	// a location on the max edge belongs to the last cell, not to one past it
+	latOffset := math.Min((lat-latRange.min)/(latRange.max-latRange.min)*cells, cells-1)
+	longOffset := math.Min((long-longRange.min)/(longRange.max-longRange.min)*cells, cells-1)
+	return geoHashBits{interleave64(uint32(latOffset), uint32(longOffset)), step}, true
+}
+
+// geohashDecode returns the cell of a geohash.
+func geohashDecode(longRange, latRange geoRange, hash geoHashBits) geoArea {
+	lat, long := deinterleave64(hash.bits)
+	cells := float64(uint64(1) << hash.step)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	return geoArea{
+		lat:  geoRange{latRange.min + float64(lat)/cells*latScale, latRange.min + float64(lat+1)/cells*latScale},
+		long: geoRange{longRange.min + float64(long)/cells*longScale, longRange.min + float64(long+1)/cells*longScale},
+	}
+}
+
+// center returns the middle of the cell, within the coordinate limits.
+func (a geoArea) center() (long, lat float64) {
+	long = math.Max(geoLongMin, math.Min(geoLongMax, (a.long.min+a.long.max)/2))
+	lat = math.Max(geoLatMin, math.Min(geoLatMax, (a.lat.min+a.lat.max)/2))
+	return
+}
+
+// geoEncodeScore returns the score of a location, which must be within the limits.
+func geoEncodeScore(long, lat float64) float64 {
+	hash, _ := geohashEncode(geoLongRange, geoLatRange, long, lat, geoStepMax)
+	return float64(hash.bits)
+}
+
+// geoDecodeScore returns the location of a score: the center of its cell.
+func geoDecodeScore(score float64) (long, lat float64) {
+	return geohashDecode(geoLongRange, geoLatRange, geoHashBits{uint64(score), geoStepMax}).center()
+}
+
+// geohashString returns the standard 11 character geohash of a score, as GEOHASH replies.
+// Standard geohashes span latitudes -90 to 90, so the location is encoded again.
+func geohashString(score float64) []byte {
+	long, lat := geoDecodeScore(score)
+	hash, _ := geohashEncode(geoRange{-180, 180}, geoRange{-90, 90}, long, lat, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// 52 bits make 10 characters and 2 bits, the last one is padded with zeros
+		if i < 10 {
+			idx = int(hash.bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return buf
+}
+
+// geoMove returns the neighbor cell dlong columns east and dlat rows north, wrapping around.
+func geoMove(hash geoHashBits, dlong, dlat int) geoHashBits {
+	// longitude on the odd bits, latitude on the even bits
+	const oddBits, evenBits = 0xaaaaaaaaaaaaaaaa, 0x5555555555555555
+	move := func(bits uint64, mask uint64, d int) uint64 {
+		if d == 0 {
+			return bits & mask
+		}
+		v := bits & mask
+		// the other coordinate's bits, set to make the carry or borrow cross them
+		others := ^mask >> (64 - hash.step*2)
+		if d > 0 {
+			v += others + 1
+		} else {
+			v = (v | others) - (others + 1)
+		}
+		return v & (mask >> (64 - hash.step*2))
+	}
+	return geoHashBits{move(hash.bits, oddBits, dlong) | move(hash.bits, evenBits, dlat), hash.step}
+}
+
+func degRad(d float64) float64 { return d * (math.Pi / 180) }
+func radDeg(r float64) float64 { return r / (math.Pi / 180) }
+
+// geoLatDistance returns the distance in meters along a meridian between two latitudes.
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// geoDistance returns the great circle distance in meters between two locations, by the haversine formula.
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, long1r := degRad(lat1), degRad(long1)
+	lat2r, long2r := degRad(lat2), degRad(long2)
+	v := math.Sin((long2r - long1r) / 2)
+	// along a meridian, skip the expensive part
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoEstimateStep returns the geohash step whose cells are about as big as a search of radius meters at lat needs.
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// make sure the radius is covered in most cases
+	step -= 2
+	// cells narrow towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
@@ -1,6 +1,7 @@
 package memdb
 
 import (
+	"bytes"
 	"gRedis/resp"
 	"strconv"
 	"strings"
@@ -8,20 +9,16 @@ import (
 
 func hDelHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -29,7 +26,7 @@ func hDelHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	// if hash table is empty, delete it
@@ -51,20 +48,16 @@ func hDelHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hExistsHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -72,7 +65,7 @@ func hExistsHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	field := string(cmd[2])
@@ -85,20 +78,16 @@ func hExistsHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hGetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewBulkString(nil)
 	}
@@ -106,7 +95,7 @@ func hGetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	field := string(cmd[2])
@@ -117,21 +106,16 @@ func hGetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hGetAllHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		// an empty list when key does not exist.
-		return resp.NewArray([]resp.RedisData{})
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		// an empty list when key does not exist.
 		return resp.NewArray([]resp.RedisData{})
@@ -140,52 +124,51 @@ func hGetAllHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
-	res := make([]resp.RedisData, 0, h.Len())
-	for k, v := range h.table {
-		res = append(res, resp.NewBulkString([]byte(k)))
-		res = append(res, resp.NewBulkString(v))
-	}
+	res := make([]resp.RedisData, 0, 2*h.Len())
+	h.Scan(func(field string, val []byte) bool {
+		res = append(res, resp.NewBulkString([]byte(field)))
+		res = append(res, resp.NewBulkString(bytes.Clone(val)))
+		return true
+	})
 
 	return resp.NewArray(res)
 }
 
 func hIncrByHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// If key does not exist, a new key holding a hash is created.
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewHash()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	}
 
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	field := string(cmd[2])
 	increment, err := strconv.Atoi(string(cmd[3]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	val, ok := h.IncrBy(field, increment)
 	if !ok {
-		return resp.NewSimpleError("hash value is not an integer")
+		return resp.NewErrorReply(resp.ErrPrefix, "hash value is not an integer")
 	}
 
 	return resp.NewInteger(int64(val))
@@ -193,39 +176,37 @@ func hIncrByHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hIncrByFloatHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// If key does not exist, a new key holding a hash is created.
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewHash()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 
 	}
 
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	field := string(cmd[2])
 	increment, err := strconv.ParseFloat(string(cmd[3]), 64)
 	if err != nil {
-		return resp.NewSimpleError("value is not an float")
+		return resp.NewNotFloatError()
 	}
 
 	val, ok := h.IncrByFloat(field, increment)
 	if !ok {
-		return resp.NewSimpleError("hash value is not an float")
+		return resp.NewErrorReply(resp.ErrPrefix, "hash value is not a float")
 	}
 
 	return resp.NewBulkString([]byte(strconv.FormatFloat(val, 'f', -1, 64)))
@@ -233,21 +214,16 @@ func hIncrByFloatHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hKeysHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		// an empty list when key does not exist.
-		return resp.NewArray([]resp.RedisData{})
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		// an empty list when key does not exist.
 		return resp.NewArray([]resp.RedisData{})
@@ -256,7 +232,7 @@ func hKeysHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	keys := h.Keys()
@@ -270,20 +246,16 @@ func hKeysHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hLenHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -291,7 +263,7 @@ func hLenHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	return resp.NewInteger(int64(h.Len()))
@@ -299,21 +271,16 @@ func hLenHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hMGetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		// an empty list when key does not exist.
-		return resp.NewArray([]resp.RedisData{})
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		// an empty list when key does not exist.
 		return resp.NewArray([]resp.RedisData{})
@@ -322,7 +289,7 @@ func hMGetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	res := make([]resp.RedisData, 0, len(cmd)-2)
@@ -337,32 +304,30 @@ func hMGetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hMSetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 4 || len(cmd)&1 == 1 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// If key does not exist, a new key holding a hash is created.
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewHash()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	}
 
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	for i := 2; i < len(cmd); i += 2 {
 		field := string(cmd[i])
-		val := cmd[i+1]
+		val := bytes.Clone(cmd[i+1])
 		h.Set(field, val)
 	}
 
@@ -371,33 +336,31 @@ func hMSetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hSetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 4 || len(cmd)&1 == 1 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// If key does not exist, a new key holding a hash is created.
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewHash()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	}
 
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	res := 0
 	for i := 2; i < len(cmd); i += 2 {
 		field := string(cmd[i])
-		val := cmd[i+1]
+		val := bytes.Clone(cmd[i+1])
 		res += h.Set(field, val)
 	}
 
@@ -406,21 +369,19 @@ func hSetHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hSetNxHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// If key does not exist, a new key holding a hash is created.
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewHash()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	} else {
 		// 0 if the field already exists in the hash and no operation was performed.
 		return resp.NewInteger(0)
@@ -429,11 +390,11 @@ func hSetNxHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	field := string(cmd[2])
-	val := cmd[3]
+	val := bytes.Clone(cmd[3])
 	h.Set(field, val)
 
 	return resp.NewInteger(1)
@@ -441,21 +402,16 @@ func hSetNxHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hValsHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		// an empty list when key does not exist.
-		return resp.NewArray([]resp.RedisData{})
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		// an empty list when key does not exist.
 		return resp.NewArray([]resp.RedisData{})
@@ -464,7 +420,7 @@ func hValsHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	vals := h.Values()
@@ -478,20 +434,16 @@ func hValsHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hStrLenHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -499,7 +451,7 @@ func hStrLenHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	field := string(cmd[2])
@@ -508,14 +460,10 @@ func hStrLenHash(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func hRandFieldHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 2 || len(cmd) > 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 	var withvalues bool
 	var err error
 	count := 1
@@ -523,13 +471,13 @@ func hRandFieldHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) >= 3 {
 		count, err = strconv.Atoi(string(cmd[2]))
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 	}
 
 	if len(cmd) == 4 {
 		if strings.ToLower(string(cmd[3])) != "withvalues" {
-			return resp.NewSimpleError("syntax error")
+			return resp.NewSyntaxError()
 		} else {
 			withvalues = true
 		}
@@ -539,7 +487,7 @@ func hRandFieldHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewBulkString(nil)
 	}
@@ -547,7 +495,7 @@ func hRandFieldHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	h, ok := v.(*Hash)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	//  a single, randomly selected field when the count option is not used
@@ -574,20 +522,464 @@ func hRandFieldHash(db *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.NewArray(res)
 }
 
+// Field expiration, as redis 7.4's HEXPIRE family and redis 8's HGETEX, HSETEX and HGETDEL.
+// Expire times are kept in unix milliseconds, up to hashMaxExpireTime.
+
+// hashMaxExpireTime is the latest time a field may expire at, in unix milliseconds, as redis's HFE_MAX_ABS_TIME_MSEC.
+const hashMaxExpireTime = 1<<48 - 1
+
+// hashFieldsArg parses FIELDS numfields at cmd[i:], followed by the arguments of numfields fields
+// of width arguments each, and returns those arguments.
+func hashFieldsArg(cmd [][]byte, i, width int) ([][]byte, resp.RedisData) {
+	if i >= len(cmd) || !strings.EqualFold(string(cmd[i]), "fields") {
+		return nil, resp.NewErrorReply(resp.ErrPrefix, "Mandatory argument FIELDS is missing or not at the right position")
+	}
+	if i+1 >= len(cmd) {
+		return nil, resp.NewWrongArgsError(cmd[0])
+	}
+	n, err := strconv.Atoi(string(cmd[i+1]))
+	if err != nil || n <= 0 {
+		return nil, resp.NewErrorReply(resp.ErrPrefix, "Number of fields must be a positive integer")
+	}
+	if n > len(cmd) || n*width != len(cmd)-i-2 {
+		return nil, resp.NewErrorReply(resp.ErrPrefix, "The `numfields` parameter must match the number of arguments")
+	}
+	return cmd[i+2:], nil
+}
+
+// hashExpireTime parses an expire time given in units of unit milliseconds, after base,
+// and returns it in unix milliseconds.
+func hashExpireTime(name, arg []byte, unit, base int64) (int64, resp.RedisData) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, resp.NewNotIntegerError()
+	}
+	if n < 0 {
+		return 0, resp.NewErrorReply(resp.ErrPrefix, "invalid expire time, must be >= 0")
+	}
+	if n > hashMaxExpireTime/unit || n*unit+base > hashMaxExpireTime {
+		return 0, resp.NewInvalidExpireError(name)
+	}
+	return n*unit + base, nil
+}
+
+// hashExpireOption parses EX, PX, EXAT or PXAT at cmd[i], with its time at cmd[i+1];
+// ok is false if cmd[i] is none of them.
+func hashExpireOption(cmd [][]byte, i int) (expireAt int64, ok bool, errReply resp.RedisData) {
+	var unit, base int64
+	switch strings.ToLower(string(cmd[i])) {
+	case "ex":
+		unit, base = 1000, hashNow()
+	case "px":
+		unit, base = 1, hashNow()
+	case "exat":
+		unit = 1000
+	case "pxat":
+		unit = 1
+	default:
+		return 0, false, nil
+	}
+	if i+1 >= len(cmd) {
+		return 0, true, resp.NewSyntaxError()
+	}
+	expireAt, errReply = hashExpireTime(cmd[0], cmd[i+1], unit, base)
+	return expireAt, true, errReply
+}
+
+// repeatReply returns an array of n times r, the reply for fields of a missing key.
+func repeatReply(r resp.RedisData, n int) resp.RedisData {
+	res := make([]resp.RedisData, n)
+	for i := range res {
+		res[i] = r
+	}
+	return resp.NewArray(res)
+}
+
+// hashFieldsWritten deletes key if its hash lost its last field, and watches it if some of its fields expire.
+// The caller holds the key's write lock.
+func (db *MemDb) hashFieldsWritten(key string, h *Hash) {
+	if h.IsEmpty() {
+		db.deleteKey(key, false)
+		return
+	}
+	db.trackFieldExpiry(key, h)
+}
+
+// hashExpire runs HEXPIRE and its variants, for times in units of unit milliseconds, since the epoch if at.
+func hashExpire(db *MemDb, cmd [][]byte, unit int64, at bool) resp.RedisData {
+	if len(cmd) < 6 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	var base int64
+	if !at {
+		base = hashNow()
+	}
+	expireAt, errReply := hashExpireTime(cmd[0], cmd[2], unit, base)
+	if errReply != nil {
+		return errReply
+	}
+	i := 3
+	cond := strings.ToLower(string(cmd[3]))
+	switch cond {
+	case "nx", "xx", "gt", "lt":
+		i++
+	default:
+		cond = ""
+	}
+	fields, errReply := hashFieldsArg(cmd, i, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, ok := db.lookupKeyWrite(key)
+	if !ok {
+		return repeatReply(resp.NewInteger(-2), len(fields))
+	}
+	h, ok := v.(*Hash)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+
+	now := hashNow()
+	res := make([]resp.RedisData, 0, len(fields))
+	for _, f := range fields {
+		field := string(f)
+		// no ttl counts as an infinite one for GT and LT
+		cur := h.ExpireTime(field)
+		switch {
+		case cur == -2:
+			res = append(res, resp.NewInteger(-2))
+		case cond == "nx" && cur != -1, cond == "xx" && cur == -1,
+			cond == "gt" && (cur == -1 || expireAt <= cur), cond == "lt" && cur != -1 && expireAt >= cur:
+			res = append(res, resp.NewInteger(0))
+		case expireAt <= now:
+			// a time already passed deletes the field
+			h.Del(field)
+			res = append(res, resp.NewInteger(2))
+		default:
+			h.SetExpireTime(field, expireAt)
+			res = append(res, resp.NewInteger(1))
+		}
+	}
+	db.hashFieldsWritten(key, h)
+
+	return resp.NewArray(res)
+}
+
+func hExpireHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashExpire(db, cmd, 1000, false)
+}
+
+func hPExpireHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashExpire(db, cmd, 1, false)
+}
+
+func hExpireAtHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashExpire(db, cmd, 1000, true)
+}
+
+func hPExpireAtHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashExpire(db, cmd, 1, true)
+}
+
+// hashTTL runs HTTL and its variants, replying times in units of unit milliseconds, since the epoch if at.
+func hashTTL(db *MemDb, cmd [][]byte, unit int64, at bool) resp.RedisData {
+	if len(cmd) < 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	fields, errReply := hashFieldsArg(cmd, 2, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	v, ok := db.lookupKeyRead(key)
+	if !ok {
+		return repeatReply(resp.NewInteger(-2), len(fields))
+	}
+	h, ok := v.(*Hash)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+
+	now := hashNow()
+	res := make([]resp.RedisData, 0, len(fields))
+	for _, f := range fields {
+		t := h.ExpireTime(string(f))
+		if t >= 0 {
+			if !at {
+				t -= now
+			}
+			// rounded up, as the field lives until then
+			t = (t + unit - 1) / unit
+		}
+		res = append(res, resp.NewInteger(t))
+	}
+
+	return resp.NewArray(res)
+}
+
+func hTTLHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashTTL(db, cmd, 1000, false)
+}
+
+func hPTTLHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashTTL(db, cmd, 1, false)
+}
+
+func hExpireTimeHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashTTL(db, cmd, 1000, true)
+}
+
+func hPExpireTimeHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	return hashTTL(db, cmd, 1, true)
+}
+
+func hPersistHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	fields, errReply := hashFieldsArg(cmd, 2, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, ok := db.lookupKeyWrite(key)
+	if !ok {
+		return repeatReply(resp.NewInteger(-2), len(fields))
+	}
+	h, ok := v.(*Hash)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+
+	res := make([]resp.RedisData, 0, len(fields))
+	for _, f := range fields {
+		field := string(f)
+		switch {
+		case !h.Exist(field):
+			res = append(res, resp.NewInteger(-2))
+		case h.Persist(field):
+			res = append(res, resp.NewInteger(1))
+		default:
+			res = append(res, resp.NewInteger(-1))
+		}
+	}
+
+	return resp.NewArray(res)
+}
+
+// HGETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST] FIELDS numfields field...
+func hGetExHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	i := 2
+	persist := strings.EqualFold(string(cmd[2]), "persist")
+	expireAt, expire, errReply := hashExpireOption(cmd, 2)
+	if errReply != nil {
+		return errReply
+	}
+	if persist {
+		i++
+	} else if expire {
+		i += 2
+	}
+	fields, errReply := hashFieldsArg(cmd, i, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, ok := db.lookupKeyWrite(key)
+	if !ok {
+		return repeatReply(resp.NewBulkString(nil), len(fields))
+	}
+	h, ok := v.(*Hash)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+
+	now := hashNow()
+	res := make([]resp.RedisData, 0, len(fields))
+	for _, f := range fields {
+		field := string(f)
+		val := h.Get(field)
+		res = append(res, resp.NewBulkString(val))
+		switch {
+		case val == nil:
+		case persist:
+			h.Persist(field)
+		case expire && expireAt <= now:
+			// a time already passed deletes the field, after replying its value
+			h.Del(field)
+		case expire:
+			h.SetExpireTime(field, expireAt)
+		}
+	}
+	db.hashFieldsWritten(key, h)
+
+	return resp.NewArray(res)
+}
+
+// HSETEX key [FNX | FXX] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+// FIELDS numfields field value...
+func hSetExHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 6 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	var fnx, fxx, keepTTL, expire bool
+	var expireAt int64
+	i := 2
+	for ; i < len(cmd) && !strings.EqualFold(string(cmd[i]), "fields"); i++ {
+		at, ok, errReply := hashExpireOption(cmd, i)
+		if errReply != nil {
+			return errReply
+		}
+		switch opt := strings.ToLower(string(cmd[i])); {
+		case ok && !expire && !keepTTL:
+			expire, expireAt = true, at
+			i++
+		case opt == "keepttl" && !expire && !keepTTL:
+			keepTTL = true
+		case opt == "fnx" && !fnx && !fxx:
+			fnx = true
+		case opt == "fxx" && !fnx && !fxx:
+			fxx = true
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+	pairs, errReply := hashFieldsArg(cmd, i, 2)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, ok := db.lookupKeyWrite(key)
+	var h *Hash
+	if ok {
+		if h, ok = v.(*Hash); !ok {
+			return resp.NewWrongTypeError()
+		}
+	}
+	// FNX sets the fields if none exists, FXX if they all do
+	if fnx || fxx {
+		for j := 0; j < len(pairs); j += 2 {
+			if exists := h != nil && h.Exist(string(pairs[j])); exists != fxx {
+				return resp.NewInteger(0)
+			}
+		}
+	}
+	if h == nil {
+		h = NewHash()
+		db.setKey(key, h)
+	}
+
+	now := hashNow()
+	for j := 0; j < len(pairs); j += 2 {
+		field := string(pairs[j])
+		h.set(field, bytes.Clone(pairs[j+1]), keepTTL)
+		if expire && expireAt <= now {
+			h.Del(field)
+		} else if expire {
+			h.SetExpireTime(field, expireAt)
+		}
+	}
+	db.hashFieldsWritten(key, h)
+
+	return resp.NewInteger(1)
+}
+
+// HGETDEL key FIELDS numfields field...
+func hGetDelHash(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	fields, errReply := hashFieldsArg(cmd, 2, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, ok := db.lookupKeyWrite(key)
+	if !ok {
+		return repeatReply(resp.NewBulkString(nil), len(fields))
+	}
+	h, ok := v.(*Hash)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+
+	res := make([]resp.RedisData, 0, len(fields))
+	for _, f := range fields {
+		field := string(f)
+		val := h.Get(field)
+		if val != nil {
+			h.Del(field)
+		}
+		res = append(res, resp.NewBulkString(val))
+	}
+	db.hashFieldsWritten(key, h)
+
+	return resp.NewArray(res)
+}
+
 func RegisterHashCommands() {
-	RegisterCommand("hdel", hDelHash)
-	RegisterCommand("hexists", hExistsHash)
-	RegisterCommand("hget", hGetHash)
-	RegisterCommand("hgetall", hGetAllHash)
-	RegisterCommand("hincrby", hIncrByHash)
-	RegisterCommand("hincrbyfloat", hIncrByFloatHash)
-	RegisterCommand("hkeys", hKeysHash)
-	RegisterCommand("hlen", hLenHash)
-	RegisterCommand("hmget", hMGetHash)
-	RegisterCommand("hmset", hMSetHash)
-	RegisterCommand("hset", hSetHash)
-	RegisterCommand("hsetnx", hSetNxHash)
-	RegisterCommand("hvals", hValsHash)
-	RegisterCommand("hstrlen", hStrLenHash)
-	RegisterCommand("hrandfield", hRandFieldHash)
+	RegisterCommand("hdel", hDelHash, 1, 1, 1)
+	RegisterCommand("hexists", hExistsHash, 1, 1, 1)
+	RegisterCommand("hget", hGetHash, 1, 1, 1)
+	RegisterCommand("hgetall", hGetAllHash, 1, 1, 1)
+	RegisterCommand("hincrby", hIncrByHash, 1, 1, 1)
+	RegisterCommand("hincrbyfloat", hIncrByFloatHash, 1, 1, 1)
+	RegisterCommand("hkeys", hKeysHash, 1, 1, 1)
+	RegisterCommand("hlen", hLenHash, 1, 1, 1)
+	RegisterCommand("hmget", hMGetHash, 1, 1, 1)
+	RegisterCommand("hmset", hMSetHash, 1, 1, 1)
+	RegisterCommand("hset", hSetHash, 1, 1, 1)
+	RegisterCommand("hsetnx", hSetNxHash, 1, 1, 1)
+	RegisterCommand("hvals", hValsHash, 1, 1, 1)
+	RegisterCommand("hstrlen", hStrLenHash, 1, 1, 1)
+	RegisterCommand("hrandfield", hRandFieldHash, 1, 1, 1)
+	RegisterCommand("hexpire", hExpireHash, 1, 1, 1)
+	RegisterCommand("hpexpire", hPExpireHash, 1, 1, 1)
+	RegisterCommand("hexpireat", hExpireAtHash, 1, 1, 1)
+	RegisterCommand("hpexpireat", hPExpireAtHash, 1, 1, 1)
+	RegisterCommand("httl", hTTLHash, 1, 1, 1)
+	RegisterCommand("hpttl", hPTTLHash, 1, 1, 1)
+	RegisterCommand("hexpiretime", hExpireTimeHash, 1, 1, 1)
+	RegisterCommand("hpexpiretime", hPExpireTimeHash, 1, 1, 1)
+	RegisterCommand("hpersist", hPersistHash, 1, 1, 1)
+	RegisterCommand("hgetex", hGetExHash, 1, 1, 1)
+	RegisterCommand("hsetex", hSetExHash, 1, 1, 1)
+	RegisterCommand("hgetdel", hGetDelHash, 1, 1, 1)
 }
@@ -1,18 +1,120 @@
 package memdb
 
 import (
+	"bytes"
+	"gRedis/config"
+	"math/rand"
 	"strconv"
+	"time"
 )
 
+// Hash packs its fields and values into a listpack while it is small, like redis,
+// and converts to a map once it holds more than hash-max-listpack-entries fields
+// or a field or value longer than hash-max-listpack-value.
+//
+// Values returned by Hash are copies, as the listpack is rewritten in place.
+//
+// Fields may expire, as with HEXPIRE. An expired field is left in place until a writer deletes it,
+// but Hash acts as if it were gone.
 type Hash struct {
-	table map[string][]byte
+	lp      []byte // field, value, field, value... nil once converted
+	count   int    // fields in lp
+	table   map[string][]byte
+	expires map[string]int64 // unix ms expire times of the fields having one, nil if none
 }
 
 func NewHash() *Hash {
-	return &Hash{table: make(map[string][]byte)}
+	if hashMaxListpackEntries() == 0 {
+		return &Hash{table: make(map[string][]byte)}
+	}
+	return &Hash{}
+}
+
+func hashMaxListpackEntries() int {
+	if config.Conf == nil {
+		return 128
+	}
+	return config.Conf.HashMaxListpackEntries
 }
 
+func hashMaxListpackValue() int {
+	if config.Conf == nil {
+		return 64
+	}
+	return config.Conf.HashMaxListpackValue
+}
+
+func (h *Hash) Encoding() string {
+	if h.table != nil {
+		return encodingHashtable
+	}
+	if h.expires != nil {
+		return encodingListpackEx
+	}
+	return encodingListpack
+}
+
+// hashNow is the clock fields expire by, in unix milliseconds.
+func hashNow() int64 {
+	return time.Now().UnixMilli()
+}
+
+// expired reports whether field has expired at now, in unix milliseconds.
+func (h *Hash) expired(field string, now int64) bool {
+	if h.expires == nil {
+		return false
+	}
+	at, ok := h.expires[field]
+	return ok && at < now
+}
+
+// find returns the offset of field in the listpack, or -1.
+func (h *Hash) find(field string) int {
+	return lpFind(h.lp, 0, []byte(field), 1)
+}
+
+func (h *Hash) convert() {
+	h.table = make(map[string][]byte, h.count)
+	for off := 0; off < len(h.lp); {
+		var field, val []byte
+		field, off = lpGet(h.lp, off)
+		val, off = lpGet(h.lp, off)
+		h.table[string(field)] = append([]byte(nil), val...)
+	}
+	h.lp, h.count = nil, 0
+}
+
+// Set sets a field, removing its expire time, and returns 1 if the field is new.
 func (h *Hash) Set(key string, v []byte) int {
+	return h.set(key, v, false)
+}
+
+// set sets a field, keeping its expire time if keepTTL, as HINCRBY does.
+func (h *Hash) set(key string, v []byte, keepTTL bool) int {
+	if at, ok := h.expires[key]; ok {
+		if at < hashNow() {
+			// an expired field is replaced by a new one
+			h.del(key)
+		} else if !keepTTL {
+			h.deleteExpire(key)
+		}
+	}
+	if h.table == nil {
+		if off := h.find(key); off >= 0 {
+			_, off = lpGet(h.lp, off)
+			if len(v) <= hashMaxListpackValue() {
+				h.lp = lpReplace(h.lp, off, v)
+				return 0
+			}
+		} else if h.count < hashMaxListpackEntries() && len(key) <= hashMaxListpackValue() && len(v) <= hashMaxListpackValue() {
+			h.lp = lpAppendEntry(h.lp, []byte(key))
+			h.lp = lpAppendEntry(h.lp, v)
+			h.count++
+			return 1
+		}
+		h.convert()
+	}
+
 	added := 0
 	if !h.Exist(key) {
 		added = 1
@@ -22,10 +124,43 @@ func (h *Hash) Set(key string, v []byte) int {
 }
 
 func (h *Hash) Get(key string) []byte {
-	return h.table[key]
+	if h.expired(key, hashNow()) {
+		return nil
+	}
+	if h.table != nil {
+		return h.table[key]
+	}
+	off := h.find(key)
+	if off < 0 {
+		return nil
+	}
+	_, off = lpGet(h.lp, off)
+	val, _ := lpGet(h.lp, off)
+	return append([]byte{}, val...)
 }
 
 func (h *Hash) Del(key string) int {
+	if h.expired(key, hashNow()) {
+		h.del(key)
+		return 0
+	}
+	return h.del(key)
+}
+
+// del deletes a field, expired or not.
+func (h *Hash) del(key string) int {
+	h.deleteExpire(key)
+	if h.table == nil {
+		off := h.find(key)
+		if off < 0 {
+			return 0
+		}
+		_, end := lpGet(h.lp, off)
+		_, end = lpGet(h.lp, end)
+		h.lp = lpDelete(h.lp, off, end)
+		h.count--
+		return 1
+	}
 	if _, ok := h.table[key]; !ok {
 		return 0
 	}
@@ -34,44 +169,117 @@ func (h *Hash) Del(key string) int {
 }
 
 func (h *Hash) Len() int {
-	return len(h.table)
+	n := len(h.table)
+	if h.table == nil {
+		n = h.count
+	}
+	if h.expires != nil {
+		now := hashNow()
+		for _, at := range h.expires {
+			if at < now {
+				n--
+			}
+		}
+	}
+	return n
 }
 
 func (h *Hash) Exist(key string) bool {
+	if h.expired(key, hashNow()) {
+		return false
+	}
+	if h.table == nil {
+		return h.find(key) >= 0
+	}
 	_, ok := h.table[key]
 	return ok
 }
 
+// Scan calls fn with each field and value until fn returns false. val is only valid during the call.
+func (h *Hash) Scan(fn func(field string, val []byte) bool) {
+	var now int64
+	if h.expires != nil {
+		now = hashNow()
+	}
+	if h.table != nil {
+		for field, val := range h.table {
+			if h.expired(field, now) {
+				continue
+			}
+			if !fn(field, val) {
+				return
+			}
+		}
+		return
+	}
+	for off := 0; off < len(h.lp); {
+		var field, val []byte
+		field, off = lpGet(h.lp, off)
+		val, off = lpGet(h.lp, off)
+		if h.expired(string(field), now) {
+			continue
+		}
+		if !fn(string(field), val) {
+			return
+		}
+	}
+}
+
 func (h *Hash) Keys() []string {
 	res := make([]string, 0, h.Len())
-	for key := range h.table {
-		res = append(res, key)
-	}
+	h.Scan(func(field string, _ []byte) bool {
+		res = append(res, field)
+		return true
+	})
 	return res
 }
 
 func (h *Hash) Values() [][]byte {
 	res := make([][]byte, 0, h.Len())
-	for _, val := range h.table {
+	h.Scan(func(_ string, val []byte) bool {
+		if h.table == nil {
+			val = append([]byte{}, val...)
+		}
 		res = append(res, val)
-	}
+		return true
+	})
 	return res
 }
 
 func (h *Hash) Clear() {
-	h.table = make(map[string][]byte)
+	*h = *NewHash()
+}
+
+// Clone returns a deep copy of h, in the same encoding.
+func (h *Hash) Clone() *Hash {
+	res := &Hash{count: h.count}
+	if h.lp != nil {
+		res.lp = bytes.Clone(h.lp)
+	}
+	if h.table != nil {
+		res.table = make(map[string][]byte, len(h.table))
+		for field, val := range h.table {
+			res.table[field] = bytes.Clone(val)
+		}
+	}
+	if h.expires != nil {
+		res.expires = make(map[string]int64, len(h.expires))
+		for field, at := range h.expires {
+			res.expires[field] = at
+		}
+	}
+	return res
 }
 
 func (h *Hash) StrLen(key string) int {
+	if h.table == nil || h.expires != nil {
+		return len(h.Get(key))
+	}
 	return len(h.table[key])
 }
 
 func (h *Hash) IsEmpty() bool {
-	return len(h.table) == 0
-}
-
-func (h *Hash) Table() map[string][]byte {
-	return h.table
+	return h.Len() == 0
 }
 
 func (h *Hash) IncrBy(key string, increment int) (int, bool) {
@@ -85,7 +293,7 @@ func (h *Hash) IncrBy(key string, increment int) (int, bool) {
 			return 0, false
 		}
 		value := n + increment
-		h.Set(key, []byte(strconv.Itoa(value)))
+		h.set(key, []byte(strconv.Itoa(value)), true)
 		return value, true
 	}
 }
@@ -101,18 +309,59 @@ func (h *Hash) IncrByFloat(key string, increment float64) (float64, bool) {
 			return 0, false
 		}
 		value := f + increment
-		h.Set(key, []byte(strconv.FormatFloat(value, 'f', -1, 64)))
+		h.set(key, []byte(strconv.FormatFloat(value, 'f', -1, 64)), true)
 		return value, true
 	}
 }
 
+// randomPairs returns count random fields of a listpack hash, and their values if withValues is set.
+func (h *Hash) randomPairs(count int, withValues bool) ([]string, [][]byte) {
+	var picks []int
+	if count > 0 {
+		if count > h.count {
+			count = h.count
+		}
+		// If the provided count argument is positive, return an array of distinct fields.
+		picks = rand.Perm(h.count)[:count]
+	} else {
+		// If called with a negative count, the behavior changes and the command is allowed to return the same field multiple times.
+		picks = make([]int, -count)
+		for i := range picks {
+			picks[i] = rand.Intn(h.count)
+		}
+	}
+
+	keys := make([]string, 0, len(picks))
+	var vals [][]byte
+	if withValues {
+		vals = make([][]byte, 0, len(picks))
+	}
+	for _, i := range picks {
+		field, off := lpGet(h.lp, lpSeek(h.lp, 2*h.count, 2*i))
+		keys = append(keys, string(field))
+		if withValues {
+			val, _ := lpGet(h.lp, off)
+			vals = append(vals, append([]byte{}, val...))
+		}
+	}
+	return keys, vals
+}
+
 func (h *Hash) Random(count int) []string {
+	if h.expires != nil && h.Len() < h.physicalLen() {
+		return h.live().Random(count)
+	}
 	var keys []string
 
 	if count == 0 || h.Len() == 0 {
 		return make([]string, 0)
 	}
 
+	if h.table == nil {
+		keys, _ = h.randomPairs(count, false)
+		return keys
+	}
+
 	if count > 0 {
 		if count > h.Len() {
 			count = h.Len()
@@ -145,6 +394,9 @@ func (h *Hash) Random(count int) []string {
 }
 
 func (h *Hash) RandomWithValue(count int) ([]string, [][]byte) {
+	if h.expires != nil && h.Len() < h.physicalLen() {
+		return h.live().RandomWithValue(count)
+	}
 	var keys []string
 	var vals [][]byte
 
@@ -152,6 +404,10 @@ func (h *Hash) RandomWithValue(count int) ([]string, [][]byte) {
 		return make([]string, 0), make([][]byte, 0)
 	}
 
+	if h.table == nil {
+		return h.randomPairs(count, true)
+	}
+
 	if count > 0 {
 		if count > h.Len() {
 			count = h.Len()
@@ -186,3 +442,71 @@ func (h *Hash) RandomWithValue(count int) ([]string, [][]byte) {
 
 	return keys, vals
 }
+
+// physicalLen returns the number of fields, expired ones included.
+func (h *Hash) physicalLen() int {
+	if h.table == nil {
+		return h.count
+	}
+	return len(h.table)
+}
+
+// live returns a copy of h without its expired fields.
+func (h *Hash) live() *Hash {
+	res := h.Clone()
+	res.removeExpired(hashNow())
+	return res
+}
+
+// ExpireTime returns the unix ms expire time of field, -1 if it has none, or -2 if there's no such field.
+func (h *Hash) ExpireTime(field string) int64 {
+	if !h.Exist(field) {
+		return -2
+	}
+	if at, ok := h.expires[field]; ok {
+		return at
+	}
+	return -1
+}
+
+// SetExpireTime sets the unix ms expire time of an existing field.
+func (h *Hash) SetExpireTime(field string, at int64) {
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	h.expires[field] = at
+}
+
+// Persist removes the expire time of field, and reports whether it had one.
+func (h *Hash) Persist(field string) bool {
+	if h.expired(field, hashNow()) {
+		return false
+	}
+	if _, ok := h.expires[field]; !ok {
+		return false
+	}
+	h.deleteExpire(field)
+	return true
+}
+
+func (h *Hash) deleteExpire(field string) {
+	if h.expires == nil {
+		return
+	}
+	delete(h.expires, field)
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+}
+
+// removeExpired deletes the fields expired at now, in unix milliseconds, and returns how many.
+func (h *Hash) removeExpired(now int64) int {
+	n := 0
+	for field, at := range h.expires {
+		if at < now {
+			h.del(field)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,123 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestHashEncoding(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 128; i++ {
+		h.Set("f"+strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	h.Set("f0", []byte("zero"))
+	if h.Encoding() != encodingListpack || h.Len() != 128 {
+		t.Error(fmt.Sprintf("hash of 128 fields is %s", h.Encoding()))
+	}
+	if !bytes.Equal(h.Get("f0"), []byte("zero")) || !bytes.Equal(h.Get("f127"), []byte("127")) || h.Get("f128") != nil {
+		t.Error("Get from a listpack hash failed")
+	}
+	if n, ok := h.IncrBy("f1", 10); !ok || n != 11 {
+		t.Error(fmt.Sprintf("IncrBy == %d, expect 11", n))
+	}
+	if h.Del("f2") != 1 || h.Del("f2") != 0 || h.Exist("f2") || h.Len() != 127 {
+		t.Error("Del from a listpack hash failed")
+	}
+
+	// past hash-max-listpack-entries
+	h.Set("f2", []byte("2"))
+	h.Set("f128", []byte("128"))
+	if h.Encoding() != encodingHashtable || h.Len() != 129 || !bytes.Equal(h.Get("f1"), []byte("11")) {
+		t.Error(fmt.Sprintf("hash of 129 fields is %s", h.Encoding()))
+	}
+
+	// a long value converts a small hash
+	h = NewHash()
+	h.Set("a", []byte("1"))
+	h.Set("a", bytes.Repeat([]byte("x"), 65))
+	if h.Encoding() != encodingHashtable || h.Len() != 1 || h.StrLen("a") != 65 {
+		t.Error(fmt.Sprintf("hash with a long value is %s", h.Encoding()))
+	}
+
+	h = NewHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte(""))
+	keys, vals := h.RandomWithValue(-5)
+	if len(keys) != 5 || len(vals) != 5 {
+		t.Error(fmt.Sprintf("RandomWithValue(-5) returns %d fields", len(keys)))
+	}
+	for i := range keys {
+		if !bytes.Equal(h.Get(keys[i]), vals[i]) {
+			t.Error(fmt.Sprintf("RandomWithValue returns %s: %s", keys[i], vals[i]))
+		}
+	}
+	if keys := h.Random(5); len(keys) != 2 || keys[0] == keys[1] {
+		t.Error(fmt.Sprintf("Random(5) == %v", keys))
+	}
+	if v := h.Get("b"); v == nil || len(v) != 0 {
+		t.Error("an empty value should read as empty, not missing")
+	}
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	for _, n := range []int{3, 200} {
+		h := NewHash()
+		for i := 0; i < n; i++ {
+			h.Set("f"+strconv.Itoa(i), []byte(strconv.Itoa(i)))
+		}
+		enc := h.Encoding()
+		now := hashNow()
+		h.SetExpireTime("f0", now-1)
+		h.SetExpireTime("f1", now+60000)
+
+		// an expired field reads as missing until a writer removes it
+		if h.Get("f0") != nil || h.Exist("f0") || h.StrLen("f0") != 0 || h.ExpireTime("f0") != -2 || h.Len() != n-1 {
+			t.Error(fmt.Sprintf("%s hash: an expired field is visible", enc))
+		}
+		if len(h.Keys()) != n-1 || len(h.Random(n)) != n-1 {
+			t.Error(fmt.Sprintf("%s hash: an expired field is scanned", enc))
+		}
+		if c := h.Clone(); c.ExpireTime("f1") != now+60000 || c.Len() != n-1 {
+			t.Error(fmt.Sprintf("%s hash: Clone lost the expire times", enc))
+		}
+		if h.ExpireTime("f1") != now+60000 || h.ExpireTime("f2") != -1 {
+			t.Error(fmt.Sprintf("%s hash: ExpireTime(f1) == %d", enc, h.ExpireTime("f1")))
+		}
+
+		// HINCRBY keeps the ttl, HSET drops it, and setting an expired field adds it anew
+		if v, _ := h.IncrBy("f1", 1); v != 2 || h.ExpireTime("f1") != now+60000 {
+			t.Error(fmt.Sprintf("%s hash: IncrBy dropped the ttl", enc))
+		}
+		if h.Set("f0", []byte("new")) != 1 || h.ExpireTime("f0") != -1 || !bytes.Equal(h.Get("f0"), []byte("new")) {
+			t.Error(fmt.Sprintf("%s hash: setting an expired field failed", enc))
+		}
+		if h.Set("f1", []byte("x")) != 0 || h.ExpireTime("f1") != -1 || h.expires != nil {
+			t.Error(fmt.Sprintf("%s hash: Set kept the ttl", enc))
+		}
+
+		h.SetExpireTime("f1", now+60000)
+		if !h.Persist("f1") || h.Persist("f1") || h.Persist("f2") {
+			t.Error(fmt.Sprintf("%s hash: Persist failed", enc))
+		}
+		h.SetExpireTime("f2", now-1)
+		if h.Del("f2") != 0 || h.removeExpired(hashNow()) != 0 || h.physicalLen() != n-1 {
+			t.Error(fmt.Sprintf("%s hash: deleting an expired field failed", enc))
+		}
+		if n > 4 {
+			h.SetExpireTime("f3", now-1)
+			h.SetExpireTime("f4", now-1)
+			if r := h.removeExpired(hashNow()); r != 2 || h.physicalLen() != n-3 || h.expires != nil {
+				t.Error(fmt.Sprintf("%s hash: removeExpired == %d", enc, r))
+			}
+		}
+	}
+
+	h := NewHash()
+	h.Set("a", []byte("1"))
+	h.SetExpireTime("a", hashNow()+1000)
+	if h.Encoding() != encodingListpackEx {
+		t.Error(fmt.Sprintf("a small hash with ttls is %s", h.Encoding()))
+	}
+}
@@ -0,0 +1,212 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashFieldTTL(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+	exec(db, "set", "str", "v")
+
+	for _, c := range []struct{ args, want string }{
+		{"hset h a 1 b 2 c 3", ":3\r\n"},
+		{"hexpire h 100 FIELDS 2 a nofield", "*2\r\n:1\r\n:-2\r\n"},
+		{"httl h FIELDS 3 a b nofield", "*3\r\n:100\r\n:-1\r\n:-2\r\n"},
+		{"hexpire h 200 NX FIELDS 2 a b", "*2\r\n:0\r\n:1\r\n"},
+		{"hexpire h 50 GT FIELDS 2 a c", "*2\r\n:0\r\n:0\r\n"},
+		{"hexpire h 50 LT FIELDS 2 a c", "*2\r\n:1\r\n:1\r\n"},
+		{"hpersist h FIELDS 3 c c nofield", "*3\r\n:1\r\n:-1\r\n:-2\r\n"},
+		{"hexpire h 300 XX FIELDS 2 a c", "*2\r\n:1\r\n:0\r\n"},
+		{"hexpireat h 4000000000 FIELDS 1 b", "*1\r\n:1\r\n"},
+		{"hexpiretime h FIELDS 2 b c", "*2\r\n:4000000000\r\n:-1\r\n"},
+		{"hpexpiretime h FIELDS 1 b", "*1\r\n:4000000000000\r\n"},
+		{"hpttl nokey FIELDS 2 a b", "*2\r\n:-2\r\n:-2\r\n"},
+		{"hexpire nokey 100 FIELDS 1 a", "*1\r\n:-2\r\n"},
+
+		{"hexpire h 100 FIELDS 0 a", "-ERR Number of fields must be a positive integer\r\n"},
+		{"hexpire h 100 FIELDS 2 a", "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{"hexpire h 100 XY FIELDS 1 a", "-ERR Mandatory argument FIELDS is missing or not at the right position\r\n"},
+		{"hexpire h -1 FIELDS 1 a", "-ERR invalid expire time, must be >= 0\r\n"},
+		{"hexpire h 281474976710655 FIELDS 1 a", "-ERR invalid expire time in 'hexpire' command\r\n"},
+		{"hexpire h x FIELDS 1 a", "-ERR value is not an integer or out of range\r\n"},
+		{"hexpire str 100 FIELDS 1 a", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"httl h FIELDS 1", "-ERR wrong number of arguments for 'httl' command\r\n"},
+
+		// a time already passed deletes the fields, and the key with its last one
+		{"hexpire h 0 FIELDS 1 a", "*1\r\n:2\r\n"},
+		{"hlen h", ":2\r\n"},
+		{"hpexpireat h 1 FIELDS 2 b c", "*2\r\n:2\r\n:2\r\n"},
+		{"exists h", ":0\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+
+	// expired fields are never read, and the key is gone with its last field
+	exec(db, "hset", "k", "a", "1", "b", "2")
+	exec(db, "hpexpire", "k", "1", "FIELDS", "1", "a")
+	time.Sleep(5 * time.Millisecond)
+	for _, c := range []struct{ args, want string }{
+		{"hlen k", ":1\r\n"},
+		{"hget k a", "$-1\r\n"},
+		{"hgetall k", bulks("b", "2")},
+		{"hkeys k", bulks("b")},
+		{"httl k FIELDS 1 a", "*1\r\n:-2\r\n"},
+		{"hset k a 3", ":1\r\n"},
+		{"httl k FIELDS 1 a", "*1\r\n:-1\r\n"},
+		{"hpexpire k 1 FIELDS 2 a b", "*2\r\n:1\r\n:1\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	for _, c := range []struct{ args, want string }{
+		{"exists k", ":0\r\n"},
+		{"hlen k", ":0\r\n"},
+		{"hset k c 1", ":1\r\n"},
+		{"hgetall k", bulks("c", "1")},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
+
+func TestHashGetSetEx(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	for _, c := range []struct{ args, want string }{
+		{"hsetex g FIELDS 2 a 1 b 2", ":1\r\n"},
+		{"hsetex g FNX FIELDS 2 a 3 c 3", ":0\r\n"},
+		{"hsetex g FXX EX 100 FIELDS 2 a 3 b 4", ":1\r\n"},
+		{"httl g FIELDS 2 a b", "*2\r\n:100\r\n:100\r\n"},
+		{"hsetex g FXX FIELDS 2 a 5 c 6", ":0\r\n"},
+		{"hsetex g KEEPTTL FIELDS 1 a 7", ":1\r\n"},
+		{"httl g FIELDS 1 a", "*1\r\n:100\r\n"},
+		{"hsetex g FIELDS 1 a 8", ":1\r\n"},
+		{"httl g FIELDS 1 a", "*1\r\n:-1\r\n"},
+		{"hsetex g EX 10 KEEPTTL FIELDS 1 a 1", "-ERR syntax error\r\n"},
+		{"hsetex g FIELDS 2 a 1", "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{"hsetex nokey FXX FIELDS 1 a 1", ":0\r\n"},
+		{"exists nokey", ":0\r\n"},
+
+		{"hgetex g PERSIST FIELDS 2 b nofield", bulks("4", "")},
+		{"httl g FIELDS 1 b", "*1\r\n:-1\r\n"},
+		{"hgetex g EXAT 4000000000 FIELDS 1 a", bulks("8")},
+		{"hexpiretime g FIELDS 1 a", "*1\r\n:4000000000\r\n"},
+		{"hgetex g FIELDS 1 a", bulks("8")},
+		{"hgetex g PXAT 1 FIELDS 1 a", bulks("8")},
+		{"hexists g a", ":0\r\n"},
+		{"hgetex g EX FIELDS 1 a", "-ERR value is not an integer or out of range\r\n"},
+		{"hgetex nokey FIELDS 1 a", bulks("")},
+
+		{"hgetdel g FIELDS 2 b nofield", bulks("4", "")},
+		{"exists g", ":0\r\n"},
+		{"hgetdel nokey FIELDS 1 a", bulks("")},
+		{"hsetex g PXAT 1 FIELDS 1 a 1", ":1\r\n"},
+		{"exists g", ":0\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
+
+func TestHashFieldTTLKeys(t *testing.T) {
+	dbs, exec := keyCommands(2)
+	db := dbs[0]
+
+	exec(db, "hset", "h", "a", "1", "b", "2")
+	exec(db, "hexpire", "h", "100", "FIELDS", "1", "a")
+	if r := exec(db, "object", "encoding", "h"); r != "$10\r\nlistpackex\r\n" {
+		t.Error(fmt.Sprintf("OBJECT ENCODING == %q", r))
+	}
+
+	// the ttls survive RENAME, COPY and DUMP with RESTORE
+	exec(db, "rename", "h", "h2")
+	exec(db, "copy", "h2", "h3", "DB", "1")
+	payload := exec(db, "dump", "h2")
+	payload = payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+	if payload[0] != rdbTypeHashMetadata || binary.LittleEndian.Uint16([]byte(payload[len(payload)-10:])) != rdbMaxVersion {
+		t.Error(fmt.Sprintf("DUMP of a hash with ttls == %q", payload))
+	}
+	if r := exec(db, "restore", "h4", "0", payload); r != "+OK\r\n" {
+		t.Fatal(fmt.Sprintf("RESTORE == %q", r))
+	}
+	for _, c := range []struct {
+		db   *MemDb
+		key  string
+		want string
+	}{{db, "h2", "*2\r\n:100\r\n:-1\r\n"}, {dbs[1], "h3", "*2\r\n:100\r\n:-1\r\n"}, {db, "h4", "*2\r\n:100\r\n:-1\r\n"}} {
+		if r := exec(c.db, "httl", c.key, "FIELDS", "2", "a", "b"); r != c.want {
+			t.Error(fmt.Sprintf("HTTL %s == %q, expect %q", c.key, r, c.want))
+		}
+	}
+
+	// fields expired when dumped are left out, as are the ones expired when restored
+	exec(db, "hset", "d", "a", "1", "b", "2", "c", "3")
+	exec(db, "hpexpire", "d", "1", "FIELDS", "1", "a")
+	exec(db, "hpexpire", "d", "20", "FIELDS", "1", "b")
+	time.Sleep(5 * time.Millisecond)
+	payload = exec(db, "dump", "d")
+	payload = payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+	time.Sleep(20 * time.Millisecond)
+	exec(db, "restore", "d2", "0", payload)
+	if r := exec(db, "hgetall", "d2"); r != bulks("c", "3") {
+		t.Error(fmt.Sprintf("restored hash == %q", r))
+	}
+}
+
+func TestHashActiveFieldExpire(t *testing.T) {
+	dbs, exec := keyCommands(2)
+	db := dbs[0]
+
+	exec(db, "hset", "h", "a", "1", "b", "2")
+	exec(db, "hset", "keep", "a", "1", "b", "2")
+	exec(db, "hpexpire", "h", "10", "FIELDS", "2", "a", "b")
+	exec(db, "hpexpire", "keep", "10", "FIELDS", "1", "a")
+	// the watched keys move with the keys
+	exec(db, "swapdb", "0", "1")
+	db = dbs[1]
+
+	// reports whether the expired fields were removed, not only hidden
+	removed := func() bool {
+		db.locks.MRLock([]string{"h", "keep"})
+		defer db.locks.MRUnLock([]string{"h", "keep"})
+		_, hasKey := db.dict.Get("h")
+		keep, _ := db.dict.Get("keep")
+		return !hasKey && keep.(*Object).Value.(*Hash).physicalLen() == 1
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !removed() {
+		if time.Now().After(deadline) {
+			t.Fatal("expired fields weren't removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the cycle stops once nothing is left to expire
+	for {
+		db.fieldExpiry.mu.Lock()
+		running := db.fieldExpiry.running
+		db.fieldExpiry.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the expire cycle keeps running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r := exec(db, "hgetall", "keep"); r != bulks("b", "2") {
+		t.Error(fmt.Sprintf("HGETALL == %q", r))
+	}
+}
@@ -0,0 +1,461 @@
+package memdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"gRedis/config"
+	"gRedis/resp"
+	"math"
+)
+
+// HyperLogLogs are strings in redis's format, so they can be moved with GET/SET or DUMP/RESTORE:
+// a 16 bytes header, "HYLL", the encoding, 3 unused bytes and the cached cardinality, then
+// 16384 registers of 6 bits packed as a dense array, or run-length encoded in the sparse encoding:
+//
+//	ZERO   00xxxxxx           xxxxxx+1 zero registers, up to 64
+//	XZERO  01xxxxxx yyyyyyyy  xxxxxxyyyyyyyy+1 zero registers, up to 16384
+//	VAL    1vvvvvxx           xx+1 registers, up to 4, set to vvvvv+1, up to 32
+//
+// A HyperLogLog starts sparse and turns dense when a register exceeds 32 or it grows past hll-sparse-max-bytes.
+
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense       = 0
+	hllSparse      = 1
+	hllMaxEncoding = 1
+
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+
+	hllAlphaInf = 0.721347520444481703680 // 1/(2 ln 2)
+)
+
+var errHllCorrupted = errors.New("Corrupted HLL object detected")
+
+func hllSparseMaxBytes() int {
+	if config.Conf == nil || config.Conf.HllSparseMaxBytes == 0 {
+		return 3000
+	}
+	return config.Conf.HllSparseMaxBytes
+}
+
+func newWrongHllError() *resp.SimpleError {
+	return resp.NewErrorReply(resp.WrongTypePrefix, "Key is not a valid HyperLogLog string value.")
+}
+
+// murmurHash64A is the hash redis uses for HyperLogLog elements.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+
+	data := key
+	for ; len(data) >= 8; data = data[8:] {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register of ele and the length of the run of zeros in its hash, plus one.
+func hllPatLen(ele []byte) (int, uint8) {
+	hash := murmurHash64A(ele, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ // the run ends at hllQ at the latest
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// isHLL reports whether v is a HyperLogLog, without checking the registers of a sparse one.
+func isHLL(v []byte) bool {
+	if len(v) < hllHdrSize || string(v[:4]) != "HYLL" || v[4] > hllMaxEncoding {
+		return false
+	}
+	return v[4] != hllDense || len(v) == hllDenseSize
+}
+
+func hllNew() []byte {
+	v := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(v, "HYLL")
+	v[4] = hllSparse
+	// a single XZERO covers every register
+	return append(v, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+func hllInvalidateCache(v []byte) {
+	v[15] |= 0x80
+}
+
+func hllDenseGet(regs []byte, i int) uint8 {
+	b, fb := i*hllBits/8, uint(i*hllBits&7)
+	v := regs[b] >> fb
+	if b+1 < len(regs) {
+		v |= regs[b+1] << (8 - fb)
+	}
+	return v & 63
+}
+
+func hllDenseSet(regs []byte, i int, val uint8) {
+	b, fb := i*hllBits/8, uint(i*hllBits&7)
+	regs[b] &^= 63 << fb
+	regs[b] |= val << fb
+	// the last register fits in the last byte
+	if b+1 < len(regs) {
+		regs[b+1] &^= 63 >> (8 - fb)
+		regs[b+1] |= val >> (8 - fb)
+	}
+}
+
+// hllDecode decodes the registers of v, a HyperLogLog, into regs.
+func hllDecode(v []byte, regs *[hllRegisters]uint8) error {
+	if v[4] == hllDense {
+		for i := range regs {
+			regs[i] = hllDenseGet(v[hllHdrSize:], i)
+		}
+		return nil
+	}
+
+	i := 0
+	for p := v[hllHdrSize:]; len(p) > 0; {
+		b := p[0]
+		var runLen int
+		var val uint8
+		switch {
+		case b&0xc0 == 0: // ZERO
+			runLen = int(b&0x3f) + 1
+			p = p[1:]
+		case b&0xc0 == 0x40: // XZERO
+			if len(p) < 2 {
+				return errHllCorrupted
+			}
+			runLen = (int(b&0x3f)<<8 | int(p[1])) + 1
+			p = p[2:]
+		default: // VAL
+			val = (b>>2)&0x1f + 1
+			runLen = int(b&3) + 1
+			p = p[1:]
+		}
+		if i+runLen > hllRegisters {
+			return errHllCorrupted
+		}
+		for end := i + runLen; i < end; i++ {
+			regs[i] = val
+		}
+	}
+	if i != hllRegisters {
+		return errHllCorrupted
+	}
+	return nil
+}
+
+// hllEncodeSparse appends regs run-length encoded to dst; ok is false if a register doesn't fit a VAL opcode.
+func hllEncodeSparse(dst []byte, regs *[hllRegisters]uint8) (res []byte, ok bool) {
+	for i := 0; i < hllRegisters; {
+		val := regs[i]
+		if val > hllSparseValMaxValue {
+			return nil, false
+		}
+		runLen := 1
+		for i+runLen < hllRegisters && regs[i+runLen] == val {
+			runLen++
+		}
+		i += runLen
+
+		for runLen > 0 {
+			n := runLen
+			switch {
+			case val != 0:
+				if n > hllSparseValMaxLen {
+					n = hllSparseValMaxLen
+				}
+				dst = append(dst, 0x80|(val-1)<<2|byte(n-1))
+			case n > hllSparseZeroMaxLen:
+				if n > hllSparseXZeroMaxLen {
+					n = hllSparseXZeroMaxLen
+				}
+				dst = append(dst, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+			default:
+				dst = append(dst, byte(n-1))
+			}
+			runLen -= n
+		}
+	}
+	return dst, true
+}
+
+// hllEncode returns a HyperLogLog of regs, with an invalid cached cardinality:
+// sparse if it may and regs fit, dense otherwise.
+func hllEncode(regs *[hllRegisters]uint8, sparse bool) []byte {
+	hdr := make([]byte, hllHdrSize, hllDenseSize)
+	copy(hdr, "HYLL")
+	hllInvalidateCache(hdr)
+	if sparse {
+		hdr[4] = hllSparse
+		if v, ok := hllEncodeSparse(hdr, regs); ok && len(v) <= hllSparseMaxBytes() {
+			return v
+		}
+	}
+	v := hdr[:hllDenseSize]
+	v[4] = hllDense
+	for i := hllHdrSize; i < len(v); i++ {
+		v[i] = 0
+	}
+	for i, val := range regs {
+		hllDenseSet(v[hllHdrSize:], i, val)
+	}
+	return v
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// hllCount estimates the cardinality of regs, with the estimator of Otmar Ertl redis uses.
+func hllCount(regs *[hllRegisters]uint8) uint64 {
+	var histo [64]int
+	for _, val := range regs {
+		histo[val]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// lookupHLL returns the HyperLogLog at key, or nil if it doesn't exist. The caller holds the key's lock.
+func (db *MemDb) lookupHLL(key string, write bool) ([]byte, resp.RedisData) {
+	v, errReply := db.lookupString(key, write)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if v != nil && !isHLL(v) {
+		return nil, newWrongHllError()
+	}
+	return v, nil
+}
+
+// storeHLL sets key to v, keeping the object, and its ttl, if key exists.
+func (db *MemDb) storeHLL(key string, v []byte, exists bool) {
+	if exists {
+		obj, _ := db.dict.Get(key)
+		obj.(*Object).Value = v
+	} else {
+		db.setKey(key, v)
+	}
+}
+
+// PFADD key [element ...]
+func pfAddHLL(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	v, errReply := db.lookupHLL(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	exists := v != nil
+	if !exists {
+		v = hllNew()
+	}
+
+	updated := false
+	if v[4] == hllDense {
+		// dense registers are updated in place
+		for _, ele := range cmd[2:] {
+			i, count := hllPatLen(ele)
+			if count > hllDenseGet(v[hllHdrSize:], i) {
+				hllDenseSet(v[hllHdrSize:], i, count)
+				updated = true
+			}
+		}
+		if updated {
+			hllInvalidateCache(v)
+		}
+	} else {
+		var regs [hllRegisters]uint8
+		if err := hllDecode(v, &regs); err != nil {
+			return resp.NewErrorReply(resp.InvalidObjPrefix, err.Error())
+		}
+		for _, ele := range cmd[2:] {
+			i, count := hllPatLen(ele)
+			if count > regs[i] {
+				regs[i] = count
+				updated = true
+			}
+		}
+		if updated {
+			v = hllEncode(&regs, true)
+		}
+	}
+
+	if !exists || updated {
+		db.storeHLL(key, v, exists)
+		return resp.NewInteger(1)
+	}
+	return resp.NewInteger(0)
+}
+
+// PFCOUNT key [key ...]; the cardinality of a single key is cached in its header, that of several keys is their union's
+func pfCountHLL(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	if len(cmd) == 2 {
+		key := string(cmd[1])
+		db.locks.Lock(key)
+		defer db.locks.UnLock(key)
+
+		v, errReply := db.lookupHLL(key, true)
+		if errReply != nil {
+			return errReply
+		}
+		if v == nil {
+			return resp.NewInteger(0)
+		}
+		if v[15]&0x80 == 0 {
+			return resp.NewInteger(int64(binary.LittleEndian.Uint64(v[8:16])))
+		}
+		var regs [hllRegisters]uint8
+		if err := hllDecode(v, &regs); err != nil {
+			return resp.NewErrorReply(resp.InvalidObjPrefix, err.Error())
+		}
+		card := hllCount(&regs)
+		binary.LittleEndian.PutUint64(v[8:16], card)
+		return resp.NewInteger(int64(card))
+	}
+
+	keys := make([]string, 0, len(cmd)-1)
+	for _, k := range cmd[1:] {
+		keys = append(keys, string(k))
+	}
+	db.locks.MRLock(keys)
+	defer db.locks.MRUnLock(keys)
+
+	var union [hllRegisters]uint8
+	if _, errReply := db.mergeHLL(keys, &union); errReply != nil {
+		return errReply
+	}
+	return resp.NewInteger(int64(hllCount(&union)))
+}
+
+// mergeHLL sets each register of max to the highest of the keys', and reports whether any of them is dense.
+// The caller holds the keys' locks.
+func (db *MemDb) mergeHLL(keys []string, max *[hllRegisters]uint8) (dense bool, errReply resp.RedisData) {
+	var regs [hllRegisters]uint8
+	for _, key := range keys {
+		v, errReply := db.lookupHLL(key, false)
+		if errReply != nil {
+			return false, errReply
+		}
+		if v == nil {
+			continue
+		}
+		if v[4] == hllDense {
+			dense = true
+		}
+		if err := hllDecode(v, &regs); err != nil {
+			return false, resp.NewErrorReply(resp.InvalidObjPrefix, err.Error())
+		}
+		for i, val := range regs {
+			if val > max[i] {
+				max[i] = val
+			}
+		}
+	}
+	return dense, nil
+}
+
+// PFMERGE destkey [sourcekey ...]; destkey is merged with the sources
+func pfMergeHLL(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	keys := make([]string, 0, len(cmd)-1)
+	for _, k := range cmd[1:] {
+		keys = append(keys, string(k))
+	}
+	db.locks.MLock(keys)
+	defer db.locks.MUnLock(keys)
+
+	var max [hllRegisters]uint8
+	dense, errReply := db.mergeHLL(keys, &max)
+	if errReply != nil {
+		return errReply
+	}
+	dest := keys[0]
+	_, exists := db.lookupKeyWrite(dest)
+	// the result stays sparse unless one of the inputs was dense
+	db.storeHLL(dest, hllEncode(&max, !dense), exists)
+	return resp.NewSimpleString("OK")
+}
+
+func RegisterHyperLogLogCommands() {
+	RegisterCommand("pfadd", pfAddHLL, 1, 1, 1)
+	RegisterCommand("pfcount", pfCountHLL, 1, -1, 1)
+	RegisterCommand("pfmerge", pfMergeHLL, 1, -1, 1)
+}
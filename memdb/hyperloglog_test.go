@@ -0,0 +1,134 @@
+package memdb
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func hllCommands() (*MemDb, func(args ...string) string) {
+	dbs, exec := keyCommands(1)
+	RegisterHyperLogLogCommands()
+	return dbs[0], func(args ...string) string { return exec(dbs[0], args...) }
+}
+
+func TestHyperLogLog(t *testing.T) {
+	db, exec := hllCommands()
+
+	// an empty HyperLogLog is a header and a single XZERO opcode, as in redis
+	if r := exec("pfadd", "h"); r != ":1\r\n" {
+		t.Error(fmt.Sprintf("PFADD without elements == %q", r))
+	}
+	if r := exec("get", "h"); r != "$18\r\nHYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff\r\n" {
+		t.Error(fmt.Sprintf("empty HyperLogLog == %q", r))
+	}
+	if r := exec("pfadd", "h", "a", "b", "c", "d", "e", "f", "g"); r != ":1\r\n" {
+		t.Error(fmt.Sprintf("PFADD == %q", r))
+	}
+	if r := exec("pfadd", "h", "a", "b"); r != ":0\r\n" {
+		t.Error(fmt.Sprintf("PFADD of known elements == %q", r))
+	}
+	if r := exec("pfcount", "h"); r != ":7\r\n" {
+		t.Error(fmt.Sprintf("PFCOUNT == %q", r))
+	}
+	// the cardinality is cached in the header, and invalidated by PFADD
+	v, _ := db.dict.Get("h")
+	hll := v.(*Object).Value.([]byte)
+	if hll[8] != 7 || hll[15]&0x80 != 0 {
+		t.Error(fmt.Sprintf("cached cardinality == %v", hll[8:16]))
+	}
+	exec("pfadd", "h", "x")
+	v, _ = db.dict.Get("h")
+	if v.(*Object).Value.([]byte)[15]&0x80 == 0 {
+		t.Error("PFADD should invalidate the cached cardinality")
+	}
+
+	// a HyperLogLog is a plain string, it survives GET and SET
+	exec("set", "copy", strings.TrimSuffix(exec("get", "h")[5:], "\r\n"))
+	if r := exec("pfcount", "copy"); r != ":8\r\n" {
+		t.Error(fmt.Sprintf("PFCOUNT of a copy == %q", r))
+	}
+
+	// union and merge
+	exec("pfadd", "h2", "x", "y", "z")
+	if r := exec("pfcount", "h", "h2", "nosuchkey"); r != ":10\r\n" {
+		t.Error(fmt.Sprintf("PFCOUNT of a union == %q", r))
+	}
+	if r := exec("pfmerge", "dest", "h", "h2"); r != "+OK\r\n" || exec("pfcount", "dest") != ":10\r\n" {
+		t.Error(fmt.Sprintf("PFMERGE == %q", r))
+	}
+	if r := exec("pfmerge", "h2", "h"); r != "+OK\r\n" || exec("pfcount", "h2") != ":10\r\n" {
+		t.Error("PFMERGE should merge the destination too")
+	}
+
+	// errors
+	exec("set", "str", "HYLLnot a hyperloglog")
+	exec("rpush", "list", "a")
+	for _, args := range [][]string{{"pfadd", "str", "a"}, {"pfcount", "str"}, {"pfmerge", "d", "str"}, {"pfcount", "h", "str"}} {
+		if r := exec(args...); r != "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n" {
+			t.Error(fmt.Sprintf("%v == %q", args, r))
+		}
+	}
+	if r := exec("pfadd", "list", "a"); !strings.HasPrefix(r, "-WRONGTYPE") {
+		t.Error(fmt.Sprintf("PFADD of a list == %q", r))
+	}
+	// a sparse HyperLogLog whose opcodes don't add up to 16384 registers
+	exec("set", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe")
+	if r := exec("pfcount", "bad"); r != "-INVALIDOBJ Corrupted HLL object detected\r\n" {
+		t.Error(fmt.Sprintf("PFCOUNT of a corrupted HyperLogLog == %q", r))
+	}
+}
+
+func TestHyperLogLogEncoding(t *testing.T) {
+	var regs, decoded [hllRegisters]uint8
+	for i := range regs {
+		if i%97 == 0 {
+			regs[i] = uint8(i%32 + 1)
+		}
+	}
+	v := hllEncode(&regs, true)
+	if v[4] != hllSparse {
+		t.Fatal("registers up to 32 should encode sparse")
+	}
+	if err := hllDecode(v, &decoded); err != nil || decoded != regs {
+		t.Error("sparse registers not decoded")
+	}
+
+	regs[hllRegisters-1] = 50
+	v = hllEncode(&regs, true)
+	if v[4] != hllDense || len(v) != hllDenseSize {
+		t.Fatal("a register above 32 should encode dense")
+	}
+	if err := hllDecode(v, &decoded); err != nil || decoded != regs {
+		t.Error("dense registers not decoded")
+	}
+}
+
+// TestHyperLogLogError checks the estimates against the standard error, 1.04/sqrt(16384) = 0.81%.
+func TestHyperLogLogError(t *testing.T) {
+	db, exec := hllCommands()
+
+	const stdError = 1.04 / 128
+	added := 0
+	for _, n := range []int{10, 100, 1000, 10000, 100000} {
+		args := []string{"pfadd", "h"}
+		for ; added < n; added++ {
+			args = append(args, "element:"+strconv.Itoa(added))
+		}
+		exec(args...)
+
+		r := exec("pfcount", "h")
+		count, _ := strconv.Atoi(r[1 : len(r)-2])
+		if math.Abs(float64(count-n)) > math.Max(3*stdError*float64(n), 1) {
+			t.Error(fmt.Sprintf("PFCOUNT of %d elements == %d", n, count))
+		}
+	}
+
+	// the sparse encoding turns dense as the registers fill
+	v, _ := db.dict.Get("h")
+	if v.(*Object).Value.([]byte)[4] != hllDense {
+		t.Error("a HyperLogLog of 100000 elements should be dense")
+	}
+}
@@ -0,0 +1,119 @@
+package memdb
+
+import "encoding/binary"
+
+// An intset is a sorted array of integers, like redis's intset.
+// Its first byte is the width of every element, 2, 4 or 8 bytes, widened when a larger integer is added.
+// Elements follow in little endian, so a set of small integers takes 2 bytes per element.
+type intset []byte
+
+func intsetWidth(v int64) int {
+	switch {
+	case v >= -1<<15 && v < 1<<15:
+		return 2
+	case v >= -1<<31 && v < 1<<31:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func (is intset) width() int {
+	if len(is) == 0 {
+		return 2
+	}
+	return int(is[0])
+}
+
+func (is intset) Len() int {
+	if len(is) == 0 {
+		return 0
+	}
+	return (len(is) - 1) / is.width()
+}
+
+func (is intset) Get(i int) int64 {
+	p := is[1+i*is.width():]
+	switch is.width() {
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(p)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(p)))
+	default:
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+}
+
+func (is intset) set(i int, v int64) {
+	p := is[1+i*is.width():]
+	switch is.width() {
+	case 2:
+		binary.LittleEndian.PutUint16(p, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(p, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(p, uint64(v))
+	}
+}
+
+// search returns the position of v, or where it would be inserted, and whether it was found.
+func (is intset) search(v int64) (int, bool) {
+	lo, hi := 0, is.Len()
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if is.Get(mid) < v {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < is.Len() && is.Get(lo) == v
+}
+
+func (is intset) Has(v int64) bool {
+	_, ok := is.search(v)
+	return ok
+}
+
+// Add adds v, and reports whether it was missing.
+func (is intset) Add(v int64) (intset, bool) {
+	pos, ok := is.search(v)
+	if ok {
+		return is, false
+	}
+
+	n := is.Len()
+	if w := intsetWidth(v); w > is.width() || len(is) == 0 {
+		if w < is.width() {
+			w = is.width()
+		}
+		// widen every element; v is out of the old range, so it goes at one end
+		wider := make(intset, 1+(n+1)*w)
+		wider[0] = byte(w)
+		for i := 0; i < n; i++ {
+			j := i
+			if i >= pos {
+				j++
+			}
+			wider.set(j, is.Get(i))
+		}
+		wider.set(pos, v)
+		return wider, true
+	}
+
+	w := is.width()
+	is = append(is, make([]byte, w)...)
+	copy(is[1+(pos+1)*w:], is[1+pos*w:1+n*w])
+	is.set(pos, v)
+	return is, true
+}
+
+// Remove removes v, and reports whether it was there.
+func (is intset) Remove(v int64) (intset, bool) {
+	pos, ok := is.search(v)
+	if !ok {
+		return is, false
+	}
+	w := is.width()
+	return append(is[:1+pos*w], is[1+(pos+1)*w:]...), true
+}
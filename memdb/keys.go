@@ -1,9 +1,9 @@
 package memdb
 
 import (
-	"fmt"
 	"gRedis/resp"
 	"gRedis/util"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -11,7 +11,7 @@ import (
 
 func pingKeys(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) > 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 	if len(cmd) == 1 {
 		return resp.NewSimpleString("PONG")
@@ -20,19 +20,27 @@ func pingKeys(db *MemDb, cmd [][]byte) resp.RedisData {
 }
 
 func delKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	return deleteKeys(db, cmd, lazyfreeUserDel())
+}
+
+// UNLINK is DEL freeing big values in the background
+func unlinkKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	return deleteKeys(db, cmd, true)
+}
+
+func deleteKeys(db *MemDb, cmd [][]byte, lazy bool) resp.RedisData {
 	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	deleted := 0
 	for _, k := range cmd[1:] {
 		key := string(k)
-		if !db.DeleteExpiredKey(key) {
-			db.locks.Lock(key)
-			deleted += db.dict.Delete(key)
-			db.DeleteExpire(key)
-			db.locks.UnLock(key)
+		db.locks.Lock(key)
+		if _, ok := db.lookupKeyWrite(key); ok {
+			deleted += db.deleteKey(key, lazy)
 		}
+		db.locks.UnLock(key)
 	}
 
 	return resp.NewInteger(int64(deleted))
@@ -40,19 +48,17 @@ func delKey(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func existsKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	existed := 0
 	for _, k := range cmd[1:] {
 		key := string(k)
-		if !db.DeleteExpiredKey(key) {
-			db.locks.RLock(key)
-			if _, ok := db.dict.Get(key); ok {
-				existed++
-			}
-			db.locks.RUnLock(key)
+		db.locks.RLock(key)
+		if _, ok := db.lookupObject(key, lookupNoTouch); ok {
+			existed++
 		}
+		db.locks.RUnLock(key)
 	}
 
 	return resp.NewInteger(int64(existed))
@@ -60,17 +66,22 @@ func existsKey(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func keysKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	pattern := string(cmd[1])
 	res := make([]resp.RedisData, 0)
 	keys := db.dict.Keys()
 	for _, key := range keys {
-		if !db.DeleteExpiredKey(key) {
-			if util.PattenMatch(pattern, key) {
-				res = append(res, resp.NewBulkString([]byte(key)))
-			}
+		if !util.PattenMatch(pattern, key) {
+			continue
+		}
+		// the key may have expired or been deleted since Keys returned
+		db.locks.RLock(key)
+		_, ok := db.lookupObject(key, lookupNoTouch)
+		db.locks.RUnLock(key)
+		if ok {
+			res = append(res, resp.NewBulkString([]byte(key)))
 		}
 	}
 
@@ -85,13 +96,13 @@ LT -- Set expiry only when the new expiry is less than current one
 */
 func expireKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 || len(cmd) > 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 	var res int
 	// set ttl
 	v, err := strconv.ParseInt(string(cmd[2]), 10, 64)
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 	ttl := time.Now().Unix() + v
 
@@ -103,12 +114,13 @@ func expireKey(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	// get key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(int64(0))
-	}
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
+	if _, ok := db.lookupKeyWrite(key); !ok {
+		return resp.NewInteger(int64(0))
+	}
+
 	switch option {
 	case "nx":
 		if _, ok := db.expires.Get(key); !ok {
@@ -128,7 +140,7 @@ func expireKey(db *MemDb, cmd [][]byte) resp.RedisData {
 		}
 	default:
 		if option != "" {
-			return resp.NewSimpleError(fmt.Sprintf("Unsupported option %s", string(cmd[3])))
+			return resp.NewErrorReplyf(resp.ErrPrefix, "Unsupported option %s", string(cmd[3]))
 		}
 		res = db.SetExpire(key, ttl)
 	}
@@ -137,34 +149,29 @@ func expireKey(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func persistKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(int64(0))
-	}
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	db.DeleteExpire(key)
-
-	return resp.NewInteger(int64(1))
+	if _, ok := db.lookupKeyWrite(key); !ok {
+		return resp.NewInteger(int64(0))
+	}
+	return resp.NewInteger(int64(db.DeleteExpire(key)))
 }
 
 func ttlKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(int64(-2))
-	}
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	if _, ok := db.dict.Get(key); !ok {
+	if _, ok := db.lookupObject(key, lookupNoTouch); !ok {
 		return resp.NewInteger(int64(-2))
 	}
 
@@ -178,23 +185,34 @@ func ttlKey(db *MemDb, cmd [][]byte) resp.RedisData {
 }
 
 func renameKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	return rename(db, cmd, false)
+}
+
+// RENAMENX renames key only if newkey doesn't exist
+func renameNxKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	return rename(db, cmd, true)
+}
+
+func rename(db *MemDb, cmd [][]byte, nx bool) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	newKey := string(cmd[2])
 	oldKey := string(cmd[1])
-	if db.DeleteExpiredKey(oldKey) {
-		return resp.NewSimpleError("no such key")
-	}
 
 	// should lock newkey and oldkey together
 	db.locks.MLock([]string{oldKey, newKey})
 	defer db.locks.MUnLock([]string{oldKey, newKey})
 
-	oldValue, ok := db.dict.Get(oldKey)
+	obj, ok := db.lookupObject(oldKey, lookupWrite)
 	if !ok {
-		return resp.NewSimpleError("no such key")
+		return resp.NewNoSuchKeyError()
+	}
+	if nx {
+		if _, ok := db.lookupObject(newKey, lookupWrite|lookupNoTouch); ok {
+			return resp.NewInteger(0)
+		}
 	}
 
 	oldTTL, ok := db.expires.Get(oldKey)
@@ -202,58 +220,429 @@ func renameKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	db.dict.Delete(oldKey)
 	db.DeleteExpire(oldKey)
 	// If newkey already exists it is overwritten
-	db.dict.Delete(newKey)
-	db.DeleteExpire(newKey)
-	db.dict.Set(newKey, oldValue)
+	db.deleteKey(newKey, lazyfreeServerDel())
+	// the object moves with its access time and counter
+	db.dict.Set(newKey, obj)
+	db.trackFieldExpiry(newKey, obj.Value)
 
 	// If a key is renamed with RENAME, the associated time to live is transferred to the new key name.
 	if ok {
 		db.SetExpire(newKey, oldTTL.(int64))
 	}
 
+	if nx {
+		return resp.NewInteger(1)
+	}
 	return resp.NewSimpleString("OK")
 }
 
 func typeKey(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	obj, ok := db.lookupObject(key, lookupNoTouch)
+	if !ok {
 		return resp.NewSimpleString("none")
 	}
 
+	return resp.NewSimpleString(obj.Type())
+}
+
+// DBSIZE reads the key count with every stripe held, so no multi-key command is half done
+func dbSizeKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 1 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	db.locks.RLockAll()
+	defer db.locks.RUnLockAll()
+
+	return resp.NewInteger(int64(db.dict.Count()))
+}
+
+// randomKeyTries bounds the keys RANDOMKEY picks before giving up, when most keys have expired
+const randomKeyTries = 100
+
+func randomKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 1 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	db.locks.RLockAll()
+	defer db.locks.RUnLockAll()
+
+	// expired keys wait for a writer to delete them, they are skipped
+	for i := 0; i < randomKeyTries; i++ {
+		key, ok := db.dict.RandomKey()
+		if !ok {
+			break
+		}
+		if !db.CheckExpire(key) {
+			return resp.NewBulkString([]byte(key))
+		}
+	}
+	return resp.NewBulkString(nil)
+}
+
+// TOUCH records an access to keys, returning how many exist
+func touchKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	touched := 0
+	for _, k := range cmd[1:] {
+		key := string(k)
+		db.locks.RLock(key)
+		if _, ok := db.lookupObject(key, 0); ok {
+			touched++
+		}
+		db.locks.RUnLock(key)
+	}
+
+	return resp.NewInteger(int64(touched))
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func copyKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	dst, replace := db, false
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(cmd) {
+				return resp.NewSyntaxError()
+			}
+			var errReply resp.RedisData
+			if dst, errReply = db.dbIndex(cmd[i+1]); errReply != nil {
+				return errReply
+			}
+			i++
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+
+	srcKey, dstKey := string(cmd[1]), string(cmd[2])
+	if dst == db && srcKey == dstKey {
+		return resp.NewErrorReply(resp.ErrPrefix, "source and destination objects are the same")
+	}
+
+	unlock := lockPair(db, srcKey, dst, dstKey)
+	defer unlock()
+
+	obj, ok := db.lookupObject(srcKey, lookupWrite)
+	if !ok {
+		return resp.NewInteger(0)
+	}
+	if _, ok := dst.lookupObject(dstKey, lookupWrite|lookupNoTouch); ok {
+		if !replace {
+			return resp.NewInteger(0)
+		}
+		dst.deleteKey(dstKey, lazyfreeServerDel())
+	}
+
+	// the copy shares nothing with the source, and is a new object with its own access time and counter
+	v := cloneValue(obj.Value)
+	dst.dict.Set(dstKey, newObject(v))
+	dst.trackFieldExpiry(dstKey, v)
+	if ttl, ok := db.expires.Get(srcKey); ok {
+		dst.SetExpire(dstKey, ttl.(int64))
+	}
+	return resp.NewInteger(1)
+}
+
+// MOVE key db moves key to another database, unless it exists there
+func moveKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	dst, errReply := db.dbIndex(cmd[2])
+	if errReply != nil {
+		return errReply
+	}
+	if dst == db {
+		return resp.NewErrorReply(resp.ErrPrefix, "source and destination objects are the same")
+	}
+
+	key := string(cmd[1])
+	unlock := lockPair(db, key, dst, key)
+	defer unlock()
+
+	obj, ok := db.lookupObject(key, lookupWrite)
+	if !ok {
+		return resp.NewInteger(0)
+	}
+	if _, ok := dst.lookupObject(key, lookupWrite|lookupNoTouch); ok {
+		return resp.NewInteger(0)
+	}
+
+	ttl, hasTTL := db.expires.Get(key)
+	db.dict.Delete(key)
+	db.DeleteExpire(key)
+	dst.dict.Set(key, obj)
+	dst.trackFieldExpiry(key, obj.Value)
+	if hasTTL {
+		dst.SetExpire(key, ttl.(int64))
+	}
+	return resp.NewInteger(1)
+}
+
+// SWAPDB index1 index2 swaps the keys of two databases; their clients see the other's keys right away
+func swapDbKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	first, err := strconv.Atoi(string(cmd[1]))
+	if err != nil {
+		return resp.NewErrorReply(resp.ErrPrefix, "invalid first DB index")
+	}
+	second, err := strconv.Atoi(string(cmd[2]))
+	if err != nil {
+		return resp.NewErrorReply(resp.ErrPrefix, "invalid second DB index")
+	}
+	if first < 0 || first >= len(db.dbs) || second < 0 || second >= len(db.dbs) {
+		return resp.NewErrorReply(resp.ErrPrefix, "DB index is out of range")
+	}
+	if first == second {
+		return resp.NewSimpleString("OK")
+	}
+	if second < first {
+		first, second = second, first
+	}
+
+	a, b := db.dbs[first], db.dbs[second]
+	a.locks.LockAll()
+	b.locks.LockAll()
+	a.dict.swap(b.dict)
+	a.expires.swap(b.expires)
+	swapFieldExpiry(a, b)
+	b.locks.UnLockAll()
+	a.locks.UnLockAll()
+
+	return resp.NewSimpleString("OK")
+}
+
+// DUMP serializes the value of key in redis's format, without its ttl
+func dumpKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	v, ok := db.dict.Get(key)
+	obj, ok := db.lookupObject(key, 0)
 	if !ok {
-		return resp.NewSimpleString("none")
+		return resp.NewBulkString(nil)
+	}
+	return resp.NewBulkString(rdbDump(obj.Value))
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func restoreKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	replace, absTTL := false, false
+	idle, freq := int64(-1), int64(-1)
+	for i := 4; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime":
+			if i+1 >= len(cmd) || freq != -1 {
+				return resp.NewSyntaxError()
+			}
+			v, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if v < 0 {
+				return resp.NewErrorReply(resp.ErrPrefix, "Invalid IDLETIME value, must be >= 0")
+			}
+			idle = v
+			i++
+		case "freq":
+			if i+1 >= len(cmd) || idle != -1 {
+				return resp.NewSyntaxError()
+			}
+			v, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if v < 0 || v > math.MaxUint8 {
+				return resp.NewErrorReply(resp.ErrPrefix, "Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			freq = v
+			i++
+		default:
+			return resp.NewSyntaxError()
+		}
 	}
 
-	switch v.(type) {
-	case []byte:
-		return resp.NewSimpleString("string")
-	case *Hash:
-		return resp.NewSimpleString("hash")
-	case *List:
-		return resp.NewSimpleString("list")
-	case *Set:
-		return resp.NewSimpleString("set")
+	// the ttl is in milliseconds, expires are kept in seconds: round up, so the key doesn't expire early
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.NewNotIntegerError()
+	}
+	if ttl < 0 {
+		return resp.NewErrorReply(resp.ErrPrefix, "Invalid TTL value, must be >= 0")
+	}
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = (ttl + 999) / 1000
+		if !absTTL {
+			expireAt += time.Now().Unix()
+		}
 	}
 
-	return resp.NewSimpleString("none")
+	v, err := rdbRestore(cmd[3])
+	if err != nil {
+		return resp.NewErrorReply(resp.ErrPrefix, err.Error())
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	if _, ok := db.lookupObject(key, lookupWrite|lookupNoTouch); ok && !replace {
+		return resp.NewErrorReply(resp.BusyKeyPrefix, "Target key name already exists.")
+	}
+	db.deleteKey(key, lazyfreeServerDel())
+	// an absolute ttl already passed: the key is restored, then expires right away
+	if expireAt > 0 && expireAt <= time.Now().Unix() {
+		return resp.NewSimpleString("OK")
+	}
+
+	obj := newObject(v)
+	if idle >= 0 {
+		obj.atime.Store(uint32(time.Now().Unix() - idle))
+	}
+	if freq >= 0 {
+		obj.lfu.Store(lfuMinutes()<<8 | uint32(freq))
+	}
+	db.dict.Set(key, obj)
+	db.trackFieldExpiry(key, v)
+	if expireAt > 0 {
+		db.SetExpire(key, expireAt)
+	}
+	return resp.NewSimpleString("OK")
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// value encodings, as OBJECT ENCODING reports them
+const (
+	encodingInt        = "int"
+	encodingEmbstr     = "embstr"
+	encodingRaw        = "raw"
+	encodingListpack   = "listpack"
+	encodingListpackEx = "listpackex"
+	encodingQuicklist  = "quicklist"
+	encodingIntset     = "intset"
+	encodingHashtable  = "hashtable"
+	encodingSkiplist   = "skiplist"
+	encodingStream     = "stream"
+)
+
+func objectKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	sub := strings.ToLower(string(cmd[1]))
+	if sub == "help" && len(cmd) == 2 {
+		res := make([]resp.RedisData, 0, len(objectHelp))
+		for _, line := range objectHelp {
+			res = append(res, resp.NewSimpleString(line))
+		}
+		return resp.NewArray(res)
+	}
+	if sub != "encoding" && sub != "freq" && sub != "idletime" && sub != "refcount" {
+		return resp.NewErrorReplyf(resp.ErrPrefix, "unknown subcommand '%s'. Try OBJECT HELP.", cmd[1])
+	}
+	if len(cmd) != 3 {
+		return resp.NewErrorReplyf(resp.ErrPrefix, "unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", cmd[1])
+	}
+
+	key := string(cmd[2])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	// OBJECT looks at the access time and counter, it mustn't change them
+	obj, ok := db.lookupObject(key, lookupNoTouch)
+	if !ok {
+		return resp.NewBulkString(nil)
+	}
+
+	switch sub {
+	case "encoding":
+		return resp.NewBulkString([]byte(obj.Encoding()))
+	case "freq":
+		return resp.NewInteger(int64(obj.Freq()))
+	case "idletime":
+		return resp.NewInteger(obj.IdleTime())
+	default:
+		// values aren't shared between keys
+		return resp.NewInteger(1)
+	}
 }
 
 func RegisterKeyCommands() {
-	RegisterCommand("ping", pingKeys)
-	RegisterCommand("del", delKey)
-	RegisterCommand("exists", existsKey)
-	RegisterCommand("keys", keysKey)
-	RegisterCommand("expire", expireKey)
-	RegisterCommand("persist", persistKey)
-	RegisterCommand("ttl", ttlKey)
-	RegisterCommand("rename", renameKey)
-	RegisterCommand("type", typeKey)
+	RegisterCommand("ping", pingKeys, noKeys, 0, 0)
+	RegisterCommand("del", delKey, 1, -1, 1)
+	RegisterCommand("unlink", unlinkKey, 1, -1, 1)
+	RegisterCommand("exists", existsKey, 1, -1, 1)
+	RegisterCommand("keys", keysKey, allKeys, 0, 0)
+	RegisterCommand("expire", expireKey, 1, 1, 1)
+	RegisterCommand("persist", persistKey, 1, 1, 1)
+	RegisterCommand("ttl", ttlKey, 1, 1, 1)
+	RegisterCommand("rename", renameKey, 1, 2, 1)
+	RegisterCommand("renamenx", renameNxKey, 1, 2, 1)
+	RegisterCommand("type", typeKey, 1, 1, 1)
+	RegisterCommand("object", objectKey, 2, 2, 1)
+	RegisterCommand("dbsize", dbSizeKey, allKeys, 0, 0)
+	RegisterCommand("randomkey", randomKey, allKeys, 0, 0)
+	RegisterCommand("touch", touchKey, 1, -1, 1)
+	RegisterCommand("copy", copyKey, 1, 2, 1)
+	// a key has the same stripe, so the same shard, in every database
+	RegisterCommand("move", moveKey, 1, 1, 1)
+	RegisterCommand("swapdb", swapDbKey, allKeys, 0, 0)
+	RegisterCommand("dump", dumpKey, 1, 1, 1)
+	RegisterCommand("restore", restoreKey, 1, 1, 1)
+	// the keys are at 3, or after KEYS; taking every argument from 3 on covers both
+	RegisterCommand("migrate", migrateKey, 3, -1, 1)
 }
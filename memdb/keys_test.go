@@ -2,20 +2,31 @@ package memdb
 
 import (
 	"bytes"
+	"fmt"
 	"gRedis/config"
 	"gRedis/resp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 func init() {
-	config.Conf = &config.Config{SegNum: 100}
+	config.Conf = &config.Config{
+		SegNum: 100,
+
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		SetMaxIntsetEntries:    512,
+		SetMaxListpackEntries:  128,
+		SetMaxListpackValue:    64,
+	}
 }
 
 func TestDelKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("key1", "Hello")
-	db.dict.Set("key2", "World")
+	db.setKey("key1", "Hello")
+	db.setKey("key2", "World")
 
 	del := delKey(db, [][]byte{[]byte("del"), []byte("key1"), []byte("key2"), []byte("key3")})
 
@@ -33,7 +44,7 @@ func TestDelKey(t *testing.T) {
 
 func TestExistsKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("key1", "Hello")
+	db.setKey("key1", "Hello")
 
 	exists1 := existsKey(db, [][]byte{[]byte("exists"), []byte("key1")})
 	if !bytes.Equal(exists1.ToRedisFormat(), []byte(":1\r\n")) {
@@ -45,7 +56,7 @@ func TestExistsKey(t *testing.T) {
 		t.Error("exists num is not correct")
 	}
 
-	db.dict.Set("key2", "World")
+	db.setKey("key2", "World")
 
 	exists3 := existsKey(db, [][]byte{[]byte("exists"), []byte("key1"), []byte("key2"), []byte("nosuchkey")})
 	if !bytes.Equal(exists3.ToRedisFormat(), []byte(":2\r\n")) {
@@ -55,9 +66,9 @@ func TestExistsKey(t *testing.T) {
 
 func TestKeysKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("firstname", "Jack")
-	db.dict.Set("lastname", "Stuntman")
-	db.dict.Set("age", 35)
+	db.setKey("firstname", "Jack")
+	db.setKey("lastname", "Stuntman")
+	db.setKey("age", 35)
 
 	// case *name*
 	_keys1 := keysKey(db, [][]byte{[]byte("keys"), []byte("*name*")})
@@ -116,8 +127,8 @@ func TestKeysKey(t *testing.T) {
 
 func TestExpireKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("mykey", "Hello")
-	db.dict.Set("mykey1", "Hello")
+	db.setKey("mykey", "Hello")
+	db.setKey("mykey1", "Hello")
 
 	// no option
 	expire1 := expireKey(db, [][]byte{[]byte("expire"), []byte("mykey"), []byte("10")})
@@ -163,7 +174,7 @@ func TestExpireKey(t *testing.T) {
 
 func TestTtlKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("mykey", "Hello")
+	db.setKey("mykey", "Hello")
 
 	expireKey(db, [][]byte{[]byte("expire"), []byte("mykey"), []byte("10")})
 	ttl := ttlKey(db, [][]byte{[]byte("ttl"), []byte("mykey")})
@@ -174,7 +185,7 @@ func TestTtlKey(t *testing.T) {
 
 func TestPersistKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("mykey", "Hello")
+	db.setKey("mykey", "Hello")
 
 	expireKey(db, [][]byte{[]byte("expire"), []byte("mykey"), []byte("10")})
 	ttl1 := ttlKey(db, [][]byte{[]byte("ttl"), []byte("mykey")})
@@ -195,15 +206,256 @@ func TestPersistKey(t *testing.T) {
 
 func TestRenameKey(t *testing.T) {
 	db := NewMemDb()
-	db.dict.Set("mykey", "Hello")
+	db.setKey("mykey", "Hello")
 
 	rename := renameKey(db, [][]byte{[]byte("rename"), []byte("mykey"), []byte("myotherkey")})
 	if !bytes.Equal(rename.ToRedisFormat(), []byte("+OK\r\n")) {
 		t.Error("rename incorrect")
 	}
 
-	v, _ := db.dict.Get("myotherkey")
+	v, _ := db.lookupKeyRead("myotherkey")
 	if v.(string) != "Hello" {
 		t.Error("rename incorrect")
 	}
 }
+
+func TestRenameNxKey(t *testing.T) {
+	db := NewMemDb()
+	db.setKey("mykey", []byte("Hello"))
+	db.setKey("myotherkey", []byte("World"))
+	db.SetExpire("mykey", time.Now().Unix()+100)
+
+	if res := renameNxKey(db, makeCmd("renamenx", "mykey", "myotherkey")); !bytes.Equal(res.ToRedisFormat(), []byte(":0\r\n")) {
+		t.Error("renamenx onto an existing key should fail")
+	}
+	if res := renameNxKey(db, makeCmd("renamenx", "mykey", "newkey")); !bytes.Equal(res.ToRedisFormat(), []byte(":1\r\n")) {
+		t.Error("renamenx incorrect")
+	}
+	if ttl := ttlKey(db, makeCmd("ttl", "newkey")).(*resp.Integer).GetData(); ttl < 99 {
+		t.Error(fmt.Sprintf("renamenx ttl == %d, expect 100", ttl))
+	}
+}
+
+// keyCommands returns an executor of the key commands on n databases.
+func keyCommands(n int) ([]*MemDb, func(db *MemDb, args ...string) string) {
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterHashCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+	dbs := NewMemDbs(n, nil)
+	return dbs, func(db *MemDb, args ...string) string {
+		return string(CmdTable[args[0]].Executor(db, makeCmd(args...)).ToRedisFormat())
+	}
+}
+
+func TestCopyKey(t *testing.T) {
+	dbs, exec := keyCommands(2)
+	db := dbs[0]
+
+	exec(db, "rpush", "list", "a", "b")
+	exec(db, "hset", "hash", "f", "v")
+	exec(db, "sadd", "set", "1", "2")
+	exec(db, "set", "str", "s")
+	exec(db, "expire", "list", "100")
+	for _, key := range []string{"list", "hash", "set", "str"} {
+		if r := exec(db, "copy", key, key+"2"); r != ":1\r\n" {
+			t.Error(fmt.Sprintf("COPY %s == %q", key, r))
+		}
+	}
+	// copies are deep
+	exec(db, "rpush", "list2", "c")
+	exec(db, "hset", "hash2", "f", "changed")
+	exec(db, "sadd", "set2", "3")
+	if r := exec(db, "lrange", "list", "0", "-1"); r != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+		t.Error(fmt.Sprintf("source list after pushing to its copy: %q", r))
+	}
+	if r := exec(db, "hget", "hash", "f"); r != "$1\r\nv\r\n" {
+		t.Error(fmt.Sprintf("source hash after setting its copy: %q", r))
+	}
+	if r := exec(db, "scard", "set"); r != ":2\r\n" {
+		t.Error(fmt.Sprintf("source set after adding to its copy: %q", r))
+	}
+	if r := exec(db, "ttl", "list2"); r != ":100\r\n" {
+		t.Error(fmt.Sprintf("COPY ttl == %q, expect 100", r))
+	}
+
+	// REPLACE and DB
+	if r := exec(db, "copy", "str", "hash"); r != ":0\r\n" {
+		t.Error("COPY onto an existing key should fail without REPLACE")
+	}
+	if r := exec(db, "copy", "str", "hash", "replace"); r != ":1\r\n" || exec(db, "type", "hash") != "+string\r\n" {
+		t.Error("COPY REPLACE should overwrite the destination")
+	}
+	if r := exec(db, "copy", "list", "list", "db", "1"); r != ":1\r\n" || exec(dbs[1], "llen", "list") != ":2\r\n" || exec(dbs[1], "ttl", "list") != ":100\r\n" {
+		t.Error("COPY DB should copy the key and its ttl to the other database")
+	}
+	if r := exec(db, "copy", "str", "str"); !strings.HasPrefix(r, "-ERR") {
+		t.Error("COPY onto itself should fail")
+	}
+	if r := exec(db, "copy", "str", "x", "db", "16"); r != "-ERR DB index is out of range\r\n" {
+		t.Error(fmt.Sprintf("COPY to a missing database: %q", r))
+	}
+	if r := exec(db, "copy", "nosuchkey", "x"); r != ":0\r\n" {
+		t.Error("COPY of a missing key should reply 0")
+	}
+}
+
+func TestMoveSwapDbKey(t *testing.T) {
+	dbs, exec := keyCommands(3)
+
+	exec(dbs[0], "set", "a", "1")
+	exec(dbs[0], "expire", "a", "100")
+	exec(dbs[0], "set", "b", "1")
+	exec(dbs[1], "set", "b", "2")
+	if r := exec(dbs[0], "move", "a", "1"); r != ":1\r\n" || exec(dbs[0], "exists", "a") != ":0\r\n" || exec(dbs[1], "ttl", "a") != ":100\r\n" {
+		t.Error("MOVE should move the key and its ttl")
+	}
+	if r := exec(dbs[0], "move", "b", "1"); r != ":0\r\n" || exec(dbs[0], "get", "b") != "$1\r\n1\r\n" {
+		t.Error("MOVE onto an existing key should fail")
+	}
+	if r := exec(dbs[0], "move", "b", "0"); !strings.HasPrefix(r, "-ERR") {
+		t.Error("MOVE to the same database should fail")
+	}
+
+	// db1 holds a and b, db2 nothing
+	if r := exec(dbs[0], "swapdb", "1", "2"); r != "+OK\r\n" {
+		t.Error(fmt.Sprintf("SWAPDB == %q", r))
+	}
+	if exec(dbs[1], "dbsize") != ":0\r\n" || exec(dbs[2], "dbsize") != ":2\r\n" || exec(dbs[2], "ttl", "a") != ":100\r\n" {
+		t.Error("SWAPDB should swap the keys and their ttls")
+	}
+	exec(dbs[2], "set", "c", "3")
+	if exec(dbs[2], "get", "c") != "$1\r\n3\r\n" || exec(dbs[1], "exists", "c") != ":0\r\n" {
+		t.Error("swapped databases should take writes")
+	}
+	if r := exec(dbs[0], "swapdb", "x", "1"); r != "-ERR invalid first DB index\r\n" {
+		t.Error(fmt.Sprintf("SWAPDB with an invalid index: %q", r))
+	}
+	if r := exec(dbs[0], "swapdb", "0", "3"); r != "-ERR DB index is out of range\r\n" {
+		t.Error(fmt.Sprintf("SWAPDB with an index out of range: %q", r))
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	if r := exec(db, "randomkey"); r != "$-1\r\n" {
+		t.Error("RANDOMKEY of an empty database should be nil")
+	}
+	// every key is picked about as often, though segments hold different numbers of keys
+	for i := 0; i < 10; i++ {
+		exec(db, "set", strconv.Itoa(i), "v")
+	}
+	db.setKey("expired", []byte("v"))
+	db.SetExpire("expired", time.Now().Unix()-1)
+	picks := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		picks[exec(db, "randomkey")]++
+	}
+	if picks["$7\r\nexpired\r\n"] != 0 || len(picks) != 10 {
+		t.Error(fmt.Sprintf("RANDOMKEY picks %v", picks))
+	}
+	for key, n := range picks {
+		if n < 800 || n > 1200 {
+			t.Error(fmt.Sprintf("RANDOMKEY picks %q %d times out of 10000", key, n))
+		}
+	}
+
+	// DBSIZE counts expired keys until they are deleted, like redis
+	if r := exec(db, "dbsize"); r != ":11\r\n" {
+		t.Error(fmt.Sprintf("DBSIZE == %q, expect 11", r))
+	}
+	if r := exec(db, "touch", "1", "2", "expired", "nosuchkey"); r != ":2\r\n" {
+		t.Error(fmt.Sprintf("TOUCH == %q, expect 2", r))
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterHashCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+	db := NewMemDb()
+	exec := func(args ...string) resp.RedisData {
+		return CmdTable[args[0]].Executor(db, makeCmd(args...))
+	}
+
+	exec("set", "int", "123")
+	exec("set", "str", "hello")
+	exec("set", "raw", string(bytes.Repeat([]byte("x"), 45)))
+	exec("hset", "hash", "f", "v")
+	exec("rpush", "list", "a")
+	exec("sadd", "ints", "1", "2")
+	exec("sadd", "strs", "a")
+	cases := map[string]string{"int": "int", "str": "embstr", "raw": "raw", "hash": "listpack", "list": "listpack", "ints": "intset", "strs": "listpack"}
+	for key, expect := range cases {
+		if res := exec("object", "encoding", key); string(res.GetBytesData()) != expect {
+			t.Error(fmt.Sprintf("OBJECT ENCODING %s == %s, expect %s", key, res.GetBytesData(), expect))
+		}
+	}
+
+	if res := exec("object", "encoding", "nosuchkey"); !bytes.Equal(res.ToRedisFormat(), []byte("$-1\r\n")) {
+		t.Error("OBJECT ENCODING of a missing key should be nil")
+	}
+	if _, ok := exec("object", "nosuchsubcommand", "int").(*resp.SimpleError); !ok {
+		t.Error("OBJECT with an unknown subcommand should fail")
+	}
+	if help := exec("object", "help").(*resp.RedisArray).GetData(); len(help) != len(objectHelp) {
+		t.Error(fmt.Sprintf("OBJECT HELP returns %d lines", len(help)))
+	}
+	if res := exec("object", "refcount", "str"); res.(*resp.Integer).GetData() != 1 {
+		t.Error("OBJECT REFCOUNT should be 1")
+	}
+
+	// OBJECT and TYPE don't count as accesses, GET does
+	v, _ := db.dict.Get("str")
+	v.(*Object).atime.Add(^uint32(99))
+	exec("type", "str")
+	if idle := exec("object", "idletime", "str").(*resp.Integer).GetData(); idle < 100 || idle > 101 {
+		t.Error(fmt.Sprintf("OBJECT IDLETIME == %d, expect 100", idle))
+	}
+	if freq := exec("object", "freq", "str").(*resp.Integer).GetData(); freq != lfuInitVal {
+		t.Error(fmt.Sprintf("OBJECT FREQ of a new key == %d, expect %d", freq, lfuInitVal))
+	}
+	for i := 0; i < 1000; i++ {
+		exec("get", "str")
+	}
+	if idle := exec("object", "idletime", "str").(*resp.Integer).GetData(); idle != 0 {
+		t.Error(fmt.Sprintf("OBJECT IDLETIME after GET == %d, expect 0", idle))
+	}
+	if freq := exec("object", "freq", "str").(*resp.Integer).GetData(); freq <= lfuInitVal {
+		t.Error(fmt.Sprintf("OBJECT FREQ after 1000 GETs == %d", freq))
+	}
+
+	// SET keeps the counter, RENAME keeps the whole header
+	exec("set", "str", "world")
+	exec("rename", "str", "str2")
+	if freq := exec("object", "freq", "str2").(*resp.Integer).GetData(); freq <= lfuInitVal {
+		t.Error(fmt.Sprintf("OBJECT FREQ after SET and RENAME == %d", freq))
+	}
+}
+
+func TestLFU(t *testing.T) {
+	now := lfuMinutes()
+	if c := lfuDecr(now<<8 | 10); c != 10 {
+		t.Error(fmt.Sprintf("counter decremented right away to %d", c))
+	}
+	if c := lfuDecr((now-3)&0xffff<<8 | 10); c != 7 {
+		t.Error(fmt.Sprintf("counter after 3 minutes == %d, expect 7", c))
+	}
+	if c := lfuDecr((now-30)&0xffff<<8 | 10); c != 0 {
+		t.Error(fmt.Sprintf("counter after 30 minutes == %d, expect 0", c))
+	}
+
+	// about a million accesses saturate the counter, as with redis's default lfu-log-factor
+	counter := uint8(lfuInitVal)
+	for i := 0; i < 100000; i++ {
+		counter = lfuLogIncr(counter)
+	}
+	if counter < 100 || counter == 255 {
+		t.Error(fmt.Sprintf("counter after 100k accesses == %d", counter))
+	}
+}
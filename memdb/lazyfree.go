@@ -0,0 +1,159 @@
+package memdb
+
+import (
+	"gRedis/config"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// Lazy freeing, like redis's lazyfree.c. Removing a value from the keyspace only drops a reference,
+// and the collector reclaims it concurrently, but the memory then stays with the process until the runtime
+// scavenges it. A value freed lazily is handed to a background goroutine, which lets go of it
+// and returns the freed memory to the OS, off the command path; a flush freed synchronously does that before replying.
+
+// lazyfreeThreshold is the number of allocations, e.g. hashtable entries or list nodes,
+// above which a value is worth freeing in the background, as redis's LAZYFREE_THRESHOLD.
+const lazyfreeThreshold = 64
+
+type lazyfreeJob struct {
+	value   any   // the value, or nil for a flush whose tables were already dropped
+	objects int64 // objects freed by the job, as counted in INFO
+}
+
+type lazyfreer struct {
+	mu   sync.Mutex
+	jobs []lazyfreeJob
+	wake chan struct{}
+	once sync.Once
+
+	pending atomic.Int64 // objects waiting to be freed
+	freed   atomic.Int64 // objects freed so far
+}
+
+var lazyfree = &lazyfreer{wake: make(chan struct{}, 1)}
+
+// add queues a job; it never blocks, as it's called under key locks.
+func (f *lazyfreer) add(job lazyfreeJob) {
+	f.once.Do(func() { go f.run() })
+	f.pending.Add(job.objects)
+	f.mu.Lock()
+	f.jobs = append(f.jobs, job)
+	f.mu.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *lazyfreer) run() {
+	for range f.wake {
+		f.mu.Lock()
+		jobs := f.jobs
+		f.jobs = nil
+		f.mu.Unlock()
+		if len(jobs) == 0 {
+			continue
+		}
+
+		objects := int64(0)
+		for i := range jobs {
+			objects += jobs[i].objects
+			jobs[i].value = nil
+		}
+		jobs = nil
+		// one collection for the whole batch
+		debug.FreeOSMemory()
+		f.pending.Add(-objects)
+		f.freed.Add(objects)
+	}
+}
+
+// freeEffort returns the number of allocations making up v, as redis's lazyfreeGetFreeEffort.
+func freeEffort(v any) int {
+	switch v := v.(type) {
+	case *List:
+		nodes := 0
+		for n := v.head; n != nil; n = n.next {
+			nodes++
+		}
+		return nodes
+	case *Hash:
+		if v.table != nil {
+			return len(v.table)
+		}
+	case *Set:
+		if v.table != nil {
+			return len(v.table)
+		}
+	case *ZSet:
+		return v.Len()
+	case *Stream:
+		return len(v.entries.chunks) + len(v.groups)
+	}
+	return 1
+}
+
+// freeObject frees obj, removed from the keyspace, in the background if lazy and obj is big enough to be worth it.
+// The caller holds the key's write lock.
+func freeObject(obj *Object, lazy bool) {
+	if lazy && freeEffort(obj.Value) > lazyfreeThreshold {
+		lazyfree.add(lazyfreeJob{value: obj.Value, objects: 1})
+	}
+}
+
+// deleteKey removes key, freeing its value in the background if lazy; it returns 1 if key existed.
+// The caller holds the key's write lock.
+func (db *MemDb) deleteKey(key string, lazy bool) int {
+	v, ok := db.dict.Get(key)
+	if !ok {
+		return 0
+	}
+	db.dict.Delete(key)
+	db.expires.Delete(key)
+	freeObject(v.(*Object), lazy)
+	return 1
+}
+
+// flush drops every key of dbs and returns how many there were.
+// Its memory is returned to the OS in the background if lazy, or before flush returns.
+func flush(dbs []*MemDb, lazy bool) int {
+	// lock the databases in order, as any command working on several of them must
+	for _, db := range dbs {
+		db.locks.LockAll()
+	}
+	keys := 0
+	for _, db := range dbs {
+		db.expires.clear()
+		keys += db.dict.clear()
+	}
+	for _, db := range dbs {
+		db.locks.UnLockAll()
+	}
+
+	if keys == 0 {
+		return 0
+	}
+	if lazy {
+		lazyfree.add(lazyfreeJob{objects: int64(keys)})
+	} else {
+		debug.FreeOSMemory()
+	}
+	return keys
+}
+
+func lazyfreeUserDel() bool {
+	return config.Conf != nil && config.Conf.LazyfreeLazyUserDel
+}
+
+func lazyfreeUserFlush() bool {
+	return config.Conf != nil && config.Conf.LazyfreeLazyUserFlush
+}
+
+func lazyfreeExpire() bool {
+	return config.Conf != nil && config.Conf.LazyfreeLazyExpire
+}
+
+func lazyfreeServerDel() bool {
+	return config.Conf != nil && config.Conf.LazyfreeLazyServerDel
+}
@@ -0,0 +1,115 @@
+package memdb
+
+import (
+	"fmt"
+	"gRedis/config"
+	"gRedis/resp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitLazyfree waits for the background freer to finish its jobs.
+func waitLazyfree(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for lazyfree.pending.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("lazy frees don't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLazyfree(t *testing.T) {
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterSetCommands()
+	RegisterServerCommands()
+	dbs := NewMemDbs(2, nil)
+	exec := func(db *MemDb, args ...string) resp.RedisData {
+		return CmdTable[args[0]].Executor(db, makeCmd(args...))
+	}
+	// a hashtable set, past set-max-listpack-entries
+	bigSet := func(db *MemDb, key string) {
+		for i := 0; i < 200; i++ {
+			exec(db, "sadd", key, "m"+strconv.Itoa(i))
+		}
+	}
+	info := func() map[string]string {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(string(exec(dbs[0], "info").GetBytesData()), "\r\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				fields[name] = value
+			}
+		}
+		return fields
+	}
+
+	// UNLINK hands big values to the freer, small ones are simply dropped
+	waitLazyfree(t)
+	freed := lazyfree.freed.Load()
+	bigSet(dbs[0], "big")
+	exec(dbs[0], "set", "small", "v")
+	if res := exec(dbs[0], "unlink", "big", "small", "nosuchkey"); res.(*resp.Integer).GetData() != 2 {
+		t.Error(fmt.Sprintf("UNLINK == %d, expect 2", res.(*resp.Integer).GetData()))
+	}
+	if res := exec(dbs[0], "exists", "big", "small"); res.(*resp.Integer).GetData() != 0 {
+		t.Error("UNLINK should remove the keys right away")
+	}
+	waitLazyfree(t)
+	if n := lazyfree.freed.Load() - freed; n != 1 {
+		t.Error(fmt.Sprintf("%d objects freed lazily after UNLINK, expect 1", n))
+	}
+
+	// DEL frees lazily with lazyfree-lazy-user-del only
+	bigSet(dbs[0], "big")
+	exec(dbs[0], "del", "big")
+	if n := lazyfree.freed.Load() - freed; n != 1 {
+		t.Error(fmt.Sprintf("%d objects freed lazily after DEL, expect 1", n))
+	}
+	config.Conf.LazyfreeLazyUserDel = true
+	bigSet(dbs[0], "big")
+	exec(dbs[0], "del", "big")
+	config.Conf.LazyfreeLazyUserDel = false
+	waitLazyfree(t)
+	if n := lazyfree.freed.Load() - freed; n != 2 {
+		t.Error(fmt.Sprintf("%d objects freed lazily after DEL with lazyfree-lazy-user-del, expect 2", n))
+	}
+
+	// FLUSHDB empties one database, FLUSHALL all of them
+	exec(dbs[0], "set", "a", "1")
+	exec(dbs[0], "expire", "a", "100")
+	exec(dbs[1], "set", "b", "1")
+	if f := info(); f["db0"] != "keys=1,expires=1" || f["db1"] != "keys=1,expires=0" {
+		t.Error(fmt.Sprintf("INFO keyspace db0:%s db1:%s", f["db0"], f["db1"]))
+	}
+	if res := exec(dbs[0], "flushdb", "sync"); string(res.ToRedisFormat()) != "+OK\r\n" {
+		t.Error("FLUSHDB should reply OK")
+	}
+	if dbs[0].dict.Count() != 0 || dbs[0].expires.Count() != 0 || dbs[1].dict.Count() != 1 {
+		t.Error("FLUSHDB should empty only its database")
+	}
+	exec(dbs[0], "set", "a", "1")
+	exec(dbs[0], "flushall", "async")
+	if dbs[0].dict.Count() != 0 || dbs[1].dict.Count() != 0 {
+		t.Error("FLUSHALL ASYNC should empty every database right away")
+	}
+	waitLazyfree(t)
+	if n := lazyfree.freed.Load() - freed; n != 4 {
+		t.Error(fmt.Sprintf("%d objects freed lazily after FLUSHALL ASYNC, expect 4", n))
+	}
+	if f := info(); f["lazyfree_pending_objects"] != "0" || f["lazyfreed_objects"] != strconv.FormatInt(lazyfree.freed.Load(), 10) {
+		t.Error(fmt.Sprintf("INFO lazyfree_pending_objects:%s lazyfreed_objects:%s", f["lazyfree_pending_objects"], f["lazyfreed_objects"]))
+	}
+	if _, ok := info()["db0"]; ok {
+		t.Error("INFO keyspace should skip empty databases")
+	}
+
+	if _, ok := exec(dbs[0], "flushdb", "later").(*resp.SimpleError); !ok {
+		t.Error("FLUSHDB with an unknown option should fail")
+	}
+	if res := exec(dbs[0], "info", "memory"); strings.Contains(string(res.GetBytesData()), "# Keyspace") {
+		t.Error("INFO memory should reply the memory section only")
+	}
+}
@@ -2,27 +2,23 @@ package memdb
 
 import (
 	"bytes"
-	"fmt"
 	"gRedis/resp"
+	"math"
 	"strconv"
 	"strings"
 )
 
 func lIndexList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
 	index, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 	}
 
@@ -30,7 +26,7 @@ func lIndexList(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewBulkString(nil)
 	}
@@ -38,32 +34,28 @@ func lIndexList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
-	node := l.Index(index)
-	if node == nil {
+	val, ok := l.Index(index)
+	if !ok {
 		return resp.NewBulkString(nil)
 	}
 
-	return resp.NewBulkString(node.Val)
+	return resp.NewBulkString(val)
 }
 
 func lInsertList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 5 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	var before bool
 	flag := strings.ToLower(string(cmd[2]))
 	if flag != "before" && flag != "after" {
-		return resp.NewSimpleError("syntax error")
+		return resp.NewSyntaxError()
 	} else {
 		if flag == "before" {
 			before = true
@@ -71,13 +63,13 @@ func lInsertList(db *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	pivot := cmd[3]
-	val := cmd[4]
+	val := bytes.Clone(cmd[4])
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -85,7 +77,7 @@ func lInsertList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	if before {
@@ -105,20 +97,16 @@ func lInsertList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lLenList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -126,7 +114,7 @@ func lLenList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	return resp.NewInteger(int64(l.Len))
@@ -134,89 +122,94 @@ func lLenList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lMoveList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 5 {
-		return resp.NewSimpleError("wrong number of arguments for command")
-	}
-
-	// passive delete expired key
-	src := string(cmd[1])
-	if db.DeleteExpiredKey(src) {
-		return resp.NewBulkString(nil)
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	des := string(cmd[2])
-	db.DeleteExpiredKey(des)
 	srcDrc := strings.ToLower(string(cmd[3]))
 	desDrc := strings.ToLower(string(cmd[4]))
 	if (srcDrc != "left" && srcDrc != "right") || (desDrc != "left" && desDrc != "right") {
-		return resp.NewSimpleError("syntax error")
+		return resp.NewSyntaxError()
 	}
 
+	return moveElement(db, string(cmd[1]), string(cmd[2]), srcDrc == "left", desDrc == "left")
+}
+
+// RPOPLPUSH is LMOVE source destination RIGHT LEFT
+func rPopLPushList(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	return moveElement(db, string(cmd[1]), string(cmd[2]), false, true)
+}
+
+// moveElement pops an element of src and pushes it to des, at the left ends if srcLeft and desLeft.
+func moveElement(db *MemDb, src, des string, srcLeft, desLeft bool) resp.RedisData {
 	db.locks.MLock([]string{src, des})
 	defer db.locks.MUnLock([]string{src, des})
 
 	// key not existed
-	srcVal, ok := db.dict.Get(src)
+	srcVal, ok := db.lookupKeyWrite(src)
 	if !ok {
 		return resp.NewBulkString(nil)
 	}
-	desVal, ok := db.dict.Get(des)
-	if !ok {
-		desVal = NewList()
-		db.dict.Set(des, desVal)
-	}
 
-	// wrong type
+	// wrong type, checked for both keys before anything changes
 	srcList, ok := srcVal.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
-
-	defer func() {
-		if srcList.Len == 0 {
-			db.dict.Delete(src)
-			db.DeleteExpire(src)
+	desVal, desOk := db.lookupKeyWrite(des)
+	if desOk {
+		if _, ok := desVal.(*List); !ok {
+			return resp.NewWrongTypeError()
 		}
-	}()
-
-	desList, ok := desVal.(*List)
-	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+	} else {
+		desVal = NewList()
+		db.setKey(des, desVal)
 	}
+	desList := desVal.(*List)
 
-	var srcPop *ListNode
-	if srcDrc == "left" {
-		srcPop = srcList.LPop()
+	var srcPop []byte
+	if srcLeft {
+		srcPop, _ = srcList.LPop()
 	} else {
-		srcPop = srcList.RPop()
+		srcPop, _ = srcList.RPop()
+	}
+	if srcList.Len == 0 {
+		db.dict.Delete(src)
+		db.DeleteExpire(src)
 	}
 
-	if desDrc == "left" {
-		desList.LPush(srcPop.Val)
+	if desLeft {
+		desList.LPush(srcPop)
 	} else {
-		fmt.Println(desList, desList.Head, desList.Tail)
-		desList.RPush(srcPop.Val)
+		desList.RPush(srcPop)
 	}
 
-	return resp.NewBulkString(srcPop.Val)
+	return resp.NewBulkString(srcPop)
 }
 
 func lPopList(db *MemDb, cmd [][]byte) resp.RedisData {
-	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+	return popList(db, cmd, true)
+}
+
+// popList pops from the left of the list if left, else from the right.
+// With a count, even 1, it replies an array, nil if the key doesn't exist.
+func popList(db *MemDb, cmd [][]byte, left bool) resp.RedisData {
+	if len(cmd) != 2 && len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
+	hasCount := len(cmd) == 3
 	count := 1
-	var err error
-	if len(cmd) == 3 {
+	if hasCount {
+		var err error
 		count, err = strconv.Atoi(string(cmd[2]))
-		if err != nil || count <= 0 {
-			return resp.NewSimpleError("value is out of range, must be positive")
+		if err != nil || count < 0 {
+			return resp.NewNotPositiveError()
 		}
 	}
 
@@ -224,17 +217,24 @@ func lPopList(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
+		if hasCount {
+			return resp.NewArray(nil)
+		}
 		return resp.NewBulkString(nil)
 	}
 
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
+	pop := l.RPop
+	if left {
+		pop = l.LPop
+	}
 	defer func() {
 		if l.Len == 0 {
 			db.dict.Delete(key)
@@ -242,43 +242,101 @@ func lPopList(db *MemDb, cmd [][]byte) resp.RedisData {
 		}
 	}()
 
-	if count == 1 {
-		node := l.LPop()
-		if node == nil {
-			return resp.NewBulkString(nil)
-		} else {
-			return resp.NewBulkString(node.Val)
+	if !hasCount {
+		val, _ := pop()
+		return resp.NewBulkString(val)
+	}
+	return resp.NewArray(popElements(pop, count))
+}
+
+// popElements pops up to count elements with pop.
+func popElements(pop func() ([]byte, bool), count int) []resp.RedisData {
+	// not nil: a count of 0 replies an empty array
+	res := make([]resp.RedisData, 0)
+	for i := 0; i < count; i++ {
+		val, ok := pop()
+		if !ok {
+			break
 		}
+		res = append(res, resp.NewBulkString(val))
 	}
+	return res
+}
 
-	if count > l.Len {
-		count = l.Len
+// LMPOP pops up to count elements from the first non-empty list of the given keys,
+// replying its key and the elements, or nil if all lists are empty.
+func lMPopList(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	res := make([]resp.RedisData, 0, count)
-	for i := 0; i < count; i++ {
-		node := l.LPop()
-		if node == nil {
-			break
+	numKeys, err := strconv.Atoi(string(cmd[1]))
+	if err != nil || numKeys <= 0 {
+		return resp.NewErrorReply(resp.ErrPrefix, "numkeys should be greater than 0")
+	}
+	if numKeys > len(cmd)-3 {
+		return resp.NewSyntaxError()
+	}
+	drc := strings.ToLower(string(cmd[numKeys+2]))
+	if drc != "left" && drc != "right" {
+		return resp.NewSyntaxError()
+	}
+	count := 1
+	switch opts := cmd[numKeys+3:]; {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToLower(string(opts[0])) == "count":
+		count, err = strconv.Atoi(string(opts[1]))
+		if err != nil || count <= 0 {
+			return resp.NewErrorReply(resp.ErrPrefix, "count should be greater than 0")
 		}
-		res = append(res, resp.NewBulkString(node.Val))
+	default:
+		return resp.NewSyntaxError()
 	}
 
-	return resp.NewArray(res)
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(cmd[i+2])
+	}
+	db.locks.MLock(keys)
+	defer db.locks.MUnLock(keys)
+
+	for _, key := range keys {
+		v, ok := db.lookupKeyWrite(key)
+		if !ok {
+			continue
+		}
+		l, ok := v.(*List)
+		if !ok {
+			return resp.NewWrongTypeError()
+		}
+
+		pop := l.RPop
+		if drc == "left" {
+			pop = l.LPop
+		}
+		res := popElements(pop, count)
+		if l.Len == 0 {
+			db.dict.Delete(key)
+			db.DeleteExpire(key)
+		}
+		return resp.NewArray([]resp.RedisData{resp.NewBulkString([]byte(key)), resp.NewArray(res)})
+	}
+
+	return resp.NewArray(nil)
 }
 
 func lPosList(db *MemDb, cmd [][]byte) resp.RedisData {
-	if len(cmd) < 3 || len(cmd)&1 != 1 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
 	element := cmd[2]
+	if len(cmd)&1 != 1 {
+		// an option without its value
+		return resp.NewSyntaxError()
+	}
 
 	var rank, count, maxLen bool
 	var rankVal, countVal, maxLenVal int
@@ -291,31 +349,31 @@ func lPosList(db *MemDb, cmd [][]byte) resp.RedisData {
 				rank = true
 				rankVal, err = strconv.Atoi(string(cmd[i+1]))
 				if err != nil {
-					return resp.NewSimpleError("value is not an integer")
+					return resp.NewNotIntegerError()
 				}
-				if rankVal == 0 {
-					return resp.NewSimpleError("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				if rankVal == 0 || rankVal == math.MinInt {
+					return resp.NewErrorReply(resp.ErrPrefix, "RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
 				}
 			case "count":
 				count = true
 				countVal, err = strconv.Atoi(string(cmd[i+1]))
 				if err != nil {
-					return resp.NewSimpleError("value is not an integer")
+					return resp.NewNotIntegerError()
 				}
 				if countVal < 0 {
-					return resp.NewSimpleError("COUNT can't be negative")
+					return resp.NewErrorReply(resp.ErrPrefix, "COUNT can't be negative")
 				}
 			case "maxlen":
 				maxLen = true
 				maxLenVal, err = strconv.Atoi(string(cmd[i+1]))
 				if err != nil {
-					return resp.NewSimpleError("value is not an integer")
+					return resp.NewNotIntegerError()
 				}
 				if maxLenVal < 0 {
-					return resp.NewSimpleError("MAXLEN can't be negative")
+					return resp.NewErrorReply(resp.ErrPrefix, "MAXLEN can't be negative")
 				}
 			default:
-				return resp.NewSimpleError(fmt.Sprintf("Unsupported option %s", string(cmd[i])))
+				return resp.NewSyntaxError()
 			}
 		}
 	}
@@ -323,177 +381,104 @@ func lPosList(db *MemDb, cmd [][]byte) resp.RedisData {
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	// key not existed
-	v, ok := db.dict.Get(key)
+	// key not existed: COUNT replies an array, even empty
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
+		if count {
+			return resp.NewArray([]resp.RedisData{})
+		}
 		return resp.NewBulkString(nil)
 	}
 
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
-	var res []resp.RedisData
-	var reverse bool
-	var pos int
-
 	// normally pos without options
 	if !count && !rank && !maxLen {
-		pos = l.Pos(element)
+		pos := l.Pos(element)
 		if pos == -1 {
 			return resp.NewBulkString(nil)
-		} else {
-			return resp.NewInteger(int64(pos))
 		}
+		return resp.NewInteger(int64(pos))
 	}
 
-	if count && countVal == 0 {
+	if !rank {
+		rankVal = 1
+	}
+	if !count {
+		countVal = 1
+	}
+	if countVal == 0 {
 		countVal = l.Len
 	}
-
-	if maxLen && maxLenVal == 0 {
+	if maxLenVal == 0 {
 		maxLenVal = l.Len
 	}
 
-	var cur *ListNode
-	if rank {
-		if rankVal > 0 {
-			pos = -1
-			for cur = l.Head.Next; cur != l.Tail; cur = cur.Next {
-				pos++
-				if bytes.Equal(element, cur.Val) {
-					rankVal--
-				}
-				if maxLen {
-					maxLenVal--
-					if maxLenVal == 0 {
-						break
-					}
-				}
-				if rankVal == 0 {
-					break
-				}
-			}
-		} else {
-			reverse = true
-			pos = l.Len
-			for cur = l.Tail.Prev; cur != l.Head; cur = cur.Prev {
-				pos--
-				if bytes.Equal(element, cur.Val) {
-					rankVal++
-				}
-				if maxLen {
-					maxLenVal--
-					if maxLenVal == 0 {
-						break
-					}
-				}
-				if rankVal == 0 {
-					break
-				}
-			}
+	// skip the first |rank|-1 matches, then collect up to count matches within the first maxlen elements
+	skip := rankVal - 1
+	reverse := rankVal < 0
+	if reverse {
+		skip = -rankVal - 1
+	}
+	var res []resp.RedisData
+	compared := 0
+	l.Scan(reverse, func(index int, val []byte) bool {
+		if compared == maxLenVal {
+			return false
 		}
-	} else {
-		cur = l.Head.Next
-		pos = 0
-		if maxLen {
-			maxLenVal--
+		compared++
+		if !bytes.Equal(element, val) {
+			return true
 		}
-	}
-
-	// when rank is out of range, return nil
-	if (rank && rankVal != 0) || cur == l.Head || cur == l.Tail {
-		return resp.NewBulkString(nil)
-	}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		res = append(res, resp.NewInteger(int64(index)))
+		return len(res) < countVal
+	})
 
 	if count {
-		if !reverse {
-			for ; cur != l.Tail; cur = cur.Next {
-				if bytes.Equal(element, cur.Val) {
-					res = append(res, resp.NewInteger(int64(pos)))
-					countVal--
-					if countVal == 0 {
-						break
-					}
-				}
-				if maxLen {
-					if maxLenVal <= 0 {
-						break
-					}
-					maxLenVal--
-				}
-				pos++
-			}
-		} else {
-			for ; cur != l.Head; cur = cur.Prev {
-				if bytes.Equal(element, cur.Val) {
-					res = append(res, resp.NewInteger(int64(pos)))
-					countVal--
-					if countVal == 0 {
-						break
-					}
-				}
-				if maxLen {
-					if maxLenVal <= 0 {
-						break
-					}
-					maxLenVal--
-				}
-				pos--
-			}
-		}
-	} else {
-		// if count is not set, return first find pos inside maxLen range
-		for ; cur != l.Tail; cur = cur.Next {
-			if bytes.Equal(element, cur.Val) {
-				return resp.NewInteger(int64(pos))
-			}
-			pos++
-			if maxLen {
-				if maxLenVal <= 0 {
-					break
-				}
-				maxLenVal--
-			}
+		if res == nil {
+			res = []resp.RedisData{}
 		}
-		return resp.NewBulkString(nil)
+		return resp.NewArray(res)
 	}
-
 	if len(res) == 0 {
 		return resp.NewBulkString(nil)
 	}
-	return resp.NewArray(res)
+	return res[0]
 }
 
 func lPushList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewList()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	}
 
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	for _, element := range cmd[2:] {
-		l.LPush(element)
+		l.LPush(bytes.Clone(element))
 	}
 
 	return resp.NewInteger(int64(l.Len))
@@ -501,20 +486,16 @@ func lPushList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lPushXList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -522,11 +503,11 @@ func lPushXList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	for _, element := range cmd[2:] {
-		l.LPush(element)
+		l.LPush(bytes.Clone(element))
 	}
 
 	return resp.NewInteger(int64(l.Len))
@@ -534,30 +515,26 @@ func lPushXList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lRangeList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewArray(nil)
-	}
 
 	start, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	end, err := strconv.Atoi(string(cmd[3]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewArray(nil)
 	}
@@ -565,7 +542,7 @@ func lRangeList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	vals := l.Range(start, end)
@@ -580,18 +557,14 @@ func lRangeList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lRemList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	count, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	element := cmd[3]
@@ -600,7 +573,7 @@ func lRemList(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -608,7 +581,7 @@ func lRemList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	defer func() {
@@ -625,41 +598,37 @@ func lRemList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lSetList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewSimpleError("no such key")
-	}
 
 	index, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 	}
 
-	val := cmd[3]
+	val := bytes.Clone(cmd[3])
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
-		return resp.NewSimpleError("no such key")
+		return resp.NewNoSuchKeyError()
 	}
 
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	if !l.Set(val, index) {
-		return resp.NewSimpleError("index out of range")
+		return resp.NewOutOfRangeError()
 	}
 
 	return resp.NewSimpleString("OK")
@@ -667,38 +636,34 @@ func lSetList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func lTrimList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewSimpleError("no such key")
-	}
 
 	start, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	end, err := strconv.Atoi(string(cmd[3]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
-		return resp.NewSimpleError("no such key")
+		return resp.NewNoSuchKeyError()
 	}
 
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	defer func() {
@@ -714,99 +679,34 @@ func lTrimList(db *MemDb, cmd [][]byte) resp.RedisData {
 }
 
 func rPopList(db *MemDb, cmd [][]byte) resp.RedisData {
-	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
-	}
-
-	// passive delete expired key
-	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
-
-	count := 1
-	var err error
-	if len(cmd) == 3 {
-		count, err = strconv.Atoi(string(cmd[2]))
-		if err != nil || count <= 0 {
-			return resp.NewSimpleError("value is out of range, must be positive")
-		}
-	}
-
-	db.locks.Lock(key)
-	defer db.locks.UnLock(key)
-
-	// key not existed
-	v, ok := db.dict.Get(key)
-	if !ok {
-		return resp.NewBulkString(nil)
-	}
-
-	// wrong type
-	l, ok := v.(*List)
-	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
-	}
-
-	defer func() {
-		if l.Len == 0 {
-			db.dict.Delete(key)
-			db.DeleteExpire(key)
-		}
-	}()
-
-	if count == 1 {
-		node := l.RPop()
-		if node == nil {
-			return resp.NewBulkString(nil)
-		} else {
-			return resp.NewBulkString(node.Val)
-		}
-	}
-
-	if count > l.Len {
-		count = l.Len
-	}
-
-	res := make([]resp.RedisData, 0, count)
-	for i := 0; i < count; i++ {
-		node := l.RPop()
-		if node == nil {
-			break
-		}
-		res = append(res, resp.NewBulkString(node.Val))
-	}
-
-	return resp.NewArray(res)
+	return popList(db, cmd, false)
 }
 
 func rPushList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewList()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	}
 
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	for _, element := range cmd[2:] {
-		l.RPush(element)
+		l.RPush(bytes.Clone(element))
 	}
 
 	return resp.NewInteger(int64(l.Len))
@@ -814,20 +714,16 @@ func rPushList(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func rPushXList(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// key not existed
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -835,30 +731,32 @@ func rPushXList(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	l, ok := v.(*List)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	for _, element := range cmd[2:] {
-		l.RPush(element)
+		l.RPush(bytes.Clone(element))
 	}
 
 	return resp.NewInteger(int64(l.Len))
 }
 
 func RegisterListCommands() {
-	RegisterCommand("lindex", lIndexList)
-	RegisterCommand("linsert", lInsertList)
-	RegisterCommand("llen", lLenList)
-	RegisterCommand("lmove", lMoveList)
-	RegisterCommand("lpop", lPopList)
-	RegisterCommand("lpos", lPosList)
-	RegisterCommand("lpush", lPushList)
-	RegisterCommand("lpushx", lPushXList)
-	RegisterCommand("lrange", lRangeList)
-	RegisterCommand("lrem", lRemList)
-	RegisterCommand("lset", lSetList)
-	RegisterCommand("ltrim", lTrimList)
-	RegisterCommand("rpop", rPopList)
-	RegisterCommand("rpush", rPushList)
-	RegisterCommand("rpushx", rPushXList)
+	RegisterCommand("lindex", lIndexList, 1, 1, 1)
+	RegisterCommand("linsert", lInsertList, 1, 1, 1)
+	RegisterCommand("llen", lLenList, 1, 1, 1)
+	RegisterCommand("lmove", lMoveList, 1, 2, 1)
+	RegisterCommand("lmpop", lMPopList, 2, -1, 1)
+	RegisterCommand("lpop", lPopList, 1, 1, 1)
+	RegisterCommand("lpos", lPosList, 1, 1, 1)
+	RegisterCommand("lpush", lPushList, 1, 1, 1)
+	RegisterCommand("lpushx", lPushXList, 1, 1, 1)
+	RegisterCommand("lrange", lRangeList, 1, 1, 1)
+	RegisterCommand("lrem", lRemList, 1, 1, 1)
+	RegisterCommand("lset", lSetList, 1, 1, 1)
+	RegisterCommand("ltrim", lTrimList, 1, 1, 1)
+	RegisterCommand("rpop", rPopList, 1, 1, 1)
+	RegisterCommand("rpoplpush", rPopLPushList, 1, 2, 1)
+	RegisterCommand("rpush", rPushList, 1, 1, 1)
+	RegisterCommand("rpushx", rPushXList, 1, 1, 1)
 }
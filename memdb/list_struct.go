@@ -2,157 +2,419 @@ package memdb
 
 import (
 	"bytes"
+	"gRedis/config"
+	"gRedis/util"
 )
 
+// List is a quicklist, like redis's: a doubly linked list of nodes, each packing many elements into a listpack.
+// Index operations skip whole nodes, and an element costs a few bytes of overhead instead of a heap node.
+// Nodes deeper than compressDepth from both ends are LZF-compressed while nobody writes to them.
+//
+// Elements returned by List are copies, as nodes are rewritten in place.
+// Reads don't change the list, so they may run concurrently under a read lock.
 type List struct {
-	Head *ListNode
-	Tail *ListNode
+	head *quicklistNode
+	tail *quicklistNode
 	Len  int
+
+	fill          int // list-max-listpack-size: entries per node if positive, else -1..-5 for 4kb..64kb per node
+	compressDepth int // list-compress-depth: nodes kept raw at each end, 0 disables compression
 }
 
-type ListNode struct {
-	Prev *ListNode
-	Next *ListNode
-	Val  []byte
+type quicklistNode struct {
+	prev  *quicklistNode
+	next  *quicklistNode
+	lp    []byte // listpack of the elements, nil while compressed
+	zlp   []byte // LZF-compressed lp
+	raw   int    // len(lp) while compressed
+	count int
 }
 
+const (
+	defaultListFill = -2 // 8kb per node
+
+	// a node limited by entries count still doesn't grow past this
+	listNodeSafetyLimit = 8192
+	// smaller nodes aren't worth compressing
+	listMinCompressBytes = 48
+)
+
 func NewList() *List {
-	// dummy node
-	head := &ListNode{}
-	tail := &ListNode{}
-	head.Next = tail
-	tail.Prev = head
-	return &List{Head: head, Tail: tail, Len: 0}
+	l := &List{fill: defaultListFill}
+	if config.Conf != nil {
+		if config.Conf.ListMaxListpackSize != 0 {
+			l.fill = config.Conf.ListMaxListpackSize
+		}
+		l.compressDepth = config.Conf.ListCompressDepth
+	}
+	return l
 }
 
-func (l *List) Index(index int) *ListNode {
-	var cur *ListNode
-	if index < 0 {
-		if -index > l.Len {
-			return nil
+// Encoding returns listpack while the list fits in a single node, as redis converts small lists to a plain listpack.
+func (l *List) Encoding() string {
+	if l.head == l.tail {
+		return encodingListpack
+	}
+	return encodingQuicklist
+}
+
+// nodeMaxBytes returns the listpack size limit of a node.
+func (l *List) nodeMaxBytes() int {
+	if l.fill > 0 {
+		return listNodeSafetyLimit
+	}
+	fill := -l.fill
+	if fill > 5 {
+		fill = 5
+	}
+	return 4096 << (fill - 1)
+}
+
+// allowInsert reports whether n can take one more entry of size bytes.
+func (l *List) allowInsert(n *quicklistNode, size int) bool {
+	if n == nil {
+		return false
+	}
+	if n.count == 0 {
+		return true
+	}
+	if l.fill > 0 && n.count >= l.fill {
+		return false
+	}
+	return n.size()+size <= l.nodeMaxBytes()
+}
+
+func (n *quicklistNode) size() int {
+	if n.lp == nil {
+		return n.raw
+	}
+	return len(n.lp)
+}
+
+// read returns the listpack of n without changing n, decompressing a copy if needed.
+func (n *quicklistNode) read() []byte {
+	if n.lp != nil || n.zlp == nil {
+		return n.lp
+	}
+	lp, err := util.LZFDecompress(n.zlp, n.raw)
+	if err != nil {
+		panic(err)
+	}
+	return lp
+}
+
+// decompress makes n writable; the caller holds the write lock.
+func (n *quicklistNode) decompress() {
+	if n.zlp != nil {
+		n.lp = n.read()
+		n.zlp = nil
+		n.raw = 0
+	}
+}
+
+func (n *quicklistNode) compress() {
+	if n.lp == nil || len(n.lp) < listMinCompressBytes {
+		return
+	}
+	// redis compresses only if it saves some bytes
+	z := util.LZFCompress(n.lp)
+	if len(z)+8 > len(n.lp) {
+		return
+	}
+	n.zlp, n.raw, n.lp = append([]byte(nil), z...), len(n.lp), nil
+}
+
+// shrink drops the spare capacity appends left in a full node.
+func (n *quicklistNode) shrink() {
+	if n != nil && n.lp != nil && cap(n.lp) > len(n.lp)+len(n.lp)/8 {
+		n.lp = append([]byte(nil), n.lp...)
+	}
+}
+
+// compress keeps the compressDepth nodes at each end raw and compresses the nodes just past them,
+// plus the interior nodes given, after they have been written to.
+func (l *List) compress(touched ...*quicklistNode) {
+	if l.compressDepth <= 0 {
+		return
+	}
+	ends := make(map[*quicklistNode]bool, 2*l.compressDepth)
+	fwd, back := l.head, l.tail
+	for i := 0; i < l.compressDepth; i++ {
+		if fwd != nil {
+			fwd.decompress()
+			ends[fwd] = true
+			fwd = fwd.next
 		}
-		cur = l.Tail.Prev
-		for i := 0; i < -index-1; i++ {
-			cur = cur.Prev
+		if back != nil {
+			back.decompress()
+			ends[back] = true
+			back = back.prev
+		}
+	}
+	for _, n := range append(touched, fwd, back) {
+		if n != nil && !ends[n] {
+			n.compress()
 		}
+	}
+}
+
+// insertNode links n after prev, or at the head if prev is nil.
+func (l *List) insertNode(prev, n *quicklistNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+}
+
+func (l *List) removeNode(n *quicklistNode) {
+	if n.prev == nil {
+		l.head = n.next
 	} else {
-		if index >= l.Len {
-			return nil
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	l.Len -= n.count
+}
+
+// split moves the upper half of an oversized node to a new node after it, until every node fits.
+func (l *List) split(n *quicklistNode) []*quicklistNode {
+	nodes := []*quicklistNode{n}
+	for n.count > 1 && (n.size() > l.nodeMaxBytes() || (l.fill > 0 && n.count > l.fill)) {
+		half := n.count / 2
+		off := lpSeek(n.lp, n.count, half)
+		right := &quicklistNode{lp: append([]byte(nil), n.lp[off:]...), count: n.count - half}
+		n.lp = n.lp[:off:off]
+		n.count = half
+		l.insertNode(n, right)
+		nodes = append(nodes, right)
+	}
+	return nodes
+}
+
+// locate returns the node holding element index, which must be in range, and the index within the node.
+func (l *List) locate(index int) (*quicklistNode, int) {
+	if index < l.Len/2 {
+		n := l.head
+		for index >= n.count {
+			index -= n.count
+			n = n.next
+		}
+		return n, index
+	}
+	n := l.tail
+	index = l.Len - 1 - index
+	for index >= n.count {
+		index -= n.count
+		n = n.prev
+	}
+	return n, n.count - 1 - index
+}
+
+// normIndex turns a negative index into a positive one, and reports whether it's in range.
+func (l *List) normIndex(index int) (int, bool) {
+	if index < 0 {
+		index += l.Len
+	}
+	return index, index >= 0 && index < l.Len
+}
+
+func (l *List) Index(index int) ([]byte, bool) {
+	index, ok := l.normIndex(index)
+	if !ok {
+		return nil, false
+	}
+	n, i := l.locate(index)
+	lp := n.read()
+	val, _ := lpGet(lp, lpSeek(lp, n.count, i))
+	return append([]byte(nil), val...), true
+}
+
+// Scan calls fn with each element and its index, from the head or from the tail, until fn returns false.
+// val is only valid during the call.
+func (l *List) Scan(reverse bool, fn func(index int, val []byte) bool) {
+	if !reverse {
+		index := 0
+		for n := l.head; n != nil; n = n.next {
+			lp := n.read()
+			for off := 0; off < len(lp); index++ {
+				var val []byte
+				val, off = lpGet(lp, off)
+				if !fn(index, val) {
+					return
+				}
+			}
 		}
-		cur = l.Head.Next
-		// 这里不包括index的原因是因为以及access了一次node
-		for i := 0; i < index; i++ {
-			cur = cur.Next
+		return
+	}
+	index := l.Len - 1
+	for n := l.tail; n != nil; n = n.prev {
+		lp := n.read()
+		for off := len(lp); off > 0; index-- {
+			off = lpPrev(lp, off)
+			val, _ := lpGet(lp, off)
+			if !fn(index, val) {
+				return
+			}
 		}
 	}
-	return cur
 }
 
 func (l *List) Pos(val []byte) int {
-	pos := 0
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if bytes.Equal(cur.Val, val) {
-			return pos
+	pos := -1
+	l.Scan(false, func(index int, v []byte) bool {
+		if string(v) == string(val) {
+			pos = index
+			return false
 		}
-		pos++
-	}
-	return -1
+		return true
+	})
+	return pos
 }
 
-func (l *List) InsertBefore(val []byte, pivot []byte) bool {
-	var ok bool
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if bytes.Equal(cur.Val, pivot) {
-			ok = true
-			node := &ListNode{Prev: cur.Prev, Next: cur, Val: val}
-			cur.Prev = node
-			node.Prev.Next = node
-			l.Len++
+// insert inserts val before or after the first element equal to pivot.
+func (l *List) insert(val []byte, pivot []byte, after bool) bool {
+	for n := l.head; n != nil; n = n.next {
+		lp := n.read()
+		off := lpFind(lp, 0, pivot, 0)
+		if off < 0 {
+			continue
 		}
+
+		n.decompress()
+		if after {
+			_, off = lpGet(n.lp, off)
+		}
+		n.lp = lpInsert(n.lp, off, val)
+		n.count++
+		l.Len++
+		l.compress(l.split(n)...)
+		return true
 	}
-	return ok
+	return false
+}
+
+func (l *List) InsertBefore(val []byte, pivot []byte) bool {
+	return l.insert(val, pivot, false)
 }
 
 func (l *List) InsertAfter(val []byte, pivot []byte) bool {
-	var ok bool
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if bytes.Equal(cur.Val, pivot) {
-			ok = true
-			node := &ListNode{Prev: cur, Next: cur.Next, Val: val}
-			cur.Next = node
-			node.Next.Prev = node
-			l.Len++
-		}
-	}
-	return ok
+	return l.insert(val, pivot, true)
 }
 
-func (l *List) LPop() *ListNode {
+func (l *List) LPop() ([]byte, bool) {
 	if l.Len == 0 {
-		return nil
+		return nil, false
 	}
-	node := l.Head.Next
-
-	l.Head.Next = node.Next
-	node.Next.Prev = l.Head
-	l.Len--
-	return node
+	n := l.head
+	val, next := lpGet(n.lp, 0)
+	val = append([]byte(nil), val...)
+	l.deleteEntries(n, 0, next, 1)
+	return val, true
 }
 
-func (l *List) RPop() *ListNode {
+func (l *List) RPop() ([]byte, bool) {
 	if l.Len == 0 {
-		return nil
+		return nil, false
 	}
-	node := l.Tail.Prev
+	n := l.tail
+	off := lpPrev(n.lp, len(n.lp))
+	val, _ := lpGet(n.lp, off)
+	val = append([]byte(nil), val...)
+	l.deleteEntries(n, off, len(n.lp), 1)
+	return val, true
+}
 
-	l.Tail.Prev = node.Prev
-	node.Prev.Next = l.Tail
-	l.Len--
-	return node
+// deleteEntries removes count entries between offsets from and to of the raw node n.
+func (l *List) deleteEntries(n *quicklistNode, from, to int, count int) {
+	if count == n.count {
+		l.removeNode(n)
+		l.compress()
+		return
+	}
+	n.lp = lpDelete(n.lp, from, to)
+	n.count -= count
+	l.Len -= count
 }
 
 func (l *List) LPush(val []byte) {
-	node := &ListNode{Next: l.Head.Next, Prev: l.Head, Val: val}
-	l.Head.Next = node
-	node.Next.Prev = node
+	size := lpEntrySize(val)
+	if !l.allowInsert(l.head, size) {
+		l.head.shrink()
+		l.insertNode(nil, &quicklistNode{})
+		l.compress()
+	}
+	l.head.lp = lpInsert(l.head.lp, 0, val)
+	l.head.count++
 	l.Len++
 }
 
 func (l *List) RPush(val []byte) {
-	node := &ListNode{Next: l.Tail, Prev: l.Tail.Prev, Val: val}
-	l.Tail.Prev = node
-	node.Prev.Next = node
+	size := lpEntrySize(val)
+	if !l.allowInsert(l.tail, size) {
+		l.tail.shrink()
+		l.insertNode(l.tail, &quicklistNode{})
+		l.compress()
+	}
+	l.tail.lp = lpAppendEntry(l.tail.lp, val)
+	l.tail.count++
 	l.Len++
 }
 
-func (l *List) Range(start, end int) [][]byte {
+// clampRange turns start and end into a valid range, and reports whether it's empty.
+func (l *List) clampRange(start, end int) (int, int, bool) {
 	if start < 0 {
 		start = l.Len + start
 	}
 	if end < 0 {
 		end = l.Len + end
 	}
-
-	if start > end || start >= l.Len || end < 0 {
-		return nil
+	if start < 0 {
+		start = 0
 	}
-
 	if end >= l.Len {
 		end = l.Len - 1
 	}
+	return start, end, start <= end
+}
 
-	if start < 0 {
-		start = 0
+func (l *List) Range(start, end int) [][]byte {
+	start, end, ok := l.clampRange(start, end)
+	if !ok {
+		return nil
 	}
 
-	res := make([][]byte, 0, end-start+1)
-	cur := l.Head
-	for i := 0; i <= end; i++ {
-		cur = cur.Next
-		if i >= start {
-			res = append(res, cur.Val)
+	// copy the elements into one buffer, then slice it
+	var buf []byte
+	bounds := make([]int, 0, end-start+2)
+	bounds = append(bounds, 0)
+	n, i := l.locate(start)
+	for index := start; index <= end; n, i = n.next, 0 {
+		lp := n.read()
+		for off := lpSeek(lp, n.count, i); off < len(lp) && index <= end; index++ {
+			var val []byte
+			val, off = lpGet(lp, off)
+			buf = append(buf, val...)
+			bounds = append(bounds, len(buf))
 		}
 	}
+
+	res := make([][]byte, 0, len(bounds)-1)
+	for j := 1; j < len(bounds); j++ {
+		res = append(res, buf[bounds[j-1]:bounds[j]:bounds[j]])
+	}
 	return res
 }
 
@@ -162,96 +424,140 @@ count < 0: Remove elements equal to element moving from tail to head.
 count = 0: Remove all elements equal to element.
 */
 func (l *List) Remove(val []byte, count int) int {
-	if l.Len == 0 {
-		return 0
-	}
-
 	if count == 0 {
 		count = l.Len
 	}
 
 	removed := 0
 	if count > 0 {
-		for cur := l.Head.Next; cur != l.Tail && removed < count; {
-			if bytes.Equal(cur.Val, val) {
-				cur.Next.Prev = cur.Prev
-				cur.Prev.Next = cur.Next
-				l.Len--
-				removed++
+		for n := l.head; n != nil && removed < count; {
+			next := n.next
+			if lpFind(n.read(), 0, val, 0) >= 0 {
+				n.decompress()
+				for off := 0; off < len(n.lp) && removed < count; {
+					v, end := lpGet(n.lp, off)
+					if string(v) != string(val) {
+						off = end
+						continue
+					}
+					removed++
+					if n.count == 1 {
+						l.removeNode(n)
+						break
+					}
+					n.lp = lpDelete(n.lp, off, end)
+					n.count--
+					l.Len--
+				}
+				l.compress(n)
 			}
-			cur = cur.Next
+			n = next
 		}
 	} else {
-		for cur := l.Tail.Prev; cur != l.Head && removed < -count; {
-			if bytes.Equal(cur.Val, val) {
-				cur.Next.Prev = cur.Prev
-				cur.Prev.Next = cur.Next
-				l.Len--
-				removed++
+		for n := l.tail; n != nil && removed < -count; {
+			prev := n.prev
+			if lpFind(n.read(), 0, val, 0) >= 0 {
+				n.decompress()
+				for off := len(n.lp); off > 0 && removed < -count; {
+					start := lpPrev(n.lp, off)
+					v, _ := lpGet(n.lp, start)
+					if string(v) == string(val) {
+						removed++
+						if n.count == 1 {
+							l.removeNode(n)
+							break
+						}
+						n.lp = lpDelete(n.lp, start, off)
+						n.count--
+						l.Len--
+					}
+					off = start
+				}
+				l.compress(n)
 			}
-			cur = cur.Prev
+			n = prev
 		}
 	}
-
+	l.compress()
 	return removed
 }
 
 func (l *List) Set(val []byte, index int) bool {
-	node := l.Index(index)
-	if node == nil {
+	index, ok := l.normIndex(index)
+	if !ok {
 		return false
 	}
-	node.Val = val
+	n, i := l.locate(index)
+	n.decompress()
+	n.lp = lpReplace(n.lp, lpSeek(n.lp, n.count, i), val)
+	l.compress(l.split(n)...)
 	return true
 }
 
 func (l *List) Trim(start, end int) {
-	if start < 0 {
-		start = l.Len + start
-	}
-	if end < 0 {
-		end = l.Len + end
-	}
-
-	if start > end || start >= l.Len || end < 0 {
+	start, end, ok := l.clampRange(start, end)
+	if !ok {
 		l.Clear()
 		return
 	}
+	l.deleteRange(end+1, l.Len-end-1)
+	l.deleteRange(0, start)
+	l.compress()
+}
 
-	if end >= l.Len {
-		end = l.Len - 1
-	}
-
-	if start < 0 {
-		start = 0
+// deleteRange removes count elements from index on.
+func (l *List) deleteRange(index, count int) {
+	if count <= 0 {
+		return
 	}
-
-	var startNode, endNode *ListNode
-	pos := 0
-	for cur := l.Head.Next; cur != l.Tail; cur = cur.Next {
-		if pos == start {
-			startNode = cur
-		}
-		if pos == end {
-			endNode = cur
-			break
+	n, i := l.locate(index)
+	for count > 0 {
+		next := n.next
+		if i == 0 && count >= n.count {
+			count -= n.count
+			l.removeNode(n)
+		} else {
+			n.decompress()
+			k := n.count - i
+			if k > count {
+				k = count
+			}
+			from := lpSeek(n.lp, n.count, i)
+			to := from
+			for j := 0; j < k; j++ {
+				_, to = lpGet(n.lp, to)
+			}
+			n.lp = lpDelete(n.lp, from, to)
+			n.count -= k
+			l.Len -= k
+			count -= k
+			l.compress(n)
 		}
-		pos++
+		n, i = next, 0
 	}
-
-	l.Head.Next = startNode
-	startNode.Prev = l.Head
-	l.Tail.Prev = endNode
-	endNode.Next = l.Tail
-	l.Len = end - start + 1
 }
 
 func (l *List) Clear() {
-	if l.Len == 0 {
-		return
-	}
+	l.head, l.tail, l.Len = nil, nil, 0
+}
 
-	l.Head.Next = l.Tail
-	l.Tail.Prev = l.Head
-	l.Len = 0
+// Clone returns a deep copy of l, its nodes compressed the same way.
+func (l *List) Clone() *List {
+	res := &List{Len: l.Len, fill: l.fill, compressDepth: l.compressDepth}
+	for n := l.head; n != nil; n = n.next {
+		node := &quicklistNode{prev: res.tail, raw: n.raw, count: n.count}
+		if n.lp != nil {
+			node.lp = bytes.Clone(n.lp)
+		}
+		if n.zlp != nil {
+			node.zlp = bytes.Clone(n.zlp)
+		}
+		if res.tail == nil {
+			res.head = node
+		} else {
+			res.tail.next = node
+		}
+		res.tail = node
+	}
+	return res
 }
@@ -0,0 +1,202 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestListpack(t *testing.T) {
+	vals := [][]byte{{}, []byte("a"), bytes.Repeat([]byte("b"), 127), bytes.Repeat([]byte("c"), 200), bytes.Repeat([]byte("d"), 20000)}
+	var lp []byte
+	for _, val := range vals {
+		lp = lpAppendEntry(lp, val)
+	}
+
+	off := 0
+	for i, val := range vals {
+		if lpSeek(lp, len(vals), i) != off {
+			t.Error(fmt.Sprintf("lpSeek(%d) == %d, expect %d", i, lpSeek(lp, len(vals), i), off))
+		}
+		v, next := lpGet(lp, off)
+		if !bytes.Equal(v, val) || next-off != lpEntrySize(val) {
+			t.Error(fmt.Sprintf("entry %d is %d bytes, expect %d", i, next-off, lpEntrySize(val)))
+		}
+		if lpPrev(lp, next) != off {
+			t.Error(fmt.Sprintf("lpPrev of entry %d == %d, expect %d", i, lpPrev(lp, next), off))
+		}
+		off = next
+	}
+
+	lp = lpReplace(lp, lpSeek(lp, len(vals), 2), []byte("x"))
+	lp = lpInsert(lp, 0, []byte("y"))
+	if off := lpFind(lp, 0, []byte("x"), 0); off != lpSeek(lp, len(vals)+1, 3) {
+		t.Error(fmt.Sprintf("lpFind(x) == %d", off))
+	}
+	if off := lpFind(lp, 0, []byte("x"), 1); off != -1 {
+		t.Error(fmt.Sprintf("lpFind(x) skipping odd entries == %d, expect -1", off))
+	}
+}
+
+// checkList compares l with the elements it should hold, and checks its nodes.
+func checkList(t *testing.T, l *List, expect [][]byte) {
+	t.Helper()
+	if l.Len != len(expect) {
+		t.Fatalf("list length is %d, expect %d", l.Len, len(expect))
+	}
+	got := l.Range(0, -1)
+	for i := range expect {
+		if !bytes.Equal(got[i], expect[i]) {
+			t.Fatalf("element %d is %q, expect %q", i, got[i], expect[i])
+		}
+	}
+
+	count := 0
+	var nodes []*quicklistNode
+	for n := l.head; n != nil; n = n.next {
+		if len(nodes) > 0 && n.prev != nodes[len(nodes)-1] || n.count == 0 {
+			t.Fatal("broken node links or empty node")
+		}
+		if l.fill > 0 && n.count > l.fill {
+			t.Fatalf("node holds %d entries, fill is %d", n.count, l.fill)
+		}
+		count += n.count
+		nodes = append(nodes, n)
+	}
+	if count != l.Len || (len(nodes) > 0 && nodes[len(nodes)-1] != l.tail) {
+		t.Fatalf("nodes hold %d entries, expect %d", count, l.Len)
+	}
+	for i, n := range nodes {
+		if n.lp == nil && (i < l.compressDepth || len(nodes)-i <= l.compressDepth) {
+			t.Fatalf("node %d of %d is compressed, compress depth is %d", i, len(nodes), l.compressDepth)
+		}
+	}
+}
+
+// TestListOps runs random list operations against a slice, with nodes small enough to split and compress.
+func TestListOps(t *testing.T) {
+	for _, fill := range []int{1, 4, 16, -1} {
+		for _, depth := range []int{0, 1, 2} {
+			l := &List{fill: fill, compressDepth: depth}
+			var expect [][]byte
+			r := rand.New(rand.NewSource(int64(fill*10 + depth)))
+			val := func() []byte {
+				// repeated text so nodes compress
+				return bytes.Repeat([]byte(strconv.Itoa(r.Intn(8))), 1+r.Intn(40))
+			}
+
+			for i := 0; i < 3000; i++ {
+				switch op := r.Intn(10); {
+				case op < 3:
+					v := val()
+					l.RPush(v)
+					expect = append(expect, v)
+				case op < 5:
+					v := val()
+					l.LPush(v)
+					expect = append([][]byte{v}, expect...)
+				case op == 5 && len(expect) > 0:
+					v, _ := l.LPop()
+					if !bytes.Equal(v, expect[0]) {
+						t.Fatalf("LPop == %q, expect %q", v, expect[0])
+					}
+					expect = expect[1:]
+				case op == 6 && len(expect) > 0:
+					v, _ := l.RPop()
+					if !bytes.Equal(v, expect[len(expect)-1]) {
+						t.Fatalf("RPop == %q, expect %q", v, expect[len(expect)-1])
+					}
+					expect = expect[:len(expect)-1]
+				case op == 7 && len(expect) > 0:
+					i := r.Intn(len(expect))
+					v := val()
+					l.Set(v, i)
+					expect[i] = v
+				case op == 8 && len(expect) > 0:
+					pivot := expect[r.Intn(len(expect))]
+					i := 0
+					for !bytes.Equal(expect[i], pivot) {
+						i++
+					}
+					v := val()
+					l.InsertAfter(v, pivot)
+					expect = append(expect[:i+1], append([][]byte{v}, expect[i+1:]...)...)
+				case op == 9 && len(expect) > 0:
+					v, count := expect[r.Intn(len(expect))], r.Intn(5)-2
+					removed := l.Remove(v, count)
+					var rest [][]byte
+					n := 0
+					if count >= 0 {
+						for _, e := range expect {
+							if bytes.Equal(e, v) && (count == 0 || n < count) {
+								n++
+								continue
+							}
+							rest = append(rest, e)
+						}
+					} else {
+						for i := len(expect) - 1; i >= 0; i-- {
+							if bytes.Equal(expect[i], v) && n < -count {
+								n++
+								continue
+							}
+							rest = append([][]byte{expect[i]}, rest...)
+						}
+					}
+					if removed != n {
+						t.Fatalf("Remove == %d, expect %d", removed, n)
+					}
+					expect = rest
+				}
+
+				if i%100 == 99 {
+					checkList(t, l, expect)
+					if len(expect) > 10 {
+						start, end := r.Intn(len(expect)), r.Intn(len(expect))
+						l.Trim(start, end)
+						if start > end {
+							expect = nil
+						} else {
+							expect = expect[start : end+1]
+						}
+					}
+				}
+			}
+			checkList(t, l, expect)
+
+			for i := range expect {
+				if v, ok := l.Index(i - len(expect)); !ok || !bytes.Equal(v, expect[i]) {
+					t.Fatalf("Index(%d) == %q, expect %q", i-len(expect), v, expect[i])
+				}
+			}
+		}
+	}
+}
+
+func TestListCompress(t *testing.T) {
+	l := &List{fill: 16, compressDepth: 1}
+	for i := 0; i < 1000; i++ {
+		l.RPush([]byte("element-" + strconv.Itoa(i%10)))
+	}
+
+	compressed := 0
+	for n := l.head; n != nil; n = n.next {
+		if n.lp == nil {
+			compressed++
+			if len(n.zlp) >= n.raw {
+				t.Error("a compressed node doesn't save space")
+			}
+		}
+	}
+	if compressed != 1000/16-1 {
+		t.Error(fmt.Sprintf("%d nodes are compressed, expect %d", compressed, 1000/16-1))
+	}
+	if v, _ := l.Index(500); string(v) != "element-0" {
+		t.Error(fmt.Sprintf("Index(500) == %s, expect element-0", v))
+	}
+	if n := l.Pos([]byte("element-9")); n != 9 {
+		t.Error(fmt.Sprintf("Pos(element-9) == %d, expect 9", n))
+	}
+}
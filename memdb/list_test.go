@@ -2,14 +2,22 @@ package memdb
 
 import (
 	"bytes"
+	"fmt"
 	"gRedis/config"
 	"gRedis/resp"
+	"strings"
 	"testing"
 )
 
 func init() {
 	config.Conf = &config.Config{
 		SegNum: 100,
+
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		SetMaxIntsetEntries:    512,
+		SetMaxListpackEntries:  128,
+		SetMaxListpackValue:    64,
 	}
 }
 
@@ -81,3 +89,75 @@ func TestLRemList(t *testing.T) {
 		t.Error("lrem error")
 	}
 }
+
+// TestListReplies checks the replies of list commands against those of redis 7, nil and empty arrays included.
+func TestListReplies(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	for _, c := range []struct{ args, want string }{
+		{"rpush l a b c d e", ":5\r\n"},
+		{"lpop l", "$1\r\na\r\n"},
+		{"lpop l 1", "*1\r\n$1\r\nb\r\n"},
+		{"rpop l 2", "*2\r\n$1\r\ne\r\n$1\r\nd\r\n"},
+		{"lpop l 0", "*0\r\n"},
+		{"rpop l 10", "*1\r\n$1\r\nc\r\n"},
+		{"exists l", ":0\r\n"},
+		{"lpop l", "$-1\r\n"},
+		{"lpop l 2", "*-1\r\n"},
+		{"rpop l 0", "*-1\r\n"},
+		{"lpop l -1", "-ERR value is out of range, must be positive\r\n"},
+		{"lpop l 1 2", "-ERR wrong number of arguments for 'lpop' command\r\n"},
+
+		{"rpush l1 a b", ":2\r\n"},
+		{"rpush l2 c d e", ":3\r\n"},
+		{"lmpop 2 nosuchkey l1 LEFT", "*2\r\n$2\r\nl1\r\n*1\r\n$1\r\na\r\n"},
+		{"lmpop 2 l1 l2 RIGHT COUNT 10", "*2\r\n$2\r\nl1\r\n*1\r\n$1\r\nb\r\n"},
+		{"lmpop 2 l1 l2 left COUNT 2", "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{"lmpop 2 l1 nosuchkey LEFT", "*-1\r\n"},
+		{"lmpop 0 l1 LEFT", "-ERR numkeys should be greater than 0\r\n"},
+		{"lmpop 3 l1 l2 LEFT", "-ERR syntax error\r\n"},
+		{"lmpop 1 l2 UP", "-ERR syntax error\r\n"},
+		{"lmpop 1 l2 LEFT COUNT 0", "-ERR count should be greater than 0\r\n"},
+		{"lmpop 1 l2 LEFT COUNT 1 COUNT 1", "-ERR syntax error\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"lmpop 2 str l2 LEFT", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{"rpush src a b c", ":3\r\n"},
+		{"rpoplpush src dst", "$1\r\nc\r\n"},
+		{"rpoplpush src src", "$1\r\nb\r\n"},
+		{"lrange src 0 -1", "*2\r\n$1\r\nb\r\n$1\r\na\r\n"},
+		{"rpoplpush nosuchkey dst", "$-1\r\n"},
+		{"rpoplpush src str", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"rpoplpush str newkey", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"exists newkey", ":0\r\n"},
+		{"lmove src dst LEFT RIGHT", "$1\r\nb\r\n"},
+		{"lrange dst 0 -1", "*2\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+
+		{"rpush p a b c 1 2 3 c c", ":8\r\n"},
+		{"lpos p c", ":2\r\n"},
+		{"lpos p c RANK 2", ":6\r\n"},
+		{"lpos p c RANK -1", ":7\r\n"},
+		{"lpos p c COUNT 2", "*2\r\n:2\r\n:6\r\n"},
+		{"lpos p c RANK -1 COUNT 2", "*2\r\n:7\r\n:6\r\n"},
+		{"lpos p c COUNT 0 MAXLEN 3", "*1\r\n:2\r\n"},
+		{"lpos p x", "$-1\r\n"},
+		{"lpos p x COUNT 2", "*0\r\n"},
+		{"lpos p c MAXLEN 2", "$-1\r\n"},
+		{"lpos nosuchkey c", "$-1\r\n"},
+		{"lpos nosuchkey c COUNT 1", "*0\r\n"},
+		{"lpos p c RANK", "-ERR syntax error\r\n"},
+		{"lpos p c RANK 0", "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n"},
+		{"lpos p c COUNT -1", "-ERR COUNT can't be negative\r\n"},
+
+		{"linsert p BEFORE 1 x", ":9\r\n"},
+		{"linsert p AFTER nosuchpivot x", ":-1\r\n"},
+		{"linsert nosuchkey BEFORE a x", ":0\r\n"},
+		{"linsert p MIDDLE 1 x", "-ERR syntax error\r\n"},
+		{"linsert str BEFORE a x", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package memdb
+
+import "encoding/binary"
+
+// A listpack packs entries into one byte slice, like redis's listpack:
+//
+//	<len uvarint> <data> <backlen>
+//
+// backlen is the size of the len and data parts, written so it can be read backwards:
+// its last byte holds the lowest 7 bits, and a set high bit means more bytes precede.
+// It lets the entries be walked from either end.
+// Offsets below are byte offsets of entry starts; len(lp) is the offset past the last entry.
+
+// lpEntrySize returns the bytes taken by an entry holding val.
+func lpEntrySize(val []byte) int {
+	n := uvarintLen(uint64(len(val))) + len(val)
+	return n + backlenSize(n)
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func backlenSize(v int) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func lpAppendEntry(dst []byte, val []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(val)))
+	dst = append(dst, val...)
+	n := uvarintLen(uint64(len(val))) + len(val)
+	size := backlenSize(n)
+	for i := size - 1; i >= 0; i-- {
+		b := byte(n>>(7*i)) & 0x7f
+		if i < size-1 {
+			b |= 0x80
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
+
+// lpGet returns the value of the entry at off, aliasing lp, and the offset of the next entry.
+func lpGet(lp []byte, off int) ([]byte, int) {
+	l, n := binary.Uvarint(lp[off:])
+	start := off + n
+	end := start + int(l)
+	return lp[start:end:end], end + backlenSize(end-off)
+}
+
+// lpPrev returns the offset of the entry ending at off.
+func lpPrev(lp []byte, off int) int {
+	n, shift := 0, 0
+	for {
+		off--
+		b := lp[off]
+		n |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return off - n
+}
+
+// lpSeek returns the offset of entry index, count being the number of entries in lp.
+func lpSeek(lp []byte, count int, index int) int {
+	if index < count/2 {
+		off := 0
+		for i := 0; i < index; i++ {
+			_, off = lpGet(lp, off)
+		}
+		return off
+	}
+	off := len(lp)
+	for i := count; i > index; i-- {
+		off = lpPrev(lp, off)
+	}
+	return off
+}
+
+// lpInsert inserts an entry holding val at off.
+func lpInsert(lp []byte, off int, val []byte) []byte {
+	if off == len(lp) {
+		return lpAppendEntry(lp, val)
+	}
+	size := lpEntrySize(val)
+	lp = append(lp, make([]byte, size)...)
+	copy(lp[off+size:], lp[off:len(lp)-size])
+	lpAppendEntry(lp[off:off], val)
+	return lp
+}
+
+// lpDelete removes the entries between offsets from and to.
+func lpDelete(lp []byte, from, to int) []byte {
+	return append(lp[:from], lp[to:]...)
+}
+
+// lpReplace replaces the value of the entry at off.
+func lpReplace(lp []byte, off int, val []byte) []byte {
+	_, next := lpGet(lp, off)
+	lp = lpDelete(lp, off, next)
+	return lpInsert(lp, off, val)
+}
+
+// lpFind returns the offset of the first entry holding val, starting at off and skipping skip entries
+// after each one compared, or -1.
+func lpFind(lp []byte, off int, val []byte, skip int) int {
+	for off < len(lp) {
+		v, next := lpGet(lp, off)
+		if string(v) == string(val) {
+			return off
+		}
+		off = next
+		for i := 0; i < skip && off < len(lp); i++ {
+			_, off = lpGet(lp, off)
+		}
+	}
+	return -1
+}
@@ -1,99 +1,195 @@
 package memdb
 
 import (
-	"fmt"
 	"gRedis/util"
 	"sort"
 	"sync"
 )
 
-// LocksManager apply to ensure some atomic operations
+// LocksManager is the only locking layer of a MemDb. A key's stripe lock guards the key in dict and expires,
+// so an executor holding it can check expiry, look up and mutate the key atomically.
+// ConcurrentMap segments are assigned to stripes by the same hash, and have no locks of their own.
+//
+// With shards, every stripe is owned by a shard goroutine instead,
+// and ShardSet.Exec only runs an executor once it owns the stripes of the command's keys,
+// so the key locks are no-ops.
 type LocksManager struct {
-	locks []*sync.RWMutex
+	stripes []stripe
+	mask    uint32    // len(stripes) - 1
+	shards  *ShardSet // nil unless the stripes are owned by shards
 }
 
+type stripe struct {
+	sync.RWMutex
+	_ [40]byte // pad to a cache line, so neighbouring stripes don't contend
+}
+
+// NewLocksManager creates size stripes, rounded up to a power of two.
 func NewLocksManager(size int) *LocksManager {
-	locks := make([]*sync.RWMutex, size)
-	for i := 0; i < size; i++ {
-		locks[i] = &sync.RWMutex{}
+	size = tableSize(size)
+	return &LocksManager{stripes: make([]stripe, size), mask: uint32(size - 1)}
+}
+
+// newShardedLocksManager creates stripes owned by shards, at least one per shard.
+func newShardedLocksManager(size int, shards *ShardSet) *LocksManager {
+	if size < shards.Size() {
+		size = shards.Size()
 	}
-	return &LocksManager{locks: locks}
+	m := NewLocksManager(size)
+	m.shards = shards
+	return m
+}
+
+func (m *LocksManager) Size() int {
+	return len(m.stripes)
 }
 
+// GetKeyPos hashes key to its stripe without allocating.
 func (m *LocksManager) GetKeyPos(key string) int {
-	key = fmt.Sprintf("@%s&", key)
-	pos := util.Hash(key) // 可能比MaxSegSize大
-	return pos % len(m.locks)
+	return int(util.Hash32(key) & m.mask)
 }
 
 // 即使映射到同一pos，也是前一个锁释放了，后一个才结束阻塞并且上锁，保证安全性。
 func (m *LocksManager) Lock(key string) {
-	pos := m.GetKeyPos(key)
-	m.locks[pos].Lock()
+	if m.shards != nil {
+		return
+	}
+	m.stripes[m.GetKeyPos(key)].Lock()
 }
 
 func (m *LocksManager) UnLock(key string) {
-	pos := m.GetKeyPos(key)
-	m.locks[pos].Unlock()
+	if m.shards != nil {
+		return
+	}
+	m.stripes[m.GetKeyPos(key)].Unlock()
 }
 
 func (m *LocksManager) RLock(key string) {
-	pos := m.GetKeyPos(key)
-	m.locks[pos].RLock()
+	if m.shards != nil {
+		return
+	}
+	m.stripes[m.GetKeyPos(key)].RLock()
 }
 
 func (m *LocksManager) RUnLock(key string) {
-	pos := m.GetKeyPos(key)
-	m.locks[pos].RUnlock()
+	if m.shards != nil {
+		return
+	}
+	m.stripes[m.GetKeyPos(key)].RUnlock()
 }
 
 // force to lock/unlock in the same order, to avoid dead lock
 func (m *LocksManager) getSortedLocks(keys []string) []int {
-	set := make(map[int]struct{})
+	order := make([]int, 0, len(keys))
 	for i := range keys {
-		pos := m.GetKeyPos(keys[i])
-		set[pos] = struct{}{}
+		order = append(order, m.GetKeyPos(keys[i]))
 	}
+	sort.Ints(order)
 
-	order := make([]int, len(set))
-	i := 0
-	for pos := range set {
-		order[i] = pos
-		i++
+	// keys sharing a stripe lock it once
+	n := 0
+	for i := range order {
+		if i == 0 || order[i] != order[n-1] {
+			order[n] = order[i]
+			n++
+		}
 	}
-
-	sort.Ints(order)
-	return order
+	return order[:n]
 }
 
 func (m *LocksManager) MLock(keys []string) {
-	order := m.getSortedLocks(keys)
-	for i := range order {
-		pos := order[i]
-		m.locks[pos].Lock()
+	if m.shards != nil {
+		return
+	}
+	for _, pos := range m.getSortedLocks(keys) {
+		m.stripes[pos].Lock()
 	}
 }
 
 func (m *LocksManager) MUnLock(keys []string) {
-	order := m.getSortedLocks(keys)
-	for i := range order {
-		pos := order[i]
-		m.locks[pos].Unlock()
+	if m.shards != nil {
+		return
+	}
+	for _, pos := range m.getSortedLocks(keys) {
+		m.stripes[pos].Unlock()
 	}
 }
 
 func (m *LocksManager) MRLock(keys []string) {
-	order := m.getSortedLocks(keys)
-	for i := range order {
-		pos := order[i]
-		m.locks[pos].RLock()
+	if m.shards != nil {
+		return
+	}
+	for _, pos := range m.getSortedLocks(keys) {
+		m.stripes[pos].RLock()
 	}
 }
 
 func (m *LocksManager) MRUnLock(keys []string) {
-	order := m.getSortedLocks(keys)
-	for i := range order {
-		pos := order[i]
-		m.locks[pos].RUnlock()
+	if m.shards != nil {
+		return
+	}
+	for _, pos := range m.getSortedLocks(keys) {
+		m.stripes[pos].RUnlock()
+	}
+}
+
+// LockAll locks every stripe in order, for operations on the whole keyspace.
+func (m *LocksManager) LockAll() {
+	if m.shards != nil {
+		return
+	}
+	for i := range m.stripes {
+		m.stripes[i].Lock()
+	}
+}
+
+func (m *LocksManager) UnLockAll() {
+	if m.shards != nil {
+		return
+	}
+	for i := range m.stripes {
+		m.stripes[i].Unlock()
+	}
+}
+
+// RLockAll read-locks every stripe in order, for reads needing a consistent view of the whole keyspace.
+func (m *LocksManager) RLockAll() {
+	if m.shards != nil {
+		return
+	}
+	for i := range m.stripes {
+		m.stripes[i].RLock()
+	}
+}
+
+func (m *LocksManager) RUnLockAll() {
+	if m.shards != nil {
+		return
+	}
+	for i := range m.stripes {
+		m.stripes[i].RUnlock()
+	}
+}
+
+// rlockStripe read-locks stripe pos for a caller holding no key lock, like LocksManager.RLock.
+func (m *LocksManager) rlockStripe(pos int) {
+	if m.shards == nil {
+		m.stripes[pos].RLock()
+	}
+}
+
+func (m *LocksManager) runlockStripe(pos int) {
+	if m.shards == nil {
+		m.stripes[pos].RUnlock()
+	}
+}
+
+// acquireStripe gives the background rehash exclusive access to stripe pos, until release is called.
+// With shards, it pauses the shard owning the stripe.
+func (m *LocksManager) acquireStripe(pos int) (release func()) {
+	if m.shards != nil {
+		return m.shards.pause([]int{m.shards.owner(pos)})
 	}
+	m.stripes[pos].Lock()
+	return m.stripes[pos].Unlock
 }
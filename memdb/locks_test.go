@@ -0,0 +1,173 @@
+package memdb
+
+import (
+	"gRedis/resp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run these with -race: executors share nothing but the stripe locks.
+
+func makeCmd(args ...string) [][]byte {
+	cmd := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		cmd = append(cmd, []byte(arg))
+	}
+	return cmd
+}
+
+// runWorkers runs fn in workers goroutines and fails if they don't finish in time, e.g. on a deadlock.
+func runWorkers(t *testing.T, workers int, fn func(w int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			fn(w)
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("workers don't finish, deadlock?")
+	}
+}
+
+func TestGetKeyPosNoAlloc(t *testing.T) {
+	m := NewLocksManager(100)
+	if m.Size() != 128 {
+		t.Errorf("Size() == %d, expect 128", m.Size())
+	}
+	if n := testing.AllocsPerRun(100, func() { m.GetKeyPos("some:key") }); n != 0 {
+		t.Errorf("GetKeyPos allocates %v times", n)
+	}
+	if pos := m.getSortedLocks([]string{"b", "a", "b", "a"}); len(pos) > 2 || (len(pos) == 2 && pos[0] >= pos[1]) {
+		t.Errorf("getSortedLocks == %v, expect sorted distinct stripes", pos)
+	}
+}
+
+// TestConcurrentIncr checks read-modify-write commands lose no update, while the table resizes under them.
+func TestConcurrentIncr(t *testing.T) {
+	db := NewMemDb()
+	counters := []string{"c0", "c1", "c2", "c3"}
+	for _, key := range counters {
+		setString(db, makeCmd("set", key, "0"))
+	}
+
+	workers, n := 8, 2000
+	runWorkers(t, workers, func(w int) {
+		for i := 0; i < n; i++ {
+			incrString(db, makeCmd("incr", counters[i%len(counters)]))
+			// keys come and go meanwhile, so segments are moved
+			key := "k" + strconv.Itoa(w) + ":" + strconv.Itoa(i)
+			mSetString(db, makeCmd("mset", key, "v", counters[(i+1)%len(counters)]+":shadow", "v"))
+			delKey(db, makeCmd("del", key))
+		}
+	})
+
+	total := 0
+	for _, key := range counters {
+		v, err := strconv.Atoi(string(getString(db, makeCmd("get", key)).GetBytesData()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += v
+	}
+	if total != workers*n {
+		t.Errorf("counters sum to %d, expect %d", total, workers*n)
+	}
+}
+
+// TestMultiKeyNoDeadlock runs multi-key commands locking the same keys in opposite orders.
+func TestMultiKeyNoDeadlock(t *testing.T) {
+	db := NewMemDb()
+	for i := 0; i < 4; i++ {
+		key := "l" + strconv.Itoa(i)
+		rPushList(db, makeCmd("rpush", key, "a", "b", "c"))
+		sAddSet(db, makeCmd("sadd", "s"+strconv.Itoa(i), "a", "b"))
+	}
+
+	runWorkers(t, 8, func(w int) {
+		for i := 0; i < 2000; i++ {
+			a, b := strconv.Itoa((w+i)%4), strconv.Itoa((w+i+1)%4)
+			if w%2 == 1 {
+				a, b = b, a
+			}
+			lMoveList(db, makeCmd("lmove", "l"+a, "l"+b, "right", "left"))
+			sInterStoreSet(db, makeCmd("sinterstore", "d"+a, "s"+a, "s"+b))
+			sUnionSet(db, makeCmd("sunion", "s"+b, "s"+a))
+			mSetString(db, makeCmd("mset", "m"+a, "1", "m"+b, "2"))
+			renameKey(db, makeCmd("rename", "m"+a, "m"+b))
+		}
+	})
+
+	// lmove moves elements, never loses them
+	total := 0
+	for i := 0; i < 4; i++ {
+		total += int(lLenList(db, makeCmd("llen", "l"+strconv.Itoa(i))).(*resp.Integer).GetData())
+	}
+	if total != 12 {
+		t.Errorf("lists hold %d elements, expect 12", total)
+	}
+}
+
+// TestExpireAtomic checks an expired key is never seen, and a key in expires is always in dict.
+func TestExpireAtomic(t *testing.T) {
+	db := NewMemDb()
+	keys := []string{"e0", "e1", "e2", "e3"}
+	expire := func(key string) {
+		db.locks.Lock(key)
+		if _, ok := db.lookupKeyWrite(key); ok {
+			db.expires.Set(key, time.Now().Unix()-1)
+		}
+		db.locks.UnLock(key)
+	}
+
+	runWorkers(t, 8, func(w int) {
+		for i := 0; i < 2000; i++ {
+			key := keys[(w+i)%len(keys)]
+			switch i % 4 {
+			case 0:
+				setString(db, makeCmd("set", key, "fresh"))
+			case 1:
+				expire(key)
+			case 2:
+				// an expired value reads as missing, a fresh one as itself
+				if v := getString(db, makeCmd("get", key)).GetBytesData(); v != nil && string(v) != "fresh" {
+					t.Errorf("GET %s == %q", key, v)
+					return
+				}
+			case 3:
+				if i%8 == 3 {
+					renameKey(db, makeCmd("rename", key, keys[(w+i+1)%len(keys)]))
+				} else {
+					expireKey(db, makeCmd("expire", key, "100"))
+				}
+			}
+		}
+	})
+
+	for _, key := range db.expires.Keys() {
+		if _, ok := db.dict.Get(key); !ok {
+			t.Errorf("%s has an expire time but no value", key)
+		}
+	}
+	for _, key := range keys {
+		if ttl := ttlKey(db, makeCmd("ttl", key)).(*resp.Integer).GetData(); ttl != -2 && ttl != -1 && ttl <= 0 {
+			t.Errorf("TTL %s == %d", key, ttl)
+		}
+	}
+	// KEYS skips expired keys
+	for _, key := range keysKey(db, makeCmd("keys", "*")).(*resp.RedisArray).ToStringCommand() {
+		if getString(db, makeCmd("get", key)).GetBytesData() == nil {
+			t.Errorf("KEYS returns expired key %s", key)
+		}
+	}
+}
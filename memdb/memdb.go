@@ -2,24 +2,168 @@ package memdb
 
 import (
 	"gRedis/config"
+	"gRedis/resp"
+	"strconv"
 	"time"
 )
 
+// MemDb guards each key with one lock, its stripe in locks, covering the key in dict and in expires.
+// Executors lock their keys first, then look them up with lookupKeyRead or lookupKeyWrite,
+// so the expiry check, the lookup and the mutation are atomic.
 type MemDb struct {
 	dict    *ConcurrentMap // memory cache db
 	expires *ConcurrentMap // keys with expire time(seconds)
 	locks   *LocksManager
+
+	id  int      // index of the database, as SELECT takes it
+	dbs []*MemDb // all databases of the server, for commands working across them
+
+	blocking    blockingKeys // commands waiting for keys, as XREAD BLOCK
+	fieldExpiry fieldExpiry  // hashes with fields to expire
 }
 
 func NewMemDb() *MemDb {
-	return &MemDb{
-		dict:    NewConcurrentMap(config.Conf.SegNum),
-		expires: NewConcurrentMap(config.Conf.SegNum),
-		locks:   NewLocksManager(2 * config.Conf.SegNum),
+	locks := NewLocksManager(config.Conf.SegNum)
+	db := &MemDb{
+		dict:    NewConcurrentMap(config.Conf.SegNum, locks),
+		expires: NewConcurrentMap(config.Conf.SegNum, locks),
+		locks:   locks,
+	}
+	db.dbs = []*MemDb{db}
+	return db
+}
+
+// NewShardedMemDb creates a MemDb whose keys are owned by shards; its commands run through ShardSet.Exec.
+func NewShardedMemDb(shards *ShardSet) *MemDb {
+	locks := newShardedLocksManager(config.Conf.SegNum, shards)
+	db := &MemDb{
+		dict:    NewConcurrentMap(config.Conf.SegNum, locks),
+		expires: NewConcurrentMap(config.Conf.SegNum, locks),
+		locks:   locks,
+	}
+	db.dbs = []*MemDb{db}
+	return db
+}
+
+// NewMemDbs creates the n databases of a server, sharded if shards isn't nil.
+func NewMemDbs(n int, shards *ShardSet) []*MemDb {
+	dbs := make([]*MemDb, n)
+	for i := range dbs {
+		if shards != nil {
+			dbs[i] = NewShardedMemDb(shards)
+		} else {
+			dbs[i] = NewMemDb()
+		}
+		dbs[i].id = i
+		dbs[i].dbs = dbs
+	}
+	recordStartupMemory()
+	return dbs
+}
+
+// lockPair write-locks key of db and otherKey of other, which may be another database.
+// Commands locking keys of several databases lock them by database, then by stripe, as FLUSHALL does,
+// so they can't deadlock. It returns the function unlocking both.
+func lockPair(db *MemDb, key string, other *MemDb, otherKey string) (unlock func()) {
+	if db == other {
+		keys := []string{key, otherKey}
+		db.locks.MLock(keys)
+		return func() { db.locks.MUnLock(keys) }
+	}
+	if other.id < db.id {
+		db, key, other, otherKey = other, otherKey, db, key
+	}
+	db.locks.Lock(key)
+	other.locks.Lock(otherKey)
+	return func() {
+		other.locks.UnLock(otherKey)
+		db.locks.UnLock(key)
+	}
+}
+
+// dbIndex parses the index of a database, as SELECT and MOVE take it.
+func (db *MemDb) dbIndex(arg []byte) (*MemDb, resp.RedisData) {
+	idx, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return nil, resp.NewNotIntegerError()
+	}
+	if idx < 0 || idx >= len(db.dbs) {
+		return nil, resp.NewErrorReply(resp.ErrPrefix, "DB index is out of range")
+	}
+	return db.dbs[idx], nil
+}
+
+// lookupObject flags
+const (
+	lookupWrite   = 1 << iota // delete the key if expired; the caller holds the write lock
+	lookupNoTouch             // don't record the access, e.g. for OBJECT or TYPE
+)
+
+// lookupObject returns the object of key, an expired key is missing.
+// The caller holds the key's lock, a read lock is enough without lookupWrite.
+func (db *MemDb) lookupObject(key string, flags int) (*Object, bool) {
+	if flags&lookupWrite != 0 {
+		db.DeleteExpiredKey(key)
+	} else if db.CheckExpire(key) {
+		return nil, false
+	}
+	v, ok := db.dict.Get(key)
+	if !ok {
+		return nil, false
+	}
+	obj := v.(*Object)
+	if h, ok := obj.Value.(*Hash); ok && h.expires != nil {
+		// a hash whose fields all expired is gone
+		if flags&lookupWrite != 0 {
+			if h.removeExpired(hashNow()) > 0 && h.physicalLen() == 0 {
+				db.deleteKey(key, lazyfreeExpire())
+				return nil, false
+			}
+		} else if h.Len() == 0 {
+			return nil, false
+		}
+	}
+	if flags&lookupNoTouch == 0 {
+		obj.touch()
+	}
+	return obj, true
+}
+
+// lookupKeyRead returns the value of key, an expired key is missing.
+// The caller holds the key's lock, a read lock is enough.
+func (db *MemDb) lookupKeyRead(key string) (any, bool) {
+	obj, ok := db.lookupObject(key, 0)
+	if !ok {
+		return nil, false
+	}
+	return obj.Value, true
+}
+
+// lookupKeyWrite returns the value of key, deleting it if expired.
+// The caller holds the key's write lock.
+func (db *MemDb) lookupKeyWrite(key string) (any, bool) {
+	obj, ok := db.lookupObject(key, lookupWrite)
+	if !ok {
+		return nil, false
+	}
+	return obj.Value, true
+}
+
+// setKey sets the value of key, keeping the access counter of the value it replaces, like redis.
+// The caller holds the key's write lock.
+func (db *MemDb) setKey(key string, v any) {
+	obj := newObject(v)
+	old, ok := db.dict.Get(key)
+	if ok {
+		obj.lfu.Store(old.(*Object).lfu.Load())
+	}
+	db.dict.Set(key, obj)
+	if ok {
+		freeObject(old.(*Object), lazyfreeServerDel())
 	}
 }
 
-// return true if expired
+// return true if expired; the caller holds the key's lock
 func (db *MemDb) CheckExpire(key string) bool {
 	_expireTime, ok := db.expires.Get(key)
 
@@ -50,13 +194,10 @@ func (db *MemDb) DeleteExpire(key string) int {
 	return db.expires.Delete(key)
 }
 
-// lazy deletion
+// lazy deletion; the caller holds the key's write lock
 func (db *MemDb) DeleteExpiredKey(key string) bool {
 	if db.CheckExpire(key) {
-		db.locks.Lock(key)
-		defer db.locks.UnLock(key)
-		db.dict.Delete(key)
-		db.expires.Delete(key)
+		db.deleteKey(key, lazyfreeExpire())
 		return true
 	}
 	return false
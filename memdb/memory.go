@@ -0,0 +1,398 @@
+package memdb
+
+import (
+	"fmt"
+	"gRedis/resp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Memory estimates follow the Go runtime: every allocation is rounded up to its size class,
+// and a Go map holds its entries in buckets of 8 slots, filled to 6.5 on average before it grows.
+
+// sizeClasses are the allocation sizes of the Go runtime's small objects.
+var sizeClasses = [...]int{
+	8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224, 240, 256,
+	288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896, 1024, 1152, 1280, 1408, 1536, 1792,
+	2048, 2304, 2688, 3072, 3200, 3456, 4096, 4864, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728, 10240,
+	10880, 12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760, 24576, 27264, 28672, 32768,
+}
+
+const (
+	pageSize         = 8192
+	mapBucketSlots   = 8
+	mapLoadFactor    = 6.5
+	mapHeaderSize    = 48
+	defaultMemSample = 5 // MEMORY USAGE samples this many elements of an aggregate by default
+
+	stringHeaderSize = int64(unsafe.Sizeof(""))
+	sliceHeaderSize  = int64(unsafe.Sizeof([]byte(nil)))
+	ifaceSize        = int64(unsafe.Sizeof(any(nil)))
+)
+
+// allocSize returns the bytes the runtime allocates for n bytes.
+func allocSize(n int) int64 {
+	if n == 0 {
+		return 0
+	}
+	if n > sizeClasses[len(sizeClasses)-1] {
+		return int64((n + pageSize - 1) / pageSize * pageSize)
+	}
+	i := sort.SearchInts(sizeClasses[:], n)
+	return int64(sizeClasses[i])
+}
+
+// mapSize returns the bytes of a Go map holding n entries, slot being the size of a key plus a value.
+func mapSize(n int, slot int64) int64 {
+	buckets := int64(1)
+	for float64(n) > mapLoadFactor*float64(buckets) {
+		buckets <<= 1
+	}
+	// a bucket holds 8 tophash bytes, the slots and an overflow pointer
+	return mapHeaderSize + buckets*allocSize(int(mapBucketSlots+mapBucketSlots*slot+8))
+}
+
+// keyOverhead returns the bytes a key takes in a dict: its share of the segment's map and the key string.
+func keyOverhead(key string) int64 {
+	slot := stringHeaderSize + ifaceSize
+	return int64(float64(mapBucketSlots+mapBucketSlots*slot+8)/mapLoadFactor) + allocSize(len(key))
+}
+
+// objectSize estimates the bytes of an object, looking at samples elements of an aggregate
+// and extrapolating to the rest; samples <= 0 looks at every element.
+func objectSize(obj *Object, samples int) int64 {
+	size := allocSize(int(unsafe.Sizeof(Object{})))
+	switch v := obj.Value.(type) {
+	case []byte:
+		size += allocSize(int(sliceHeaderSize)) + allocSize(cap(v))
+	case *List:
+		size += allocSize(int(unsafe.Sizeof(List{})))
+		nodes, sampled, bytes := 0, 0, int64(0)
+		for n := v.head; n != nil; n = n.next {
+			nodes++
+			if samples <= 0 || sampled < samples {
+				sampled++
+				bytes += allocSize(int(unsafe.Sizeof(quicklistNode{}))) + allocSize(cap(n.lp)) + allocSize(cap(n.zlp))
+			}
+		}
+		if sampled > 0 {
+			size += bytes * int64(nodes) / int64(sampled)
+		}
+	case *Hash:
+		size += allocSize(int(unsafe.Sizeof(Hash{})))
+		if v.expires != nil {
+			// the field names are counted with the fields
+			size += mapSize(len(v.expires), stringHeaderSize+8)
+		}
+		if v.table == nil {
+			size += allocSize(cap(v.lp))
+			break
+		}
+		size += mapSize(len(v.table), stringHeaderSize+sliceHeaderSize)
+		sampled, bytes := 0, int64(0)
+		for field, val := range v.table {
+			if samples > 0 && sampled >= samples {
+				break
+			}
+			sampled++
+			bytes += allocSize(len(field)) + allocSize(cap(val))
+		}
+		if sampled > 0 {
+			size += bytes * int64(len(v.table)) / int64(sampled)
+		}
+	case *Set:
+		size += allocSize(int(unsafe.Sizeof(Set{})))
+		if v.table == nil {
+			size += allocSize(cap(v.data))
+			break
+		}
+		size += mapSize(len(v.table), stringHeaderSize)
+		sampled, bytes := 0, int64(0)
+		for member := range v.table {
+			if samples > 0 && sampled >= samples {
+				break
+			}
+			sampled++
+			bytes += allocSize(len(member))
+		}
+		if sampled > 0 {
+			size += bytes * int64(len(v.table)) / int64(sampled)
+		}
+	case *ZSet:
+		size += allocSize(int(unsafe.Sizeof(ZSet{}))) + allocSize(int(unsafe.Sizeof(zskiplistNode{}))) + allocSize(zskiplistMaxLevel*8)
+		size += mapSize(len(v.dict), stringHeaderSize+8)
+		// the map and the skiplist node of a member share its string
+		sampled, bytes := 0, int64(0)
+		for n := v.head.next[0]; n != nil && (samples <= 0 || sampled < samples); n = n.next[0] {
+			sampled++
+			bytes += allocSize(int(unsafe.Sizeof(zskiplistNode{}))) + allocSize(len(n.next)*8) + allocSize(len(n.member))
+		}
+		if sampled > 0 {
+			size += bytes * int64(v.Len()) / int64(sampled)
+		}
+	case *Stream:
+		size += allocSize(int(unsafe.Sizeof(Stream{}))) + allocSize(len(v.entries.chunks)*int(sliceHeaderSize))
+		item := int(unsafe.Sizeof(idItem[[][]byte]{}))
+		sampled, bytes := 0, int64(0)
+		for _, chunk := range v.entries.chunks {
+			size += allocSize(cap(chunk) * item)
+			for _, it := range chunk {
+				if samples > 0 && sampled >= samples {
+					break
+				}
+				sampled++
+				bytes += allocSize(len(it.val) * int(sliceHeaderSize))
+				for _, f := range it.val {
+					bytes += allocSize(cap(f))
+				}
+			}
+		}
+		if sampled > 0 {
+			size += bytes * int64(v.Len()) / int64(sampled)
+		}
+		// the consumer groups take about a pending entry in both lists for each nack
+		nack := allocSize(int(unsafe.Sizeof(streamNack{}))) + 2*int64(unsafe.Sizeof(idItem[*streamNack]{}))
+		for name, g := range v.groups {
+			size += allocSize(int(unsafe.Sizeof(streamGroup{}))) + allocSize(len(name)) + int64(g.pel.Len())*nack
+			for cname := range g.consumers {
+				size += allocSize(int(unsafe.Sizeof(streamConsumer{}))) + allocSize(len(cname))
+			}
+		}
+	}
+	return size
+}
+
+// mapOverhead returns the bytes of a ConcurrentMap's segments and of its entries' slots, without the keys and values.
+func mapOverhead(m *ConcurrentMap, slot int64) int64 {
+	segs := m.Size()
+	size := int64(segs) * (allocSize(int(unsafe.Sizeof(segment{}))) + int64(unsafe.Sizeof((*segment)(nil))))
+	perSeg := m.Count() / segs
+	return size + int64(segs)*mapSize(perSeg, slot)
+}
+
+// overhead returns the bytes spent on the dict, expires and locks of db, besides the keys and values.
+func (db *MemDb) overhead() (dict, expires, locks int64) {
+	dict = mapOverhead(db.dict, stringHeaderSize+ifaceSize)
+	// an expire time is boxed in the interface
+	expires = mapOverhead(db.expires, stringHeaderSize+ifaceSize) + int64(db.expires.Count())*allocSize(8)
+	locks = int64(db.locks.Size()) * int64(unsafe.Sizeof(stripe{}))
+	return
+}
+
+// startupAllocated is the heap in use once the databases are created, as redis's startup.allocated.
+var startupAllocated atomic.Uint64
+
+func recordStartupMemory() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	startupAllocated.Store(ms.HeapAlloc)
+}
+
+func memoryUsage(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 && len(cmd) != 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	samples := defaultMemSample
+	if len(cmd) == 5 {
+		if strings.ToLower(string(cmd[3])) != "samples" {
+			return resp.NewSyntaxError()
+		}
+		n, err := strconv.Atoi(string(cmd[4]))
+		if err != nil || n < 0 {
+			return resp.NewNotIntegerError()
+		}
+		// 0 samples every element
+		samples = n
+	}
+
+	key := string(cmd[2])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	obj, ok := db.lookupObject(key, lookupNoTouch)
+	if !ok {
+		return resp.NewBulkString(nil)
+	}
+	return resp.NewInteger(keyOverhead(key) + objectSize(obj, samples))
+}
+
+func memoryStats(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	startup := int64(startupAllocated.Load())
+	total := int64(ms.HeapAlloc)
+
+	str := func(s string) resp.RedisData { return resp.NewBulkString([]byte(s)) }
+	num := func(n int64) resp.RedisData { return resp.NewInteger(n) }
+	ratio := func(a, b int64) resp.RedisData {
+		if b == 0 {
+			return str("0")
+		}
+		return str(strconv.FormatFloat(float64(a)/float64(b), 'f', 2, 64))
+	}
+
+	res := []resp.RedisData{
+		str("total.allocated"), num(total),
+		str("startup.allocated"), num(startup),
+	}
+
+	overhead, keys := startup, int64(0)
+	for _, d := range db.dbs {
+		count := d.dict.Count()
+		if count == 0 {
+			continue
+		}
+		dict, expires, locks := d.overhead()
+		overhead += dict + expires + locks
+		keys += int64(count)
+		res = append(res, str("db."+strconv.Itoa(d.id)), resp.NewArray([]resp.RedisData{
+			str("overhead.hashtable.main"), num(dict),
+			str("overhead.hashtable.expires"), num(expires),
+			str("overhead.locks"), num(locks),
+		}))
+	}
+
+	dataset := total - overhead
+	if dataset < 0 {
+		dataset = 0
+	}
+	perKey := int64(0)
+	if keys > 0 {
+		perKey = (total - startup) / keys
+	}
+	res = append(res,
+		str("overhead.total"), num(overhead),
+		str("keys.count"), num(keys),
+		str("keys.bytes-per-key"), num(perKey),
+		str("dataset.bytes"), num(dataset),
+		str("dataset.percentage"), ratio(100*dataset, total-startup),
+		str("allocator.allocated"), num(total),
+		str("allocator.active"), num(int64(ms.HeapInuse)),
+		str("allocator.resident"), num(int64(ms.HeapSys-ms.HeapReleased)),
+		str("allocator-fragmentation.ratio"), ratio(int64(ms.HeapInuse), total),
+		str("allocator-fragmentation.bytes"), num(int64(ms.HeapInuse)-total),
+		str("runtime.sys"), num(int64(ms.Sys)),
+		str("runtime.gc-cycles"), num(int64(ms.NumGC)),
+		str("runtime.goroutines"), num(int64(runtime.NumGoroutine())),
+	)
+	return resp.NewArray(res)
+}
+
+// MEMORY DOCTOR thresholds
+const (
+	doctorMinHeap       = 5 << 20  // below this, there's nothing to diagnose
+	doctorFragRatio     = 1.4      // heap spans in use over live heap
+	doctorRSSRatio      = 1.5      // heap held from the OS over heap spans in use
+	doctorWasteBytes    = 10 << 20 // fragmentation or held memory below this isn't worth reporting
+	doctorBigKeyBytes   = 1 << 20
+	doctorMaxBigKeys    = 10
+	doctorBigKeySamples = defaultMemSample
+)
+
+type bigKey struct {
+	db   int
+	key  string
+	size int64
+}
+
+func memoryDoctor(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc < doctorMinHeap {
+		return resp.NewBulkString([]byte("Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."))
+	}
+
+	var issues []string
+	inuse, alloc := float64(ms.HeapInuse), float64(ms.HeapAlloc)
+	if inuse/alloc > doctorFragRatio && inuse-alloc > doctorWasteBytes {
+		issues = append(issues, fmt.Sprintf("High allocator fragmentation: heap spans in use are %.2f times the live heap, %d bytes are wasted. Deleting many values of similar size leaves spans partly empty; they are reused as new values of that size are written.", inuse/alloc, ms.HeapInuse-ms.HeapAlloc))
+	}
+	held := float64(ms.HeapSys - ms.HeapReleased)
+	if held/inuse > doctorRSSRatio && held-inuse > doctorWasteBytes {
+		issues = append(issues, fmt.Sprintf("High process RSS overhead: the heap holds %.2f times the memory in use, %d bytes were freed but not returned to the OS yet. MEMORY PURGE returns them now.", held/inuse, int64(held-inuse)))
+	}
+
+	var big []bigKey
+	for _, d := range db.dbs {
+		for _, key := range d.dict.Keys() {
+			d.locks.RLock(key)
+			if obj, ok := d.lookupObject(key, lookupNoTouch); ok {
+				if size := keyOverhead(key) + objectSize(obj, doctorBigKeySamples); size >= doctorBigKeyBytes {
+					big = append(big, bigKey{db: d.id, key: key, size: size})
+				}
+			}
+			d.locks.RUnLock(key)
+		}
+	}
+	if len(big) > 0 {
+		sort.Slice(big, func(i, j int) bool { return big[i].size > big[j].size })
+		lines := make([]string, 0, doctorMaxBigKeys)
+		for i := 0; i < len(big) && i < doctorMaxBigKeys; i++ {
+			lines = append(lines, fmt.Sprintf("    db %d, %q: %d bytes", big[i].db, big[i].key, big[i].size))
+		}
+		issues = append(issues, fmt.Sprintf("Big keys: %d keys take 1mb or more each, the biggest being:\n%s\n    Deleting them holds their lock until they are freed.", len(big), strings.Join(lines, "\n")))
+	}
+
+	if len(issues) == 0 {
+		return resp.NewBulkString([]byte("Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."))
+	}
+	report := "Sam, I detected a few issues in this gRedis instance memory implants:\n\n * " + strings.Join(issues, "\n\n * ") + "\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+	return resp.NewBulkString([]byte(report))
+}
+
+func memoryPurge(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	// collect garbage, then return as much memory to the OS as possible
+	debug.FreeOSMemory()
+	return resp.NewSimpleString("OK")
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"PURGE",
+	"    Force a garbage collection and return as much memory to the OS as possible.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+func memoryHelpCmd(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	res := make([]resp.RedisData, 0, len(memoryHelp))
+	for _, line := range memoryHelp {
+		res = append(res, resp.NewSimpleString(line))
+	}
+	return resp.NewArray(res)
+}
+
+func RegisterMemoryCommands() {
+	RegisterSubcommand("memory", "usage", memoryUsage, 2, 2, 1)
+	RegisterSubcommand("memory", "stats", memoryStats, noKeys, 0, 0)
+	RegisterSubcommand("memory", "doctor", memoryDoctor, allKeys, 0, 0)
+	RegisterSubcommand("memory", "purge", memoryPurge, noKeys, 0, 0)
+	RegisterSubcommand("memory", "help", memoryHelpCmd, noKeys, 0, 0)
+}
@@ -0,0 +1,117 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"gRedis/resp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAllocSize(t *testing.T) {
+	cases := map[int]int64{0: 0, 1: 8, 8: 8, 9: 16, 33: 48, 32768: 32768, 32769: 40960}
+	for n, expect := range cases {
+		if size := allocSize(n); size != expect {
+			t.Error(fmt.Sprintf("allocSize(%d) == %d, expect %d", n, size, expect))
+		}
+	}
+	if mapSize(6, 16) != mapSize(1, 16) || mapSize(7, 16) <= mapSize(6, 16) {
+		t.Error("a map should grow past 6.5 entries per bucket")
+	}
+}
+
+func TestMemoryKey(t *testing.T) {
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterHashCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+	RegisterMemoryCommands()
+	dbs := NewMemDbs(2, nil)
+	exec := func(db *MemDb, args ...string) resp.RedisData {
+		cmd := makeCmd(args...)
+		c, errReply := CmdTable[args[0]].Subcommand(cmd)
+		if errReply != nil {
+			return errReply
+		}
+		return c.Executor(db, cmd)
+	}
+	usage := func(key string, samples int) int64 {
+		res := exec(dbs[0], "memory", "usage", key, "samples", strconv.Itoa(samples))
+		n, ok := res.(*resp.Integer)
+		if !ok {
+			t.Fatal(fmt.Sprintf("MEMORY USAGE %s returns %s", key, res.ToRedisFormat()))
+		}
+		return n.GetData()
+	}
+
+	exec(dbs[0], "set", "short", "a")
+	exec(dbs[0], "set", "long", strings.Repeat("a", 1000))
+	if s, l := usage("short", 0), usage("long", 0); l-s < 1000 {
+		t.Error(fmt.Sprintf("MEMORY USAGE of a 1000 bytes string is %d, of a 1 byte one %d", l, s))
+	}
+	if res := exec(dbs[0], "memory", "usage", "nosuchkey"); !bytes.Equal(res.ToRedisFormat(), []byte("$-1\r\n")) {
+		t.Error("MEMORY USAGE of a missing key should be nil")
+	}
+	if _, ok := exec(dbs[0], "memory", "usage", "short", "samples", "-1").(*resp.SimpleError); !ok {
+		t.Error("MEMORY USAGE with negative samples should fail")
+	}
+
+	// compact encodings are smaller than hashtables of the same elements
+	for i := 0; i < 100; i++ {
+		exec(dbs[0], "sadd", "ints", strconv.Itoa(i))
+		exec(dbs[0], "sadd", "strs", "m"+strconv.Itoa(i))
+		exec(dbs[0], "hset", "hash", "f"+strconv.Itoa(i), "v")
+		exec(dbs[0], "rpush", "list", "e"+strconv.Itoa(i))
+	}
+	exec(dbs[0], "sadd", "big", "m")
+	for i := 0; i < 200; i++ {
+		exec(dbs[0], "sadd", "big", "m"+strconv.Itoa(i))
+	}
+	if ints, strs, big := usage("ints", 0), usage("strs", 0), usage("big", 0); ints >= strs || strs >= big/2 {
+		t.Error(fmt.Sprintf("MEMORY USAGE of an intset %d, a listpack %d, a hashtable of twice the members %d", ints, strs, big))
+	}
+	if usage("hash", 0) < 100*4 || usage("list", 0) < 100*4 {
+		t.Error("MEMORY USAGE should count every element")
+	}
+
+	// sampling extrapolates from the first nodes, which are full unlike the last one
+	for i := 0; i < 10000; i++ {
+		exec(dbs[0], "rpush", "biglist", strconv.Itoa(i))
+	}
+	all, sampled := usage("biglist", 0), usage("biglist", 5)
+	if sampled < all*4/5 || sampled > all*5/4 {
+		t.Error(fmt.Sprintf("MEMORY USAGE of a list sampling 5 nodes is %d, %d for all nodes", sampled, all))
+	}
+
+	// STATS reports only the databases holding keys
+	exec(dbs[1], "set", "k", "v")
+	stats := exec(dbs[0], "memory", "stats").(*resp.RedisArray).GetData()
+	fields := make(map[string]resp.RedisData)
+	for i := 0; i+1 < len(stats); i += 2 {
+		fields[string(stats[i].GetBytesData())] = stats[i+1]
+	}
+	if _, ok := fields["db.1"]; !ok {
+		t.Error("MEMORY STATS should report db.1")
+	}
+	if count := fields["keys.count"].(*resp.Integer).GetData(); count != 9 {
+		t.Error(fmt.Sprintf("MEMORY STATS keys.count == %d, expect 9", count))
+	}
+
+	if res := exec(dbs[0], "memory", "doctor"); len(res.GetBytesData()) == 0 {
+		t.Error("MEMORY DOCTOR should reply a report")
+	}
+	if res := exec(dbs[0], "memory", "purge"); string(res.ToRedisFormat()) != "+OK\r\n" {
+		t.Error("MEMORY PURGE should reply OK")
+	}
+	if help := exec(dbs[0], "memory", "help").(*resp.RedisArray).GetData(); len(help) != len(memoryHelp) {
+		t.Error(fmt.Sprintf("MEMORY HELP returns %d lines", len(help)))
+	}
+	if _, ok := exec(dbs[0], "memory", "nosuchsubcommand").(*resp.SimpleError); !ok {
+		t.Error("MEMORY with an unknown subcommand should fail")
+	}
+	if _, ok := exec(dbs[0], "memory").(*resp.SimpleError); !ok {
+		t.Error("MEMORY without a subcommand should fail")
+	}
+}
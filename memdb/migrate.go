@@ -0,0 +1,322 @@
+package memdb
+
+import (
+	"bufio"
+	"errors"
+	"gRedis/resp"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MIGRATE sends keys to another instance as RESTORE commands and deletes them once the target replied OK.
+// Like redis, connections to targets are cached for a while, so moving many keys one call at a time
+// doesn't connect every time.
+
+const (
+	migrateDefaultTimeout = time.Second
+	migrateCacheTTL       = 10 * time.Second // an idle cached connection is closed after this
+	migrateCacheMax       = 64
+)
+
+// migrateConn is a cached connection to a target; mu serializes the calls using it.
+type migrateConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	w      *bufio.Writer
+	dec    *resp.Decoder
+	lastDb int // the database selected on the target, or -1
+	idle   *time.Timer
+	closed bool
+}
+
+var migrateCache = struct {
+	mu    sync.Mutex
+	conns map[string]*migrateConn
+}{conns: make(map[string]*migrateConn)}
+
+// migrateGetConn returns the cached connection to addr, or a new one, locked; cached reports which.
+func migrateGetConn(addr string, timeout time.Duration) (mc *migrateConn, cached bool, err error) {
+	migrateCache.mu.Lock()
+	mc, cached = migrateCache.conns[addr]
+	migrateCache.mu.Unlock()
+	if cached {
+		mc.mu.Lock()
+		if !mc.closed {
+			mc.idle.Stop()
+			return mc, true, nil
+		}
+		mc.mu.Unlock()
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	mc = &migrateConn{conn: conn, w: bufio.NewWriter(conn), dec: resp.NewDecoder(conn), lastDb: -1}
+	mc.idle = time.AfterFunc(migrateCacheTTL, func() { migrateCloseConn(addr, mc) })
+	mc.idle.Stop() // armed on release
+	mc.mu.Lock()
+
+	migrateCache.mu.Lock()
+	if len(migrateCache.conns) >= migrateCacheMax {
+		// make room by dropping any cached connection, as redis does
+		for other, old := range migrateCache.conns {
+			go migrateCloseConn(other, old)
+			delete(migrateCache.conns, other)
+			break
+		}
+	}
+	migrateCache.conns[addr] = mc
+	migrateCache.mu.Unlock()
+	return mc, false, nil
+}
+
+// release unlocks mc, closing it on error, so the next call reconnects.
+func (mc *migrateConn) release(addr string, err error) {
+	if err != nil {
+		mc.closed = true
+		mc.conn.Close()
+		migrateCache.mu.Lock()
+		if migrateCache.conns[addr] == mc {
+			delete(migrateCache.conns, addr)
+		}
+		migrateCache.mu.Unlock()
+	} else {
+		mc.idle.Reset(migrateCacheTTL)
+	}
+	mc.mu.Unlock()
+}
+
+func migrateCloseConn(addr string, mc *migrateConn) {
+	mc.mu.Lock()
+	mc.closed = true
+	mc.conn.Close()
+	mc.mu.Unlock()
+	migrateCache.mu.Lock()
+	if migrateCache.conns[addr] == mc {
+		delete(migrateCache.conns, addr)
+	}
+	migrateCache.mu.Unlock()
+}
+
+// migrateIOError tells which step failed talking to the target.
+type migrateIOError struct {
+	op  string // connecting, writing or reading
+	err error
+}
+
+func (e *migrateIOError) Error() string {
+	return "error or timeout " + e.op + " to target instance"
+}
+
+// a timeout can't be retried: the target may still process what was sent
+func (e *migrateIOError) retryable() bool {
+	var netErr net.Error
+	return !(errors.As(e.err, &netErr) && netErr.Timeout())
+}
+
+// migrateEntry is a key to send, serialized while its lock is held.
+type migrateEntry struct {
+	key     string
+	ttl     int64 // milliseconds left, 0 if the key doesn't expire
+	payload []byte
+}
+
+// migrateSend writes the pipeline to the target and reads its replies.
+// It returns the reply to each entry's RESTORE, or an error reply of AUTH or SELECT, which fails the whole call.
+func migrateSend(mc *migrateConn, db int, auth [][]byte, entries []migrateEntry, replace bool, timeout time.Duration) ([]resp.RedisData, resp.RedisData, error) {
+	var buf []byte
+	sent := 0
+	if auth != nil {
+		buf = appendCommand(buf, append([][]byte{[]byte("AUTH")}, auth...)...)
+		sent++
+	}
+	selectDb := mc.lastDb != db
+	if selectDb {
+		buf = appendCommand(buf, []byte("SELECT"), []byte(strconv.Itoa(db)))
+		sent++
+	}
+	for _, e := range entries {
+		args := [][]byte{[]byte("RESTORE"), []byte(e.key), []byte(strconv.FormatInt(e.ttl, 10)), e.payload}
+		if replace {
+			args = append(args, []byte("REPLACE"))
+		}
+		buf = appendCommand(buf, args...)
+	}
+
+	mc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := mc.w.Write(buf); err != nil {
+		return nil, nil, &migrateIOError{"writing", err}
+	}
+	if err := mc.w.Flush(); err != nil {
+		return nil, nil, &migrateIOError{"writing", err}
+	}
+
+	var failed resp.RedisData
+	for i := 0; i < sent; i++ {
+		mc.conn.SetReadDeadline(time.Now().Add(timeout))
+		reply, err := mc.dec.ReadValue()
+		if err != nil {
+			return nil, nil, &migrateIOError{"reading", err}
+		}
+		if _, ok := reply.(*resp.SimpleError); ok && failed == nil {
+			failed = reply
+		}
+	}
+	replies := make([]resp.RedisData, len(entries))
+	for i := range replies {
+		mc.conn.SetReadDeadline(time.Now().Add(timeout))
+		reply, err := mc.dec.ReadValue()
+		if err != nil {
+			return nil, nil, &migrateIOError{"reading", err}
+		}
+		replies[i] = reply
+	}
+	if failed != nil {
+		// the target may have failed SELECT, so don't trust the database it's on
+		mc.lastDb = -1
+		return nil, failed, nil
+	}
+	if selectDb {
+		mc.lastDb = db
+	}
+	return replies, nil, nil
+}
+
+// appendCommand appends a request of args to dst.
+func appendCommand(dst []byte, args ...[]byte) []byte {
+	dst = resp.AppendArrayHeader(dst, len(args))
+	for _, arg := range args {
+		dst = resp.AppendBulkString(dst, arg)
+	}
+	return dst
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...]
+func migrateKey(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 6 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	copyKeys, replace := false, false
+	var auth [][]byte
+	keys := []string{string(cmd[3])}
+	for i := 6; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 >= len(cmd) {
+				return resp.NewSyntaxError()
+			}
+			auth = [][]byte{cmd[i+1]}
+			i++
+		case "auth2":
+			if i+2 >= len(cmd) {
+				return resp.NewSyntaxError()
+			}
+			auth = [][]byte{cmd[i+1], cmd[i+2]}
+			i += 2
+		case "keys":
+			if len(cmd[3]) != 0 {
+				return resp.NewErrorReply(resp.ErrPrefix, "When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = keys[:0]
+			for _, key := range cmd[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(cmd)
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+
+	port, err := strconv.Atoi(string(cmd[2]))
+	if err != nil {
+		return resp.NewNotIntegerError()
+	}
+	dbIndex, err := strconv.Atoi(string(cmd[4]))
+	if err != nil {
+		return resp.NewNotIntegerError()
+	}
+	ms, err := strconv.ParseInt(string(cmd[5]), 10, 64)
+	if err != nil {
+		return resp.NewNotIntegerError()
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	if ms <= 0 {
+		timeout = migrateDefaultTimeout
+	}
+	addr := net.JoinHostPort(string(cmd[1]), strconv.Itoa(port))
+
+	// the keys stay locked until the target replied, so nobody sees them on both sides or on neither
+	if copyKeys {
+		db.locks.MRLock(keys)
+		defer db.locks.MRUnLock(keys)
+	} else {
+		db.locks.MLock(keys)
+		defer db.locks.MUnLock(keys)
+	}
+
+	entries := make([]migrateEntry, 0, len(keys))
+	now := time.Now().UnixMilli()
+	for _, key := range keys {
+		obj, ok := db.lookupObject(key, lookupNoTouch)
+		if !ok {
+			continue
+		}
+		e := migrateEntry{key: key, payload: rdbDump(obj.Value)}
+		if at, ok := db.expires.Get(key); ok {
+			e.ttl = at.(int64)*1000 - now
+			if e.ttl < 1 {
+				e.ttl = 1
+			}
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return resp.NewSimpleString("NOKEY")
+	}
+
+	var replies []resp.RedisData
+	var failed resp.RedisData
+	for attempt := 0; ; attempt++ {
+		mc, cached, err := migrateGetConn(addr, timeout)
+		if err != nil {
+			return resp.NewErrorReply(resp.IOErrPrefix, (&migrateIOError{"connecting", err}).Error())
+		}
+		replies, failed, err = migrateSend(mc, dbIndex, auth, entries, replace, timeout)
+		mc.release(addr, err)
+		if err == nil {
+			break
+		}
+		// the cached connection may have been closed by the target meanwhile: retry once on a new one
+		if ioErr := err.(*migrateIOError); !cached || attempt > 0 || !ioErr.retryable() {
+			return resp.NewErrorReply(resp.IOErrPrefix, ioErr.Error())
+		}
+	}
+	if failed != nil {
+		return resp.NewErrorReplyf(resp.ErrPrefix, "Target instance replied with error: %s", failed.(*resp.SimpleError).GetData())
+	}
+
+	// keys the target refused stay here, and each of them is reported
+	var errs []string
+	for i, reply := range replies {
+		if e, ok := reply.(*resp.SimpleError); ok {
+			errs = append(errs, "'"+entries[i].key+"': "+e.GetData())
+			continue
+		}
+		if !copyKeys {
+			db.deleteKey(entries[i].key, lazyfreeServerDel())
+		}
+	}
+	if errs != nil {
+		return resp.NewErrorReply(resp.ErrPrefix, "Target instance replied with error: "+strings.Join(errs, "; "))
+	}
+	return resp.NewSimpleString("OK")
+}
@@ -0,0 +1,180 @@
+package memdb
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Object is what dict holds for each key: the value, a []byte, *Hash, *List, *Set or *ZSet,
+// behind a header like redis's robj, recording the value's type and how recently and how often it was accessed.
+// The encoding isn't kept in the header, as hashes, lists and sets convert themselves while they grow;
+// Encoding asks the value instead.
+//
+// Readers touch the header under a read lock, so the access fields are atomic.
+type Object struct {
+	Value any
+	atime atomic.Uint32 // unix time in seconds of the last access
+	lfu   atomic.Uint32 // minutes of the last counter decrement << 8 | logarithmic access counter
+	typ   uint8
+}
+
+// object types
+const (
+	objString uint8 = iota
+	objList
+	objSet
+	objHash
+	objZSet
+	objStream
+)
+
+// as redis's lfu-log-factor, lfu-decay-time and LFU_INIT_VAL defaults
+const (
+	lfuLogFactor = 10
+	lfuDecayTime = 1 // minutes for the counter to decrement by one
+	lfuInitVal   = 5
+)
+
+func newObject(v any) *Object {
+	obj := &Object{Value: v}
+	switch v.(type) {
+	case *List:
+		obj.typ = objList
+	case *Set:
+		obj.typ = objSet
+	case *Hash:
+		obj.typ = objHash
+	case *ZSet:
+		obj.typ = objZSet
+	case *Stream:
+		obj.typ = objStream
+	default:
+		obj.typ = objString
+	}
+	obj.atime.Store(uint32(time.Now().Unix()))
+	obj.lfu.Store(lfuMinutes()<<8 | lfuInitVal)
+	return obj
+}
+
+// cloneValue returns a deep copy of a value, as COPY stores it.
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case *List:
+		return v.Clone()
+	case *Set:
+		return v.Clone()
+	case *Hash:
+		return v.Clone()
+	case *ZSet:
+		return v.Clone()
+	case *Stream:
+		return v.Clone()
+	case []byte:
+		return bytes.Clone(v)
+	}
+	return v
+}
+
+// Type returns the type name of the value, as TYPE replies.
+func (obj *Object) Type() string {
+	switch obj.typ {
+	case objList:
+		return "list"
+	case objSet:
+		return "set"
+	case objHash:
+		return "hash"
+	case objZSet:
+		return "zset"
+	case objStream:
+		return "stream"
+	default:
+		return "string"
+	}
+}
+
+// Encoding returns the encoding of the value, as OBJECT ENCODING replies.
+func (obj *Object) Encoding() string {
+	switch v := obj.Value.(type) {
+	case []byte:
+		if _, ok := intsetMember(string(v)); ok {
+			return encodingInt
+		}
+		if len(v) <= 44 {
+			return encodingEmbstr
+		}
+		return encodingRaw
+	case *Hash:
+		return v.Encoding()
+	case *List:
+		return v.Encoding()
+	case *Set:
+		return v.Encoding()
+	case *ZSet:
+		return v.Encoding()
+	case *Stream:
+		return v.Encoding()
+	}
+	return "unknown"
+}
+
+// IdleTime returns the seconds since the last access.
+func (obj *Object) IdleTime() int64 {
+	idle := time.Now().Unix() - int64(obj.atime.Load())
+	if idle < 0 {
+		return 0
+	}
+	return idle
+}
+
+// Freq returns the access counter, decayed by the minutes since it was last decremented.
+func (obj *Object) Freq() uint8 {
+	return lfuDecr(obj.lfu.Load())
+}
+
+// touch records an access.
+func (obj *Object) touch() {
+	obj.atime.Store(uint32(time.Now().Unix()))
+	for {
+		old := obj.lfu.Load()
+		counter := lfuLogIncr(lfuDecr(old))
+		if obj.lfu.CompareAndSwap(old, lfuMinutes()<<8|uint32(counter)) {
+			return
+		}
+	}
+}
+
+// lfuMinutes returns the time in minutes, in 16 bits like redis.
+func lfuMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & math.MaxUint16
+}
+
+// lfuDecr returns the counter of lfu, decremented once per lfuDecayTime minutes since its last decrement.
+func lfuDecr(lfu uint32) uint8 {
+	ldt, counter := lfu>>8, uint8(lfu)
+	// the 16-bit clock wraps every 45 days
+	elapsed := (lfuMinutes() - ldt) & math.MaxUint16
+	periods := elapsed / lfuDecayTime
+	if periods >= uint32(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// lfuLogIncr increments counter with a probability falling as the counter grows, so 255 takes about a million accesses.
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
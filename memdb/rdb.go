@@ -0,0 +1,1188 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"gRedis/util"
+	"math"
+	"strconv"
+)
+
+// Values are serialized in redis's RDB format, as DUMP and RESTORE exchange them with redis:
+//
+//	<type> <value> <rdb version, 2 bytes> <crc64, 8 bytes>
+//
+// both little endian, the checksum covering everything before it.
+// Compact values are written in redis's own listpack and intset formats, which differ from ours.
+
+const (
+	// DUMP writes the version of redis 7.0, so every redis 7 restores its payloads, but for hashes with field TTLs,
+	// which need the version of redis 7.4; RESTORE reads up to that version
+	rdbVersion    = 10
+	rdbMaxVersion = 12
+
+	rdbTypeString         = 0
+	rdbTypeList           = 1
+	rdbTypeSet            = 2
+	rdbTypeHash           = 4
+	rdbTypeZSet2          = 5 // scores as binary doubles
+	rdbTypeSetIntset      = 11
+	rdbTypeHashListpack   = 16
+	rdbTypeZSetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeSetListpack    = 20 // since redis 7.2, so DUMP writes listpack sets as rdbTypeSet
+	rdbTypeHashMetadata   = 24 // since redis 7.4, a hash with field TTLs
+	rdbTypeHashListpackEx = 25 // since redis 7.4, a listpack hash with field TTLs
+
+	rdbTypeStreamListpacks  = 15 // before redis 7.0
+	rdbTypeStreamListpacks2 = 19 // adds the first and max deleted IDs, the entries added and groups' entries read
+	rdbTypeStreamListpacks3 = 21 // since redis 7.2, adds consumers' active time
+
+	// flags of the entries of a stream listpack
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+
+	// the top 2 bits of a length: 6 bits, 14 bits, or 32/64 bits following, or a special encoding
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	// special encodings of strings
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+
+	// quicklist node containers
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+var (
+	errRdbBadData  = errors.New("Bad data format")
+	errRdbChecksum = errors.New("DUMP payload version or checksum are wrong")
+)
+
+// rdbDump serializes v into a DUMP payload.
+func rdbDump(v any) []byte {
+	buf := rdbAppendObject(nil, v)
+	version := rdbVersion
+	if buf[0] == rdbTypeHashMetadata {
+		version = rdbMaxVersion
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(version))
+	return binary.LittleEndian.AppendUint64(buf, util.CRC64(0, buf))
+}
+
+// rdbRestore deserializes a DUMP payload, checking its version and checksum.
+func rdbRestore(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, errRdbChecksum
+	}
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbMaxVersion || binary.LittleEndian.Uint64(footer[2:]) != util.CRC64(0, payload[:len(payload)-8]) {
+		return nil, errRdbChecksum
+	}
+	r := &rdbReader{buf: payload[:len(payload)-10]}
+	typ, err := r.readByte()
+	if err != nil {
+		return nil, errRdbBadData
+	}
+	v, err := r.readObject(typ)
+	if err != nil {
+		return nil, errRdbBadData
+	}
+	return v, nil
+}
+
+func rdbAppendObject(dst []byte, v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		dst = append(dst, rdbTypeString)
+		return rdbAppendString(dst, v)
+	case *List:
+		dst = append(dst, rdbTypeListQuicklist2)
+		nodes := 0
+		for n := v.head; n != nil; n = n.next {
+			nodes++
+		}
+		dst = rdbAppendLen(dst, uint64(nodes))
+		for n := v.head; n != nil; n = n.next {
+			lp := n.read()
+			rlp := redisLpNew()
+			for off := 0; off < len(lp); {
+				var val []byte
+				val, off = lpGet(lp, off)
+				rlp = redisLpAppend(rlp, val)
+			}
+			dst = rdbAppendLen(dst, quicklistNodePacked)
+			dst = rdbAppendString(dst, redisLpFinish(rlp, n.count))
+		}
+		return dst
+	case *Hash:
+		if v.expires != nil {
+			return rdbAppendHashMetadata(dst, v)
+		}
+		if v.table == nil {
+			dst = append(dst, rdbTypeHashListpack)
+			rlp := redisLpNew()
+			for off := 0; off < len(v.lp); {
+				var val []byte
+				val, off = lpGet(v.lp, off)
+				rlp = redisLpAppend(rlp, val)
+			}
+			return rdbAppendString(dst, redisLpFinish(rlp, 2*v.count))
+		}
+		dst = append(dst, rdbTypeHash)
+		dst = rdbAppendLen(dst, uint64(len(v.table)))
+		for field, val := range v.table {
+			dst = rdbAppendString(dst, []byte(field))
+			dst = rdbAppendString(dst, val)
+		}
+		return dst
+	case *Set:
+		if v.enc == setIntset {
+			dst = append(dst, rdbTypeSetIntset)
+			return rdbAppendString(dst, redisIntset(intset(v.data)))
+		}
+		dst = append(dst, rdbTypeSet)
+		dst = rdbAppendLen(dst, uint64(v.Len()))
+		v.scan(func(member string) bool {
+			dst = rdbAppendString(dst, []byte(member))
+			return true
+		})
+		return dst
+	case *ZSet:
+		dst = append(dst, rdbTypeZSet2)
+		dst = rdbAppendLen(dst, uint64(v.Len()))
+		v.scan(func(member string, score float64) bool {
+			dst = rdbAppendString(dst, []byte(member))
+			dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(score))
+			return true
+		})
+		return dst
+	case *Stream:
+		return rdbAppendStream(append(dst, rdbTypeStreamListpacks2), v)
+	}
+	panic("rdb: unknown value type")
+}
+
+func rdbAppendLen(dst []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(dst, byte(n))
+	case n < 1<<14:
+		return append(dst, byte(rdb14BitLen<<6|n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, rdb32BitLen), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(dst, rdb64BitLen), n)
+	}
+}
+
+// rdbAppendString writes s as an integer if it is a small one, LZF-compressed if that saves space, or as is, like redis.
+func rdbAppendString(dst []byte, s []byte) []byte {
+	if len(s) <= 11 {
+		if v, ok := intsetMember(string(s)); ok && v >= math.MinInt32 && v <= math.MaxInt32 {
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				return append(dst, rdbEncVal<<6|rdbEncInt8, byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				return binary.LittleEndian.AppendUint16(append(dst, rdbEncVal<<6|rdbEncInt16), uint16(v))
+			default:
+				return binary.LittleEndian.AppendUint32(append(dst, rdbEncVal<<6|rdbEncInt32), uint32(v))
+			}
+		}
+	}
+	// redis compresses strings longer than 20 bytes, when that saves more than 4 bytes
+	if len(s) > 20 {
+		if z := util.LZFCompress(s); len(z) <= len(s)-4 {
+			dst = append(dst, rdbEncVal<<6|rdbEncLZF)
+			dst = rdbAppendLen(dst, uint64(len(z)))
+			dst = rdbAppendLen(dst, uint64(len(s)))
+			return append(dst, z...)
+		}
+	}
+	dst = rdbAppendLen(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+type rdbReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errRdbBadData
+	}
+	r.pos++
+	return r.buf[r.pos-1], nil
+}
+
+func (r *rdbReader) readN(n uint64) ([]byte, error) {
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, errRdbBadData
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// readLen returns a length, or the special encoding of a string if encoded is set.
+func (r *rdbReader) readLen() (n uint64, encoded bool, err error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case rdb6BitLen:
+		return uint64(b & 0x3f), false, nil
+	case rdb14BitLen:
+		next, err := r.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case rdbEncVal:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case rdb32BitLen:
+		p, err := r.readN(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+	case rdb64BitLen:
+		p, err := r.readN(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(p), false, nil
+	}
+	return 0, false, errRdbBadData
+}
+
+// readCount reads the number of elements of an aggregate, which can't be empty.
+func (r *rdbReader) readCount() (int, error) {
+	n, encoded, err := r.readLen()
+	if err != nil || encoded || n == 0 || n > uint64(len(r.buf)) {
+		return 0, errRdbBadData
+	}
+	return int(n), nil
+}
+
+// readString returns a string, aliasing the payload unless it was encoded.
+func (r *rdbReader) readString() ([]byte, error) {
+	n, encoded, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return r.readN(n)
+	}
+	switch n {
+	case rdbEncInt8:
+		b, err := r.readByte()
+		return strconv.AppendInt(nil, int64(int8(b)), 10), err
+	case rdbEncInt16:
+		p, err := r.readN(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(p))), 10), nil
+	case rdbEncInt32:
+		p, err := r.readN(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(p))), 10), nil
+	case rdbEncLZF:
+		clen, _, err := r.readLen()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := r.readLen()
+		if err != nil || ulen > math.MaxInt32 {
+			return nil, errRdbBadData
+		}
+		z, err := r.readN(clen)
+		if err != nil {
+			return nil, err
+		}
+		s, err := util.LZFDecompress(z, int(ulen))
+		if err != nil || len(s) != int(ulen) {
+			return nil, errRdbBadData
+		}
+		return s, nil
+	}
+	return nil, errRdbBadData
+}
+
+func (r *rdbReader) readObject(typ byte) (any, error) {
+	switch typ {
+	case rdbTypeString:
+		s, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		return bytesCloneNonNil(s), nil
+
+	case rdbTypeList, rdbTypeListQuicklist2:
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		l := NewList()
+		for i := 0; i < n; i++ {
+			container := uint64(quicklistNodePlain)
+			if typ == rdbTypeListQuicklist2 {
+				if container, _, err = r.readLen(); err != nil {
+					return nil, err
+				}
+			}
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			switch container {
+			case quicklistNodePlain:
+				l.RPush(bytesCloneNonNil(s))
+			case quicklistNodePacked:
+				vals, err := redisLpEntries(s)
+				if err != nil {
+					return nil, err
+				}
+				for _, val := range vals {
+					l.RPush(val)
+				}
+			default:
+				return nil, errRdbBadData
+			}
+		}
+		if l.Len == 0 {
+			return nil, errRdbBadData
+		}
+		return l, nil
+
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack:
+		var members [][]byte
+		switch typ {
+		case rdbTypeSet:
+			n, err := r.readCount()
+			if err != nil {
+				return nil, err
+			}
+			members = make([][]byte, 0, n)
+			for i := 0; i < n; i++ {
+				s, err := r.readString()
+				if err != nil {
+					return nil, err
+				}
+				members = append(members, s)
+			}
+		case rdbTypeSetIntset:
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			if members, err = redisIntsetMembers(s); err != nil {
+				return nil, err
+			}
+		default:
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			if members, err = redisLpEntries(s); err != nil {
+				return nil, err
+			}
+		}
+		set := NewSet()
+		for _, member := range members {
+			if set.Add(string(member)) == 0 {
+				// duplicate members
+				return nil, errRdbBadData
+			}
+		}
+		if set.Len() == 0 {
+			return nil, errRdbBadData
+		}
+		return set, nil
+
+	case rdbTypeHash, rdbTypeHashListpack:
+		var pairs [][]byte
+		if typ == rdbTypeHash {
+			n, err := r.readCount()
+			if err != nil {
+				return nil, err
+			}
+			pairs = make([][]byte, 0, 2*n)
+			for i := 0; i < 2*n; i++ {
+				s, err := r.readString()
+				if err != nil {
+					return nil, err
+				}
+				pairs = append(pairs, s)
+			}
+		} else {
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			if pairs, err = redisLpEntries(s); err != nil || len(pairs)%2 != 0 {
+				return nil, errRdbBadData
+			}
+		}
+		h := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			if h.Set(string(pairs[i]), bytesCloneNonNil(pairs[i+1])) == 0 {
+				// duplicate fields
+				return nil, errRdbBadData
+			}
+		}
+		if h.Len() == 0 {
+			return nil, errRdbBadData
+		}
+		return h, nil
+
+	case rdbTypeHashMetadata, rdbTypeHashListpackEx:
+		return r.readHashMetadata(typ)
+
+	case rdbTypeZSet2, rdbTypeZSetListpack:
+		z := NewZSet()
+		add := func(member []byte, score float64) error {
+			if math.IsNaN(score) || z.Add(string(member), score) == 0 {
+				// duplicate members
+				return errRdbBadData
+			}
+			return nil
+		}
+		if typ == rdbTypeZSet2 {
+			n, err := r.readCount()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < n; i++ {
+				member, err := r.readString()
+				if err != nil {
+					return nil, err
+				}
+				p, err := r.readN(8)
+				if err != nil {
+					return nil, err
+				}
+				if err := add(member, math.Float64frombits(binary.LittleEndian.Uint64(p))); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			pairs, err := redisLpEntries(s)
+			if err != nil || len(pairs)%2 != 0 {
+				return nil, errRdbBadData
+			}
+			for i := 0; i < len(pairs); i += 2 {
+				score, err := strconv.ParseFloat(string(pairs[i+1]), 64)
+				if err != nil {
+					return nil, errRdbBadData
+				}
+				if err := add(pairs[i], score); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if z.Len() == 0 {
+			return nil, errRdbBadData
+		}
+		return z, nil
+
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return r.readStream(typ)
+	}
+	// modules, and the ziplist encodings of redis before 7
+	return nil, errRdbBadData
+}
+
+// A stream is written as redis writes it: its entries as listpacks, each keyed by the ID of its first entry,
+// the "master" entry whose fields the other entries may share, then its metadata and consumer groups.
+//
+//	master entry: <count> <deleted> <number of fields> <field> ... <0>
+//	entry:        <flags> <ms diff> <seq diff> [<number of fields>] [<field>] <value> ... <listpack elements of the entry>
+//
+// the fields being there unless the entry has the master's. A chunk makes a listpack.
+func rdbAppendStream(dst []byte, s *Stream) []byte {
+	dst = rdbAppendLen(dst, uint64(len(s.entries.chunks)))
+	for _, chunk := range s.entries.chunks {
+		master := chunk[0]
+		dst = rdbAppendString(dst, streamIDKey(master.id))
+
+		lp := redisLpNew()
+		count := 0
+		appendInt := func(n int64) {
+			lp = redisLpAppend(lp, strconv.AppendInt(nil, n, 10))
+			count++
+		}
+		appendInt(int64(len(chunk)))
+		appendInt(0)
+		appendInt(int64(len(master.val) / 2))
+		for i := 0; i < len(master.val); i += 2 {
+			lp = redisLpAppend(lp, master.val[i])
+			count++
+		}
+		appendInt(0)
+		for _, it := range chunk {
+			same := len(it.val) == len(master.val)
+			for i := 0; same && i < len(it.val); i += 2 {
+				same = bytes.Equal(it.val[i], master.val[i])
+			}
+			nf := int64(len(it.val) / 2)
+			if same {
+				appendInt(streamItemSameFields)
+			} else {
+				appendInt(0)
+			}
+			// the differences wrap around as redis's unsigned ones
+			appendInt(int64(it.id.ms - master.id.ms))
+			appendInt(int64(it.id.seq - master.id.seq))
+			if same {
+				for i := 1; i < len(it.val); i += 2 {
+					lp = redisLpAppend(lp, it.val[i])
+					count++
+				}
+				appendInt(nf + 3)
+				continue
+			}
+			appendInt(nf)
+			for _, f := range it.val {
+				lp = redisLpAppend(lp, f)
+				count++
+			}
+			appendInt(2*nf + 4)
+		}
+		dst = rdbAppendString(dst, redisLpFinish(lp, count))
+	}
+
+	dst = rdbAppendLen(dst, uint64(s.Len()))
+	for _, id := range []streamID{s.lastID, s.firstID, s.maxDeletedID} {
+		dst = rdbAppendLen(rdbAppendLen(dst, id.ms), id.seq)
+	}
+	dst = rdbAppendLen(dst, s.entriesAdded)
+
+	dst = rdbAppendLen(dst, uint64(len(s.groups)))
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		dst = rdbAppendString(dst, []byte(name))
+		dst = rdbAppendLen(rdbAppendLen(dst, g.lastID.ms), g.lastID.seq)
+		dst = rdbAppendLen(dst, uint64(g.entriesRead))
+		dst = rdbAppendLen(dst, uint64(g.pel.Len()))
+		g.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+			dst = append(dst, streamIDKey(it.id)...)
+			dst = binary.LittleEndian.AppendUint64(dst, uint64(it.val.deliveryTime))
+			dst = rdbAppendLen(dst, uint64(it.val.deliveryCount))
+			return true
+		})
+		dst = rdbAppendLen(dst, uint64(len(g.consumers)))
+		for _, cname := range g.consumerNames() {
+			c := g.consumers[cname]
+			dst = rdbAppendString(dst, []byte(cname))
+			dst = binary.LittleEndian.AppendUint64(dst, uint64(c.seenTime))
+			dst = rdbAppendLen(dst, uint64(c.pel.Len()))
+			c.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+				dst = append(dst, streamIDKey(it.id)...)
+				return true
+			})
+		}
+	}
+	return dst
+}
+
+// streamIDKey encodes an ID as redis's radix tree keys, big endian.
+func streamIDKey(id streamID) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(make([]byte, 0, 16), id.ms), id.seq)
+}
+
+func (r *rdbReader) readUint() (uint64, error) {
+	n, encoded, err := r.readLen()
+	if err != nil || encoded {
+		return 0, errRdbBadData
+	}
+	return n, nil
+}
+
+func (r *rdbReader) readStreamID() (streamID, error) {
+	ms, err := r.readUint()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, err := r.readUint()
+	return streamID{ms, seq}, err
+}
+
+// readRawStreamID reads an ID encoded as a radix tree key.
+func (r *rdbReader) readRawStreamID() (streamID, error) {
+	p, err := r.readN(16)
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{binary.BigEndian.Uint64(p), binary.BigEndian.Uint64(p[8:])}, nil
+}
+
+func (r *rdbReader) readMillis() (int64, error) {
+	p, err := r.readN(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(p)), nil
+}
+
+// rdbAppendHashMetadata appends a hash with field TTLs, without its expired fields:
+// the earliest expire time, then each field after its TTL, relative to that time and plus 1, or 0 for none.
+func rdbAppendHashMetadata(dst []byte, h *Hash) []byte {
+	var fields []string
+	var vals [][]byte
+	h.Scan(func(field string, val []byte) bool {
+		fields = append(fields, field)
+		vals = append(vals, val)
+		return true
+	})
+	var minExpire int64
+	for _, field := range fields {
+		if at, ok := h.expires[field]; ok && (minExpire == 0 || at < minExpire) {
+			minExpire = at
+		}
+	}
+
+	dst = append(dst, rdbTypeHashMetadata)
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(minExpire))
+	dst = rdbAppendLen(dst, uint64(len(fields)))
+	for i, field := range fields {
+		var ttl uint64
+		if at, ok := h.expires[field]; ok {
+			ttl = uint64(at-minExpire) + 1
+		}
+		dst = rdbAppendLen(dst, ttl)
+		dst = rdbAppendString(dst, []byte(field))
+		dst = rdbAppendString(dst, vals[i])
+	}
+	return dst
+}
+
+// readHashMetadata reads a hash with field TTLs, dropping the fields already expired.
+func (r *rdbReader) readHashMetadata(typ byte) (*Hash, error) {
+	minExpire, err := r.readMillis()
+	if err != nil {
+		return nil, err
+	}
+
+	h := NewHash()
+	now := hashNow()
+	add := func(field, val []byte, expireAt int64) error {
+		if h.Set(string(field), bytesCloneNonNil(val)) == 0 {
+			// duplicate fields
+			return errRdbBadData
+		}
+		if expireAt > 0 {
+			if expireAt > hashMaxExpireTime {
+				return errRdbBadData
+			}
+			h.SetExpireTime(string(field), expireAt)
+			if expireAt < now {
+				h.del(string(field))
+			}
+		}
+		return nil
+	}
+
+	if typ == rdbTypeHashMetadata {
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			ttl, err := r.readUint()
+			if err != nil {
+				return nil, err
+			}
+			field, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			val, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			var expireAt int64
+			if ttl > 0 {
+				expireAt = minExpire + int64(ttl) - 1
+			}
+			if err := add(field, val, expireAt); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// field, value and absolute expire time triplets, 0 for none
+		s, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := redisLpEntries(s)
+		if err != nil || len(entries)%3 != 0 {
+			return nil, errRdbBadData
+		}
+		for i := 0; i < len(entries); i += 3 {
+			expireAt, err := strconv.ParseInt(string(entries[i+2]), 10, 64)
+			if err != nil || expireAt < 0 {
+				return nil, errRdbBadData
+			}
+			if err := add(entries[i], entries[i+1], expireAt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if h.Len() == 0 {
+		return nil, errRdbBadData
+	}
+	return h, nil
+}
+
+func (r *rdbReader) readStream(typ byte) (*Stream, error) {
+	s := NewStream()
+	nodes, err := r.readUint()
+	if err != nil {
+		return nil, err
+	}
+	for ; nodes > 0; nodes-- {
+		key, err := r.readString()
+		if err != nil || len(key) != 16 {
+			return nil, errRdbBadData
+		}
+		master := streamID{binary.BigEndian.Uint64(key), binary.BigEndian.Uint64(key[8:])}
+		lp, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		vals, err := redisLpEntries(lp)
+		if err != nil {
+			return nil, err
+		}
+		if err := readStreamListpack(s, master, vals); err != nil {
+			return nil, err
+		}
+	}
+
+	length, err := r.readUint()
+	if err != nil || length != uint64(s.Len()) {
+		return nil, errRdbBadData
+	}
+	if s.lastID, err = r.readStreamID(); err != nil {
+		return nil, err
+	}
+	if last, ok := s.entries.Last(); ok && last.id.cmp(s.lastID) > 0 {
+		return nil, errRdbBadData
+	}
+	if typ >= rdbTypeStreamListpacks2 {
+		if s.firstID, err = r.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.maxDeletedID, err = r.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.entriesAdded, err = r.readUint(); err != nil {
+			return nil, err
+		}
+	} else {
+		s.updateFirstID()
+		s.entriesAdded = uint64(s.Len())
+	}
+
+	groups, err := r.readUint()
+	if err != nil {
+		return nil, err
+	}
+	for ; groups > 0; groups-- {
+		name, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := r.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		entriesRead := s.entriesUpTo(lastID)
+		if typ >= rdbTypeStreamListpacks2 {
+			n, err := r.readUint()
+			if err != nil {
+				return nil, err
+			}
+			entriesRead = int64(n)
+		}
+		g := s.createGroup(string(name), lastID, entriesRead)
+		if g == nil {
+			return nil, errRdbBadData
+		}
+
+		pending, err := r.readUint()
+		if err != nil {
+			return nil, err
+		}
+		for ; pending > 0; pending-- {
+			id, err := r.readRawStreamID()
+			if err != nil {
+				return nil, err
+			}
+			nack := &streamNack{}
+			if nack.deliveryTime, err = r.readMillis(); err != nil {
+				return nil, err
+			}
+			count, err := r.readUint()
+			if err != nil {
+				return nil, err
+			}
+			nack.deliveryCount = int64(count)
+			if !g.pel.Insert(id, nack) {
+				return nil, errRdbBadData
+			}
+		}
+
+		consumers, err := r.readUint()
+		if err != nil {
+			return nil, err
+		}
+		for ; consumers > 0; consumers-- {
+			cname, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			seen, err := r.readMillis()
+			if err != nil {
+				return nil, err
+			}
+			active := seen
+			if typ >= rdbTypeStreamListpacks3 {
+				if active, err = r.readMillis(); err != nil {
+					return nil, err
+				}
+			}
+			if g.consumer(string(cname), false, 0) != nil {
+				return nil, errRdbBadData
+			}
+			c := g.consumer(string(cname), true, seen)
+			c.activeTime = active
+			pending, err := r.readUint()
+			if err != nil {
+				return nil, err
+			}
+			for ; pending > 0; pending-- {
+				id, err := r.readRawStreamID()
+				if err != nil {
+					return nil, err
+				}
+				// an entry is pending in its group's list and in its consumer's
+				nack, ok := g.pel.Get(id)
+				if !ok || nack.consumer != nil {
+					return nil, errRdbBadData
+				}
+				g.claim(id, nack, c)
+			}
+		}
+		bad := false
+		g.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+			bad = it.val.consumer == nil
+			return !bad
+		})
+		if bad {
+			return nil, errRdbBadData
+		}
+	}
+	return s, nil
+}
+
+// readStreamListpack adds the entries of a listpack of a stream whose first entry has the ID master.
+func readStreamListpack(s *Stream, master streamID, vals [][]byte) error {
+	pos := 0
+	next := func() (int64, bool) {
+		if pos >= len(vals) {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(string(vals[pos]), 10, 64)
+		pos++
+		return n, err == nil
+	}
+	count, ok1 := next()
+	deleted, ok2 := next()
+	nf, ok3 := next()
+	if !ok1 || !ok2 || !ok3 || nf < 0 || int64(len(vals)-pos) <= nf {
+		return errRdbBadData
+	}
+	masterFields := vals[pos : pos+int(nf)]
+	pos += int(nf)
+	if zero, ok := next(); !ok || zero != 0 {
+		return errRdbBadData
+	}
+
+	var live, dead int64
+	for pos < len(vals) {
+		flags, ok1 := next()
+		msDiff, ok2 := next()
+		seqDiff, ok3 := next()
+		if !ok1 || !ok2 || !ok3 {
+			return errRdbBadData
+		}
+		id := streamID{master.ms + uint64(msDiff), master.seq + uint64(seqDiff)}
+		var fields [][]byte
+		if flags&streamItemSameFields != 0 {
+			if len(vals)-pos < len(masterFields) {
+				return errRdbBadData
+			}
+			fields = make([][]byte, 0, 2*len(masterFields))
+			for _, f := range masterFields {
+				fields = append(fields, f, vals[pos])
+				pos++
+			}
+		} else {
+			n, ok := next()
+			if !ok || n < 0 || int64(len(vals)-pos) < 2*n {
+				return errRdbBadData
+			}
+			fields = vals[pos : pos+2*int(n) : pos+2*int(n)]
+			pos += 2 * int(n)
+		}
+		if _, ok := next(); !ok {
+			return errRdbBadData
+		}
+		if flags&streamItemDeleted != 0 {
+			dead++
+			continue
+		}
+		live++
+		if last, ok := s.entries.Last(); ok && id.cmp(last.id) <= 0 {
+			return errRdbBadData
+		}
+		s.entries.Insert(id, fields)
+	}
+	if live != count || dead != deleted {
+		return errRdbBadData
+	}
+	return nil
+}
+
+// bytesCloneNonNil copies s, an empty s included, as values are never nil.
+func bytesCloneNonNil(s []byte) []byte {
+	return append(make([]byte, 0, len(s)), s...)
+}
+
+// redis's intset: <encoding, 4 bytes> <length, 4 bytes> <elements>, little endian, like ours with a longer header.
+func redisIntset(is intset) []byte {
+	buf := make([]byte, 8, 8+len(is))
+	binary.LittleEndian.PutUint32(buf, uint32(is.width()))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(is.Len()))
+	if len(is) > 0 {
+		buf = append(buf, is[1:]...)
+	}
+	return buf
+}
+
+func redisIntsetMembers(s []byte) ([][]byte, error) {
+	if len(s) < 8 {
+		return nil, errRdbBadData
+	}
+	width := binary.LittleEndian.Uint32(s)
+	n := uint64(binary.LittleEndian.Uint32(s[4:]))
+	if (width != 2 && width != 4 && width != 8) || uint64(len(s)-8) != n*uint64(width) {
+		return nil, errRdbBadData
+	}
+	is := append(intset{byte(width)}, s[8:]...)
+	members := make([][]byte, 0, n)
+	for i := 0; i < int(n); i++ {
+		members = append(members, strconv.AppendInt(nil, is.Get(i), 10))
+	}
+	return members, nil
+}
+
+// redis's listpack:
+//
+//	<total bytes, 4 bytes> <entries, 2 bytes> <entry> ... <0xff>
+//
+// An entry is an encoding byte, possibly followed by a length, then the data and the entry's size,
+// written backwards in 7-bit groups. Integers are stored in as few bytes as they fit.
+const (
+	redisLpHeaderSize = 6
+	redisLpEOF        = 0xff
+
+	redisLp7BitUint  = 0x00 // 0xxxxxxx
+	redisLp6BitStr   = 0x80 // 10xxxxxx
+	redisLp13BitInt  = 0xc0 // 110xxxxx xxxxxxxx
+	redisLp12BitStr  = 0xe0 // 1110xxxx xxxxxxxx
+	redisLp16BitInt  = 0xf1
+	redisLp24BitInt  = 0xf2
+	redisLp32BitInt  = 0xf3
+	redisLp64BitInt  = 0xf4
+	redisLp32BitStr  = 0xf0
+	redisLpUnknownNb = math.MaxUint16 // the entries count when it doesn't fit
+)
+
+func redisLpNew() []byte {
+	return make([]byte, redisLpHeaderSize)
+}
+
+func redisLpAppend(lp []byte, val []byte) []byte {
+	start := len(lp)
+	if v, ok := intsetMember(string(val)); ok {
+		switch {
+		case v >= 0 && v <= 127:
+			lp = append(lp, byte(v))
+		case v >= -4096 && v <= 4095:
+			u := uint64(v) & 0x1fff
+			lp = append(lp, redisLp13BitInt|byte(u>>8), byte(u))
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			lp = binary.LittleEndian.AppendUint16(append(lp, redisLp16BitInt), uint16(v))
+		case v >= -1<<23 && v < 1<<23:
+			u := uint32(v)
+			lp = append(lp, redisLp24BitInt, byte(u), byte(u>>8), byte(u>>16))
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			lp = binary.LittleEndian.AppendUint32(append(lp, redisLp32BitInt), uint32(v))
+		default:
+			lp = binary.LittleEndian.AppendUint64(append(lp, redisLp64BitInt), uint64(v))
+		}
+	} else {
+		switch n := len(val); {
+		case n < 64:
+			lp = append(lp, redisLp6BitStr|byte(n))
+		case n < 4096:
+			lp = append(lp, redisLp12BitStr|byte(n>>8), byte(n))
+		default:
+			lp = binary.LittleEndian.AppendUint32(append(lp, redisLp32BitStr), uint32(n))
+		}
+		lp = append(lp, val...)
+	}
+
+	// the entry size, most significant group first, every group but the first one flagged
+	size := uint64(len(lp) - start)
+	groups := redisLpBacklenSize(size)
+	for i := groups - 1; i >= 0; i-- {
+		b := byte(size>>(7*i)) & 0x7f
+		if i < groups-1 {
+			b |= 0x80
+		}
+		lp = append(lp, b)
+	}
+	return lp
+}
+
+func redisLpBacklenSize(size uint64) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+func redisLpFinish(lp []byte, count int) []byte {
+	lp = append(lp, redisLpEOF)
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	if count >= redisLpUnknownNb {
+		count = redisLpUnknownNb
+	}
+	binary.LittleEndian.PutUint16(lp[4:], uint16(count))
+	return lp
+}
+
+// redisLpEntries returns the values of a redis listpack, checking its structure.
+func redisLpEntries(lp []byte) ([][]byte, error) {
+	if len(lp) < redisLpHeaderSize+1 || binary.LittleEndian.Uint32(lp) != uint32(len(lp)) || lp[len(lp)-1] != redisLpEOF {
+		return nil, errRdbBadData
+	}
+	count := int(binary.LittleEndian.Uint16(lp[4:]))
+	var vals [][]byte
+	p := lp[redisLpHeaderSize : len(lp)-1]
+	for len(p) > 0 {
+		var val []byte
+		var size uint64
+		enc := p[0]
+		intVal := func(n int) (int64, bool) {
+			if len(p) < 1+n {
+				return 0, false
+			}
+			var u uint64
+			for i := n; i > 0; i-- {
+				u = u<<8 | uint64(p[i])
+			}
+			size = uint64(1 + n)
+			// sign extend
+			shift := 64 - 8*n
+			return int64(u<<shift) >> shift, true
+		}
+		strVal := func(header int, n uint64) bool {
+			if uint64(len(p)) < uint64(header)+n {
+				return false
+			}
+			val = append([]byte(nil), p[header:header+int(n)]...)
+			size = uint64(header) + n
+			return true
+		}
+
+		ok := true
+		switch {
+		case enc&0x80 == redisLp7BitUint:
+			val, size = strconv.AppendInt(nil, int64(enc), 10), 1
+		case enc&0xc0 == redisLp6BitStr:
+			ok = strVal(1, uint64(enc&0x3f))
+		case enc&0xe0 == redisLp13BitInt:
+			if len(p) < 2 {
+				return nil, errRdbBadData
+			}
+			u := uint64(enc&0x1f)<<8 | uint64(p[1])
+			v := int64(u)
+			if u >= 1<<12 {
+				v -= 1 << 13
+			}
+			val, size = strconv.AppendInt(nil, v, 10), 2
+		case enc&0xf0 == redisLp12BitStr:
+			if len(p) < 2 {
+				return nil, errRdbBadData
+			}
+			ok = strVal(2, uint64(enc&0x0f)<<8|uint64(p[1]))
+		case enc == redisLp32BitStr:
+			if len(p) < 5 {
+				return nil, errRdbBadData
+			}
+			ok = strVal(5, uint64(binary.LittleEndian.Uint32(p[1:])))
+		case enc >= redisLp16BitInt && enc <= redisLp64BitInt:
+			// 2, 3, 4 or 8 bytes
+			n := int(enc-redisLp16BitInt) + 2
+			if enc == redisLp64BitInt {
+				n = 8
+			}
+			var v int64
+			v, ok = intVal(n)
+			val = strconv.AppendInt(nil, v, 10)
+		default:
+			return nil, errRdbBadData
+		}
+		if !ok {
+			return nil, errRdbBadData
+		}
+
+		size += uint64(redisLpBacklenSize(size))
+		if size > uint64(len(p)) {
+			return nil, errRdbBadData
+		}
+		p = p[size:]
+		vals = append(vals, val)
+	}
+	if count != redisLpUnknownNb && count != len(vals) {
+		return nil, errRdbBadData
+	}
+	return vals, nil
+}
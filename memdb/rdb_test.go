@@ -0,0 +1,202 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"gRedis/util"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisListpack(t *testing.T) {
+	vals := [][]byte{
+		[]byte("0"), []byte("127"), []byte("-1"), []byte("4095"), []byte("-4096"), []byte("-4097"),
+		[]byte("32767"), []byte("-32768"), []byte("8388607"), []byte("-8388608"), []byte("-8388609"),
+		[]byte("2147483647"), []byte("-9223372036854775808"), []byte("9223372036854775807"),
+		[]byte("01"), []byte("+1"), []byte(""), []byte("abc"), bytes.Repeat([]byte("x"), 100), bytes.Repeat([]byte("y"), 5000),
+	}
+	lp := redisLpNew()
+	for _, v := range vals {
+		lp = redisLpAppend(lp, v)
+	}
+	lp = redisLpFinish(lp, len(vals))
+
+	got, err := redisLpEntries(lp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(vals) {
+		t.Fatal(fmt.Sprintf("listpack holds %d entries", len(got)))
+	}
+	for i := range vals {
+		if !bytes.Equal(got[i], vals[i]) {
+			t.Error(fmt.Sprintf("listpack entry %d == %q, expect %q", i, got[i], vals[i]))
+		}
+	}
+	// an int of 13 bits takes 2 bytes, and 1 of backlen
+	if small := redisLpFinish(redisLpAppend(redisLpNew(), []byte("-4096")), 1); len(small) != 6+3+1 {
+		t.Error(fmt.Sprintf("listpack of a 13 bit int is %d bytes", len(small)))
+	}
+
+	if _, err := redisLpEntries(lp[:len(lp)-1]); err == nil {
+		t.Error("a truncated listpack should be rejected")
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	list := NewList()
+	list.fill, list.compressDepth = 16, 1
+	for i := 0; i < 200; i++ {
+		list.RPush([]byte(strings.Repeat("v", i%70) + strconv.Itoa(i)))
+	}
+	smallHash, bigHash := NewHash(), NewHash()
+	smallHash.Set("f", []byte("v"))
+	smallHash.Set("n", []byte("-12"))
+	for i := 0; i < 300; i++ {
+		bigHash.Set("field"+strconv.Itoa(i), []byte(strconv.Itoa(i*i)))
+	}
+	intSet, lpSet, bigSet := NewSet(), NewSet(), NewSet()
+	for i := 0; i < 300; i++ {
+		intSet.Add(strconv.Itoa(i * 100000))
+		bigSet.Add("m" + strconv.Itoa(i))
+	}
+	intSet.Add("-1")
+	lpSet.Add("a")
+	lpSet.Add("1")
+	zset := NewZSet()
+	for i := 0; i < 100; i++ {
+		zset.Add("m"+strconv.Itoa(i), float64(i%10)/3)
+	}
+
+	values := []any{
+		[]byte("10"), []byte("-123456789"), []byte("hello"), bytes.Repeat([]byte("compressible"), 100), []byte(""),
+		list, smallHash, bigHash, intSet, lpSet, bigSet, zset,
+	}
+	for _, v := range values {
+		got, err := rdbRestore(rdbDump(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch v := v.(type) {
+		case []byte:
+			if !bytes.Equal(got.([]byte), v) {
+				t.Error(fmt.Sprintf("string restored as %q", got))
+			}
+		case *List:
+			if !reflect.DeepEqual(got.(*List).Range(0, -1), v.Range(0, -1)) {
+				t.Error("list not restored")
+			}
+		case *Hash:
+			h := got.(*Hash)
+			if h.Len() != v.Len() || h.Encoding() != v.Encoding() {
+				t.Error(fmt.Sprintf("hash restored with %d fields as %s", h.Len(), h.Encoding()))
+			}
+			v.Scan(func(field string, val []byte) bool {
+				if !bytes.Equal(h.Get(field), val) {
+					t.Error(fmt.Sprintf("hash field %s restored as %q", field, h.Get(field)))
+				}
+				return true
+			})
+		case *Set:
+			s := got.(*Set)
+			a, b := s.Members(), v.Members()
+			sort.Strings(a)
+			sort.Strings(b)
+			if !reflect.DeepEqual(a, b) || s.Encoding() != v.Encoding() {
+				t.Error(fmt.Sprintf("set restored as %s %v", s.Encoding(), a))
+			}
+		case *ZSet:
+			z := got.(*ZSet)
+			if !reflect.DeepEqual(z.dict, v.dict) {
+				t.Error(fmt.Sprintf("zset restored as %v", z.dict))
+			}
+		}
+	}
+
+	// DUMP of the integer 10 in redis's documentation
+	got, err := rdbRestore([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	if err != nil || string(got.([]byte)) != "10" {
+		t.Error(fmt.Sprintf("redis payload restored as %q, %v", got, err))
+	}
+	if _, err := rdbRestore([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\x0b")); err != errRdbChecksum {
+		t.Error("a bad checksum should be rejected")
+	}
+	bad := rdbDump([]byte("10"))
+	bad[len(bad)-10] = rdbMaxVersion + 1
+	if _, err := rdbRestore(bad); err != errRdbChecksum {
+		t.Error("a newer version should be rejected")
+	}
+
+	// redis dumps small sorted sets as a listpack of members and scores
+	lp := redisLpNew()
+	for _, v := range []string{"a", "1", "b", "2.5"} {
+		lp = redisLpAppend(lp, []byte(v))
+	}
+	payload := rdbAppendString([]byte{rdbTypeZSetListpack}, redisLpFinish(lp, 4))
+	payload = binary.LittleEndian.AppendUint16(payload, rdbVersion)
+	payload = binary.LittleEndian.AppendUint64(payload, util.CRC64(0, payload))
+	got, err = rdbRestore(payload)
+	if z, ok := got.(*ZSet); err != nil || !ok || !reflect.DeepEqual(z.dict, map[string]float64{"a": 1, "b": 2.5}) {
+		t.Error(fmt.Sprintf("listpack zset restored as %v, %v", got, err))
+	}
+}
+
+func TestDumpRestoreKey(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	exec(db, "rpush", "list", "a", "b", "1")
+	exec(db, "set", "str", "s")
+	payload := exec(db, "dump", "list")
+	if r := exec(db, "dump", "nosuchkey"); r != "$-1\r\n" {
+		t.Error(fmt.Sprintf("DUMP of a missing key == %q", r))
+	}
+	value := payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+
+	if r := exec(db, "restore", "list", "0", value); r != "-BUSYKEY Target key name already exists.\r\n" {
+		t.Error(fmt.Sprintf("RESTORE onto an existing key == %q", r))
+	}
+	if r := exec(db, "restore", "str", "0", value, "replace"); r != "+OK\r\n" || exec(db, "lrange", "str", "0", "-1") != "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n1\r\n" {
+		t.Error("RESTORE REPLACE should overwrite the key")
+	}
+	if r := exec(db, "restore", "copy", "5000", value); r != "+OK\r\n" || exec(db, "ttl", "copy") != ":5\r\n" {
+		t.Error(fmt.Sprintf("RESTORE with ttl: ttl == %q", exec(db, "ttl", "copy")))
+	}
+	at := strconv.FormatInt((time.Now().Unix()+3600)*1000, 10)
+	if r := exec(db, "restore", "abs", at, value, "absttl"); r != "+OK\r\n" || exec(db, "ttl", "abs") != ":3600\r\n" {
+		t.Error(fmt.Sprintf("RESTORE ABSTTL: ttl == %q", exec(db, "ttl", "abs")))
+	}
+	if r := exec(db, "restore", "past", "1000", value, "absttl"); r != "+OK\r\n" || exec(db, "exists", "past") != ":0\r\n" {
+		t.Error("RESTORE with an absolute ttl in the past should not create the key")
+	}
+
+	exec(db, "restore", "idle", "0", value, "idletime", "1000")
+	if r := exec(db, "object", "idletime", "idle"); r != ":1000\r\n" {
+		t.Error(fmt.Sprintf("RESTORE IDLETIME: idletime == %q", r))
+	}
+	exec(db, "restore", "freq", "0", value, "freq", "100")
+	if r := exec(db, "object", "freq", "freq"); r != ":100\r\n" {
+		t.Error(fmt.Sprintf("RESTORE FREQ: freq == %q", r))
+	}
+
+	for _, args := range [][]string{
+		{"restore", "k", "-1", value},
+		{"restore", "k", "0", value, "freq", "256"},
+		{"restore", "k", "0", value, "idletime", "-1"},
+		{"restore", "k", "0", value, "idletime", "1", "freq", "1"},
+		{"restore", "k", "0", value, "nosuchoption"},
+		{"restore", "k", "0", "garbage"},
+	} {
+		if r := exec(db, args...); !strings.HasPrefix(r, "-ERR") {
+			t.Error(fmt.Sprintf("%v == %q, expect an error", args, r))
+		}
+	}
+	if exec(db, "exists", "k") != ":0\r\n" {
+		t.Error("a failed RESTORE should not create the key")
+	}
+}
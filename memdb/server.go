@@ -0,0 +1,104 @@
+package memdb
+
+import (
+	"gRedis/resp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// flushMode parses the SYNC or ASYNC option of FLUSHDB and FLUSHALL, defaulting to lazyfree-lazy-user-flush.
+func flushMode(cmd [][]byte) (lazy bool, errReply resp.RedisData) {
+	if len(cmd) > 2 {
+		return false, resp.NewWrongArgsError(cmd[0])
+	}
+	if len(cmd) == 1 {
+		return lazyfreeUserFlush(), nil
+	}
+	switch strings.ToLower(string(cmd[1])) {
+	case "async":
+		return true, nil
+	case "sync":
+		return false, nil
+	}
+	return false, resp.NewSyntaxError()
+}
+
+func flushDb(db *MemDb, cmd [][]byte) resp.RedisData {
+	lazy, errReply := flushMode(cmd)
+	if errReply != nil {
+		return errReply
+	}
+	flush([]*MemDb{db}, lazy)
+	return resp.NewSimpleString("OK")
+}
+
+func flushAll(db *MemDb, cmd [][]byte) resp.RedisData {
+	lazy, errReply := flushMode(cmd)
+	if errReply != nil {
+		return errReply
+	}
+	flush(db.dbs, lazy)
+	return resp.NewSimpleString("OK")
+}
+
+// infoSections writes the sections of INFO, in order; INFO reads atomics only, it takes no lock.
+var infoSections = []struct {
+	name  string
+	write func(db *MemDb, b *strings.Builder)
+}{
+	{"memory", infoMemory},
+	{"keyspace", infoKeyspace},
+}
+
+func infoMemory(db *MemDb, b *strings.Builder) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	writeInfoField(b, "used_memory", int64(ms.HeapAlloc))
+	writeInfoField(b, "used_memory_rss", int64(ms.HeapSys-ms.HeapReleased))
+	writeInfoField(b, "used_memory_startup", int64(startupAllocated.Load()))
+	writeInfoField(b, "lazyfree_pending_objects", lazyfree.pending.Load())
+	writeInfoField(b, "lazyfreed_objects", lazyfree.freed.Load())
+}
+
+func infoKeyspace(db *MemDb, b *strings.Builder) {
+	for _, d := range db.dbs {
+		keys := d.dict.Count()
+		if keys == 0 {
+			continue
+		}
+		b.WriteString("db" + strconv.Itoa(d.id) + ":keys=" + strconv.Itoa(keys) + ",expires=" + strconv.Itoa(d.expires.Count()) + "\r\n")
+	}
+}
+
+func writeInfoField(b *strings.Builder, name string, value int64) {
+	b.WriteString(name + ":" + strconv.FormatInt(value, 10) + "\r\n")
+}
+
+// INFO [section ...]; all and everything name every section
+func infoCmd(db *MemDb, cmd [][]byte) resp.RedisData {
+	want := make(map[string]bool)
+	for _, arg := range cmd[1:] {
+		want[strings.ToLower(string(arg))] = true
+	}
+	all := len(want) == 0 || want["all"] || want["everything"] || want["default"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !want[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.write(db, &b)
+	}
+	return resp.NewBulkString([]byte(b.String()))
+}
+
+func RegisterServerCommands() {
+	RegisterCommand("flushdb", flushDb, allKeys, 0, 0)
+	RegisterCommand("flushall", flushAll, allKeys, 0, 0)
+	RegisterCommand("info", infoCmd, noKeys, 0, 0)
+}
@@ -7,26 +7,24 @@ import (
 
 func sAddSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		v = NewSet()
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 	}
 
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	res := 0
@@ -40,19 +38,15 @@ func sAddSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sCardSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -60,7 +54,7 @@ func sCardSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	return resp.NewInteger(int64(s.Len()))
@@ -68,23 +62,19 @@ func sCardSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sInterSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	keys := make([]string, 0, len(cmd)-1)
 	for _, _key := range cmd[1:] {
 		key := string(_key)
-		if !db.DeleteExpiredKey(key) {
-			// can't guarantee the key must to be existed
-			keys = append(keys, key)
-		}
+		keys = append(keys, key)
 	}
 
 	db.locks.MRLock(keys)
 	defer db.locks.MRUnLock(keys)
 
-	v, ok := db.dict.Get(keys[0])
+	v, ok := db.lookupKeyRead(keys[0])
 	if !ok {
 		// Keys that do not exist are considered to be empty sets.
 		v = NewSet()
@@ -93,7 +83,7 @@ func sInterSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	var res []resp.RedisData
@@ -107,12 +97,12 @@ func sInterSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	sets := make([]*Set, 0, len(keys)-1)
 	for _, key := range keys[1:] {
-		_set, ok := db.dict.Get(key)
+		_set, ok := db.lookupKeyRead(key)
 		var set *Set
 		if ok {
 			set, ok = _set.(*Set)
 			if !ok {
-				return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+				return resp.NewWrongTypeError()
 			}
 		} else {
 			// Keys that do not exist are considered to be empty sets.
@@ -132,21 +122,17 @@ func sInterSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sInterStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	// check destination
 	dest := string(cmd[1])
-	db.DeleteExpiredKey(dest)
 
 	// check set operation keys
 	keys := make([]string, 0, len(cmd)-2)
 	for _, _key := range cmd[2:] {
 		key := string(_key)
-		if !db.DeleteExpiredKey(key) {
-			// can't guarantee the key must to be existed
-			keys = append(keys, key)
-		}
+		keys = append(keys, key)
 	}
 	if len(keys) == 0 {
 		return resp.NewInteger(0)
@@ -162,7 +148,7 @@ func sInterStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.MUnLock(lockKeys)
 
 	// check primary key
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		// if primary doesn't existed, treat it as a empty set
 		v = NewSet()
@@ -170,15 +156,15 @@ func sInterStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	// whatever old destination key/value it is, just cover before return
-	_, oldOk := db.dict.Get(dest)
+	_, oldOk := db.lookupKeyWrite(dest)
 	destSet := NewSet()
 
 	defer func() {
-		db.dict.Set(dest, destSet)
+		db.setKey(dest, destSet)
 		if oldOk {
 			db.DeleteExpire(dest)
 		}
@@ -196,11 +182,11 @@ func sInterStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// calculate set operation
 	sets := make([]*Set, 0, len(keys)-1)
 	for _, key := range keys[1:] {
-		_set, ok := db.dict.Get(key)
+		_set, ok := db.lookupKeyWrite(key)
 		if ok {
 			set, ok := _set.(*Set)
 			if !ok {
-				return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+				return resp.NewWrongTypeError()
 			}
 			sets = append(sets, set)
 		}
@@ -217,23 +203,19 @@ func sInterStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sDiffSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	keys := make([]string, 0, len(cmd)-1)
 	for _, _key := range cmd[1:] {
 		key := string(_key)
-		if !db.DeleteExpiredKey(key) {
-			// can't guarantee the key must to be existed
-			keys = append(keys, key)
-		}
+		keys = append(keys, key)
 	}
 
 	db.locks.MRLock(keys)
 	defer db.locks.MRUnLock(keys)
 
-	v, ok := db.dict.Get(keys[0])
+	v, ok := db.lookupKeyRead(keys[0])
 	if !ok {
 		// Keys that do not exist are considered to be empty sets.
 		v = NewSet()
@@ -242,7 +224,7 @@ func sDiffSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	var res []resp.RedisData
@@ -256,12 +238,12 @@ func sDiffSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	sets := make([]*Set, 0, len(keys)-1)
 	for _, key := range keys[1:] {
-		_set, ok := db.dict.Get(key)
+		_set, ok := db.lookupKeyRead(key)
 		var set *Set
 		if ok {
 			set, ok = _set.(*Set)
 			if !ok {
-				return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+				return resp.NewWrongTypeError()
 			}
 		} else {
 			// Keys that do not exist are considered to be empty sets.
@@ -281,21 +263,17 @@ func sDiffSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sDiffStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	// check destination
 	dest := string(cmd[1])
-	db.DeleteExpiredKey(dest)
 
 	// check set operation keys
 	keys := make([]string, 0, len(cmd)-2)
 	for _, _key := range cmd[2:] {
 		key := string(_key)
-		if !db.DeleteExpiredKey(key) {
-			// can't guarantee the key must to be existed
-			keys = append(keys, key)
-		}
+		keys = append(keys, key)
 	}
 	if len(keys) == 0 {
 		return resp.NewInteger(0)
@@ -311,7 +289,7 @@ func sDiffStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.MUnLock(lockKeys)
 
 	// check primary key
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		// if primary doesn't existed, treat it as a empty set
 		v = NewSet()
@@ -319,15 +297,15 @@ func sDiffStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	// whatever old destination key/value it is, just cover old before return
-	_, oldOk := db.dict.Get(dest)
+	_, oldOk := db.lookupKeyWrite(dest)
 	destSet := NewSet()
 
 	defer func() {
-		db.dict.Set(dest, destSet)
+		db.setKey(dest, destSet)
 		if oldOk {
 			db.DeleteExpire(dest)
 		}
@@ -345,11 +323,11 @@ func sDiffStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// calculate set operation
 	sets := make([]*Set, 0, len(keys)-1)
 	for _, key := range keys[1:] {
-		_set, ok := db.dict.Get(key)
+		_set, ok := db.lookupKeyWrite(key)
 		if ok {
 			set, ok := _set.(*Set)
 			if !ok {
-				return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+				return resp.NewWrongTypeError()
 			}
 			sets = append(sets, set)
 		}
@@ -366,21 +344,17 @@ func sDiffStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sIsMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewInteger(0)
-	}
 
 	member := string(cmd[2])
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -388,7 +362,7 @@ func sIsMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	if !s.Has(member) {
@@ -400,19 +374,15 @@ func sIsMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sMembersSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewArray(nil)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewArray(nil)
 	}
@@ -420,7 +390,7 @@ func sMembersSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	var res []resp.RedisData
@@ -434,33 +404,26 @@ func sMembersSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sMoveSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	src := string(cmd[1])
-	if db.DeleteExpiredKey(src) {
-		return resp.NewInteger(0)
-	}
 
 	des := string(cmd[2])
-	if db.DeleteExpiredKey(des) {
-		return resp.NewInteger(0)
-	}
 
 	member := string(cmd[3])
 
 	db.locks.MLock([]string{src, des})
 	defer db.locks.MUnLock([]string{src, des})
 
-	srcVal, ok := db.dict.Get(src)
+	srcVal, ok := db.lookupKeyWrite(src)
 	if !ok {
 		return resp.NewInteger(0)
 	}
 
 	srcSet, ok := srcVal.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	defer func() {
@@ -470,14 +433,14 @@ func sMoveSet(db *MemDb, cmd [][]byte) resp.RedisData {
 		}
 	}()
 
-	desVal, ok := db.dict.Get(des)
+	desVal, ok := db.lookupKeyWrite(des)
 	if !ok {
 		return resp.NewInteger(0)
 	}
 
 	desSet, ok := desVal.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	res := srcSet.Move(desSet, member)
@@ -487,14 +450,10 @@ func sMoveSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sPopSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 && len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
 	countVal := 1
 	var err error
@@ -503,25 +462,25 @@ func sPopSet(db *MemDb, cmd [][]byte) resp.RedisData {
 		count = true
 		countVal, err = strconv.Atoi(string(cmd[2]))
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 	}
 
 	if countVal <= 0 {
-		return resp.NewSimpleError("value must be positive")
+		return resp.NewNotPositiveError()
 	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewBulkString(nil)
 	}
 
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	defer func() {
@@ -553,14 +512,10 @@ func sPopSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sRandMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 && len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
 	countVal := 1
 	var err error
@@ -569,7 +524,7 @@ func sRandMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 		count = true
 		countVal, err = strconv.Atoi(string(cmd[2]))
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 	}
 
@@ -580,14 +535,14 @@ func sRandMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewBulkString(nil)
 	}
 
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	var rand []string
@@ -616,17 +571,15 @@ func sRandMemberSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sRemSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		return resp.NewInteger(0)
 	}
@@ -634,7 +587,7 @@ func sRemSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	res := 0
@@ -648,23 +601,19 @@ func sRemSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sUnionSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	keys := make([]string, 0, len(cmd)-1)
 	for _, _key := range cmd[1:] {
 		key := string(_key)
-		if !db.DeleteExpiredKey(key) {
-			// can't guarantee the key must to be existed
-			keys = append(keys, key)
-		}
+		keys = append(keys, key)
 	}
 
 	db.locks.MRLock(keys)
 	defer db.locks.MRUnLock(keys)
 
-	v, ok := db.dict.Get(keys[0])
+	v, ok := db.lookupKeyRead(keys[0])
 	if !ok {
 		// Keys that do not exist are considered to be empty sets.
 		v = NewSet()
@@ -673,7 +622,7 @@ func sUnionSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// wrong type
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	var res []resp.RedisData
@@ -687,12 +636,12 @@ func sUnionSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	sets := make([]*Set, 0, len(keys)-1)
 	for _, key := range keys[1:] {
-		_set, ok := db.dict.Get(key)
+		_set, ok := db.lookupKeyRead(key)
 		var set *Set
 		if ok {
 			set, ok = _set.(*Set)
 			if !ok {
-				return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+				return resp.NewWrongTypeError()
 			}
 		} else {
 			// Keys that do not exist are considered to be empty sets.
@@ -712,21 +661,17 @@ func sUnionSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func sUnionStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	// check destination
 	dest := string(cmd[1])
-	db.DeleteExpiredKey(dest)
 
 	// check set operation keys
 	keys := make([]string, 0, len(cmd)-2)
 	for _, _key := range cmd[2:] {
 		key := string(_key)
-		if !db.DeleteExpiredKey(key) {
-			// can't guarantee the key must to be existed
-			keys = append(keys, key)
-		}
+		keys = append(keys, key)
 	}
 	if len(keys) == 0 {
 		return resp.NewInteger(0)
@@ -742,7 +687,7 @@ func sUnionStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	defer db.locks.MUnLock(lockKeys)
 
 	// check primary key
-	v, ok := db.dict.Get(key)
+	v, ok := db.lookupKeyWrite(key)
 	if !ok {
 		// if primary doesn't existed, treat it as a empty set
 		v = NewSet()
@@ -750,15 +695,15 @@ func sUnionStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	s, ok := v.(*Set)
 	if !ok {
-		return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+		return resp.NewWrongTypeError()
 	}
 
 	// whatever old destination key/value it is, just cover before return
-	_, oldOk := db.dict.Get(dest)
+	_, oldOk := db.lookupKeyWrite(dest)
 	destSet := NewSet()
 
 	defer func() {
-		db.dict.Set(dest, destSet)
+		db.setKey(dest, destSet)
 		if oldOk {
 			db.DeleteExpire(dest)
 		}
@@ -776,11 +721,11 @@ func sUnionStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 	// calculate set operation
 	sets := make([]*Set, 0, len(keys)-1)
 	for _, key := range keys[1:] {
-		_set, ok := db.dict.Get(key)
+		_set, ok := db.lookupKeyWrite(key)
 		if ok {
 			set, ok := _set.(*Set)
 			if !ok {
-				return resp.NewSimpleError("Operation against a key holding the wrong kind of value")
+				return resp.NewWrongTypeError()
 			}
 			sets = append(sets, set)
 		}
@@ -796,18 +741,18 @@ func sUnionStoreSet(db *MemDb, cmd [][]byte) resp.RedisData {
 }
 
 func RegisterSetCommands() {
-	RegisterCommand("sadd", sAddSet)
-	RegisterCommand("scard", sCardSet)
-	RegisterCommand("sdiff", sDiffSet)
-	RegisterCommand("sdiffstore", sDiffStoreSet)
-	RegisterCommand("sinter", sInterSet)
-	RegisterCommand("sinterstore", sInterStoreSet)
-	RegisterCommand("sismember", sIsMemberSet)
-	RegisterCommand("smembers", sMembersSet)
-	RegisterCommand("smove", sMoveSet)
-	RegisterCommand("spop", sPopSet)
-	RegisterCommand("srandmember", sRandMemberSet)
-	RegisterCommand("srem", sRemSet)
-	RegisterCommand("sunion", sUnionSet)
-	RegisterCommand("sunionstore", sUnionStoreSet)
+	RegisterCommand("sadd", sAddSet, 1, 1, 1)
+	RegisterCommand("scard", sCardSet, 1, 1, 1)
+	RegisterCommand("sdiff", sDiffSet, 1, -1, 1)
+	RegisterCommand("sdiffstore", sDiffStoreSet, 1, -1, 1)
+	RegisterCommand("sinter", sInterSet, 1, -1, 1)
+	RegisterCommand("sinterstore", sInterStoreSet, 1, -1, 1)
+	RegisterCommand("sismember", sIsMemberSet, 1, 1, 1)
+	RegisterCommand("smembers", sMembersSet, 1, 1, 1)
+	RegisterCommand("smove", sMoveSet, 1, 2, 1)
+	RegisterCommand("spop", sPopSet, 1, 1, 1)
+	RegisterCommand("srandmember", sRandMemberSet, 1, 1, 1)
+	RegisterCommand("srem", sRemSet, 1, 1, 1)
+	RegisterCommand("sunion", sUnionSet, 1, -1, 1)
+	RegisterCommand("sunionstore", sUnionStoreSet, 1, -1, 1)
 }
@@ -1,41 +1,192 @@
 package memdb
 
+import (
+	"bytes"
+	"gRedis/config"
+	"math/rand"
+	"strconv"
+)
+
 type void struct{}
 
+// Set is encoded like in redis: an intset while every member is an integer,
+// a listpack while it is small, and a map past set-max-intset-entries, set-max-listpack-entries
+// or set-max-listpack-value.
 type Set struct {
+	data  []byte // intset or listpack
+	count int    // members in the listpack
 	table map[string]void
+	enc   uint8
 }
 
+const (
+	setIntset = iota
+	setListpack
+	setHashtable
+)
+
 func NewSet() *Set {
-	return &Set{table: make(map[string]void)}
+	return &Set{}
+}
+
+func setMaxIntsetEntries() int {
+	if config.Conf == nil {
+		return 512
+	}
+	return config.Conf.SetMaxIntsetEntries
+}
+
+func setMaxListpackEntries() int {
+	if config.Conf == nil {
+		return 128
+	}
+	return config.Conf.SetMaxListpackEntries
+}
+
+func setMaxListpackValue() int {
+	if config.Conf == nil {
+		return 64
+	}
+	return config.Conf.SetMaxListpackValue
+}
+
+// intsetMember returns the integer a member stands for, if it's the canonical form of one.
+func intsetMember(key string) (int64, bool) {
+	if len(key) == 0 || len(key) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != key {
+		return 0, false
+	}
+	return v, true
+}
+
+func (s *Set) Encoding() string {
+	switch s.enc {
+	case setIntset:
+		return encodingIntset
+	case setListpack:
+		return encodingListpack
+	default:
+		return encodingHashtable
+	}
+}
+
+// convert moves the members to the listpack or map encoding, making room for one more member of size bytes.
+func (s *Set) convert(size int) {
+	members := s.Members()
+	if s.enc == setIntset && len(members) < setMaxListpackEntries() && size <= setMaxListpackValue() {
+		s.enc, s.data = setListpack, nil
+		for _, member := range members {
+			s.data = lpAppendEntry(s.data, []byte(member))
+		}
+		s.count = len(members)
+		return
+	}
+
+	s.table = make(map[string]void, len(members)+1)
+	for _, member := range members {
+		s.table[member] = void{}
+	}
+	s.enc, s.data, s.count = setHashtable, nil, 0
 }
 
 func (s *Set) Has(key string) bool {
-	_, ok := s.table[key]
-	return ok
+	switch s.enc {
+	case setIntset:
+		v, ok := intsetMember(key)
+		return ok && intset(s.data).Has(v)
+	case setListpack:
+		return lpFind(s.data, 0, []byte(key), 0) >= 0
+	default:
+		_, ok := s.table[key]
+		return ok
+	}
 }
 
 func (s *Set) Len() int {
-	return len(s.table)
+	switch s.enc {
+	case setIntset:
+		return intset(s.data).Len()
+	case setListpack:
+		return s.count
+	default:
+		return len(s.table)
+	}
 }
 
 func (s *Set) Add(key string) int {
 	if s.Has(key) {
 		return 0
 	}
+
+	if s.enc == setIntset {
+		if v, ok := intsetMember(key); ok && intset(s.data).Len() < setMaxIntsetEntries() {
+			s.data, _ = intset(s.data).Add(v)
+			return 1
+		}
+		s.convert(len(key))
+	}
+	if s.enc == setListpack {
+		if s.count < setMaxListpackEntries() && len(key) <= setMaxListpackValue() {
+			s.data = lpAppendEntry(s.data, []byte(key))
+			s.count++
+			return 1
+		}
+		s.convert(len(key))
+	}
 	s.table[key] = void{}
 	return 1
 }
 
 func (s *Set) Remove(key string) int {
-	if s.Has(key) {
+	switch s.enc {
+	case setIntset:
+		v, ok := intsetMember(key)
+		if !ok {
+			return 0
+		}
+		is, ok := intset(s.data).Remove(v)
+		if !ok {
+			return 0
+		}
+		s.data = is
+	case setListpack:
+		off := lpFind(s.data, 0, []byte(key), 0)
+		if off < 0 {
+			return 0
+		}
+		_, end := lpGet(s.data, off)
+		s.data = lpDelete(s.data, off, end)
+		s.count--
+	default:
+		if !s.Has(key) {
+			return 0
+		}
 		delete(s.table, key)
-		return 1
 	}
-	return 0
+	return 1
+}
+
+// member returns the member at position i of a compact set.
+func (s *Set) member(i int) string {
+	if s.enc == setIntset {
+		return strconv.FormatInt(intset(s.data).Get(i), 10)
+	}
+	val, _ := lpGet(s.data, lpSeek(s.data, s.count, i))
+	return string(val)
 }
 
 func (s *Set) Pop() string {
+	if s.enc != setHashtable {
+		if s.Len() == 0 {
+			return ""
+		}
+		key := s.member(rand.Intn(s.Len()))
+		s.Remove(key)
+		return key
+	}
 	for key := range s.table {
 		delete(s.table, key)
 		return key
@@ -43,11 +194,38 @@ func (s *Set) Pop() string {
 	return ""
 }
 
+// scan calls fn with each member until fn returns false.
+func (s *Set) scan(fn func(key string) bool) {
+	switch s.enc {
+	case setIntset:
+		for i := 0; i < intset(s.data).Len(); i++ {
+			if !fn(strconv.FormatInt(intset(s.data).Get(i), 10)) {
+				return
+			}
+		}
+	case setListpack:
+		for off := 0; off < len(s.data); {
+			var val []byte
+			val, off = lpGet(s.data, off)
+			if !fn(string(val)) {
+				return
+			}
+		}
+	default:
+		for key := range s.table {
+			if !fn(key) {
+				return
+			}
+		}
+	}
+}
+
 func (s *Set) Members() []string {
 	res := make([]string, 0, s.Len())
-	for key := range s.table {
+	s.scan(func(key string) bool {
 		res = append(res, key)
-	}
+		return true
+	})
 	return res
 }
 
@@ -61,59 +239,72 @@ func (s *Set) Move(set *Set, key string) int {
 }
 
 func (s *Set) Clear() {
-	s.table = make(map[string]void)
+	*s = Set{}
+}
+
+// Clone returns a deep copy of s, in the same encoding.
+func (s *Set) Clone() *Set {
+	res := &Set{count: s.count, enc: s.enc}
+	if s.data != nil {
+		res.data = bytes.Clone(s.data)
+	}
+	if s.table != nil {
+		res.table = make(map[string]void, len(s.table))
+		for member := range s.table {
+			res.table[member] = void{}
+		}
+	}
+	return res
 }
 
 // set operations
 
 func (s *Set) Union(sets ...*Set) *Set {
-	res := &Set{table: make(map[string]void)}
-	for key := range s.table {
-		res.Add(key)
-	}
-	for _, set := range sets {
-		for key := range set.table {
+	res := NewSet()
+	for _, set := range append([]*Set{s}, sets...) {
+		set.scan(func(key string) bool {
 			res.Add(key)
-		}
+			return true
+		})
 	}
 	return res
 }
 
 func (s *Set) Intersect(sets ...*Set) *Set {
-	res := &Set{table: make(map[string]void)}
-	for key := range s.table {
-		res.Add(key)
-	}
-	for _, set := range sets {
-		for key := range res.table {
+	res := NewSet()
+	s.scan(func(key string) bool {
+		for _, set := range sets {
 			if !set.Has(key) {
-				res.Remove(key)
+				return true
 			}
 		}
-	}
+		res.Add(key)
+		return true
+	})
 	return res
 }
 
 func (s *Set) Difference(sets ...*Set) *Set {
-	res := &Set{table: make(map[string]void)}
-	for key := range s.table {
-		res.Add(key)
-	}
-	for _, set := range sets {
-		for key := range set.table {
-			res.Remove(key)
+	res := NewSet()
+	s.scan(func(key string) bool {
+		for _, set := range sets {
+			if set.Has(key) {
+				return true
+			}
 		}
-	}
+		res.Add(key)
+		return true
+	})
 	return res
 }
 
 func (s *Set) isSubset(set *Set) bool {
-	for key := range set.table {
-		if !s.Has(key) {
-			return false
-		}
-	}
-	return true
+	subset := true
+	set.scan(func(key string) bool {
+		subset = s.Has(key)
+		return subset
+	})
+	return subset
 }
 
 func (s *Set) Random(count int) []string {
@@ -123,6 +314,26 @@ func (s *Set) Random(count int) []string {
 		return make([]string, 0)
 	}
 
+	if s.enc != setHashtable {
+		if count > 0 {
+			// If the provided count argument is positive, return an array of distinct fields.
+			if count > s.Len() {
+				count = s.Len()
+			}
+			res = make([]string, 0, count)
+			for _, i := range rand.Perm(s.Len())[:count] {
+				res = append(res, s.member(i))
+			}
+		} else {
+			// If called with a negative count, the behavior changes and the command is allowed to return the same field multiple times.
+			res = make([]string, 0, -count)
+			for i := 0; i < -count; i++ {
+				res = append(res, s.member(rand.Intn(s.Len())))
+			}
+		}
+		return res
+	}
+
 	if count > 0 {
 		if count > s.Len() {
 			count = s.Len()
@@ -1,6 +1,11 @@
 package memdb
 
-import "testing"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+)
 
 func TestDifference1(t *testing.T) {
 	s1 := NewSet()
@@ -43,3 +48,78 @@ func TestDifference2(t *testing.T) {
 		t.Error(res.Members())
 	}
 }
+
+func TestIntset(t *testing.T) {
+	var is intset
+	// widen from 2 to 4 to 8 bytes, adding at both ends
+	vals := []int64{5, -3, 100, 1 << 20, -1 << 40, 7, 5}
+	for i, v := range vals {
+		var added bool
+		is, added = is.Add(v)
+		if added != (i != len(vals)-1) {
+			t.Error(fmt.Sprintf("Add(%d) == %v", v, added))
+		}
+	}
+
+	expect := []int64{-1 << 40, -3, 5, 7, 100, 1 << 20}
+	if is.Len() != len(expect) || is.width() != 8 {
+		t.Fatal(fmt.Sprintf("intset holds %d elements of %d bytes, expect %d of 8", is.Len(), is.width(), len(expect)))
+	}
+	for i, v := range expect {
+		if is.Get(i) != v {
+			t.Error(fmt.Sprintf("Get(%d) == %d, expect %d", i, is.Get(i), v))
+		}
+	}
+
+	is, _ = is.Remove(7)
+	if _, ok := is.Remove(7); ok || is.Has(7) || !is.Has(100) || is.Len() != len(expect)-1 {
+		t.Error("Remove(7) failed")
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	s := NewSet()
+	for i := 0; i < 512; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != encodingIntset || s.Len() != 512 {
+		t.Error(fmt.Sprintf("set of 512 integers is %s", s.Encoding()))
+	}
+	// past set-max-intset-entries
+	s.Add("512")
+	if s.Encoding() != encodingHashtable || s.Len() != 513 || !s.Has("0") || !s.Has("512") {
+		t.Error(fmt.Sprintf("set of 513 integers is %s", s.Encoding()))
+	}
+
+	s = NewSet()
+	s.Add("1")
+	s.Add("01") // not the canonical form of an integer
+	s.Add("a")
+	if s.Encoding() != encodingListpack || s.Len() != 3 || !s.Has("01") || s.Has("0") {
+		t.Error(fmt.Sprintf("small set is %s", s.Encoding()))
+	}
+	members := s.Members()
+	sort.Strings(members)
+	if fmt.Sprint(members) != "[01 1 a]" {
+		t.Error(fmt.Sprintf("members == %v", members))
+	}
+	if s.Remove("1") != 1 || s.Remove("1") != 0 || s.Len() != 2 {
+		t.Error("Remove from a listpack set failed")
+	}
+	s.Add(string(make([]byte, 65)))
+	if s.Encoding() != encodingHashtable || s.Len() != 3 || !s.Has("a") {
+		t.Error(fmt.Sprintf("set with a long member is %s", s.Encoding()))
+	}
+
+	s = NewSet()
+	for i := 0; i < 10; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	seen := make(map[string]bool)
+	for s.Len() > 0 {
+		seen[s.Pop()] = true
+	}
+	if len(seen) != 10 {
+		t.Error(fmt.Sprintf("Pop returns %d distinct members, expect 10", len(seen)))
+	}
+}
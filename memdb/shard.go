@@ -0,0 +1,136 @@
+package memdb
+
+import (
+	"gRedis/resp"
+	"sort"
+	"sync"
+)
+
+// ShardSet is the lock-free alternative to key locks: every keyspace sharing the set is split into shards,
+// each owned by one goroutine that runs the commands on its keys, one at a time and without locks.
+// A key belongs to the shard owning its LocksManager stripe.
+//
+// A command whose keys span several shards, or that works on the whole keyspace like KEYS,
+// pauses the shards involved, in order so two such commands can't deadlock,
+// and runs on the caller's goroutine while they wait.
+type ShardSet struct {
+	shards  []*shard
+	replies sync.Pool // chan resp.RedisData, one per command in flight
+}
+
+type shard struct {
+	tasks chan shardTask
+}
+
+// shardTask runs an executor, or pauses the shard when pause isn't nil.
+type shardTask struct {
+	executor cmdExecutor
+	db       *MemDb
+	cmd      [][]byte
+	reply    chan resp.RedisData
+	pause    *pause
+}
+
+type pause struct {
+	paused  chan struct{} // receives a value from each shard as it pauses
+	release chan struct{} // closed to resume the shards
+}
+
+// shardQueueSize bounds the commands waiting for a shard, from different connections.
+const shardQueueSize = 1024
+
+// NewShardSet starts n shard goroutines.
+func NewShardSet(n int) *ShardSet {
+	if n <= 0 {
+		n = 1
+	}
+	s := &ShardSet{shards: make([]*shard, n)}
+	s.replies.New = func() any { return make(chan resp.RedisData, 1) }
+	for i := range s.shards {
+		s.shards[i] = &shard{tasks: make(chan shardTask, shardQueueSize)}
+		go s.shards[i].run()
+	}
+	return s
+}
+
+func (s *ShardSet) Size() int {
+	return len(s.shards)
+}
+
+// owner returns the shard owning LocksManager stripe pos.
+func (s *ShardSet) owner(pos int) int {
+	return pos % len(s.shards)
+}
+
+func (sh *shard) run() {
+	for t := range sh.tasks {
+		if t.pause != nil {
+			t.pause.paused <- struct{}{}
+			<-t.pause.release
+			continue
+		}
+		t.reply <- t.executor(t.db, t.cmd)
+	}
+}
+
+// pause stops the given shards, sorted and distinct, once they finish their queued commands.
+// They stay paused until release is called, so the caller owns their keys meanwhile.
+func (s *ShardSet) pause(shards []int) (release func()) {
+	p := &pause{paused: make(chan struct{}, len(shards)), release: make(chan struct{})}
+	for _, i := range shards {
+		s.shards[i].tasks <- shardTask{pause: p}
+		// wait before pausing the next shard: they are paused in order, like sorted locks
+		<-p.paused
+	}
+	return func() { close(p.release) }
+}
+
+// Exec runs a command of db on the shards owning its keys. cmd is only used until Exec returns.
+func (s *ShardSet) Exec(db *MemDb, c *command, cmd [][]byte) resp.RedisData {
+	if c.firstKey == noKeys {
+		return c.Executor(db, cmd)
+	}
+	if c.firstKey == allKeys {
+		all := make([]int, len(s.shards))
+		for i := range all {
+			all[i] = i
+		}
+		defer s.pause(all)()
+		return c.Executor(db, cmd)
+	}
+
+	first, last, step := c.keyRange(cmd)
+	if first > last {
+		// the command lacks its keys, the executor replies with an error
+		return c.Executor(db, cmd)
+	}
+
+	// the common case: all keys on one shard
+	owner := s.owner(db.locks.GetKeyPos(string(cmd[first])))
+	single := true
+	for i := first + step; i <= last && single; i += step {
+		single = s.owner(db.locks.GetKeyPos(string(cmd[i]))) == owner
+	}
+	if single {
+		reply := s.replies.Get().(chan resp.RedisData)
+		s.shards[owner].tasks <- shardTask{executor: c.Executor, db: db, cmd: cmd, reply: reply}
+		res := <-reply
+		s.replies.Put(reply)
+		return res
+	}
+
+	shards := make([]int, 0, (last-first)/step+1)
+	for i := first; i <= last; i += step {
+		shards = append(shards, s.owner(db.locks.GetKeyPos(string(cmd[i]))))
+	}
+	sort.Ints(shards)
+	n := 0
+	for i := range shards {
+		if i == 0 || shards[i] != shards[n-1] {
+			shards[n] = shards[i]
+			n++
+		}
+	}
+	defer s.pause(shards[:n])()
+	return c.Executor(db, cmd)
+}
@@ -0,0 +1,87 @@
+package memdb
+
+import (
+	"gRedis/resp"
+	"strconv"
+	"testing"
+)
+
+// TestShardSet runs single and multi-shard commands concurrently, while the tables resize. Run it with -race.
+func TestShardSet(t *testing.T) {
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+
+	shards := NewShardSet(4)
+	db := NewShardedMemDb(shards)
+	exec := func(args ...string) resp.RedisData {
+		return shards.Exec(db, CmdTable[args[0]], makeCmd(args...))
+	}
+
+	counters := []string{"c0", "c1", "c2", "c3"}
+	for i, key := range counters {
+		exec("set", key, "0")
+		exec("rpush", "l"+strconv.Itoa(i), "a", "b", "c")
+	}
+
+	workers, n := 8, 1000
+	runWorkers(t, workers, func(w int) {
+		for i := 0; i < n; i++ {
+			exec("incr", counters[i%len(counters)])
+
+			a, b := strconv.Itoa((w+i)%4), strconv.Itoa((w+i+1)%4)
+			if w%2 == 1 {
+				a, b = b, a
+			}
+			exec("lmove", "l"+a, "l"+b, "left", "right")
+			exec("mset", "m"+a, "1", "m"+b, "2")
+			exec("rename", "m"+a, "m"+b)
+
+			// enough keys to grow the tables, and a whole keyspace command now and then
+			key := "k" + strconv.Itoa(w) + ":" + strconv.Itoa(i)
+			exec("sadd", key, "x")
+			exec("sunionstore", key+":u", key, "s"+a)
+			if i%100 == 0 {
+				exec("keys", "m*")
+			}
+		}
+	})
+
+	total := 0
+	for _, key := range counters {
+		v, err := strconv.Atoi(string(exec("get", key).GetBytesData()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += v
+	}
+	if total != workers*n {
+		t.Errorf("counters sum to %d, expect %d", total, workers*n)
+	}
+
+	total = 0
+	for i := 0; i < 4; i++ {
+		total += int(exec("llen", "l"+strconv.Itoa(i)).(*resp.Integer).GetData())
+	}
+	if total != 12 {
+		t.Errorf("lists hold %d elements, expect 12", total)
+	}
+
+	if keys := exec("keys", "k*").(*resp.RedisArray).GetData(); len(keys) != 2*workers*n {
+		t.Errorf("KEYS returns %d keys, expect %d", len(keys), 2*workers*n)
+	}
+}
+
+// TestShardSetExecNoAlloc checks routing a command to its shard allocates nothing beyond the executor.
+func TestShardSetExecNoAlloc(t *testing.T) {
+	shards := NewShardSet(2)
+	db := NewShardedMemDb(shards)
+	c := &command{Executor: pingKeys, firstKey: 1, lastKey: 1, keyStep: 1}
+	cmd := makeCmd("ping", "key")
+	shards.Exec(db, c, cmd)
+	direct := testing.AllocsPerRun(100, func() { c.Executor(db, cmd) })
+	if n := testing.AllocsPerRun(100, func() { shards.Exec(db, c, cmd) }); n != direct {
+		t.Errorf("Exec allocates %v times, the executor alone %v", n, direct)
+	}
+}
@@ -0,0 +1,1472 @@
+package memdb
+
+import (
+	"bytes"
+	"gRedis/resp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Streams are append-only logs of entries, read by ID ranges, or by consumer groups sharing them
+// between consumers and tracking what they didn't acknowledge (see stream_struct.go).
+// XREAD and XREADGROUP BLOCK wait through a BlockedReply (see blocking.go), woken by XADD.
+
+const errInvalidStreamID = "Invalid stream ID specified as stream command argument"
+
+// lookupStream returns the stream at key, nil if there is none, or a WRONGTYPE error.
+func (db *MemDb) lookupStream(key string, write bool) (*Stream, resp.RedisData) {
+	var v any
+	var ok bool
+	if write {
+		v, ok = db.lookupKeyWrite(key)
+	} else {
+		v, ok = db.lookupKeyRead(key)
+	}
+	if !ok {
+		return nil, nil
+	}
+	s, ok := v.(*Stream)
+	if !ok {
+		return nil, resp.NewWrongTypeError()
+	}
+	return s, nil
+}
+
+func parseStrictStreamID(arg []byte) (streamID, resp.RedisData) {
+	id, ok := parseStreamID(arg, true, 0)
+	if !ok {
+		return id, resp.NewErrorReply(resp.ErrPrefix, errInvalidStreamID)
+	}
+	return id, nil
+}
+
+// parseStreamInterval parses the start (end false) or the end of an ID interval, which can be exclusive with a ( prefix.
+func parseStreamInterval(arg []byte, end bool) (streamID, resp.RedisData) {
+	missingSeq := uint64(0)
+	if end {
+		missingSeq = streamMaxID.seq
+	}
+	if len(arg) > 1 && arg[0] == '(' {
+		id, ok := parseStreamID(arg[1:], true, missingSeq)
+		if !ok {
+			return id, resp.NewErrorReply(resp.ErrPrefix, errInvalidStreamID)
+		}
+		if end {
+			if id, ok = id.prev(); !ok {
+				return id, resp.NewErrorReply(resp.ErrPrefix, "invalid end ID for the interval")
+			}
+		} else if id, ok = id.next(); !ok {
+			return id, resp.NewErrorReply(resp.ErrPrefix, "invalid start ID for the interval")
+		}
+		return id, nil
+	}
+	id, ok := parseStreamID(arg, false, missingSeq)
+	if !ok {
+		return id, resp.NewErrorReply(resp.ErrPrefix, errInvalidStreamID)
+	}
+	return id, nil
+}
+
+func streamIDReply(id streamID) resp.RedisData {
+	return resp.NewBulkString([]byte(id.String()))
+}
+
+// streamEntryReply replies an entry as its ID and its fields, nil fields if it was deleted.
+func streamEntryReply(id streamID, fields [][]byte) resp.RedisData {
+	if fields == nil {
+		return resp.NewArray([]resp.RedisData{streamIDReply(id), resp.NewArray(nil)})
+	}
+	res := make([]resp.RedisData, len(fields))
+	for i, f := range fields {
+		res[i] = resp.NewBulkString(f)
+	}
+	return resp.NewArray([]resp.RedisData{streamIDReply(id), resp.NewArray(res)})
+}
+
+// streamRange replies the entries from start to end, in reverse order if rev, at most count if positive.
+func (s *Stream) streamRange(start, end streamID, rev bool, count int) []resp.RedisData {
+	res := make([]resp.RedisData, 0)
+	if start.cmp(end) > 0 {
+		return res
+	}
+	fn := func(it *idItem[[][]byte]) bool {
+		if count > 0 && len(res) >= count || !rev && it.id.cmp(end) > 0 || rev && it.id.cmp(start) < 0 {
+			return false
+		}
+		res = append(res, streamEntryReply(it.id, it.val))
+		return true
+	}
+	if rev {
+		s.entries.Descend(end, fn)
+	} else {
+		s.entries.Ascend(start, fn)
+	}
+	return res
+}
+
+// streamTrimArgs are the trimming options of XADD and XTRIM.
+type streamTrimArgs struct {
+	trim   bool
+	maxLen int64
+	minID  *streamID // trim by MINID rather than MAXLEN
+	approx bool
+	limit  int64
+}
+
+// parseStreamTrim parses the options of XADD, or of XTRIM if id is nil, from cmd[2].
+// For XADD it returns the position of the entry ID, and sets id, seqGiven and noMkStream.
+func parseStreamTrim(cmd [][]byte, id *streamID, seqGiven, noMkStream *bool) (args streamTrimArgs, i int, errReply resp.RedisData) {
+	xadd := id != nil
+	limitGiven := false
+options:
+	for i = 2; i < len(cmd); i++ {
+		moreArgs := len(cmd) - 1 - i
+		opt := strings.ToLower(string(cmd[i]))
+		switch {
+		case xadd && opt == "*":
+			break options
+		case (opt == "maxlen" || opt == "minid") && moreArgs > 0:
+			if args.trim {
+				return args, i, resp.NewErrorReply(resp.ErrPrefix, "syntax error, MAXLEN and MINID options at the same time are not compatible")
+			}
+			args.trim, args.approx = true, false
+			if next := string(cmd[i+1]); moreArgs >= 2 && (next == "~" || next == "=") {
+				args.approx = next == "~"
+				i++
+			}
+			i++
+			if opt == "maxlen" {
+				n, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+				if err != nil {
+					return args, i, resp.NewNotIntegerError()
+				}
+				if n < 0 {
+					return args, i, resp.NewErrorReply(resp.ErrPrefix, "The MAXLEN argument must be >= 0.")
+				}
+				args.maxLen = n
+			} else {
+				minID, errReply := parseStrictStreamID(cmd[i])
+				if errReply != nil {
+					return args, i, errReply
+				}
+				args.minID = &minID
+			}
+		case opt == "limit" && moreArgs > 0:
+			n, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return args, i, resp.NewNotIntegerError()
+			}
+			if n < 0 {
+				return args, i, resp.NewErrorReply(resp.ErrPrefix, "The LIMIT argument must be >= 0.")
+			}
+			args.limit, limitGiven = n, true
+			i++
+		case xadd && opt == "nomkstream":
+			*noMkStream = true
+		case xadd:
+			// the entry ID, ms-seq or ms-* for a sequence generated within ms
+			arg := cmd[i]
+			*seqGiven = true
+			if bytes.HasSuffix(arg, []byte("-*")) {
+				arg, *seqGiven = arg[:len(arg)-2], false
+			}
+			parsed, errReply := parseStrictStreamID(arg)
+			if errReply != nil {
+				return args, i, errReply
+			}
+			*id = parsed
+			break options
+		default:
+			return args, i, resp.NewSyntaxError()
+		}
+	}
+
+	if args.limit > 0 && !args.trim {
+		return args, i, resp.NewErrorReply(resp.ErrPrefix, "syntax error, LIMIT cannot be used without specifying a trimming strategy")
+	}
+	if !xadd && !args.trim {
+		return args, i, resp.NewErrorReply(resp.ErrPrefix, "syntax error, XTRIM must be called with a trimming strategy")
+	}
+	if limitGiven && !args.approx {
+		return args, i, resp.NewErrorReply(resp.ErrPrefix, "syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if !limitGiven && args.approx {
+		// as redis, bound the work of an approximate trim: 100 times stream-node-max-entries
+		args.limit = 100 * streamChunkSize
+	}
+	return args, i, nil
+}
+
+func (s *Stream) trimBy(args streamTrimArgs) int64 {
+	return s.trim(args.maxLen, args.minID, args.approx, args.limit)
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func xAddStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	var id streamID
+	idGiven, seqGiven, noMkStream := false, false, false
+	args, i, errReply := parseStreamTrim(cmd, &id, &seqGiven, &noMkStream)
+	if errReply != nil {
+		return errReply
+	}
+	if i >= len(cmd) || (len(cmd)-i-1) < 2 || (len(cmd)-i-1)%2 != 0 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	idGiven = string(cmd[i]) != "*"
+	fields := cmd[i+1:]
+	if idGiven && seqGiven && id.isZero() {
+		return resp.NewErrorReply(resp.ErrPrefix, "The ID specified in XADD must be greater than 0-0")
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, errReply := db.lookupStream(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	created := false
+	if s == nil {
+		if noMkStream {
+			return resp.NewBulkString(nil)
+		}
+		s, created = NewStream(), true
+	}
+	if s.lastID == streamMaxID {
+		return resp.NewErrorReply(resp.ErrPrefix, "The stream has exhausted the last possible ID, unable to add more items")
+	}
+
+	switch {
+	case !idGiven:
+		if ms := uint64(time.Now().UnixMilli()); ms > s.lastID.ms {
+			id = streamID{ms, 0}
+		} else {
+			id, _ = s.lastID.next()
+		}
+	case !seqGiven && id.ms == s.lastID.ms:
+		if s.lastID.seq == streamMaxID.seq {
+			return resp.NewErrorReply(resp.ErrPrefix, "The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+		id.seq = s.lastID.seq + 1
+	}
+	if id.cmp(s.lastID) <= 0 {
+		return resp.NewErrorReply(resp.ErrPrefix, "The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+
+	entry := make([][]byte, len(fields))
+	for j, f := range fields {
+		entry[j] = bytes.Clone(f)
+	}
+	s.add(id, entry)
+	if created {
+		db.setKey(key, s)
+	}
+	if args.trim {
+		s.trimBy(args)
+	}
+	db.signalKeyReady(key)
+	return streamIDReply(id)
+}
+
+// XRANGE key start end [COUNT count], and XREVRANGE key end start [COUNT count] if rev
+func xRange(db *MemDb, cmd [][]byte, rev bool) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	startArg, endArg := cmd[2], cmd[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseStreamInterval(startArg, false)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseStreamInterval(endArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	count := int64(-1)
+	for i := 4; i < len(cmd); i++ {
+		if strings.ToLower(string(cmd[i])) != "count" || i+1 >= len(cmd) {
+			return resp.NewSyntaxError()
+		}
+		i++
+		n, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+		if err != nil {
+			return resp.NewNotIntegerError()
+		}
+		if count = n; count < 0 {
+			count = 0
+		}
+	}
+
+	key := string(cmd[1])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	s, errReply := db.lookupStream(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.NewArray([]resp.RedisData{})
+	}
+	if count == 0 {
+		return resp.NewArray(nil)
+	}
+	if count < 0 {
+		count = 0
+	}
+	return resp.NewArray(s.streamRange(start, end, rev, int(count)))
+}
+
+func xRangeStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	return xRange(db, cmd, false)
+}
+
+func xRevRangeStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	return xRange(db, cmd, true)
+}
+
+func xLenStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	s, errReply := db.lookupStream(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(int64(s.Len()))
+}
+
+// XDEL key id [id ...]
+func xDelStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	// parse every ID first, not to delete some and fail
+	ids := make([]streamID, len(cmd)-2)
+	for i, arg := range cmd[2:] {
+		id, errReply := parseStrictStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, errReply := db.lookupStream(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.NewInteger(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.remove(id) {
+			deleted++
+		}
+	}
+	// unlike other types, streams stay when emptied: their groups and last ID remain
+	return resp.NewInteger(int64(deleted))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func xTrimStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	args, _, errReply := parseStreamTrim(cmd, nil, nil, nil)
+	if errReply != nil {
+		return errReply
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, errReply := db.lookupStream(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(s.trimBy(args))
+}
+
+// deliver replies the entries after start to consumer c of group g, at most count if positive,
+// moving the group's last ID past them and adding them to the pending lists unless noack, as XREADGROUP > does.
+func (s *Stream) deliver(g *streamGroup, c *streamConsumer, start streamID, count int, noack bool, now int64) []resp.RedisData {
+	res := make([]resp.RedisData, 0)
+	s.entries.Ascend(start, func(it *idItem[[][]byte]) bool {
+		if count > 0 && len(res) >= count {
+			return false
+		}
+		if it.id.cmp(g.lastID) > 0 {
+			if g.entriesRead != streamInvalidEntriesRead && !s.hasTombstones(it.id) {
+				g.entriesRead++
+			} else if s.entriesAdded > 0 {
+				g.entriesRead = s.entriesUpTo(it.id)
+			}
+			g.lastID = it.id
+		}
+		res = append(res, streamEntryReply(it.id, it.val))
+		if !noack {
+			nack, ok := g.pel.Get(it.id)
+			if !ok {
+				// XGROUP SETID may have moved the group back over entries pending for another consumer
+				nack = &streamNack{}
+				g.pel.Insert(it.id, nack)
+			}
+			g.claim(it.id, nack, c)
+			nack.deliveryTime, nack.deliveryCount = now, 1
+			c.activeTime = now
+		}
+		return true
+	})
+	return res
+}
+
+// history replies the entries after start pending for consumer c, at most count if positive,
+// counting a new delivery of each; entries deleted since are replied with nil fields.
+func (s *Stream) history(c *streamConsumer, start streamID, count int, now int64) []resp.RedisData {
+	res := make([]resp.RedisData, 0)
+	c.pel.Ascend(start, func(it *idItem[*streamNack]) bool {
+		if count > 0 && len(res) >= count {
+			return false
+		}
+		fields, ok := s.entries.Get(it.id)
+		res = append(res, streamEntryReply(it.id, fields))
+		if ok {
+			it.val.deliveryTime = now
+			it.val.deliveryCount++
+		}
+		return true
+	})
+	return res
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...], and
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...] if group
+func xRead(db *MemDb, cmd [][]byte, group bool) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	count := 0
+	timeout := time.Duration(-1)
+	noack, groupGiven := false, false
+	var groupName, consumerName string
+	streamsArg := 0
+	for i := 1; i < len(cmd) && streamsArg == 0; i++ {
+		moreArgs := len(cmd) - 1 - i
+		switch opt := strings.ToLower(string(cmd[i])); {
+		case opt == "block" && moreArgs > 0:
+			i++
+			ms, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil {
+				return resp.NewErrorReply(resp.ErrPrefix, "timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return resp.NewErrorReply(resp.ErrPrefix, "timeout is negative")
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+		case opt == "count" && moreArgs > 0:
+			i++
+			n, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if n > 0 {
+				count = int(n)
+			}
+		case opt == "streams" && moreArgs > 0:
+			streamsArg = i + 1
+			if (len(cmd)-streamsArg)%2 != 0 {
+				symbol := "$"
+				if group {
+					symbol = ">"
+				}
+				return resp.NewErrorReplyf(resp.ErrPrefix, "Unbalanced '%s' list of streams: for each stream key an ID or '%s' must be specified.",
+					strings.ToLower(string(cmd[0])), symbol)
+			}
+		case opt == "group" && moreArgs >= 2:
+			if !group {
+				return resp.NewErrorReply(resp.ErrPrefix, "The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			groupName, consumerName, groupGiven = string(cmd[i+1]), string(cmd[i+2]), true
+			i += 2
+		case opt == "noack":
+			if !group {
+				return resp.NewErrorReply(resp.ErrPrefix, "The NOACK option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			noack = true
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+	if streamsArg == 0 {
+		return resp.NewSyntaxError()
+	}
+	if group && !groupGiven {
+		return resp.NewErrorReply(resp.ErrPrefix, "Missing GROUP option for XREADGROUP")
+	}
+	n := (len(cmd) - streamsArg) / 2
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = string(cmd[streamsArg+i])
+	}
+
+	// reading in a group delivers entries, a write
+	if group {
+		db.locks.MLock(keys)
+		defer db.locks.MUnLock(keys)
+	} else {
+		db.locks.MRLock(keys)
+		defer db.locks.MRUnLock(keys)
+	}
+
+	streams := make([]*Stream, n)
+	groups := make([]*streamGroup, n)
+	ids := make([]streamID, n)
+	for i, key := range keys {
+		s, errReply := db.lookupStream(key, group)
+		if errReply != nil {
+			return errReply
+		}
+		streams[i] = s
+		if group {
+			if s != nil {
+				groups[i] = s.groups[groupName]
+			}
+			if groups[i] == nil {
+				return resp.NewErrorReplyf(resp.NoGroupPrefix, "No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, groupName)
+			}
+		}
+
+		switch arg := string(cmd[streamsArg+n+i]); arg {
+		case "$":
+			if group {
+				return resp.NewErrorReply(resp.ErrPrefix, "The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+			}
+			if s != nil {
+				ids[i] = s.lastID
+			}
+		case ">":
+			if !group {
+				return resp.NewErrorReply(resp.ErrPrefix, "The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+			}
+			ids[i] = streamMaxID
+		default:
+			id, errReply := parseStrictStreamID(cmd[streamsArg+n+i])
+			if errReply != nil {
+				return errReply
+			}
+			ids[i] = id
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	res := make([]resp.RedisData, 0)
+	for i, s := range streams {
+		if s == nil {
+			continue
+		}
+		after := ids[i]
+		serve, history := false, false
+		var consumer *streamConsumer
+		if group {
+			last, ok := s.entries.Last()
+			if after != streamMaxID {
+				serve, history = true, true
+			} else if ok && last.id.cmp(groups[i].lastID) > 0 {
+				serve, after = true, groups[i].lastID
+			}
+			consumer = groups[i].consumer(consumerName, true, now)
+			consumer.seenTime = now
+		} else if last, ok := s.entries.Last(); ok && last.id.cmp(after) > 0 {
+			serve = true
+		}
+		if !serve {
+			continue
+		}
+
+		start, _ := after.next()
+		var entries []resp.RedisData
+		switch {
+		case history:
+			entries = s.history(consumer, start, count, now)
+		case group:
+			entries = s.deliver(groups[i], consumer, start, count, noack, now)
+		default:
+			entries = s.streamRange(start, streamMaxID, false, count)
+		}
+		res = append(res, resp.NewArray([]resp.RedisData{resp.NewBulkString(cmd[streamsArg+i]), resp.NewArray(entries)}))
+	}
+	if len(res) > 0 {
+		return resp.NewArray(res)
+	}
+
+	if timeout < 0 {
+		return resp.NewArray(nil)
+	}
+	// wait for XADD, then read again after the same IDs: $ stands for the last ID now, not then
+	retry := make([][]byte, len(cmd))
+	for i, arg := range cmd {
+		if i >= streamsArg+n && string(arg) == "$" {
+			retry[i] = []byte(ids[i-streamsArg-n].String())
+		} else {
+			retry[i] = bytes.Clone(arg)
+		}
+	}
+	return db.block(keys, timeout, retry, resp.NewArray(nil))
+}
+
+func xReadStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	return xRead(db, cmd, false)
+}
+
+func xReadGroupStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	return xRead(db, cmd, true)
+}
+
+// lookupStreamGroup returns the stream at key and its group named name, or a NOGROUP error if either is missing.
+func (db *MemDb) lookupStreamGroup(key, name string, write bool) (*Stream, *streamGroup, resp.RedisData) {
+	s, errReply := db.lookupStream(key, write)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	var g *streamGroup
+	if s != nil {
+		g = s.groups[name]
+	}
+	if g == nil {
+		return nil, nil, resp.NewErrorReplyf(resp.NoGroupPrefix, "No such key '%s' or consumer group '%s'", key, name)
+	}
+	return s, g, nil
+}
+
+// XACK key group id [id ...]
+func xAckStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, errReply := db.lookupStream(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	var g *streamGroup
+	if s != nil {
+		g = s.groups[string(cmd[2])]
+	}
+	if g == nil {
+		return resp.NewInteger(0)
+	}
+	ids := make([]streamID, len(cmd)-3)
+	for i, arg := range cmd[3:] {
+		id, errReply := parseStrictStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	return resp.NewInteger(int64(acked))
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xPendingStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	if len(cmd) != 3 && (len(cmd) < 6 || len(cmd) > 9) {
+		return resp.NewSyntaxError()
+	}
+
+	var minIdle, count int64
+	var start, end streamID
+	var consumerName []byte
+	if len(cmd) >= 6 {
+		i := 3
+		if strings.ToLower(string(cmd[3])) == "idle" {
+			n, err := strconv.ParseInt(string(cmd[4]), 10, 64)
+			if err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if len(cmd) < 8 {
+				return resp.NewSyntaxError()
+			}
+			minIdle = n
+			i += 2
+		}
+		n, err := strconv.ParseInt(string(cmd[i+2]), 10, 64)
+		if err != nil {
+			return resp.NewNotIntegerError()
+		}
+		if count = n; count < 0 {
+			count = 0
+		}
+		var errReply resp.RedisData
+		if start, errReply = parseStreamInterval(cmd[i], false); errReply != nil {
+			return errReply
+		}
+		if end, errReply = parseStreamInterval(cmd[i+1], true); errReply != nil {
+			return errReply
+		}
+		if i+3 < len(cmd) {
+			consumerName = cmd[i+3]
+		}
+	}
+
+	key := string(cmd[1])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	_, g, errReply := db.lookupStreamGroup(key, string(cmd[2]), false)
+	if errReply != nil {
+		return errReply
+	}
+
+	if len(cmd) == 3 {
+		first, ok := g.pel.First()
+		if !ok {
+			return resp.NewArray([]resp.RedisData{resp.NewInteger(0), resp.NewBulkString(nil), resp.NewBulkString(nil), resp.NewArray(nil)})
+		}
+		last, _ := g.pel.Last()
+		consumers := make([]resp.RedisData, 0)
+		for _, name := range g.consumerNames() {
+			if n := g.consumers[name].pel.Len(); n > 0 {
+				consumers = append(consumers, resp.NewArray([]resp.RedisData{
+					resp.NewBulkString([]byte(name)), resp.NewBulkString([]byte(strconv.Itoa(n))),
+				}))
+			}
+		}
+		return resp.NewArray([]resp.RedisData{
+			resp.NewInteger(int64(g.pel.Len())), streamIDReply(first.id), streamIDReply(last.id), resp.NewArray(consumers),
+		})
+	}
+
+	pel := &g.pel
+	if consumerName != nil {
+		c, ok := g.consumers[string(consumerName)]
+		if !ok {
+			return resp.NewArray([]resp.RedisData{})
+		}
+		pel = &c.pel
+	}
+	now := time.Now().UnixMilli()
+	res := make([]resp.RedisData, 0)
+	pel.Ascend(start, func(it *idItem[*streamNack]) bool {
+		if count == 0 || it.id.cmp(end) > 0 {
+			return false
+		}
+		idle := now - it.val.deliveryTime
+		if idle < 0 {
+			idle = 0
+		}
+		if minIdle > 0 && idle < minIdle {
+			return true
+		}
+		count--
+		res = append(res, resp.NewArray([]resp.RedisData{
+			streamIDReply(it.id), resp.NewBulkString([]byte(it.val.consumer.name)), resp.NewInteger(idle), resp.NewInteger(it.val.deliveryCount),
+		}))
+		return true
+	})
+	return resp.NewArray(res)
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func xClaimStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 6 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, g, errReply := db.lookupStreamGroup(key, string(cmd[2]), true)
+	if errReply != nil {
+		return errReply
+	}
+	minIdle, err := strconv.ParseInt(string(cmd[4]), 10, 64)
+	if err != nil {
+		return resp.NewErrorReply(resp.ErrPrefix, "Invalid min-idle-time argument for XCLAIM")
+	}
+
+	// the IDs run up to the first argument that isn't one, the options follow
+	var ids []streamID
+	j := 5
+	for ; j < len(cmd); j++ {
+		id, ok := parseStreamID(cmd[j], true, 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	now := time.Now().UnixMilli()
+	deliveryTime, retryCount := int64(-1), int64(-1)
+	force, justID := false, false
+	var lastID streamID
+	for ; j < len(cmd); j++ {
+		moreArgs := len(cmd) - 1 - j
+		opt := strings.ToLower(string(cmd[j]))
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case (opt == "idle" || opt == "time" || opt == "retrycount") && moreArgs > 0:
+			j++
+			n, err := strconv.ParseInt(string(cmd[j]), 10, 64)
+			if err != nil {
+				return resp.NewErrorReplyf(resp.ErrPrefix, "Invalid %s option argument for XCLAIM", strings.ToUpper(opt))
+			}
+			switch opt {
+			case "idle":
+				deliveryTime = now - n
+			case "time":
+				deliveryTime = n
+			default:
+				retryCount = n
+			}
+		case opt == "lastid" && moreArgs > 0:
+			j++
+			id, errReply := parseStrictStreamID(cmd[j])
+			if errReply != nil {
+				return errReply
+			}
+			lastID = id
+		default:
+			return resp.NewErrorReplyf(resp.ErrPrefix, "Unrecognized XCLAIM option '%s'", cmd[j])
+		}
+	}
+
+	if lastID.cmp(g.lastID) > 0 {
+		g.lastID = lastID
+	}
+	// a delivery time in the future or before the epoch is taken as now, as the client's clock may be off
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	var consumer *streamConsumer
+	res := make([]resp.RedisData, 0)
+	for _, id := range ids {
+		nack, pending := g.pel.Get(id)
+		fields, exists := s.entries.Get(id)
+		if !exists {
+			// the entry was deleted, it can't be pending anymore
+			if pending {
+				g.ack(id)
+			}
+			continue
+		}
+		if force && !pending {
+			nack, pending = &streamNack{}, true
+			g.pel.Insert(id, nack)
+		}
+		if !pending {
+			continue
+		}
+		if nack.consumer != nil && minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+		if consumer == nil {
+			consumer = g.consumer(string(cmd[3]), true, now)
+		}
+		g.claim(id, nack, consumer)
+		nack.deliveryTime = deliveryTime
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		} else if !justID {
+			nack.deliveryCount++
+		}
+		consumer.seenTime, consumer.activeTime = now, now
+		if justID {
+			res = append(res, streamIDReply(id))
+		} else {
+			res = append(res, streamEntryReply(id, fields))
+		}
+	}
+	return resp.NewArray(res)
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func xAutoClaimStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 6 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	minIdle, err := strconv.ParseInt(string(cmd[4]), 10, 64)
+	if err != nil {
+		return resp.NewErrorReply(resp.ErrPrefix, "Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, errReply := parseStreamInterval(cmd[5], false)
+	if errReply != nil {
+		return errReply
+	}
+	count := int64(100)
+	justID := false
+	for j := 6; j < len(cmd); j++ {
+		switch opt := strings.ToLower(string(cmd[j])); {
+		case opt == "count" && j+1 < len(cmd):
+			j++
+			n, err := strconv.ParseInt(string(cmd[j]), 10, 64)
+			// as redis, bounded so that count times the attempts factor fits
+			if err != nil || n < 1 || n > (1<<63-1)/16 {
+				return resp.NewErrorReply(resp.ErrPrefix, "COUNT must be > 0")
+			}
+			count = n
+		case opt == "justid":
+			justID = true
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+
+	key := string(cmd[1])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, g, errReply := db.lookupStreamGroup(key, string(cmd[2]), true)
+	if errReply != nil {
+		return errReply
+	}
+
+	// scan at most 10 times count pending entries, so a long list of young ones can't stall the server
+	attempts := count * 10
+	now := time.Now().UnixMilli()
+	var consumer *streamConsumer
+	claimed, deleted := make([]resp.RedisData, 0), make([]resp.RedisData, 0)
+	var deletedIDs []streamID
+	next := streamID{}
+	g.pel.Ascend(start, func(it *idItem[*streamNack]) bool {
+		if attempts == 0 || count == 0 {
+			next = it.id
+			return false
+		}
+		attempts--
+		fields, exists := s.entries.Get(it.id)
+		if !exists {
+			// acknowledged after the scan, not to change the list under it
+			deletedIDs = append(deletedIDs, it.id)
+			deleted = append(deleted, streamIDReply(it.id))
+			count--
+			return true
+		}
+		if minIdle > 0 && now-it.val.deliveryTime < minIdle {
+			return true
+		}
+		if consumer == nil {
+			consumer = g.consumer(string(cmd[3]), true, now)
+		}
+		g.claim(it.id, it.val, consumer)
+		it.val.deliveryTime = now
+		if !justID {
+			it.val.deliveryCount++
+		}
+		consumer.seenTime, consumer.activeTime = now, now
+		if justID {
+			claimed = append(claimed, streamIDReply(it.id))
+		} else {
+			claimed = append(claimed, streamEntryReply(it.id, fields))
+		}
+		count--
+		return true
+	})
+	for _, id := range deletedIDs {
+		g.ack(id)
+	}
+	return resp.NewArray([]resp.RedisData{streamIDReply(next), resp.NewArray(claimed), resp.NewArray(deleted)})
+}
+
+// lookupXGroup returns the stream of an XGROUP subcommand, which must exist unless mkstream,
+// and its group, which must exist if mustExist.
+func (db *MemDb) lookupXGroup(cmd [][]byte, mkstream, mustExist bool) (*Stream, *streamGroup, resp.RedisData) {
+	s, errReply := db.lookupStream(string(cmd[2]), true)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil {
+		if mkstream {
+			return nil, nil, nil
+		}
+		return nil, nil, resp.NewErrorReply(resp.ErrPrefix, "The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	g := s.groups[string(cmd[3])]
+	if g == nil && mustExist {
+		return nil, nil, resp.NewErrorReplyf(resp.NoGroupPrefix, "No such consumer group '%s' for key name '%s'", cmd[3], cmd[2])
+	}
+	return s, g, nil
+}
+
+// parseXGroupOptions parses the options following the ID of XGROUP CREATE (create) or SETID.
+func parseXGroupOptions(cmd [][]byte, create bool) (mkstream bool, entriesRead int64, errReply resp.RedisData) {
+	entriesRead = streamInvalidEntriesRead
+	for i := 5; i < len(cmd); i++ {
+		switch opt := strings.ToLower(string(cmd[i])); {
+		case create && opt == "mkstream":
+			mkstream = true
+		case opt == "entriesread" && i+1 < len(cmd):
+			i++
+			n, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil {
+				return false, 0, resp.NewNotIntegerError()
+			}
+			if n < 0 && n != streamInvalidEntriesRead {
+				return false, 0, resp.NewErrorReply(resp.ErrPrefix, "value for ENTRIESREAD must be positive or -1")
+			}
+			entriesRead = n
+		default:
+			return false, 0, resp.NewErrorReplyf(resp.ErrPrefix, "unknown subcommand or wrong number of arguments for '%s'. Try XGROUP HELP.", cmd[1])
+		}
+	}
+	return
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+func xGroupCreate(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 5 || len(cmd) > 8 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	mkstream, entriesRead, errReply := parseXGroupOptions(cmd, true)
+	if errReply != nil {
+		return errReply
+	}
+
+	key := string(cmd[2])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, _, errReply := db.lookupXGroup(cmd, mkstream, false)
+	if errReply != nil {
+		return errReply
+	}
+	var id streamID
+	if string(cmd[4]) == "$" {
+		if s != nil {
+			id = s.lastID
+		}
+	} else if id, errReply = parseStrictStreamID(cmd[4]); errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s = NewStream()
+		db.setKey(key, s)
+	}
+	if s.createGroup(string(cmd[3]), id, entriesRead) == nil {
+		return resp.NewErrorReply(resp.BusyGroupPrefix, "Consumer Group name already exists")
+	}
+	return resp.NewSimpleString("OK")
+}
+
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+func xGroupSetID(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 5 && len(cmd) != 7 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	_, entriesRead, errReply := parseXGroupOptions(cmd, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	key := string(cmd[2])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, g, errReply := db.lookupXGroup(cmd, false, true)
+	if errReply != nil {
+		return errReply
+	}
+	id := s.lastID
+	if string(cmd[4]) != "$" {
+		parsed, ok := parseStreamID(cmd[4], false, 0)
+		if !ok {
+			return resp.NewErrorReply(resp.ErrPrefix, errInvalidStreamID)
+		}
+		id = parsed
+	}
+	g.lastID, g.entriesRead = id, entriesRead
+	return resp.NewSimpleString("OK")
+}
+
+// XGROUP DESTROY key group
+func xGroupDestroy(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[2])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	s, g, errReply := db.lookupXGroup(cmd, false, false)
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return resp.NewInteger(0)
+	}
+	delete(s.groups, string(cmd[3]))
+	return resp.NewInteger(1)
+}
+
+// XGROUP CREATECONSUMER key group consumer
+func xGroupCreateConsumer(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[2])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	_, g, errReply := db.lookupXGroup(cmd, false, true)
+	if errReply != nil {
+		return errReply
+	}
+	if g.consumer(string(cmd[4]), false, 0) != nil {
+		return resp.NewInteger(0)
+	}
+	g.consumer(string(cmd[4]), true, time.Now().UnixMilli())
+	return resp.NewInteger(1)
+}
+
+// XGROUP DELCONSUMER key group consumer
+func xGroupDelConsumer(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 5 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[2])
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	_, g, errReply := db.lookupXGroup(cmd, false, true)
+	if errReply != nil {
+		return errReply
+	}
+	c := g.consumer(string(cmd[4]), false, 0)
+	if c == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(int64(g.deleteConsumer(c)))
+}
+
+var xGroupHelp = []string{
+	"XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CREATE <key> <groupname> <id|$> [option]",
+	"    Create a new consumer group. Options are:",
+	"    * MKSTREAM",
+	"      Create the empty stream if it does not exist.",
+	"    * ENTRIESREAD entries_read",
+	"      Set the group's entries_read counter (internal use).",
+	"CREATECONSUMER <key> <groupname> <consumer>",
+	"    Create a new consumer in the specified group.",
+	"DELCONSUMER <key> <groupname> <consumer>",
+	"    Remove the specified consumer.",
+	"DESTROY <key> <groupname>",
+	"    Remove the specified group.",
+	"SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]",
+	"    Set the current group ID and entries_read counter.",
+	"HELP",
+	"    Print this help.",
+}
+
+var xInfoHelp = []string{
+	"XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CONSUMERS <key> <groupname>",
+	"    Show consumers of <groupname>.",
+	"GROUPS <key>",
+	"    Show the stream consumer groups.",
+	"STREAM <key> [FULL [COUNT <count>]",
+	"    Show information about the stream.",
+	"HELP",
+	"    Print this help.",
+}
+
+func helpReply(lines []string) resp.RedisData {
+	res := make([]resp.RedisData, 0, len(lines))
+	for _, line := range lines {
+		res = append(res, resp.NewSimpleString(line))
+	}
+	return resp.NewArray(res)
+}
+
+func xGroupHelpCmd(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	return helpReply(xGroupHelp)
+}
+
+func xInfoHelpCmd(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	return helpReply(xInfoHelp)
+}
+
+// lookupXInfo returns the stream of an XINFO subcommand, replying an error if there is none.
+func (db *MemDb) lookupXInfo(key string) (*Stream, resp.RedisData) {
+	s, errReply := db.lookupStream(key, false)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if s == nil {
+		return nil, resp.NewNoSuchKeyError()
+	}
+	return s, nil
+}
+
+// fieldsReply replies name, value pairs, as redis replies maps to RESP2 clients.
+func fieldsReply(pairs ...any) resp.RedisData {
+	res := make([]resp.RedisData, 0, len(pairs))
+	for i, p := range pairs {
+		if i%2 == 0 {
+			res = append(res, resp.NewBulkString([]byte(p.(string))))
+			continue
+		}
+		switch v := p.(type) {
+		case int64:
+			res = append(res, resp.NewInteger(v))
+		case int:
+			res = append(res, resp.NewInteger(int64(v)))
+		case streamID:
+			res = append(res, streamIDReply(v))
+		case string:
+			res = append(res, resp.NewBulkString([]byte(v)))
+		case resp.RedisData:
+			res = append(res, v)
+		default:
+			res = append(res, resp.NewBulkString(nil))
+		}
+	}
+	return resp.NewArray(res)
+}
+
+// entriesReadReply replies the entries read by a group, or nil when unknown.
+func entriesReadReply(n int64) resp.RedisData {
+	if n == streamInvalidEntriesRead {
+		return resp.NewBulkString(nil)
+	}
+	return resp.NewInteger(n)
+}
+
+func lagReply(s *Stream, g *streamGroup) resp.RedisData {
+	if lag, ok := s.lag(g); ok {
+		return resp.NewInteger(lag)
+	}
+	return resp.NewBulkString(nil)
+}
+
+// XINFO STREAM key [FULL [COUNT count]]
+func xInfoStream(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+	full := false
+	count := int64(10)
+	if len(cmd) > 3 {
+		if strings.ToLower(string(cmd[3])) != "full" {
+			return resp.NewSyntaxError()
+		}
+		full = true
+		if len(cmd) == 6 && strings.ToLower(string(cmd[4])) == "count" {
+			n, err := strconv.ParseInt(string(cmd[5]), 10, 64)
+			if err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if count = n; count < 0 {
+				count = 0
+			}
+		} else if len(cmd) != 4 {
+			return resp.NewSyntaxError()
+		}
+	}
+
+	key := string(cmd[2])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	s, errReply := db.lookupXInfo(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// a chunk stands for a radix tree node and its key
+	header := []any{
+		"length", s.Len(),
+		"radix-tree-keys", len(s.entries.chunks),
+		"radix-tree-nodes", len(s.entries.chunks),
+		"last-generated-id", s.lastID,
+		"max-deleted-entry-id", s.maxDeletedID,
+		"entries-added", int64(s.entriesAdded),
+		"recorded-first-entry-id", s.firstID,
+	}
+	if !full {
+		var first, last resp.RedisData = resp.NewBulkString(nil), resp.NewBulkString(nil)
+		if it, ok := s.entries.First(); ok {
+			first = streamEntryReply(it.id, it.val)
+		}
+		if it, ok := s.entries.Last(); ok {
+			last = streamEntryReply(it.id, it.val)
+		}
+		return fieldsReply(append(header, "groups", len(s.groups), "first-entry", first, "last-entry", last)...)
+	}
+
+	limit := int(count)
+	groups := make([]resp.RedisData, 0, len(s.groups))
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		pending := make([]resp.RedisData, 0)
+		g.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+			if limit > 0 && len(pending) >= limit {
+				return false
+			}
+			pending = append(pending, resp.NewArray([]resp.RedisData{
+				streamIDReply(it.id), resp.NewBulkString([]byte(it.val.consumer.name)),
+				resp.NewInteger(it.val.deliveryTime), resp.NewInteger(it.val.deliveryCount),
+			}))
+			return true
+		})
+		consumers := make([]resp.RedisData, 0, len(g.consumers))
+		for _, cname := range g.consumerNames() {
+			c := g.consumers[cname]
+			cpending := make([]resp.RedisData, 0)
+			c.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+				if limit > 0 && len(cpending) >= limit {
+					return false
+				}
+				cpending = append(cpending, resp.NewArray([]resp.RedisData{
+					streamIDReply(it.id), resp.NewInteger(it.val.deliveryTime), resp.NewInteger(it.val.deliveryCount),
+				}))
+				return true
+			})
+			consumers = append(consumers, fieldsReply(
+				"name", cname, "seen-time", c.seenTime, "active-time", c.activeTime,
+				"pel-count", c.pel.Len(), "pending", resp.NewArray(cpending)))
+		}
+		groups = append(groups, fieldsReply(
+			"name", name, "last-delivered-id", g.lastID, "entries-read", entriesReadReply(g.entriesRead),
+			"lag", lagReply(s, g), "pel-count", g.pel.Len(), "pending", resp.NewArray(pending),
+			"consumers", resp.NewArray(consumers)))
+	}
+	entries := s.streamRange(streamID{}, streamMaxID, false, limit)
+	return fieldsReply(append(header, "entries", resp.NewArray(entries), "groups", resp.NewArray(groups))...)
+}
+
+// XINFO GROUPS key
+func xInfoGroups(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[2])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	s, errReply := db.lookupXInfo(key)
+	if errReply != nil {
+		return errReply
+	}
+	res := make([]resp.RedisData, 0, len(s.groups))
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		res = append(res, fieldsReply(
+			"name", name, "consumers", len(g.consumers), "pending", g.pel.Len(),
+			"last-delivered-id", g.lastID, "entries-read", entriesReadReply(g.entriesRead), "lag", lagReply(s, g)))
+	}
+	return resp.NewArray(res)
+}
+
+// XINFO CONSUMERS key group
+func xInfoConsumers(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 4 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[2])
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	s, errReply := db.lookupXInfo(key)
+	if errReply != nil {
+		return errReply
+	}
+	g := s.groups[string(cmd[3])]
+	if g == nil {
+		return resp.NewErrorReplyf(resp.NoGroupPrefix, "No such consumer group '%s' for key name '%s'", cmd[3], cmd[2])
+	}
+	now := time.Now().UnixMilli()
+	res := make([]resp.RedisData, 0, len(g.consumers))
+	for _, name := range g.consumerNames() {
+		c := g.consumers[name]
+		inactive := int64(-1)
+		if c.activeTime != -1 {
+			inactive = now - c.activeTime
+		}
+		res = append(res, fieldsReply("name", name, "pending", c.pel.Len(), "idle", now-c.seenTime, "inactive", inactive))
+	}
+	return resp.NewArray(res)
+}
+
+func RegisterStreamCommands() {
+	RegisterCommand("xadd", xAddStream, 1, 1, 1)
+	RegisterCommand("xrange", xRangeStream, 1, 1, 1)
+	RegisterCommand("xrevrange", xRevRangeStream, 1, 1, 1)
+	RegisterCommand("xlen", xLenStream, 1, 1, 1)
+	RegisterCommand("xdel", xDelStream, 1, 1, 1)
+	RegisterCommand("xtrim", xTrimStream, 1, 1, 1)
+	// the keys follow STREAMS; as for MIGRATE, every argument is taken for a key
+	RegisterCommand("xread", xReadStream, 1, -1, 1)
+	RegisterCommand("xreadgroup", xReadGroupStream, 1, -1, 1)
+	RegisterCommand("xack", xAckStream, 1, 1, 1)
+	RegisterCommand("xpending", xPendingStream, 1, 1, 1)
+	RegisterCommand("xclaim", xClaimStream, 1, 1, 1)
+	RegisterCommand("xautoclaim", xAutoClaimStream, 1, 1, 1)
+	RegisterSubcommand("xgroup", "create", xGroupCreate, 2, 2, 1)
+	RegisterSubcommand("xgroup", "setid", xGroupSetID, 2, 2, 1)
+	RegisterSubcommand("xgroup", "destroy", xGroupDestroy, 2, 2, 1)
+	RegisterSubcommand("xgroup", "createconsumer", xGroupCreateConsumer, 2, 2, 1)
+	RegisterSubcommand("xgroup", "delconsumer", xGroupDelConsumer, 2, 2, 1)
+	RegisterSubcommand("xgroup", "help", xGroupHelpCmd, noKeys, 0, 0)
+	RegisterSubcommand("xinfo", "stream", xInfoStream, 2, 2, 1)
+	RegisterSubcommand("xinfo", "groups", xInfoGroups, 2, 2, 1)
+	RegisterSubcommand("xinfo", "consumers", xInfoConsumers, 2, 2, 1)
+	RegisterSubcommand("xinfo", "help", xInfoHelpCmd, noKeys, 0, 0)
+}
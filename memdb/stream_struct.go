@@ -0,0 +1,536 @@
+package memdb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// streamID is the ID of a stream entry: a unix time in milliseconds, and a sequence number within it.
+type streamID struct {
+	ms, seq uint64
+}
+
+var streamMaxID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) cmp(o streamID) int {
+	switch {
+	case id.ms < o.ms:
+		return -1
+	case id.ms > o.ms:
+		return 1
+	case id.seq < o.seq:
+		return -1
+	case id.seq > o.seq:
+		return 1
+	}
+	return 0
+}
+
+func (id streamID) isZero() bool {
+	return id.ms == 0 && id.seq == 0
+}
+
+// next returns the ID following id; false if id is the last possible one.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the ID preceding id; false if id is 0-0.
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// parseStreamID parses an ID as ms-seq, or ms with the sequence missingSeq.
+// Unless strict, - and + stand for the smallest and greatest IDs.
+func parseStreamID(arg []byte, strict bool, missingSeq uint64) (streamID, bool) {
+	s := string(arg)
+	if !strict {
+		switch s {
+		case "-":
+			return streamID{}, true
+		case "+":
+			return streamMaxID, true
+		}
+	}
+	if len(s) > 127 {
+		return streamID{}, false
+	}
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	seq := missingSeq
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, false
+		}
+	}
+	return streamID{ms, seq}, true
+}
+
+// streamChunkSize is the most items of a chunk, as redis's stream-node-max-entries.
+const streamChunkSize = 100
+
+type idItem[T any] struct {
+	id  streamID
+	val T
+}
+
+// idChunks is a list of items in ID order, split in chunks of up to streamChunkSize items,
+// so inserting or deleting an item moves a chunk rather than the whole list.
+// It plays the part of redis's radix trees keyed by stream IDs. Chunks are never empty.
+type idChunks[T any] struct {
+	chunks [][]idItem[T]
+	n      int
+}
+
+func (c *idChunks[T]) Len() int {
+	return c.n
+}
+
+// search returns the position of the first item whose ID is id or greater; ci is len(c.chunks) if there's none.
+func (c *idChunks[T]) search(id streamID) (ci, i int) {
+	ci = sort.Search(len(c.chunks), func(k int) bool {
+		chunk := c.chunks[k]
+		return chunk[len(chunk)-1].id.cmp(id) >= 0
+	})
+	if ci == len(c.chunks) {
+		return ci, 0
+	}
+	chunk := c.chunks[ci]
+	i = sort.Search(len(chunk), func(k int) bool { return chunk[k].id.cmp(id) >= 0 })
+	return ci, i
+}
+
+func (c *idChunks[T]) Get(id streamID) (T, bool) {
+	ci, i := c.search(id)
+	if ci < len(c.chunks) && c.chunks[ci][i].id == id {
+		return c.chunks[ci][i].val, true
+	}
+	var zero T
+	return zero, false
+}
+
+// Insert adds an item; it returns false, leaving the list as is, if there's an item of that ID already.
+func (c *idChunks[T]) Insert(id streamID, val T) bool {
+	item := idItem[T]{id, val}
+	ci, i := c.search(id)
+	if ci == len(c.chunks) {
+		// after the last item, the common case of stream entries
+		if ci == 0 || len(c.chunks[ci-1]) >= streamChunkSize {
+			c.chunks = append(c.chunks, make([]idItem[T], 0, 8))
+		}
+		ci = len(c.chunks) - 1
+		c.chunks[ci] = append(c.chunks[ci], item)
+		c.n++
+		return true
+	}
+	if c.chunks[ci][i].id == id {
+		return false
+	}
+	if len(c.chunks[ci]) >= streamChunkSize {
+		// split the chunk in halves
+		chunk := c.chunks[ci]
+		half := len(chunk) / 2
+		right := append(make([]idItem[T], 0, streamChunkSize), chunk[half:]...)
+		c.chunks = append(c.chunks, nil)
+		copy(c.chunks[ci+2:], c.chunks[ci+1:])
+		c.chunks[ci], c.chunks[ci+1] = chunk[:half:half], right
+		if i > half {
+			ci, i = ci+1, i-half
+		}
+	}
+	chunk := append(c.chunks[ci], idItem[T]{})
+	copy(chunk[i+1:], chunk[i:])
+	chunk[i] = item
+	c.chunks[ci] = chunk
+	c.n++
+	return true
+}
+
+// Delete removes the item of an ID, and returns its value.
+func (c *idChunks[T]) Delete(id streamID) (T, bool) {
+	ci, i := c.search(id)
+	if ci == len(c.chunks) || c.chunks[ci][i].id != id {
+		var zero T
+		return zero, false
+	}
+	val := c.chunks[ci][i].val
+	chunk := c.chunks[ci]
+	copy(chunk[i:], chunk[i+1:])
+	chunk[len(chunk)-1] = idItem[T]{}
+	c.chunks[ci] = chunk[:len(chunk)-1]
+	if len(c.chunks[ci]) == 0 {
+		copy(c.chunks[ci:], c.chunks[ci+1:])
+		c.chunks[len(c.chunks)-1] = nil
+		c.chunks = c.chunks[:len(c.chunks)-1]
+	}
+	c.n--
+	return val, true
+}
+
+// removeFront removes the first n items.
+func (c *idChunks[T]) removeFront(n int) {
+	c.n -= n
+	for n > 0 {
+		if n >= len(c.chunks[0]) {
+			n -= len(c.chunks[0])
+			c.chunks[0] = nil
+			c.chunks = c.chunks[1:]
+			continue
+		}
+		c.chunks[0] = c.chunks[0][n:]
+		n = 0
+	}
+}
+
+func (c *idChunks[T]) First() (*idItem[T], bool) {
+	if c.n == 0 {
+		return nil, false
+	}
+	return &c.chunks[0][0], true
+}
+
+func (c *idChunks[T]) Last() (*idItem[T], bool) {
+	if c.n == 0 {
+		return nil, false
+	}
+	chunk := c.chunks[len(c.chunks)-1]
+	return &chunk[len(chunk)-1], true
+}
+
+// Ascend calls fn on the items from ID from on, in order, until it returns false.
+// fn must not change the list.
+func (c *idChunks[T]) Ascend(from streamID, fn func(it *idItem[T]) bool) {
+	ci, i := c.search(from)
+	for ; ci < len(c.chunks); ci, i = ci+1, 0 {
+		for chunk := c.chunks[ci]; i < len(chunk); i++ {
+			if !fn(&chunk[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Descend calls fn on the items up to ID from, in reverse order, until it returns false.
+// fn must not change the list.
+func (c *idChunks[T]) Descend(from streamID, fn func(it *idItem[T]) bool) {
+	ci, i := c.search(from)
+	// start at the last item not greater than from
+	if ci == len(c.chunks) || c.chunks[ci][i].id != from {
+		i--
+	}
+	for ; ci >= 0; ci-- {
+		if ci < len(c.chunks) {
+			chunk := c.chunks[ci]
+			for ; i >= 0; i-- {
+				if !fn(&chunk[i]) {
+					return
+				}
+			}
+		}
+		if ci > 0 {
+			i = len(c.chunks[ci-1]) - 1
+		}
+	}
+}
+
+// Stream is an append-only log of entries, each a list of field-value pairs under an increasing ID,
+// with the consumer groups reading it.
+type Stream struct {
+	entries      idChunks[[][]byte] // field, value, field, value...
+	lastID       streamID           // the ID of the last entry ever added, even if deleted
+	firstID      streamID           // the ID of the first entry, 0-0 if there is none
+	maxDeletedID streamID           // the greatest ID deleted by XDEL
+	entriesAdded uint64             // entries ever added
+	groups       map[string]*streamGroup
+}
+
+// streamGroup is a consumer group: how far it read the stream,
+// and the entries delivered to its consumers but not acknowledged yet, the pending entries list.
+type streamGroup struct {
+	lastID      streamID
+	entriesRead int64 // entries read up to lastID, or -1 when unknown
+	pel         idChunks[*streamNack]
+	consumers   map[string]*streamConsumer
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // unix time in milliseconds of the last attempted interaction
+	activeTime int64 // and of the last successful one, or -1
+	pel        idChunks[*streamNack]
+}
+
+// streamNack is a pending entry, in its group's list and its consumer's.
+type streamNack struct {
+	consumer      *streamConsumer
+	deliveryTime  int64 // unix time in milliseconds
+	deliveryCount int64
+}
+
+// as redis's SCG_INVALID_ENTRIES_READ
+const streamInvalidEntriesRead = -1
+
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+func (s *Stream) Encoding() string {
+	return encodingStream
+}
+
+func (s *Stream) Len() int {
+	return s.entries.Len()
+}
+
+// add appends an entry, whose ID must be greater than lastID.
+func (s *Stream) add(id streamID, fields [][]byte) {
+	s.entries.Insert(id, fields)
+	s.lastID = id
+	s.entriesAdded++
+	if s.Len() == 1 {
+		s.firstID = id
+	}
+}
+
+// remove deletes an entry, as XDEL does, recording the deleted ID.
+func (s *Stream) remove(id streamID) bool {
+	if _, ok := s.entries.Delete(id); !ok {
+		return false
+	}
+	if id.cmp(s.maxDeletedID) > 0 {
+		s.maxDeletedID = id
+	}
+	if id == s.firstID {
+		s.updateFirstID()
+	}
+	return true
+}
+
+func (s *Stream) updateFirstID() {
+	if first, ok := s.entries.First(); ok {
+		s.firstID = first.id
+	} else {
+		s.firstID = streamID{}
+	}
+}
+
+// trim removes the oldest entries while there are more than maxLen, or, if minID isn't nil, while their ID is below it.
+// With approx it only removes whole chunks, at most limit entries if positive. It returns the number of entries removed.
+func (s *Stream) trim(maxLen int64, minID *streamID, approx bool, limit int64) int64 {
+	var removed int64
+	for s.Len() > 0 {
+		if minID == nil && int64(s.Len()) <= maxLen {
+			break
+		}
+		chunk := s.entries.chunks[0]
+		n := int64(len(chunk))
+		if limit > 0 && removed+n > limit {
+			break
+		}
+		var whole bool
+		if minID == nil {
+			whole = int64(s.Len())-n >= maxLen
+		} else {
+			whole = chunk[len(chunk)-1].id.cmp(*minID) < 0
+		}
+		if whole {
+			s.entries.removeFront(int(n))
+			removed += n
+			continue
+		}
+		if approx {
+			break
+		}
+		i := 0
+		for i < len(chunk) {
+			if minID == nil && int64(s.Len()-i) <= maxLen || minID != nil && chunk[i].id.cmp(*minID) >= 0 {
+				break
+			}
+			i++
+		}
+		s.entries.removeFront(i)
+		removed += int64(i)
+		break
+	}
+	if removed > 0 {
+		s.updateFirstID()
+	}
+	return removed
+}
+
+// hasTombstones tells whether entries were deleted from start on, as redis's streamRangeHasTombstones.
+func (s *Stream) hasTombstones(start streamID) bool {
+	if s.Len() == 0 || s.maxDeletedID.isZero() || s.firstID.cmp(s.maxDeletedID) > 0 {
+		return false
+	}
+	return start.cmp(s.maxDeletedID) <= 0
+}
+
+// entriesUpTo estimates the number of entries ever added up to id, or returns streamInvalidEntriesRead,
+// as redis's streamEstimateDistanceFromFirstEverEntry.
+func (s *Stream) entriesUpTo(id streamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.Len() == 0 && id.cmp(s.lastID) < 1 {
+		return int64(s.entriesAdded)
+	}
+	switch c := id.cmp(s.lastID); {
+	case c == 0:
+		return int64(s.entriesAdded)
+	case c > 0:
+		return streamInvalidEntriesRead
+	}
+	if s.maxDeletedID.isZero() || s.maxDeletedID.cmp(s.firstID) < 0 {
+		// no fragmentation ahead
+		switch id.cmp(s.firstID) {
+		case -1:
+			return int64(s.entriesAdded) - int64(s.Len())
+		case 0:
+			return int64(s.entriesAdded) - int64(s.Len()) + 1
+		}
+	}
+	return streamInvalidEntriesRead
+}
+
+// lag returns the entries the group has yet to read, false if that's unknown.
+func (s *Stream) lag(g *streamGroup) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.entriesRead != streamInvalidEntriesRead && !s.hasTombstones(g.lastID) {
+		return int64(s.entriesAdded) - g.entriesRead, true
+	}
+	if read := s.entriesUpTo(g.lastID); read != streamInvalidEntriesRead {
+		return int64(s.entriesAdded) - read, true
+	}
+	return 0, false
+}
+
+// createGroup returns nil if there is a group of that name already.
+func (s *Stream) createGroup(name string, lastID streamID, entriesRead int64) *streamGroup {
+	if s.groups == nil {
+		s.groups = make(map[string]*streamGroup)
+	}
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	g := &streamGroup{lastID: lastID, entriesRead: entriesRead, consumers: make(map[string]*streamConsumer)}
+	s.groups[name] = g
+	return g
+}
+
+// groupNames returns the names of the groups in order, as redis lists them.
+func (s *Stream) groupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// consumer returns the consumer of a name, creating it if create is set.
+func (g *streamGroup) consumer(name string, create bool, now int64) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok && create {
+		c = &streamConsumer{name: name, seenTime: now, activeTime: -1}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+func (g *streamGroup) consumerNames() []string {
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deleteConsumer removes a consumer and its pending entries, returning how many it had.
+func (g *streamGroup) deleteConsumer(c *streamConsumer) int {
+	n := c.pel.Len()
+	c.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+		g.pel.Delete(it.id)
+		return true
+	})
+	delete(g.consumers, c.name)
+	return n
+}
+
+// ack removes a pending entry, returning whether there was one.
+func (g *streamGroup) ack(id streamID) bool {
+	nack, ok := g.pel.Delete(id)
+	if !ok {
+		return false
+	}
+	if nack.consumer != nil {
+		nack.consumer.pel.Delete(id)
+	}
+	return true
+}
+
+// claim assigns a pending entry to c.
+func (g *streamGroup) claim(id streamID, nack *streamNack, c *streamConsumer) {
+	if nack.consumer == c {
+		return
+	}
+	if nack.consumer != nil {
+		nack.consumer.pel.Delete(id)
+	}
+	nack.consumer = c
+	c.pel.Insert(id, nack)
+}
+
+func (s *Stream) Clone() *Stream {
+	c := *s
+	c.entries = idChunks[[][]byte]{}
+	s.entries.Ascend(streamID{}, func(it *idItem[[][]byte]) bool {
+		fields := make([][]byte, len(it.val))
+		for i, f := range it.val {
+			fields[i] = append(make([]byte, 0, len(f)), f...)
+		}
+		c.entries.Insert(it.id, fields)
+		return true
+	})
+	c.groups = nil
+	for name, g := range s.groups {
+		cg := c.createGroup(name, g.lastID, g.entriesRead)
+		for cname, consumer := range g.consumers {
+			cc := cg.consumer(cname, true, consumer.seenTime)
+			cc.activeTime = consumer.activeTime
+		}
+		g.pel.Ascend(streamID{}, func(it *idItem[*streamNack]) bool {
+			nack := &streamNack{deliveryTime: it.val.deliveryTime, deliveryCount: it.val.deliveryCount}
+			cg.pel.Insert(it.id, nack)
+			if it.val.consumer != nil {
+				cg.claim(it.id, nack, cg.consumers[it.val.consumer.name])
+			}
+			return true
+		})
+	}
+	return &c
+}
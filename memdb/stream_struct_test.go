@@ -0,0 +1,111 @@
+package memdb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// TestIDChunks runs random inserts and deletes against a sorted slice, enough to split and drop chunks.
+func TestIDChunks(t *testing.T) {
+	var c idChunks[int]
+	var want []streamID
+	for i := 0; i < 20000; i++ {
+		id := streamID{uint64(rand.Intn(50)), uint64(rand.Intn(100))}
+		j := sort.Search(len(want), func(k int) bool { return want[k].cmp(id) >= 0 })
+		found := j < len(want) && want[j] == id
+		if rand.Intn(3) == 0 {
+			if _, ok := c.Delete(id); ok != found {
+				t.Fatal(fmt.Sprintf("Delete(%v) == %v", id, ok))
+			}
+			if found {
+				want = append(want[:j], want[j+1:]...)
+			}
+			continue
+		}
+		if ok := c.Insert(id, int(id.ms*1000+id.seq)); ok == found {
+			t.Fatal(fmt.Sprintf("Insert(%v) == %v", id, ok))
+		}
+		if !found {
+			want = append(want, streamID{})
+			copy(want[j+1:], want[j:])
+			want[j] = id
+		}
+	}
+	if c.Len() != len(want) {
+		t.Fatal(fmt.Sprintf("Len() == %d, expect %d", c.Len(), len(want)))
+	}
+	for _, chunk := range c.chunks {
+		if len(chunk) == 0 || len(chunk) > streamChunkSize {
+			t.Fatal(fmt.Sprintf("chunk of %d items", len(chunk)))
+		}
+	}
+
+	// from an ID present or not, both ways
+	for _, from := range []streamID{{}, want[0], {25, 50}, {25, 51}, want[len(want)-1], streamMaxID} {
+		j := sort.Search(len(want), func(k int) bool { return want[k].cmp(from) >= 0 })
+		var got []streamID
+		c.Ascend(from, func(it *idItem[int]) bool {
+			if it.val != int(it.id.ms*1000+it.id.seq) {
+				t.Error(fmt.Sprintf("item %v holds %d", it.id, it.val))
+			}
+			got = append(got, it.id)
+			return true
+		})
+		if fmt.Sprint(got) != fmt.Sprint(want[j:]) {
+			t.Error(fmt.Sprintf("Ascend(%v) visits %d items, expect %d", from, len(got), len(want)-j))
+		}
+
+		if j == len(want) || want[j] != from {
+			j--
+		}
+		got = got[:0]
+		c.Descend(from, func(it *idItem[int]) bool {
+			got = append(got, it.id)
+			return true
+		})
+		if len(got) != j+1 || (j >= 0 && (got[0] != want[j] || got[j] != want[0])) {
+			t.Error(fmt.Sprintf("Descend(%v) visits %d items, expect %d", from, len(got), j+1))
+		}
+	}
+
+	c.removeFront(150)
+	if first, _ := c.First(); c.Len() != len(want)-150 || first.id != want[150] {
+		t.Error(fmt.Sprintf("removeFront(150) leaves %d items from %v", c.Len(), first.id))
+	}
+	if last, _ := c.Last(); last.id != want[len(want)-1] {
+		t.Error(fmt.Sprintf("Last() == %v", last.id))
+	}
+}
+
+func TestParseStreamID(t *testing.T) {
+	for _, c := range []struct {
+		arg    string
+		strict bool
+		want   streamID
+		ok     bool
+	}{
+		{"1-2", true, streamID{1, 2}, true},
+		{"5", true, streamID{5, 7}, true},
+		{"18446744073709551615-18446744073709551615", true, streamMaxID, true},
+		{"-", false, streamID{}, true},
+		{"+", false, streamMaxID, true},
+		{"-", true, streamID{}, false},
+		{"1-", true, streamID{}, false},
+		{"1-x", true, streamID{}, false},
+		{"-1", true, streamID{}, false},
+		{"18446744073709551616", true, streamID{}, false},
+	} {
+		id, ok := parseStreamID([]byte(c.arg), c.strict, 7)
+		if ok != c.ok || (ok && id != c.want) {
+			t.Error(fmt.Sprintf("parseStreamID(%s) == %v %v", c.arg, id, ok))
+		}
+	}
+	if id, _ := (streamID{3, 0}).prev(); id != (streamID{2, 1<<64 - 1}) {
+		t.Error(fmt.Sprintf("3-0 prev == %v", id))
+	}
+	if _, ok := streamMaxID.next(); ok {
+		t.Error("the max ID has a next")
+	}
+}
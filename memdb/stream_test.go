@@ -0,0 +1,181 @@
+package memdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func streamCommands() (*MemDb, func(args ...string) string) {
+	dbs, _ := keyCommands(1)
+	RegisterStreamCommands()
+	return dbs[0], func(args ...string) string {
+		cmd := makeCmd(args...)
+		c, errReply := CmdTable[args[0]].Subcommand(cmd)
+		if errReply != nil {
+			return string(errReply.ToRedisFormat())
+		}
+		return string(c.Executor(dbs[0], cmd).ToRedisFormat())
+	}
+}
+
+// entry is the reply of a stream entry.
+func entry(id string, fields ...string) string {
+	return "*2\r\n$" + strconv.Itoa(len(id)) + "\r\n" + id + "\r\n" + bulks(fields...)
+}
+
+// streamsReply is the reply of XREAD for one stream.
+func streamsReply(key string, entries ...string) string {
+	return "*1\r\n*2\r\n" + bulks(key)[4:] + "*" + strconv.Itoa(len(entries)) + "\r\n" + strings.Join(entries, "")
+}
+
+func TestStream(t *testing.T) {
+	_, exec := streamCommands()
+
+	for _, c := range []struct{ args, want string }{
+		{"xadd s 1-1 a 1", "$3\r\n1-1\r\n"},
+		{"xadd s 1-1 a 2", "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"xadd s 1-* b 2", "$3\r\n1-2\r\n"},
+		{"xadd s 2-0 c 3 d 4", "$3\r\n2-0\r\n"},
+		{"xadd s 3-0 odd", "-ERR wrong number of arguments for 'xadd' command\r\n"},
+		{"xadd s 0-0 a 1", "-ERR The ID specified in XADD must be greater than 0-0\r\n"},
+		{"xadd s 1-x a 1", "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{"xadd nokey NOMKSTREAM * a 1", "$-1\r\n"},
+		{"exists nokey", ":0\r\n"},
+		{"type s", "+stream\r\n"},
+		{"xlen s", ":3\r\n"},
+		{"xlen nokey", ":0\r\n"},
+
+		{"xrange s - +", "*3\r\n" + entry("1-1", "a", "1") + entry("1-2", "b", "2") + entry("2-0", "c", "3", "d", "4")},
+		{"xrange s (1-1 + COUNT 1", "*1\r\n" + entry("1-2", "b", "2")},
+		{"xrange s 1 1", "*2\r\n" + entry("1-1", "a", "1") + entry("1-2", "b", "2")},
+		{"xrevrange s + - COUNT 2", "*2\r\n" + entry("2-0", "c", "3", "d", "4") + entry("1-2", "b", "2")},
+		{"xrange s - + COUNT 0", "*-1\r\n"},
+		{"xrange s 3 2", "*0\r\n"},
+		{"xrange nokey - +", "*0\r\n"},
+		{"xrange s (- +", "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{"xrange s - (0-0", "-ERR invalid end ID for the interval\r\n"},
+
+		{"xread COUNT 1 STREAMS s 0", streamsReply("s", entry("1-1", "a", "1"))},
+		{"xread STREAMS s nokey 1-2 0", streamsReply("s", entry("2-0", "c", "3", "d", "4"))},
+		{"xread STREAMS s 2-0", "*-1\r\n"},
+		{"xread STREAMS s $", "*-1\r\n"},
+		{"xread STREAMS s t 0", "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n"},
+
+		{"xdel s 1-2 9-9", ":1\r\n"},
+		{"xlen s", ":2\r\n"},
+		{"xadd s 1-2 b 2", "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"xtrim s MAXLEN 1", ":1\r\n"},
+		{"xtrim s MINID 9", ":1\r\n"},
+		{"exists s", ":1\r\n"},
+		{"xadd s MAXLEN 2 3-0 e 5", "$3\r\n3-0\r\n"},
+		{"xtrim s MAXLEN 1 LIMIT 10", "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"xadd str * a 1", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	} {
+		if r := exec(strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
+
+func TestStreamGroups(t *testing.T) {
+	_, exec := streamCommands()
+	exec("xadd", "s", "1-0", "a", "1")
+	exec("xadd", "s", "2-0", "b", "2")
+
+	for _, c := range []struct{ args, want string }{
+		{"xgroup create s g 0", "+OK\r\n"},
+		{"xgroup create s g 0", "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{"xgroup create nokey g $", "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{"xgroup create mk g $ MKSTREAM", "+OK\r\n"},
+		{"xlen mk", ":0\r\n"},
+
+		{"xreadgroup GROUP g alice COUNT 1 STREAMS s >", streamsReply("s", entry("1-0", "a", "1"))},
+		{"xreadgroup GROUP g bob STREAMS s >", streamsReply("s", entry("2-0", "b", "2"))},
+		{"xreadgroup GROUP g bob STREAMS s >", "*-1\r\n"},
+		{"xreadgroup GROUP g alice STREAMS s 0", streamsReply("s", entry("1-0", "a", "1"))},
+		{"xreadgroup GROUP nog alice STREAMS s >", "-NOGROUP No such key 's' or consumer group 'nog' in XREADGROUP with GROUP option\r\n"},
+		{"xpending s g", "*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n"},
+		{"xpending s nog", "-NOGROUP No such key 's' or consumer group 'nog'\r\n"},
+
+		{"xack s g 1-0 9-0", ":1\r\n"},
+		{"xack s nog 1-0", ":0\r\n"},
+		{"xreadgroup GROUP g alice STREAMS s 0", streamsReply("s")},
+		{"xclaim s g alice 0 2-0 JUSTID", bulks("2-0")},
+		{"xclaim s g alice 3600000 2-0", "*0\r\n"},
+		{"xautoclaim s g bob 0 0 COUNT 10 JUSTID", "*3\r\n$3\r\n0-0\r\n" + bulks("2-0") + "*0\r\n"},
+		{"xpending s g", "*4\r\n:1\r\n$3\r\n2-0\r\n$3\r\n2-0\r\n*1\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n"},
+
+		// an entry deleted while pending is dropped from the PEL when claimed
+		{"xdel s 2-0", ":1\r\n"},
+		{"xautoclaim s g alice 0 0", "*3\r\n$3\r\n0-0\r\n*0\r\n" + bulks("2-0")},
+		{"xpending s g", "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"},
+
+		{"xgroup createconsumer s g carol", ":1\r\n"},
+		{"xgroup createconsumer s g carol", ":0\r\n"},
+		{"xgroup delconsumer s g bob", ":0\r\n"},
+		{"xgroup setid s g 0 ENTRIESREAD 0", "+OK\r\n"},
+		{"xreadgroup GROUP g carol STREAMS s >", streamsReply("s", entry("1-0", "a", "1"))},
+		{"xgroup delconsumer s g carol", ":1\r\n"},
+		{"xgroup destroy s g", ":1\r\n"},
+		{"xgroup destroy s g", ":0\r\n"},
+		{"xgroup foo s", "-ERR unknown subcommand 'foo'. Try XGROUP HELP.\r\n"},
+	} {
+		if r := exec(strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
+
+func TestStreamInfo(t *testing.T) {
+	_, exec := streamCommands()
+	for i := 1; i <= 1000; i++ {
+		exec("xadd", "s", strconv.Itoa(i)+"-0", "f", strconv.Itoa(i))
+	}
+	exec("xgroup", "create", "s", "g", "0")
+	exec("xreadgroup", "GROUP", "g", "alice", "COUNT", "3", "STREAMS", "s", ">")
+	exec("xdel", "s", "500-0")
+
+	info := exec("xinfo", "stream", "s")
+	for _, want := range []string{
+		"$6\r\nlength\r\n:999\r\n",
+		"$17\r\nlast-generated-id\r\n$6\r\n1000-0\r\n",
+		"$20\r\nmax-deleted-entry-id\r\n$5\r\n500-0\r\n",
+		"$13\r\nentries-added\r\n:1000\r\n",
+		"$6\r\ngroups\r\n:1\r\n",
+		"$11\r\nfirst-entry\r\n" + entry("1-0", "f", "1"),
+	} {
+		if !strings.Contains(info, want) {
+			t.Error(fmt.Sprintf("XINFO STREAM lacks %q", want))
+		}
+	}
+	groups := exec("xinfo", "groups", "s")
+	for _, want := range []string{"$7\r\npending\r\n:3\r\n", "$17\r\nlast-delivered-id\r\n$3\r\n3-0\r\n", "$3\r\nlag\r\n$-1\r\n"} {
+		if !strings.Contains(groups, want) {
+			t.Error(fmt.Sprintf("XINFO GROUPS lacks %q: %q", want, groups))
+		}
+	}
+	if r := exec("xinfo", "consumers", "s", "g"); !strings.HasPrefix(r, "*1\r\n*8\r\n$4\r\nname\r\n$5\r\nalice\r\n$7\r\npending\r\n:3\r\n") {
+		t.Error(fmt.Sprintf("XINFO CONSUMERS == %q", r))
+	}
+
+	// the stream and its groups survive DUMP and RESTORE
+	full := exec("xinfo", "stream", "s", "full")
+	payload := exec("dump", "s")
+	if r := exec("restore", "s2", "0", payload[strings.Index(payload, "\r\n")+2:len(payload)-2]); r != "+OK\r\n" {
+		t.Fatal(fmt.Sprintf("RESTORE == %q", r))
+	}
+	if r := exec("xinfo", "stream", "s2", "full"); r != full {
+		t.Error(fmt.Sprintf("restored stream: %.200q, expect %.200q", r, full))
+	}
+
+	// an approximate trim removes whole chunks only
+	if r := exec("xtrim", "s", "MAXLEN", "~", "550"); r != ":400\r\n" {
+		t.Error(fmt.Sprintf("XTRIM MAXLEN ~ 550 == %q", r))
+	}
+	if r := exec("xtrim", "s", "MAXLEN", "550"); r != ":49\r\n" {
+		t.Error(fmt.Sprintf("XTRIM MAXLEN 550 == %q", r))
+	}
+}
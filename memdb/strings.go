@@ -1,8 +1,9 @@
 package memdb
 
 import (
-	"fmt"
+	"bytes"
 	"gRedis/resp"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -20,14 +21,12 @@ GET -- Return the old string stored at key, or nil if key did not exist. An erro
 */
 func setString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
-	val := cmd[2]
+	val := bytes.Clone(cmd[2])
 
 	// parse options
 	var nx, xx, get, ex, px, exat, pxat, keepttl bool
@@ -47,53 +46,59 @@ func setString(db *MemDb, cmd [][]byte) resp.RedisData {
 			optNum++
 			i++
 			if i >= len(cmd) {
-				resp.NewSimpleError("syntax error")
+				return resp.NewSyntaxError()
 			}
 			exval, err = strconv.ParseInt(string(cmd[i]), 10, 64)
 			if err != nil {
-				resp.NewSimpleError("value is not an integer")
+				return resp.NewNotIntegerError()
 			}
 		case "px":
 			px = true
 			optNum++
 			i++
 			if i >= len(cmd) {
-				resp.NewSimpleError("syntax error")
+				return resp.NewSyntaxError()
 			}
 			pxval, err = strconv.ParseInt(string(cmd[i]), 10, 64)
 			if err != nil {
-				return resp.NewSimpleError("value is not an integer")
+				return resp.NewNotIntegerError()
 			}
 		case "exat":
 			exat = true
 			optNum++
+			i++
 			if i >= len(cmd) {
-				return resp.NewSimpleError("syntax error")
+				return resp.NewSyntaxError()
 			}
 			exatval, err = strconv.ParseInt(string(cmd[i]), 10, 64)
 			if err != nil {
-				return resp.NewSimpleError("value is not an integer")
+				return resp.NewNotIntegerError()
 			}
 		case "pxat":
 			pxat = true
 			optNum++
+			i++
 			if i >= len(cmd) {
-				return resp.NewSimpleError("syntax error")
+				return resp.NewSyntaxError()
 			}
 			pxatval, err = strconv.ParseInt(string(cmd[i]), 10, 64)
 			if err != nil {
-				return resp.NewSimpleError("value is not an integer")
+				return resp.NewNotIntegerError()
 			}
 		case "keepttl":
 			keepttl = true
 			optNum++
 		default:
-			return resp.NewSimpleError(fmt.Sprintf("Unsupported option %s", string(cmd[i])))
+			return resp.NewSyntaxError()
 		}
 	}
 
 	if nx && xx || optNum > 1 {
-		return resp.NewSimpleError("syntax error")
+		return resp.NewSyntaxError()
+	}
+
+	if (ex && exval <= 0) || (px && pxval <= 0) || (exat && exatval <= 0) || (pxat && pxatval <= 0) {
+		return resp.NewInvalidExpireError(cmd[0])
 	}
 
 	// set
@@ -101,31 +106,31 @@ func setString(db *MemDb, cmd [][]byte) resp.RedisData {
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	oldVal, oldOk := db.dict.Get(key)
+	oldVal, oldOk := db.lookupKeyWrite(key)
 	if oldOk {
 		if _, ok := oldVal.([]byte); !ok {
-			return resp.NewSimpleError("The value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 	}
 
 	if nx || xx {
 		if nx {
 			if !oldOk {
-				db.dict.Set(key, val)
+				db.setKey(key, val)
 				res = resp.NewSimpleString("OK")
 			} else {
 				res = resp.NewBulkString(nil)
 			}
 		} else {
 			if oldOk {
-				db.dict.Set(key, val)
+				db.setKey(key, val)
 				res = resp.NewSimpleString("OK")
 			} else {
 				res = resp.NewBulkString(nil)
 			}
 		}
 	} else {
-		db.dict.Set(key, val)
+		db.setKey(key, val)
 		res = resp.NewSimpleString("OK")
 	}
 
@@ -159,24 +164,21 @@ func setString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func getString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString(nil)
-	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	val, ok := db.dict.Get(key)
+	val, ok := db.lookupKeyRead(key)
 	if ok {
 		if v, ok := val.([]byte); !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		} else {
-			return resp.NewBulkString(v)
+			// strings are rewritten in place by SETBIT and SETRANGE, so reply with a copy
+			return resp.NewBulkString(bytes.Clone(v))
 		}
 	} else {
 		return resp.NewBulkString(nil)
@@ -185,35 +187,31 @@ func getString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func getRangeString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	if db.DeleteExpiredKey(key) {
-		return resp.NewBulkString([]byte{})
-	}
 
 	start, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	end, err := strconv.Atoi(string(cmd[3]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	val, ok := db.dict.Get(key)
+	val, ok := db.lookupKeyRead(key)
 	if !ok {
 		return resp.NewBulkString([]byte{})
 	}
 	v, ok := val.([]byte)
 	if !ok {
-		return resp.NewSimpleError("the value stored at key is not a string")
+		return resp.NewWrongTypeError()
 	}
 
 	if start < 0 {
@@ -236,82 +234,80 @@ func getRangeString(db *MemDb, cmd [][]byte) resp.RedisData {
 		end = len(v)
 	}
 
-	return resp.NewBulkString(v[start:end])
+	return resp.NewBulkString(bytes.Clone(v[start:end]))
 }
 
 func setRangeString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	// parse cmd
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	offset, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 	if offset < 0 {
-		return resp.NewSimpleError("offset is out of range")
+		return resp.NewErrorReply(resp.ErrPrefix, "offset is out of range")
 	}
 
 	value := cmd[3]
 
-	var oldVal, newVal []byte
+	if int64(offset)+int64(len(value)) > maxStringSize() {
+		return resp.NewErrorReply(resp.ErrPrefix, "string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
 	// check if key existed
-	val, ok := db.dict.Get(key)
+	val, ok := db.lookupKeyWrite(key)
+	var newVal []byte
 	if ok {
-		oldVal, ok = val.([]byte)
+		newVal, ok = val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
-	} else {
-		oldVal = make([]byte, 0)
+	}
+	// an empty value changes nothing, and doesn't create the key
+	if len(value) == 0 {
+		return resp.NewInteger(int64(len(newVal)))
 	}
 
 	//  If the offset is larger than the current length of the string at key, the string is padded with zero-bytes to make offset fit.
-	if offset > len(oldVal) {
-		newVal = oldVal
-		zeroes := make([]byte, offset-len(oldVal)) // pre-allocating memory
-		newVal = append(newVal, zeroes...)
-	} else {
-		newVal = oldVal[:offset]
+	if end := offset + len(value); end > len(newVal) {
+		newVal = append(newVal, make([]byte, end-len(newVal))...)
 	}
-
-	newVal = append(newVal, value...)
-	db.dict.Set(key, newVal)
+	// the bytes after the range are kept, the string is overwritten in place
+	copy(newVal[offset:], value)
+	db.setKey(key, newVal)
 	return resp.NewInteger(int64(len(newVal)))
 }
 
 func mGetString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	res := make([]resp.RedisData, 0)
 
 	for _, k := range cmd[1:] {
 		key := string(k)
-		if !db.DeleteExpiredKey(key) {
-			db.locks.RLock(key)
-
-			if val, ok := db.dict.Get(key); ok {
-				if v, ok := val.([]byte); ok {
-					res = append(res, resp.NewBulkString(v))
-				} else {
-					res = append(res, resp.NewBulkString(nil)) // not string
-				}
+		db.locks.RLock(key)
+
+		if val, ok := db.lookupKeyRead(key); ok {
+			if v, ok := val.([]byte); ok {
+				res = append(res, resp.NewBulkString(bytes.Clone(v)))
 			} else {
-				res = append(res, resp.NewBulkString(nil))
+				res = append(res, resp.NewBulkString(nil)) // not string
 			}
-
-			db.locks.RUnLock(key)
+		} else {
+			res = append(res, resp.NewBulkString(nil))
 		}
+
+		db.locks.RUnLock(key)
 	}
 
 	return resp.NewArray(res)
@@ -320,14 +316,14 @@ func mGetString(db *MemDb, cmd [][]byte) resp.RedisData {
 // MSET is atomic, so all given keys are set at once.
 func mSetString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 || len(cmd)&1 != 1 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	keys := make([]string, 0)
 	vals := make([][]byte, 0)
 	for i := 1; i < len(cmd); i += 2 {
 		keys = append(keys, string(cmd[i]))
-		vals = append(vals, cmd[i+1])
+		vals = append(vals, bytes.Clone(cmd[i+1]))
 	}
 
 	db.locks.MLock(keys)
@@ -335,7 +331,7 @@ func mSetString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 	for i := 0; i < len(keys); i++ {
 		db.DeleteExpire(keys[i])
-		db.dict.Set(keys[i], vals[i])
+		db.setKey(keys[i], vals[i])
 	}
 
 	return resp.NewSimpleString("OK")
@@ -343,24 +339,25 @@ func mSetString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func setExString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 4 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	sec, err := strconv.ParseInt(string(cmd[2]), 10, 64)
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
+	}
+	if sec <= 0 {
+		return resp.NewInvalidExpireError(cmd[0])
 	}
 
-	val := cmd[3]
+	val := bytes.Clone(cmd[3])
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	db.dict.Set(key, val)
+	db.setKey(key, val)
 	db.SetExpire(key, time.Now().Unix()+sec)
 
 	return resp.NewSimpleString("OK")
@@ -368,43 +365,37 @@ func setExString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func setNxString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
-
-	if _, ok := db.dict.Get(key); ok {
-		return resp.NewInteger(0)
-	}
-
-	val := cmd[2]
 
+	// the existence check and the set are atomic under the key's lock
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	db.dict.Set(key, val)
+	if _, ok := db.lookupKeyWrite(key); ok {
+		return resp.NewInteger(0)
+	}
+	db.setKey(key, bytes.Clone(cmd[2]))
 
 	return resp.NewInteger(1)
 }
 
 func strLenString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.RLock(key)
 	defer db.locks.RUnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyRead(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("ERR the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 		return resp.NewInteger(int64(len(v)))
 	} else {
@@ -414,30 +405,31 @@ func strLenString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func incrString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyWrite(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 
 		nV, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 
-		nV++
+		nV, ok = addInt64(nV, 1)
+		if !ok {
+			return resp.NewOverflowError()
+		}
 		nVal := strconv.FormatInt(nV, 10)
-		db.dict.Set(key, []byte(nVal))
+		db.setKey(key, []byte(nVal))
 
 		return resp.NewInteger(nV)
 	} else {
@@ -447,35 +439,36 @@ func incrString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func incrByString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	increment, err := strconv.ParseInt(string(cmd[2]), 10, 64)
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyWrite(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 
 		nV, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 
-		nV += increment
+		nV, ok = addInt64(nV, increment)
+		if !ok {
+			return resp.NewOverflowError()
+		}
 		nVal := strconv.FormatInt(nV, 10)
-		db.dict.Set(key, []byte(nVal))
+		db.setKey(key, []byte(nVal))
 
 		return resp.NewInteger(nV)
 	} else {
@@ -485,30 +478,31 @@ func incrByString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func decrString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyWrite(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 
 		nV, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
-			return resp.NewSimpleError("value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 
-		nV--
+		nV, ok = addInt64(nV, -1)
+		if !ok {
+			return resp.NewOverflowError()
+		}
 		nVal := strconv.FormatInt(nV, 10)
-		db.dict.Set(key, []byte(nVal))
+		db.setKey(key, []byte(nVal))
 
 		return resp.NewInteger(nV)
 	} else {
@@ -518,35 +512,39 @@ func decrString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func decrByString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	decrement, err := strconv.ParseInt(string(cmd[2]), 10, 64)
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
+	}
+	if decrement == math.MinInt64 {
+		return resp.NewErrorReply(resp.ErrPrefix, "decrement would overflow")
 	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyWrite(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 
 		nV, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
-			return resp.NewSimpleError("(error) value is not an integer")
+			return resp.NewNotIntegerError()
 		}
 
-		nV -= decrement
+		nV, ok = addInt64(nV, -decrement)
+		if !ok {
+			return resp.NewOverflowError()
+		}
 		nVal := strconv.FormatInt(nV, 10)
-		db.dict.Set(key, []byte(nVal))
+		db.setKey(key, []byte(nVal))
 
 		return resp.NewInteger(nV)
 	} else {
@@ -556,88 +554,408 @@ func decrByString(db *MemDb, cmd [][]byte) resp.RedisData {
 
 func incrByFloatString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	increment, err := strconv.ParseFloat(string(cmd[2]), 64)
 	if err != nil {
-		return resp.NewSimpleError("value is not an float")
+		return resp.NewNotFloatError()
 	}
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyWrite(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 
 		fV, err := strconv.ParseFloat(string(v), 64)
 		if err != nil {
-			return resp.NewSimpleError("value is not an float")
+			return resp.NewNotFloatError()
 		}
 
 		fV += increment
+		if math.IsNaN(fV) || math.IsInf(fV, 0) {
+			return resp.NewErrorReply(resp.ErrPrefix, "increment would produce NaN or Infinity")
+		}
 		fVal := strconv.FormatFloat(fV, 'f', -1, 64)
-		db.dict.Set(key, []byte(fVal))
+		db.setKey(key, []byte(fVal))
 
 		return resp.NewBulkString([]byte(fVal))
 	} else {
 		//  If the key does not exist, it is set to 0 before performing the operation.
 		f := []byte(strconv.FormatFloat(increment, 'f', -1, 64))
-		db.dict.Set(key, f)
+		db.setKey(key, f)
 		return resp.NewBulkString(f)
 	}
 }
 
 func appendString(db *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) != 3 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
-	// passive delete expired key
 	key := string(cmd[1])
-	db.DeleteExpiredKey(key)
 
 	apd := cmd[2]
 
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	if val, ok := db.dict.Get(key); ok {
+	if val, ok := db.lookupKeyWrite(key); ok {
 		v, ok := val.([]byte)
 		if !ok {
-			return resp.NewSimpleError("the value stored at key is not a string")
+			return resp.NewWrongTypeError()
 		}
 		v = append(v, apd...)
-		db.dict.Set(key, v)
+		db.setKey(key, v)
 		return resp.NewInteger(int64(len(v)))
 	} else {
-		db.dict.Set(key, apd)
+		db.setKey(key, bytes.Clone(apd))
 		return resp.NewInteger(int64(len(apd)))
 	}
 
 }
 
+// GETEX is GET setting or removing the key's expire time, as SET's options do.
+func getExString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	// expires are kept in seconds: a time in milliseconds is rounded up, so the key doesn't expire early
+	var expireAt int64
+	var persist bool
+	optNum := 0
+	for i := 2; i < len(cmd); i++ {
+		opt := strings.ToLower(string(cmd[i]))
+		optNum++
+		if opt == "persist" {
+			persist = true
+			continue
+		}
+		if opt != "ex" && opt != "px" && opt != "exat" && opt != "pxat" {
+			return resp.NewSyntaxError()
+		}
+		i++
+		if i >= len(cmd) {
+			return resp.NewSyntaxError()
+		}
+		n, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+		if err != nil {
+			return resp.NewNotIntegerError()
+		}
+		if n <= 0 || (opt == "ex" && n > math.MaxInt64/1000) {
+			return resp.NewInvalidExpireError(cmd[0])
+		}
+		switch opt {
+		case "ex":
+			expireAt = time.Now().Unix() + n
+		case "px":
+			expireAt = time.Now().Unix() + (n+999)/1000
+		case "exat":
+			expireAt = n
+		case "pxat":
+			expireAt = (n + 999) / 1000
+		}
+	}
+	if optNum > 1 {
+		return resp.NewSyntaxError()
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	val, ok := db.lookupKeyWrite(key)
+	if !ok {
+		return resp.NewBulkString(nil)
+	}
+	v, ok := val.([]byte)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+	res := resp.NewBulkString(bytes.Clone(v))
+
+	switch {
+	case persist:
+		db.DeleteExpire(key)
+	case expireAt > 0 && expireAt < time.Now().Unix():
+		// a time already passed deletes the key, after replying its value
+		db.deleteKey(key, lazyfreeExpire())
+	case expireAt > 0:
+		db.SetExpire(key, expireAt)
+	}
+
+	return res
+}
+
+func getDelString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	val, ok := db.lookupKeyWrite(key)
+	if !ok {
+		return resp.NewBulkString(nil)
+	}
+	v, ok := val.([]byte)
+	if !ok {
+		return resp.NewWrongTypeError()
+	}
+	// the value leaves the keyspace, no copy needed
+	db.deleteKey(key, false)
+
+	return resp.NewBulkString(v)
+}
+
+// GETSET is SET key value GET: the key's expire time is removed.
+func getSetString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	val, ok := db.lookupKeyWrite(key)
+	res := resp.NewBulkString(nil)
+	if ok {
+		v, ok := val.([]byte)
+		if !ok {
+			return resp.NewWrongTypeError()
+		}
+		res = resp.NewBulkString(v)
+	}
+	db.setKey(key, bytes.Clone(cmd[2]))
+	db.DeleteExpire(key)
+
+	return res
+}
+
+// MSETNX sets all given keys at once, or none if any of them exists.
+func mSetNxString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 || len(cmd)&1 != 1 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	keys := make([]string, 0, len(cmd)/2)
+	for i := 1; i < len(cmd); i += 2 {
+		keys = append(keys, string(cmd[i]))
+	}
+
+	db.locks.MLock(keys)
+	defer db.locks.MUnLock(keys)
+
+	for _, key := range keys {
+		if _, ok := db.lookupKeyWrite(key); ok {
+			return resp.NewInteger(0)
+		}
+	}
+	for i, key := range keys {
+		db.setKey(key, bytes.Clone(cmd[2*i+2]))
+	}
+
+	return resp.NewInteger(1)
+}
+
+/*
+LEN -- Reply the length of the longest common subsequence instead of the subsequence.
+IDX -- Reply the ranges of the subsequence in both strings, from the end, with its length.
+MINMATCHLEN len -- Only reply the ranges of at least len bytes.
+WITHMATCHLEN -- Reply the length of each range with it.
+*/
+func lcsString(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	var getLen, getIdx, withMatchLen bool
+	var minMatchLen int64
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			i++
+			if i >= len(cmd) {
+				return resp.NewSyntaxError()
+			}
+			n, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil {
+				return resp.NewNotIntegerError()
+			}
+			if n > 0 {
+				minMatchLen = n
+			}
+		default:
+			return resp.NewSyntaxError()
+		}
+	}
+	if getLen && getIdx {
+		return resp.NewErrorReply(resp.ErrPrefix, "If you want both the length and indexes, please just use IDX.")
+	}
+
+	keys := []string{string(cmd[1]), string(cmd[2])}
+	db.locks.MRLock(keys)
+	defer db.locks.MRUnLock(keys)
+
+	var strs [2][]byte
+	for i, key := range keys {
+		if val, ok := db.lookupKeyRead(key); ok {
+			v, ok := val.([]byte)
+			if !ok {
+				return resp.NewErrorReply(resp.ErrPrefix, "The specified keys must contain string values")
+			}
+			strs[i] = v
+		}
+	}
+	a, b := strs[0], strs[1]
+
+	// the dynamic programming table takes (len(a)+1)*(len(b)+1) 32-bit lengths
+	if uint64(len(a)+1)*uint64(len(b)+1) > uint64(maxStringSize())/4 {
+		return resp.NewErrorReply(resp.ErrPrefix, "Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+	width := len(b) + 1
+	table := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				table[i*width+j] = table[(i-1)*width+j-1] + 1
+			case table[(i-1)*width+j] > table[i*width+j-1]:
+				table[i*width+j] = table[(i-1)*width+j]
+			default:
+				table[i*width+j] = table[i*width+j-1]
+			}
+		}
+	}
+	lcsLen := table[len(a)*width+len(b)]
+	if getLen {
+		return resp.NewInteger(int64(lcsLen))
+	}
+
+	// walk the table back from the end, collecting the subsequence or its ranges as redis does
+	var result []byte
+	if !getIdx {
+		result = make([]byte, lcsLen)
+	}
+	var matches []resp.RedisData
+	idx := lcsLen
+	noRange := len(a)
+	aStart, aEnd, bStart, bEnd := noRange, 0, 0, 0
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			if result != nil {
+				result[idx-1] = a[i-1]
+			}
+			if aStart == noRange {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				// the range goes on backward
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			// a range reaching the start of a string ends the walk
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if table[(i-1)*width+j] > table[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != noRange {
+				emit = true
+			}
+		}
+
+		if emit {
+			if matchLen := int64(aEnd - aStart + 1); getIdx && (minMatchLen == 0 || matchLen >= minMatchLen) {
+				match := []resp.RedisData{
+					resp.NewArray([]resp.RedisData{resp.NewInteger(int64(aStart)), resp.NewInteger(int64(aEnd))}),
+					resp.NewArray([]resp.RedisData{resp.NewInteger(int64(bStart)), resp.NewInteger(int64(bEnd))}),
+				}
+				if withMatchLen {
+					match = append(match, resp.NewInteger(matchLen))
+				}
+				matches = append(matches, resp.NewArray(match))
+			}
+			aStart = noRange
+		}
+	}
+
+	if !getIdx {
+		return resp.NewBulkString(result)
+	}
+	if matches == nil {
+		matches = []resp.RedisData{}
+	}
+	return resp.NewArray([]resp.RedisData{
+		resp.NewBulkString([]byte("matches")), resp.NewArray(matches),
+		resp.NewBulkString([]byte("len")), resp.NewInteger(int64(lcsLen)),
+	})
+}
+
+// addInt64 returns a + b and false if the sum overflows int64
+func addInt64(a, b int64) (int64, bool) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, false
+	}
+	return sum, true
+}
+
 func RegisterStringCommands() {
-	RegisterCommand("set", setString)
-	RegisterCommand("get", getString)
-	RegisterCommand("getrange", getRangeString)
-	RegisterCommand("setrange", setRangeString)
-	RegisterCommand("mget", mGetString)
-	RegisterCommand("mset", mSetString)
-	RegisterCommand("setex", setExString)
-	RegisterCommand("setnx", setNxString)
-	RegisterCommand("strlen", strLenString)
-	RegisterCommand("incr", incrString)
-	RegisterCommand("incrby", incrByString)
-	RegisterCommand("decr", decrString)
-	RegisterCommand("decrby", decrByString)
-	RegisterCommand("incrbyfloat", incrByFloatString)
-	RegisterCommand("append", appendString)
+	RegisterCommand("set", setString, 1, 1, 1)
+	RegisterCommand("get", getString, 1, 1, 1)
+	RegisterCommand("getrange", getRangeString, 1, 1, 1)
+	RegisterCommand("setrange", setRangeString, 1, 1, 1)
+	RegisterCommand("mget", mGetString, 1, -1, 1)
+	RegisterCommand("mset", mSetString, 1, -1, 2)
+	RegisterCommand("setex", setExString, 1, 1, 1)
+	RegisterCommand("setnx", setNxString, 1, 1, 1)
+	RegisterCommand("strlen", strLenString, 1, 1, 1)
+	RegisterCommand("incr", incrString, 1, 1, 1)
+	RegisterCommand("incrby", incrByString, 1, 1, 1)
+	RegisterCommand("decr", decrString, 1, 1, 1)
+	RegisterCommand("decrby", decrByString, 1, 1, 1)
+	RegisterCommand("incrbyfloat", incrByFloatString, 1, 1, 1)
+	RegisterCommand("append", appendString, 1, 1, 1)
+	RegisterCommand("getex", getExString, 1, 1, 1)
+	RegisterCommand("getdel", getDelString, 1, 1, 1)
+	RegisterCommand("getset", getSetString, 1, 1, 1)
+	RegisterCommand("msetnx", mSetNxString, 1, -1, 2)
+	RegisterCommand("lcs", lcsString, 1, 2, 1)
+	RegisterCommand("substr", getRangeString, 1, 1, 1)
+	RegisterCommand("setbit", setBitString, 1, 1, 1)
+	RegisterCommand("getbit", getBitString, 1, 1, 1)
+	RegisterCommand("bitcount", bitCountString, 1, 1, 1)
+	RegisterCommand("bitpos", bitPosString, 1, 1, 1)
+	RegisterCommand("bitop", bitOpString, 2, -1, 1)
+	RegisterCommand("bitfield", bitFieldString, 1, 1, 1)
+	RegisterCommand("bitfield_ro", bitFieldRoString, 1, 1, 1)
 }
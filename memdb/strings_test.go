@@ -2,6 +2,9 @@ package memdb
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -256,3 +259,118 @@ func TestStringsCommand10(t *testing.T) {
 	}
 
 }
+
+func TestStringsCommandErrors(t *testing.T) {
+	db := NewMemDb()
+
+	// INCR a value which is not an integer
+	setString(db, [][]byte{[]byte("set"), []byte("mykey"), []byte("Hello")})
+	incr := incrString(db, [][]byte{[]byte("incr"), []byte("mykey")})
+	if !bytes.Equal(incr.ToRedisFormat(), []byte("-ERR value is not an integer or out of range\r\n")) {
+		t.Error("INCR error is not correct")
+	}
+
+	// LPUSH against a string
+	lpush := lPushList(db, [][]byte{[]byte("lpush"), []byte("mykey"), []byte("a")})
+	if !bytes.Equal(lpush.ToRedisFormat(), []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")) {
+		t.Error("LPUSH error is not correct")
+	}
+
+	// INCRBY overflow
+	setString(db, [][]byte{[]byte("set"), []byte("counter"), []byte("9223372036854775807")})
+	incrby := incrByString(db, [][]byte{[]byte("incrby"), []byte("counter"), []byte("1")})
+	if !bytes.Equal(incrby.ToRedisFormat(), []byte("-ERR increment or decrement would overflow\r\n")) {
+		t.Error("INCRBY error is not correct")
+	}
+
+	// SET with an invalid expire time
+	set := setString(db, [][]byte{[]byte("set"), []byte("mykey"), []byte("Hello"), []byte("EX"), []byte("0")})
+	if !bytes.Equal(set.ToRedisFormat(), []byte("-ERR invalid expire time in 'set' command\r\n")) {
+		t.Error("SET error is not correct")
+	}
+
+	// wrong number of arguments
+	get := getString(db, [][]byte{[]byte("GET")})
+	if !bytes.Equal(get.ToRedisFormat(), []byte("-ERR wrong number of arguments for 'get' command\r\n")) {
+		t.Error("GET error is not correct")
+	}
+}
+
+func TestGetExDelSet(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+
+	for _, c := range []struct{ args, want string }{
+		{"set mykey Hello", "+OK\r\n"},
+		{"getex mykey", "$5\r\nHello\r\n"},
+		{"ttl mykey", ":-1\r\n"},
+		{"getex mykey EX 60", "$5\r\nHello\r\n"},
+		{"ttl mykey", ":60\r\n"},
+		{"getex mykey PX 30000", "$5\r\nHello\r\n"},
+		{"ttl mykey", ":30\r\n"},
+		{"getex mykey PERSIST", "$5\r\nHello\r\n"},
+		{"ttl mykey", ":-1\r\n"},
+		{"getex mykey EX 0", "-ERR invalid expire time in 'getex' command\r\n"},
+		{"getex mykey EX 10 PERSIST", "-ERR syntax error\r\n"},
+		{"getex mykey EX", "-ERR syntax error\r\n"},
+		{"getex mykey EX x", "-ERR value is not an integer or out of range\r\n"},
+		{"getex mykey EXAT 1", "$5\r\nHello\r\n"},
+		{"exists mykey", ":0\r\n"},
+		{"getex nosuchkey EX 10", "$-1\r\n"},
+
+		{"set mykey Hello EX 60", "+OK\r\n"},
+		{"getset mykey World", "$5\r\nHello\r\n"},
+		{"ttl mykey", ":-1\r\n"},
+		{"getset newkey v", "$-1\r\n"},
+		{"getdel mykey", "$5\r\nWorld\r\n"},
+		{"getdel mykey", "$-1\r\n"},
+		{"rpush list a", ":1\r\n"},
+		{"getex list", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"getdel list", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"getset list v", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"llen list", ":1\r\n"},
+
+		{"msetnx key1 Hello key2 there", ":1\r\n"},
+		{"msetnx key2 new key3 world", ":0\r\n"},
+		{"mget key1 key2 key3", "*3\r\n$5\r\nHello\r\n$5\r\nthere\r\n$-1\r\n"},
+		{"msetnx key3", "-ERR wrong number of arguments for 'msetnx' command\r\n"},
+		{"substr key1 1 3", "$3\r\nell\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
+
+// The expected replies are those of the examples in redis's documentation.
+func TestLcs(t *testing.T) {
+	dbs, exec := keyCommands(1)
+	db := dbs[0]
+	exec(db, "mset", "key1", "ohmytext", "key2", "mynewtext")
+
+	// match is the reply of the ranges of a match in both strings, followed by its length if given
+	match := func(a0, a1, b0, b1 string, matchLen ...string) string {
+		s := "*" + strconv.Itoa(2+len(matchLen)) + "\r\n*2\r\n:" + a0 + "\r\n:" + a1 + "\r\n*2\r\n:" + b0 + "\r\n:" + b1 + "\r\n"
+		for _, n := range matchLen {
+			s += ":" + n + "\r\n"
+		}
+		return s
+	}
+	for _, c := range []struct{ args, want string }{
+		{"lcs key1 key2", "$6\r\nmytext\r\n"},
+		{"lcs key1 key2 LEN", ":6\r\n"},
+		{"lcs key1 key2 IDX", "*4\r\n$7\r\nmatches\r\n*2\r\n" + match("4", "7", "5", "8") + match("2", "3", "0", "1") + "$3\r\nlen\r\n:6\r\n"},
+		{"lcs key1 key2 IDX MINMATCHLEN 4", "*4\r\n$7\r\nmatches\r\n*1\r\n" + match("4", "7", "5", "8") + "$3\r\nlen\r\n:6\r\n"},
+		{"lcs key1 key2 IDX MINMATCHLEN 4 WITHMATCHLEN", "*4\r\n$7\r\nmatches\r\n*1\r\n" + match("4", "7", "5", "8", "4") + "$3\r\nlen\r\n:6\r\n"},
+		{"lcs key1 nosuchkey", "$0\r\n\r\n"},
+		{"lcs key1 nosuchkey IDX", "*4\r\n$7\r\nmatches\r\n*0\r\n$3\r\nlen\r\n:0\r\n"},
+		{"lcs key1 key2 LEN IDX", "-ERR If you want both the length and indexes, please just use IDX.\r\n"},
+		{"lcs key1 key2 FOO", "-ERR syntax error\r\n"},
+		{"rpush list a", ":1\r\n"},
+		{"lcs key1 list", "-ERR The specified keys must contain string values\r\n"},
+	} {
+		if r := exec(db, strings.Fields(c.args)...); r != c.want {
+			t.Error(fmt.Sprintf("%s == %q, expect %q", c.args, r, c.want))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package memdb
+
+import (
+	"gRedis/resp"
+)
+
+// Sorted sets back the GEO commands for now; these are the commands redis points to for geo indexes,
+// removing members and looking at them.
+
+// lookupZSet returns the sorted set at key, nil if there is none, or a WRONGTYPE error.
+func (db *MemDb) lookupZSet(key string, write bool) (*ZSet, resp.RedisData) {
+	var v any
+	var ok bool
+	if write {
+		v, ok = db.lookupKeyWrite(key)
+	} else {
+		v, ok = db.lookupKeyRead(key)
+	}
+	if !ok {
+		return nil, nil
+	}
+	z, ok := v.(*ZSet)
+	if !ok {
+		return nil, resp.NewWrongTypeError()
+	}
+	return z, nil
+}
+
+func zCardZSet(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	z, errReply := db.lookupZSet(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return resp.NewInteger(0)
+	}
+	return resp.NewInteger(int64(z.Len()))
+}
+
+func zScoreZSet(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	z, errReply := db.lookupZSet(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return resp.NewBulkString(nil)
+	}
+	score, ok := z.Score(string(cmd[2]))
+	if !ok {
+		return resp.NewBulkString(nil)
+	}
+	return resp.NewBulkString(formatScore(score))
+}
+
+func zRemZSet(db *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) < 3 {
+		return resp.NewWrongArgsError(cmd[0])
+	}
+
+	key := string(cmd[1])
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	z, errReply := db.lookupZSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return resp.NewInteger(0)
+	}
+
+	res := 0
+	for _, member := range cmd[2:] {
+		res += z.Remove(string(member))
+	}
+	if z.Len() == 0 {
+		db.deleteKey(key, false)
+	}
+	return resp.NewInteger(int64(res))
+}
+
+func RegisterZSetCommands() {
+	RegisterCommand("zcard", zCardZSet, 1, 1, 1)
+	RegisterCommand("zrem", zRemZSet, 1, 1, 1)
+	RegisterCommand("zscore", zScoreZSet, 1, 1, 1)
+}
@@ -0,0 +1,169 @@
+package memdb
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// ZSet is a sorted set in redis's skiplist encoding: a map from members to their scores,
+// and a skiplist of the members ordered by score, then by member, to walk ranges of scores.
+type ZSet struct {
+	dict  map[string]float64
+	head  *zskiplistNode
+	level int
+}
+
+type zskiplistNode struct {
+	member string
+	score  float64
+	next   []*zskiplistNode
+}
+
+// as redis's ZSKIPLIST_MAXLEVEL and ZSKIPLIST_P
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+func NewZSet() *ZSet {
+	return &ZSet{
+		dict:  make(map[string]float64),
+		head:  &zskiplistNode{next: make([]*zskiplistNode, zskiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func (z *ZSet) Encoding() string {
+	return encodingSkiplist
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Add sets the score of member; it returns 1 if member was added, 0 if it was updated.
+func (z *ZSet) Add(member string, score float64) int {
+	if old, ok := z.dict[member]; ok {
+		if old != score {
+			z.delete(member, old)
+			z.insert(member, score)
+			z.dict[member] = score
+		}
+		return 0
+	}
+	z.dict[member] = score
+	z.insert(member, score)
+	return 1
+}
+
+// Remove returns 1 if member was removed, 0 if it wasn't a member.
+func (z *ZSet) Remove(member string) int {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0
+	}
+	delete(z.dict, member)
+	z.delete(member, score)
+	return 1
+}
+
+// seek returns the first node scoring min or more, or nil.
+func (z *ZSet) seek(min float64) *zskiplistNode {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].score < min {
+			x = x.next[i]
+		}
+	}
+	return x.next[0]
+}
+
+// scan calls fn on the members in order, until it returns false.
+func (z *ZSet) scan(fn func(member string, score float64) bool) {
+	for n := z.head.next[0]; n != nil; n = n.next[0] {
+		if !fn(n.member, n.score) {
+			return
+		}
+	}
+}
+
+func (z *ZSet) Clone() *ZSet {
+	c := NewZSet()
+	z.scan(func(member string, score float64) bool {
+		c.Add(member, score)
+		return true
+	})
+	return c
+}
+
+// zslBefore tells whether n sorts before the element of score and member.
+func zslBefore(n *zskiplistNode, score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// zslRandomLevel returns a level between 1 and zskiplistMaxLevel, each level zskiplistP as likely as the one below.
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+func (z *ZSet) insert(member string, score float64) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && zslBefore(x.next[i], score, member) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	level := zslRandomLevel()
+	for ; z.level < level; z.level++ {
+		update[z.level] = z.head
+	}
+	n := &zskiplistNode{member: member, score: score, next: make([]*zskiplistNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+}
+
+func (z *ZSet) delete(member string, score float64) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && zslBefore(x.next[i], score, member) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	x = x.next[0]
+	if x == nil || x.member != member {
+		return
+	}
+	for i := 0; i < len(x.next); i++ {
+		update[i].next[i] = x.next[i]
+	}
+	for z.level > 1 && z.head.next[z.level-1] == nil {
+		z.level--
+	}
+}
+
+// formatScore formats a score as redis does, with up to 17 significant digits.
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	}
+	return strconv.AppendFloat(nil, score, 'g', 17, 64)
+}
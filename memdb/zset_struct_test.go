@@ -0,0 +1,64 @@
+package memdb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestZSet(t *testing.T) {
+	z := NewZSet()
+	want := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(rand.Intn(500))
+		if rand.Intn(3) == 0 {
+			_, ok := want[member]
+			if removed := z.Remove(member); removed != 0 != ok {
+				t.Fatal(fmt.Sprintf("Remove(%s) == %d", member, removed))
+			}
+			delete(want, member)
+			continue
+		}
+		// few distinct scores, so members order ties
+		score := float64(rand.Intn(50))
+		_, ok := want[member]
+		if added := z.Add(member, score); added != 0 == ok {
+			t.Fatal(fmt.Sprintf("Add(%s) == %d", member, added))
+		}
+		want[member] = score
+	}
+	if z.Len() != len(want) {
+		t.Fatal(fmt.Sprintf("Len() == %d, expect %d", z.Len(), len(want)))
+	}
+
+	members := make([]string, 0, len(want))
+	for member := range want {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		return want[a] < want[b] || (want[a] == want[b] && a < b)
+	})
+	i := 0
+	z.Clone().scan(func(member string, score float64) bool {
+		if member != members[i] || score != want[member] {
+			t.Fatal(fmt.Sprintf("member %d == %s %v, expect %s %v", i, member, score, members[i], want[members[i]]))
+		}
+		i++
+		return true
+	})
+	if i != len(members) {
+		t.Error(fmt.Sprintf("scan visited %d members", i))
+	}
+
+	// seek lands on the first member of a score, past those of lower scores
+	for score := -1.0; score <= 50; score += 0.5 {
+		j := sort.Search(len(members), func(i int) bool { return want[members[i]] >= score })
+		n := z.seek(score)
+		if (n == nil) != (j == len(members)) || (n != nil && n.member != members[j]) {
+			t.Error(fmt.Sprintf("seek(%v) == %v, expect member %d", score, n, j))
+		}
+	}
+}
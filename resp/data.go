@@ -7,7 +7,6 @@ Redis clients use a protocol called REdis Serialization Protocol (RESP).
 */
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 )
@@ -19,6 +18,7 @@ var (
 type RedisData interface {
 	GetBytesData() []byte
 	ToRedisFormat() []byte
+	AppendTo(dst []byte) []byte // append the RESP encoding to dst
 	String() string
 }
 
@@ -60,7 +60,11 @@ func (s *SimpleString) GetBytesData() []byte {
 }
 
 func (s *SimpleString) ToRedisFormat() []byte {
-	return []byte(fmt.Sprintf("+%s%s", s.data, CRLF)) // +OK\r\n
+	return s.AppendTo(nil)
+}
+
+func (s *SimpleString) AppendTo(dst []byte) []byte {
+	return AppendSimpleString(dst, s.data) // +OK\r\n
 }
 
 func (s *SimpleString) String() string {
@@ -83,13 +87,32 @@ func (e *SimpleError) GetBytesData() []byte {
 }
 
 func (e *SimpleError) ToRedisFormat() []byte {
-	return []byte(fmt.Sprintf("-%s%s", e.data, CRLF)) // -Error message\r\n
+	return e.AppendTo(nil)
+}
+
+func (e *SimpleError) AppendTo(dst []byte) []byte {
+	return AppendError(dst, e.data) // -Error message\r\n
 }
 
 func (e *SimpleError) String() string {
 	return e.data
 }
 
+// Prefix returns the error kind, e.g. "ERR" or "WRONGTYPE".
+// The prefix is the first word of the message when it is all upper-case.
+func (e *SimpleError) Prefix() string {
+	word, _, _ := strings.Cut(e.data, " ")
+	if word == "" {
+		return ""
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'A' || word[i] > 'Z' {
+			return ""
+		}
+	}
+	return word
+}
+
 // Integer
 func NewInteger(data int64) *Integer {
 	return &Integer{
@@ -106,7 +129,11 @@ func (i *Integer) GetBytesData() []byte {
 }
 
 func (i *Integer) ToRedisFormat() []byte {
-	return []byte(fmt.Sprintf(":%s%s", strconv.FormatInt(i.data, 10), CRLF)) // [<+|->]<value>\r\n
+	return i.AppendTo(nil)
+}
+
+func (i *Integer) AppendTo(dst []byte) []byte {
+	return AppendInteger(dst, i.data) // [<+|->]<value>\r\n
 }
 
 func (i *Integer) String() string {
@@ -129,10 +156,11 @@ func (bs *BulkString) GetBytesData() []byte {
 }
 
 func (bs *BulkString) ToRedisFormat() []byte {
-	if bs.data == nil {
-		return []byte("$-1\r\n")
-	}
-	return []byte("$" + strconv.Itoa(len(bs.data)) + CRLF + string(bs.data) + CRLF)
+	return bs.AppendTo(nil)
+}
+
+func (bs *BulkString) AppendTo(dst []byte) []byte {
+	return AppendBulkString(dst, bs.data) // $5\r\nhello\r\n
 }
 
 func (bs *BulkString) String() string {
@@ -162,16 +190,20 @@ func (a *RedisArray) GetBytesData() []byte {
 }
 
 func (a *RedisArray) ToRedisFormat() []byte {
+	return a.AppendTo(nil)
+}
+
+// AppendTo encodes the elements in place, without a temporary slice per element.
+func (a *RedisArray) AppendTo(dst []byte) []byte {
 	if a.data == nil {
-		return []byte("*-1\r\n")
+		return AppendArrayHeader(dst, -1)
 	}
 
-	arr := []byte(fmt.Sprintf("*%d%s", len(a.data), CRLF))
-
+	dst = AppendArrayHeader(dst, len(a.data))
 	for i := range a.data {
-		arr = append(arr, a.data[i].ToRedisFormat()...)
+		dst = a.data[i].AppendTo(dst)
 	}
-	return arr
+	return dst
 }
 
 func (a *RedisArray) String() string {
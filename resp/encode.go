@@ -0,0 +1,47 @@
+package resp
+
+import "strconv"
+
+// Append-style encoders write RESP into dst and return the extended slice,
+// so replies can be built in a reused buffer without intermediate strings.
+
+func AppendSimpleString(dst []byte, s string) []byte {
+	dst = append(dst, '+')
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func AppendError(dst []byte, s string) []byte {
+	dst = append(dst, '-')
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func AppendInteger(dst []byte, n int64) []byte {
+	dst = append(dst, ':')
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, '\r', '\n')
+}
+
+// AppendBulkString encodes nil as the null bulk string "$-1\r\n".
+func AppendBulkString(dst []byte, b []byte) []byte {
+	if b == nil {
+		return append(dst, "$-1\r\n"...)
+	}
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(b)), 10)
+	dst = append(dst, '\r', '\n')
+	dst = append(dst, b...)
+	return append(dst, '\r', '\n')
+}
+
+// AppendArrayHeader writes "*<n>\r\n"; the n elements are appended by the caller.
+// A negative n encodes the null array.
+func AppendArrayHeader(dst []byte, n int) []byte {
+	if n < 0 {
+		return append(dst, "*-1\r\n"...)
+	}
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(n), 10)
+	return append(dst, '\r', '\n')
+}
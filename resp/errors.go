@@ -0,0 +1,96 @@
+package resp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reference: https://redis.io/docs/reference/protocol-spec/#simple-errors
+/*
+The first word of an error reply is an upper-case prefix naming the kind of error.
+Client libraries branch on it, e.g. redis-py raises ResponseError for ERR
+and go-redis exposes the prefix to callers, so replies must use the exact Redis wording.
+*/
+
+const (
+	ErrPrefix        = "ERR"        // generic error
+	WrongTypePrefix  = "WRONGTYPE"  // operation against a key holding the wrong kind of value
+	NoAuthPrefix     = "NOAUTH"     // authentication required
+	OOMPrefix        = "OOM"        // command not allowed when used memory > maxmemory
+	ExecAbortPrefix  = "EXECABORT"  // transaction discarded because of previous errors
+	BusyPrefix       = "BUSY"       // server busy running a script
+	BusyKeyPrefix    = "BUSYKEY"    // target key name already exists
+	NoScriptPrefix   = "NOSCRIPT"   // no matching script
+	NoProtoPrefix    = "NOPROTO"    // unsupported protocol version
+	LoadingPrefix    = "LOADING"    // server is loading the dataset in memory
+	ReadOnlyPrefix   = "READONLY"   // write against a read only replica
+	IOErrPrefix      = "IOERR"      // MIGRATE failed talking to the target instance
+	InvalidObjPrefix = "INVALIDOBJ" // a value is corrupted, e.g. a HyperLogLog
+	NoGroupPrefix    = "NOGROUP"    // no such stream consumer group
+	BusyGroupPrefix  = "BUSYGROUP"  // stream consumer group name already exists
+)
+
+// NewErrorReply builds an error reply: "<prefix> <msg>"
+func NewErrorReply(prefix string, msg string) *SimpleError {
+	return &SimpleError{
+		data: prefix + " " + msg,
+	}
+}
+
+// NewErrorReplyf builds an error reply with a formatted message.
+func NewErrorReplyf(prefix string, format string, a ...any) *SimpleError {
+	return NewErrorReply(prefix, fmt.Sprintf(format, a...))
+}
+
+// ERR wrong number of arguments for 'get' command
+func NewWrongArgsError(cmdName []byte) *SimpleError {
+	return NewErrorReplyf(ErrPrefix, "wrong number of arguments for '%s' command", strings.ToLower(string(cmdName)))
+}
+
+// ERR unknown command 'foo', with args beginning with: 'a' 'b'
+func NewUnknownCommandError(cmd [][]byte) *SimpleError {
+	var args strings.Builder
+	for _, arg := range cmd[1:] {
+		args.WriteString("'")
+		args.Write(arg)
+		args.WriteString("' ")
+	}
+	return NewErrorReplyf(ErrPrefix, "unknown command '%s', with args beginning with: %s", string(cmd[0]), args.String())
+}
+
+func NewWrongTypeError() *SimpleError {
+	return NewErrorReply(WrongTypePrefix, "Operation against a key holding the wrong kind of value")
+}
+
+func NewSyntaxError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "syntax error")
+}
+
+func NewNotIntegerError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "value is not an integer or out of range")
+}
+
+func NewNotFloatError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "value is not a valid float")
+}
+
+func NewNoSuchKeyError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "no such key")
+}
+
+func NewOutOfRangeError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "index out of range")
+}
+
+func NewOverflowError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "increment or decrement would overflow")
+}
+
+func NewNotPositiveError() *SimpleError {
+	return NewErrorReply(ErrPrefix, "value is out of range, must be positive")
+}
+
+// ERR invalid expire time in 'set' command
+func NewInvalidExpireError(cmdName []byte) *SimpleError {
+	return NewErrorReplyf(ErrPrefix, "invalid expire time in '%s' command", strings.ToLower(string(cmdName)))
+}
@@ -0,0 +1,38 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestErrorReply(t *testing.T) {
+	wrongArgs := NewWrongArgsError([]byte("GET"))
+	if !bytes.Equal(wrongArgs.ToRedisFormat(), []byte("-ERR wrong number of arguments for 'get' command\r\n")) {
+		t.Error(fmt.Sprintf("Error reply: %q", wrongArgs.ToRedisFormat()))
+	}
+	if wrongArgs.Prefix() != ErrPrefix {
+		t.Error(fmt.Sprintf("Error prefix: %s expect %s", wrongArgs.Prefix(), ErrPrefix))
+	}
+
+	wrongType := NewWrongTypeError()
+	if !bytes.Equal(wrongType.ToRedisFormat(), []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")) {
+		t.Error(fmt.Sprintf("Error reply: %q", wrongType.ToRedisFormat()))
+	}
+	if wrongType.Prefix() != WrongTypePrefix {
+		t.Error(fmt.Sprintf("Error prefix: %s expect %s", wrongType.Prefix(), WrongTypePrefix))
+	}
+
+	unknown := NewUnknownCommandError([][]byte{[]byte("foo"), []byte("a"), []byte("b")})
+	if unknown.String() != "ERR unknown command 'foo', with args beginning with: 'a' 'b' " {
+		t.Error(fmt.Sprintf("Error reply: %s", unknown.String()))
+	}
+
+	// errors without an upper-case first word have no prefix
+	if prefix := NewSimpleError("Error message").Prefix(); prefix != "" {
+		t.Error(fmt.Sprintf("Error prefix: %s expect empty", prefix))
+	}
+	if prefix := NewErrorReply(BusyKeyPrefix, "Target key name already exists.").Prefix(); prefix != BusyKeyPrefix {
+		t.Error(fmt.Sprintf("Error prefix: %s expect %s", prefix, BusyKeyPrefix))
+	}
+}
@@ -0,0 +1,148 @@
+package resp
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// reference: https://redis.io/docs/reference/protocol-spec/#inline-commands
+/*
+Inline commands are space-separated arguments on a single line, e.g. "SET a b\r\n".
+They let telnet, netcat or health-check probes talk to the server without RESP arrays.
+Quoting follows redis-cli:
+"hello\x41\n" -- double quotes support \n \r \t \b \a \\ \" and \xHH escapes
+'it\'s'       -- single quotes support only \' escape
+*/
+
+// RESP type flags; a line starting with anything else is an inline command
+func isRespType(b byte) bool {
+	switch b {
+	case '+', '-', ':', '$', '*':
+		return true
+	}
+	return false
+}
+
+// parseInline converts an inline command into a RedisArray of bulk strings.
+// nil is returned for an empty line.
+func parseInline(msg []byte) (RedisData, error) {
+	args, err := parseInlineArgs(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	arr := make([]RedisData, 0, len(args))
+	for _, arg := range args {
+		arr = append(arr, NewBulkString(arg))
+	}
+	return NewArray(arr), nil
+}
+
+// parseInlineArgs splits a line ending with "\r\n" or "\n" into arguments.
+// The arguments are copies, they don't alias msg.
+func parseInlineArgs(msg []byte) ([][]byte, error) {
+	msg = bytes.TrimSuffix(msg, []byte{'\n'})
+	msg = bytes.TrimSuffix(msg, []byte{'\r'})
+	return splitArgs(msg)
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// splitArgs splits a line into arguments the way redis-cli does (sdssplitargs).
+func splitArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	unbalanced := newProtocolError("unbalanced quotes in request")
+
+	i := 0
+	for {
+		// skip blanks
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var inDq, inSq bool
+		arg := make([]byte, 0)
+		for done := false; !done; {
+			if inDq {
+				if i == len(line) {
+					return nil, unbalanced
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					b, _ := hex.DecodeString(string(line[i+2 : i+4]))
+					arg = append(arg, b...)
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if line[i] == '"' {
+					// closing quote must be followed by a space or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, unbalanced
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else if inSq {
+				if i == len(line) {
+					return nil, unbalanced
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if line[i] == '\'' {
+					// closing quote must be followed by a space or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, unbalanced
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else {
+				if i == len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inDq = true
+				case '\'':
+					inSq = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, arg)
+	}
+}
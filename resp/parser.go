@@ -1,215 +1,541 @@
 package resp
 
 import (
-	"bufio"
-	"errors"
-	"gRedis/logger"
+	"bytes"
+	"fmt"
 	"io"
+	"math"
 	"strconv"
 )
 
-type RedisResp struct {
-	Data RedisData
-	Err  error
+const (
+	maxInlineSize     = 64 * 1024 // max length of a line without "\r\n", same as PROTO_INLINE_MAX_SIZE
+	readBufferSize    = 16 * 1024 // initial read buffer of a decoder, same as PROTO_IOBUF_LEN
+	maxIdleBufferSize = 64 * 1024 // a buffer grown beyond this is released once it is drained
+	maxNestingDepth   = 128       // nested arrays deeper than this are rejected by ReadValue
+	defaultMaxBulk    = 512 * 1024 * 1024
+	defaultMaxMulti   = 1024 * 1024
+	defaultQueryBuf   = 1024 * 1024 * 1024
+)
+
+// ProtoLimits bounds what a client may send, so a forged header can't exhaust memory.
+type ProtoLimits struct {
+	MaxBulkLen       int64 // proto-max-bulk-len
+	MaxMultiBulkLen  int64 // max number of elements in an array
+	QueryBufferLimit int64 // client-query-buffer-limit; max bytes buffered for one request
 }
-type readBuffer struct {
-	stringLen int64 // bulk string
-	multiLine bool
-	arrayLen  int
-	inArray   bool
-	arrayData *RedisArray
+
+var DefaultProtoLimits = ProtoLimits{
+	MaxBulkLen:       defaultMaxBulk,
+	MaxMultiBulkLen:  defaultMaxMulti,
+	QueryBufferLimit: defaultQueryBuf,
 }
 
-func ParseStream(reader io.Reader) <-chan *RedisResp {
-	ch := make(chan *RedisResp) // 双向通道，但是对外是只读通道
-	go parse(reader, ch)
-	return ch
+// ProtocolError means the stream can't be parsed any more; the connection should be closed.
+type ProtocolError struct {
+	msg string
 }
 
-func parse(reader io.Reader, ch chan *RedisResp) {
-	streamReader := bufio.NewReader(reader)
-	buf := &readBuffer{}
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
 
-	for {
-		var data RedisData
-		msg, err := readline(streamReader, buf)
+func newProtocolError(msg string) *ProtocolError {
+	return &ProtocolError{msg: msg}
+}
+
+// span locates an argument inside the request being parsed.
+type span struct {
+	start, end int
+}
+
+// CommandParser parses client requests out of a byte slice without copying them.
+// It remembers how far an incomplete request was parsed,
+// so feeding it more input doesn't scan the received part again.
+// The parser is independent of any io.Reader, so a caller owning the buffer (e.g. an event loop) can use it directly.
+type CommandParser struct {
+	limits  ProtoLimits
+	argc    int // bulk strings in the multibulk request being parsed, 0 before its header
+	bulkLen int // length of the next bulk string, -1 until its header is parsed
+	pos     int // bytes of the current request already parsed
+	spans   []span
+	args    [][]byte // reused by every request
+}
+
+func NewCommandParser(limits ProtoLimits) *CommandParser {
+	return &CommandParser{limits: limits, bulkLen: -1}
+}
+
+// Parse parses one request from the start of buf and returns its arguments and the bytes consumed.
+// n == 0 means the request is incomplete; call Parse again with the same bytes followed by more input.
+// A request without arguments, e.g. an empty line or "*0\r\n", consumes its bytes and returns no args.
+// args alias buf and are overwritten by the next call.
+func (p *CommandParser) Parse(buf []byte) (args [][]byte, n int, err error) {
+	if p.argc == 0 {
+		if len(buf) == 0 {
+			return nil, 0, nil
+		}
+		// anything but a multibulk is an inline command, like redis does
+		if buf[0] != '*' {
+			return p.parseInline(buf)
+		}
+
+		end, err := lineEnd(buf, 0)
 		if err != nil {
-			// read all and close channel
-			if err == io.EOF {
-				ch <- &RedisResp{Err: err}
-				close(ch)
-				return
+			return nil, 0, p.fail(err)
+		}
+		if end < 0 {
+			return p.incomplete(buf)
+		}
+		count, ok := parseLen(buf[1 : end-2])
+		if !ok || count < -1 {
+			return nil, 0, p.fail(newProtocolError("invalid multibulk length"))
+		}
+		if limit := p.limits.MaxMultiBulkLen; limit > 0 && count > limit {
+			return nil, 0, p.fail(newProtocolError("invalid multibulk length"))
+		}
+		// "*0" and "*-1" are empty requests
+		if count <= 0 {
+			return p.args[:0], end, nil
+		}
+		p.argc = int(count)
+		p.pos = end
+		p.bulkLen = -1
+		p.spans = p.spans[:0]
+	}
+
+	for len(p.spans) < p.argc {
+		if p.bulkLen < 0 {
+			if p.pos >= len(buf) {
+				return p.incomplete(buf)
 			}
-			logger.Error("Stream Error: ", err)
-			ch <- &RedisResp{Err: err}
-			buf = &readBuffer{}
-			continue
-		}
-		// make redis data
-		if buf.multiLine {
-			// bulk string msg
-			data, err = parseBulkString(msg)
-			buf.multiLine = false
-			buf.stringLen = 0
-		} else {
-			// bulk string header
-			if msg[0] == '$' {
-				err = parseBulkStringHeader(msg, buf)
-
-				if err != nil {
-					logger.Error("Stream Error: ", err)
-					ch <- &RedisResp{Err: err}
-					buf = &readBuffer{}
-				} else {
-					if buf.stringLen == -1 { // null bulk string
-						if buf.inArray { // Null elements in arrays; ["hello",nil,"world"]
-							buf.arrayData.data = append(buf.arrayData.data, NewBulkString(nil))
-							if len(buf.arrayData.data) == buf.arrayLen {
-								ch <- &RedisResp{Data: buf.arrayData}
-								buf.inArray = false
-								buf.arrayLen = 0
-								buf.arrayData = nil
-							}
-						} else {
-							ch <- &RedisResp{Data: NewBulkString(nil)}
-						}
-						buf.stringLen = 0
-					}
-				}
-				continue
+			if buf[p.pos] != '$' {
+				return nil, 0, p.fail(newProtocolError(fmt.Sprintf("expected '$', got '%c'", buf[p.pos])))
 			}
-
-			// array
-			if msg[0] == '*' {
-				err = parseArrayHeader(msg, buf)
-				if err != nil {
-					logger.Error("Stream Error: ", err)
-					ch <- &RedisResp{Err: err}
-					buf = &readBuffer{}
-				} else {
-					if buf.arrayLen == -1 { // null bulk string
-						ch <- &RedisResp{Data: NewArray(nil)}
-						buf.arrayLen = 0
-					} else if buf.arrayLen == 0 {
-						ch <- &RedisResp{Data: NewArray([]RedisData{})}
-					}
-				}
-				continue
+			end, err := lineEnd(buf, p.pos)
+			if err != nil {
+				return nil, 0, p.fail(err)
+			}
+			if end < 0 {
+				return p.incomplete(buf)
+			}
+			bulkLen, err := checkBulkLen(buf[p.pos+1:end-2], int64(end), &p.limits)
+			if err != nil {
+				return nil, 0, p.fail(err)
+			}
+			// a null bulk string is not a valid argument
+			if bulkLen < 0 {
+				return nil, 0, p.fail(newProtocolError("invalid bulk length"))
 			}
+			p.bulkLen = int(bulkLen)
+			p.pos = end
+		}
 
-			// simple message
-			data, err = parseSingleLine(msg)
+		if len(buf)-p.pos < p.bulkLen+2 {
+			return p.incomplete(buf)
+		}
+		end := p.pos + p.bulkLen
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			return nil, 0, p.fail(newProtocolError("stream msg invalid"))
 		}
+		p.spans = append(p.spans, span{start: p.pos, end: end})
+		p.pos = end + 2
+		p.bulkLen = -1
+	}
 
-		if err != nil {
-			logger.Error("Stream Error: ", err)
-			ch <- &RedisResp{Err: err}
-			buf = &readBuffer{}
-			continue
-		}
-
-		// send redis data
-		if buf.inArray { // send array data
-			buf.arrayData.data = append(buf.arrayData.data, data)
-			if len(buf.arrayData.data) == buf.arrayLen {
-				ch <- &RedisResp{Data: buf.arrayData}
-				buf.inArray = false
-				buf.arrayLen = 0
-				buf.arrayData = nil
-			}
-		} else { // send single data
-			ch <- &RedisResp{Data: data}
+	args = p.args[:0]
+	for _, s := range p.spans {
+		// cap the slice so appending to an argument can't overwrite the next one
+		args = append(args, buf[s.start:s.end:s.end])
+	}
+	p.args = args
+	n = p.pos
+	p.reset()
+	return args, n, nil
+}
+
+// Pending reports whether part of a request has been parsed and more input is expected.
+func (p *CommandParser) Pending() bool {
+	return p.argc > 0 || p.pos > 0
+}
+
+func (p *CommandParser) parseInline(buf []byte) ([][]byte, int, error) {
+	i := bytes.IndexByte(buf[p.pos:], '\n')
+	if i < 0 {
+		if len(buf) > maxInlineSize {
+			return nil, 0, p.fail(newProtocolError("too big inline request"))
 		}
+		// don't scan the received part again
+		p.pos = len(buf)
+		return p.incomplete(buf)
+	}
+
+	end := p.pos + i + 1
+	p.pos = 0
+	args, err := parseInlineArgs(buf[:end])
+	if err != nil {
+		return nil, 0, p.fail(err)
+	}
+	return args, end, nil
+}
+
+// incomplete asks for more input, unless the pending request is already too big.
+func (p *CommandParser) incomplete(buf []byte) ([][]byte, int, error) {
+	if limit := p.limits.QueryBufferLimit; limit > 0 && int64(len(buf)) > limit {
+		return nil, 0, p.fail(newProtocolError("query buffer limit exceeded"))
+	}
+	return nil, 0, nil
+}
+
+func (p *CommandParser) fail(err error) error {
+	p.reset()
+	return err
+}
+
+func (p *CommandParser) reset() {
+	p.argc = 0
+	p.bulkLen = -1
+	p.pos = 0
+	p.spans = p.spans[:0]
+}
+
+// Decoder reads RESP from a stream into a reused buffer.
+// Requests are pulled synchronously with ReadCommand, so no goroutine or channel is needed per connection.
+type Decoder struct {
+	rd     io.Reader
+	buf    []byte
+	r, w   int   // buf[r:w] is received but not yet consumed
+	err    error // sticky error of rd
+	parser CommandParser
+}
+
+func NewDecoder(rd io.Reader) *Decoder {
+	return NewDecoderWithLimits(rd, DefaultProtoLimits)
+}
+
+func NewDecoderWithLimits(rd io.Reader, limits ProtoLimits) *Decoder {
+	return &Decoder{
+		rd:     rd,
+		buf:    make([]byte, readBufferSize),
+		parser: CommandParser{limits: limits, bulkLen: -1},
 	}
 }
 
-func readline(reader *bufio.Reader, buf *readBuffer) (msg []byte, err error) {
-	if buf.multiLine { // read bulk string
-		msg = make([]byte, buf.stringLen+2)
-		_, err = io.ReadFull(reader, msg)
+// Buffered returns the number of received bytes not consumed yet.
+func (d *Decoder) Buffered() int {
+	return d.w - d.r
+}
+
+// NextCommand returns the next request if it is already buffered, without reading from the stream.
+// ok is false when more input is needed. The arguments are valid until the next call on d.
+func (d *Decoder) NextCommand() (args [][]byte, ok bool, err error) {
+	for d.r < d.w {
+		args, n, err := d.parser.Parse(d.buf[d.r:d.w])
 		if err != nil {
+			return nil, false, err
+		}
+		if n == 0 {
+			break
+		}
+		d.r += n
+		// skip empty requests
+		if len(args) > 0 {
+			return args, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// ReadCommand returns the next request, reading from the stream as needed.
+// The arguments alias the decoder's buffer and are valid until the next call on d.
+func (d *Decoder) ReadCommand() ([][]byte, error) {
+	for {
+		args, ok, err := d.NextCommand()
+		if err != nil || ok {
+			return args, err
+		}
+		if err := d.fill(); err != nil {
 			return nil, err
 		}
+	}
+}
 
-		if msg[len(msg)-1] != '\n' || msg[len(msg)-2] != '\r' {
-			return nil, errors.New("Protocol error: stream msg invalid")
+// ReadValue returns the next value of any RESP type, e.g. a reply read by a client.
+// A line that is not RESP is read as an inline command. The value doesn't alias the buffer.
+// Incomplete values are parsed again from the start when more input arrives,
+// which is fine for replies but makes ReadCommand the better choice for requests.
+func (d *Decoder) ReadValue() (RedisData, error) {
+	for {
+		for d.r < d.w {
+			data, n, err := d.parseValue(d.buf[d.r:d.w])
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				break
+			}
+			d.r += n
+			// skip empty inline lines
+			if data != nil {
+				return data, nil
+			}
 		}
-	} else {
-		// read simple string.
-		// \n is not allowed in simple string.
-		// \n can be terminator for read line
-		msg, err = reader.ReadBytes('\n')
-		if err != nil {
+		if limit := d.parser.limits.QueryBufferLimit; limit > 0 && int64(d.Buffered()) > limit {
+			return nil, newProtocolError("query buffer limit exceeded")
+		}
+		if err := d.fill(); err != nil {
 			return nil, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
-			return nil, errors.New("Protocol error: stream msg invalid")
+	}
+}
+
+func (d *Decoder) parseValue(buf []byte) (RedisData, int, error) {
+	if isRespType(buf[0]) {
+		data, end, err := parseValue(buf, 0, 0, &d.parser.limits)
+		if end < 0 {
+			return nil, 0, err
+		}
+		return data, end, err
+	}
+
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		if len(buf) > maxInlineSize {
+			return nil, 0, newProtocolError("too big inline request")
+		}
+		return nil, 0, nil
+	}
+	data, err := parseInline(buf[:i+1])
+	return data, i + 1, err
+}
+
+// fill reads more input after the unread bytes, growing the buffer when it is full.
+// Arguments returned earlier are invalid afterwards, since the unread bytes may be moved.
+func (d *Decoder) fill() error {
+	if d.err != nil {
+		return d.readErr()
+	}
+
+	if d.r == d.w {
+		d.r, d.w = 0, 0
+		// release the memory of a big request
+		if len(d.buf) > maxIdleBufferSize {
+			d.buf = make([]byte, readBufferSize)
+		}
+	} else if d.r > 0 {
+		copy(d.buf, d.buf[d.r:d.w])
+		d.w -= d.r
+		d.r = 0
+	}
+	// grow with the bytes actually received, not the length claimed by a header
+	if d.w == len(d.buf) {
+		buf := make([]byte, 2*len(d.buf))
+		copy(buf, d.buf[:d.w])
+		d.buf = buf
+	}
+
+	n, err := d.rd.Read(d.buf[d.w:])
+	d.w += n
+	if err != nil {
+		d.err = err
+		if n == 0 {
+			return d.readErr()
 		}
 	}
-	return msg, nil
+	return nil
 }
 
-func parseSingleLine(msg []byte) (RedisData, error) {
-	msgType := msg[0]
+// readErr reports a stream ending in the middle of a request as io.ErrUnexpectedEOF.
+func (d *Decoder) readErr() error {
+	if d.err == io.EOF && (d.r < d.w || d.parser.Pending()) {
+		return io.ErrUnexpectedEOF
+	}
+	return d.err
+}
+
+// parseValue parses the value starting at buf[pos] and returns it with the offset just past it.
+// The offset is -1 when the value is incomplete.
+func parseValue(buf []byte, pos int, depth int, limits *ProtoLimits) (RedisData, int, error) {
+	if pos >= len(buf) {
+		return nil, -1, nil
+	}
+	msgType := buf[pos]
+	if !isRespType(msgType) {
+		return nil, -1, newProtocolError(fmt.Sprintf("expected '$', got '%c'", msgType))
+	}
+	end, err := lineEnd(buf, pos)
+	if err != nil || end < 0 {
+		return nil, -1, err
+	}
 	// read header; discard flag and "\r\n"
-	msgData := string(msg[1 : len(msg)-2])
-	var data RedisData
+	msg := buf[pos+1 : end-2]
 
 	switch msgType {
 	case '+':
-		data = NewSimpleString(msgData)
+		return NewSimpleString(string(msg)), end, nil
 	case '-':
-		data = NewSimpleError(msgData)
+		return NewSimpleError(string(msg)), end, nil
 	case ':':
-		integerData, err := strconv.ParseInt(msgData, 10, 64)
+		n, err := strconv.ParseInt(string(msg), 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, -1, newProtocolError("invalid integer")
+		}
+		return NewInteger(n), end, nil
+	case '$':
+		n, err := checkBulkLen(msg, int64(end), limits)
+		if err != nil {
+			return nil, -1, err
+		}
+		if n == -1 { // null bulk string
+			return NewBulkString(nil), end, nil
+		}
+		if int64(len(buf)-end) < n+2 {
+			return nil, -1, nil
+		}
+		stop := end + int(n)
+		if buf[stop] != '\r' || buf[stop+1] != '\n' {
+			return nil, -1, newProtocolError("stream msg invalid")
+		}
+		return NewBulkString(bytes.Clone(buf[end:stop])), stop + 2, nil
+	default: // '*'
+		n, ok := parseLen(msg)
+		if !ok || n < -1 {
+			return nil, -1, newProtocolError("invalid multibulk length")
+		}
+		if limit := limits.MaxMultiBulkLen; limit > 0 && n > limit {
+			return nil, -1, newProtocolError("invalid multibulk length")
+		}
+		if n == -1 { // null array
+			return NewArray(nil), end, nil
+		}
+		if depth >= maxNestingDepth {
+			return nil, -1, newProtocolError("too deeply nested")
+		}
+
+		// don't trust the header for the capacity
+		capacity := n
+		if capacity > 1024 {
+			capacity = 1024
 		}
-		data = NewInteger(integerData)
+		arr := make([]RedisData, 0, capacity)
+		next := end
+		for i := int64(0); i < n; i++ {
+			var item RedisData
+			item, next, err = parseValue(buf, next, depth+1, limits)
+			if err != nil || next < 0 {
+				return nil, -1, err
+			}
+			arr = append(arr, item)
+		}
+		return NewArray(arr), next, nil
 	}
+}
 
-	return data, nil
+// checkBulkLen parses a bulk string header and applies the limits.
+// queryLen is the size of the request up to the header.
+func checkBulkLen(msg []byte, queryLen int64, limits *ProtoLimits) (int64, error) {
+	n, ok := parseLen(msg)
+	if !ok || n < -1 {
+		return 0, newProtocolError("invalid bulk length")
+	}
+	if limit := limits.MaxBulkLen; limit > 0 && n > limit {
+		return 0, newProtocolError("invalid bulk length")
+	}
+	if limit := limits.QueryBufferLimit; limit > 0 && queryLen+n > limit {
+		return 0, newProtocolError("query buffer limit exceeded")
+	}
+	return n, nil
 }
 
-func parseBulkStringHeader(msg []byte, buf *readBuffer) error {
-	// read header; discard flag and "\r\n"
-	stringLen, err := strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
-	if stringLen < -1 || err != nil {
-		return errors.New("Protocol error: " + string(msg))
+// lineEnd returns the offset just past the "\r\n" ending the line at buf[start],
+// or -1 when the line is incomplete.
+func lineEnd(buf []byte, start int) (int, error) {
+	i := bytes.IndexByte(buf[start:], '\n')
+	if i < 0 {
+		// a line without "\r\n" must not grow forever
+		if len(buf)-start > maxInlineSize {
+			return -1, tooBigLineError(buf[start])
+		}
+		return -1, nil
+	}
+	if i > maxInlineSize {
+		return -1, tooBigLineError(buf[start])
+	}
+	// the flag, then "\r\n" at least
+	if i < 2 || buf[start+i-1] != '\r' {
+		return -1, newProtocolError("stream msg invalid")
 	}
+	return start + i + 1, nil
+}
 
-	// len == -1 or len == 0 or len > 0
-	buf.stringLen = stringLen
+// parseLen parses the decimal length of a header without allocating.
+func parseLen(b []byte) (int64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	neg := b[0] == '-'
+	if neg {
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, false
+		}
+	}
 
-	// only for len > -1
-	if stringLen > -1 {
-		buf.multiLine = true
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		d := int64(c - '0')
+		if n > (math.MaxInt64-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
 	}
-	return nil
+	if neg {
+		n = -n
+	}
+	return n, true
 }
 
-func parseBulkString(msg []byte) (RedisData, error) {
-	// read data; discard "\r\n"
-	msgData := msg[:len(msg)-2]
-	data := NewBulkString(msgData)
+func tooBigLineError(msgType byte) *ProtocolError {
+	switch msgType {
+	case '*':
+		return newProtocolError("too big mbulk count string")
+	case '$':
+		return newProtocolError("too big bulk count string")
+	default:
+		return newProtocolError("too big inline request")
+	}
+}
 
-	return data, nil
+type RedisResp struct {
+	Data RedisData
+	Err  error
 }
 
-func parseArrayHeader(msg []byte, buf *readBuffer) error {
-	// read header; discard flag and "\r\n"
-	arrayLen, err := strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
-	if arrayLen < -1 || err != nil {
-		return errors.New("Protocol error: " + string(msg))
-	}
+func ParseStream(reader io.Reader) <-chan *RedisResp {
+	return ParseStreamWithLimits(reader, DefaultProtoLimits)
+}
 
-	// len == -1 or len == 0 or len > 0
-	buf.arrayLen = int(arrayLen)
+// ParseStreamWithLimits sends the values read from reader to a channel, see Decoder.ReadValue.
+// It costs a goroutine per stream; servers should pull requests from a Decoder instead.
+func ParseStreamWithLimits(reader io.Reader, limits ProtoLimits) <-chan *RedisResp {
+	ch := make(chan *RedisResp) // 双向通道，但是对外是只读通道
+	go parse(NewDecoderWithLimits(reader, limits), ch)
+	return ch
+}
 
-	// only for len > 0
-	if arrayLen > 0 {
-		buf.inArray = true
-		buf.arrayData = NewArray([]RedisData{})
+func parse(dec *Decoder, ch chan *RedisResp) {
+	// the stream is out of sync after an error, so stop parsing
+	defer close(ch)
+
+	for {
+		data, err := dec.ReadValue()
+		if err != nil {
+			ch <- &RedisResp{Err: err}
+			return
+		}
+		ch <- &RedisResp{Data: data}
 	}
-	return nil
 }
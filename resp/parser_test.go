@@ -1,11 +1,11 @@
 package resp
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestParseStream(t *testing.T) {
@@ -106,10 +106,9 @@ func TestParseStream(t *testing.T) {
 	}
 
 	// Nested Array
-	b = []byte("*2\r\n*3\r\n:1\r\n:2\r\n:3\r\n*2\r\n+Hello\r\n-World\r\n") // send [1 2 3] and [Hello World] respectively
+	b = []byte("*2\r\n*3\r\n:1\r\n:2\r\n:3\r\n*2\r\n+Hello\r\n-World\r\n") // send [[1 2 3] [Hello World]]
 	reader = bytes.NewReader(b)
 	ch = ParseStream(reader)
-	k := 0
 	for resp := range ch {
 		if resp.Err != nil {
 			if resp.Err != io.EOF {
@@ -118,62 +117,56 @@ func TestParseStream(t *testing.T) {
 			break
 		}
 
-		arr := resp.Data.(*RedisArray)
-		if k == 0 {
-			if arr.GetData()[0].(*Integer).GetData() != 1 {
-				t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[0].(*Integer).GetData(), 1))
-			}
-			if arr.GetData()[1].(*Integer).GetData() != 2 {
-				t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[1].(*Integer).GetData(), 2))
-			}
-			if arr.GetData()[2].(*Integer).GetData() != 3 {
-				t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[2].(*Integer).GetData(), 3))
-			}
+		outer := resp.Data.(*RedisArray).GetData()
+		if len(outer) != 2 {
+			t.Fatal(fmt.Sprintf("Stream error. msg: %v expect 2 elements", outer))
 		}
-		if k == 1 {
-			if arr.GetData()[0].(*SimpleString).GetData() != "Hello" {
-				t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[0].(*SimpleString).GetData(), "Hello"))
+		for k := range outer {
+			arr := outer[k].(*RedisArray)
+			if k == 0 {
+				if arr.GetData()[0].(*Integer).GetData() != 1 {
+					t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[0].(*Integer).GetData(), 1))
+				}
+				if arr.GetData()[1].(*Integer).GetData() != 2 {
+					t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[1].(*Integer).GetData(), 2))
+				}
+				if arr.GetData()[2].(*Integer).GetData() != 3 {
+					t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[2].(*Integer).GetData(), 3))
+				}
 			}
-			if arr.GetData()[1].(*SimpleError).GetData() != "World" {
-				t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[1].(*SimpleError).GetData(), "World"))
+			if k == 1 {
+				if arr.GetData()[0].(*SimpleString).GetData() != "Hello" {
+					t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[0].(*SimpleString).GetData(), "Hello"))
+				}
+				if arr.GetData()[1].(*SimpleError).GetData() != "World" {
+					t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", arr.GetData()[1].(*SimpleError).GetData(), "World"))
+				}
 			}
 		}
-		k++
 	}
 }
 
-func TestReadLine(t *testing.T) {
+func TestLineEnd(t *testing.T) {
 	b := []byte("+OK\r\n:-2\r\n")
-	ioReader := bytes.NewReader(b)
-	bufioReader := bufio.NewReader(ioReader)
-	buf := &readBuffer{}
 
 	// simple message
-	for i := 0; ; i++ {
-		msg, err := readline(bufioReader, buf)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			t.Error("Stream error")
-		}
-		if !bytes.Equal(msg, b[i*5:(i+1)*5]) {
-			t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", msg, b[i*5:(i+1)*5]))
+	for i := 0; i < 2; i++ {
+		end, err := lineEnd(b, i*5)
+		if err != nil || end != (i+1)*5 {
+			t.Error(fmt.Sprintf("Stream error. end: %d expect %d, err: %v", end, (i+1)*5, err))
 		}
 	}
 
-	// bulk string message
-	buf.stringLen = 7
-	buf.multiLine = true
-	b = []byte("1\r\n2\n34\r\n")
-	ioReader = bytes.NewReader(b)
-	bufioReader = bufio.NewReader(ioReader)
-	msg, err := readline(bufioReader, buf)
-	if err != nil {
-		t.Error(err)
+	// incomplete line
+	end, err := lineEnd([]byte("+OK\r"), 0)
+	if end != -1 || err != nil {
+		t.Error(fmt.Sprintf("Stream error. end: %d, err: %v", end, err))
 	}
-	if !bytes.Equal(msg, b) {
-		t.Error(fmt.Sprintf("Stream error. msg: %v expect %v", msg, b))
+
+	// RESP lines must end with "\r\n"
+	_, err = lineEnd([]byte("+OK\n"), 0)
+	if err == nil {
+		t.Error("Stream error. expect error for bare \\n")
 	}
 }
 
@@ -187,80 +180,400 @@ func TestParseSingleLine(t *testing.T) {
 	i1 := NewInteger(1000)
 	i2 := NewInteger(-20)
 
-	data1, err := parseSingleLine(msg1)
+	data1, _, err := parseValue(msg1, 0, 0, &DefaultProtoLimits)
 	if data1.(*SimpleString).data != ss.data || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg1)))
 	}
-	data2, err := parseSingleLine(msg2)
+	data2, _, err := parseValue(msg2, 0, 0, &DefaultProtoLimits)
 	if data2.(*SimpleError).data != se.data || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg2)))
 	}
-	data3, err := parseSingleLine(msg3)
+	data3, _, err := parseValue(msg3, 0, 0, &DefaultProtoLimits)
 	if data3.(*Integer).data != i1.data || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg3)))
 	}
-	data4, err := parseSingleLine(msg4)
-	if data4.(*Integer).data != i2.data || err != nil {
+	data4, end, err := parseValue(msg4, 0, 0, &DefaultProtoLimits)
+	if data4.(*Integer).data != i2.data || end != len(msg4) || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg4)))
 	}
 }
 
-func TestParseBulkStringHeader(t *testing.T) {
-	msg1 := []byte("$5\r\n")
-	msg2 := []byte("$-1\r\n")
-	msg3 := []byte("$-2\r\n")
-	buf1 := &readBuffer{}
-	buf2 := &readBuffer{}
-	buf3 := &readBuffer{}
-
-	err := parseBulkStringHeader(msg1, buf1)
-	if buf1.stringLen != 5 || !buf1.multiLine || err != nil {
-		t.Error(fmt.Sprintf("Protocol error: %s", string(msg1)))
-	}
-	err = parseBulkStringHeader(msg2, buf2)
-	if buf2.stringLen != -1 || buf2.multiLine || err != nil {
-		t.Error(fmt.Sprintf("Protocol error: %s", string(msg2)))
+func TestCheckBulkLen(t *testing.T) {
+	cases := []struct {
+		msg    string
+		expect int64
+		ok     bool
+	}{
+		{"5", 5, true},
+		{"-1", -1, true},
+		{"0", 0, true},
+		{"-2", 0, false},
+		{"", 0, false},
+		{"1a", 0, false},
+		{"99999999999999999999", 0, false},
 	}
-	err = parseBulkStringHeader(msg3, buf3)
-	if buf3.stringLen != 0 || buf3.multiLine || err == nil {
-		t.Error(fmt.Sprintf("Protocol error: %s", string(msg3)))
+	for _, c := range cases {
+		n, err := checkBulkLen([]byte(c.msg), 0, &DefaultProtoLimits)
+		if (err == nil) != c.ok || n != c.expect {
+			t.Error(fmt.Sprintf("Protocol error: %q, len: %d, err: %v", c.msg, n, err))
+		}
 	}
 }
 
 func TestParseBulkString(t *testing.T) {
-	msg1 := []byte("hello\r\n")
-	msg2 := []byte("\r\n")
+	msg1 := []byte("$5\r\nhello\r\n")
+	msg2 := []byte("$0\r\n\r\n")
+	msg3 := []byte("$7\r\n1\r\n2\n34\r\n") // "\r\n" inside a bulk string is data
 	bs1 := NewBulkString([]byte("hello"))
 	bs2 := NewBulkString([]byte(""))
+	bs3 := NewBulkString([]byte("1\r\n2\n34"))
 
-	data1, err := parseBulkString(msg1)
-	if !bytes.Equal(data1.(*BulkString).data, bs1.data) || err != nil {
-		t.Error(fmt.Sprintf("Protocol error. data: %v, expect: %v", data1.(*BulkString).data, bs1.data))
-	}
-	data2, err := parseBulkString(msg2)
-	if !bytes.Equal(data2.(*BulkString).data, bs2.data) || err != nil {
-		t.Error(fmt.Sprintf("Protocol error. data: %v, expect: %v", data2.(*BulkString).data, bs2.data))
+	for i, c := range []struct {
+		msg    []byte
+		expect *BulkString
+	}{{msg1, bs1}, {msg2, bs2}, {msg3, bs3}} {
+		data, end, err := parseValue(c.msg, 0, 0, &DefaultProtoLimits)
+		if err != nil || end != len(c.msg) || !bytes.Equal(data.(*BulkString).data, c.expect.data) {
+			t.Error(fmt.Sprintf("Protocol error. case %d data: %v, expect: %v", i, data, c.expect.data))
+		}
+		// incomplete
+		_, end, err = parseValue(c.msg[:len(c.msg)-1], 0, 0, &DefaultProtoLimits)
+		if end != -1 || err != nil {
+			t.Error(fmt.Sprintf("Protocol error. case %d incomplete end: %d, err: %v", i, end, err))
+		}
 	}
 }
 
 func TestParseArrayHeader(t *testing.T) {
 	msg1 := []byte("*0\r\n")
-	msg2 := []byte("*1\r\n")
+	msg2 := []byte("*1\r\n:1\r\n")
 	msg3 := []byte("*-1\r\n")
-	buf1 := &readBuffer{}
-	buf2 := &readBuffer{}
-	buf3 := &readBuffer{}
 
-	err := parseArrayHeader(msg1, buf1)
-	if buf1.arrayLen != 0 || buf1.inArray || err != nil {
+	data, _, err := parseValue(msg1, 0, 0, &DefaultProtoLimits)
+	if arr := data.(*RedisArray); arr.data == nil || len(arr.data) != 0 || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg1)))
 	}
-	err = parseArrayHeader(msg2, buf2)
-	if buf2.arrayLen != 1 || !buf2.inArray || buf2.arrayData == nil || err != nil {
+	data, _, err = parseValue(msg2, 0, 0, &DefaultProtoLimits)
+	if arr := data.(*RedisArray); len(arr.data) != 1 || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg2)))
 	}
-	err = parseArrayHeader(msg3, buf3)
-	if buf3.arrayLen != -1 || buf3.inArray || err != nil {
+	data, _, err = parseValue(msg3, 0, 0, &DefaultProtoLimits)
+	if arr := data.(*RedisArray); arr.data != nil || err != nil {
 		t.Error(fmt.Sprintf("Protocol error: %s", string(msg3)))
 	}
+
+	// nesting is bounded
+	deep := bytes.Repeat([]byte("*1\r\n"), maxNestingDepth+1)
+	_, _, err = parseValue(deep, 0, 0, &DefaultProtoLimits)
+	if err == nil {
+		t.Error("Protocol error: expect error for deeply nested arrays")
+	}
+}
+
+func TestParseStreamLimits(t *testing.T) {
+	limits := ProtoLimits{MaxBulkLen: 16, MaxMultiBulkLen: 4, QueryBufferLimit: 64}
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{"$9999999999\r\n", "Protocol error: invalid bulk length"},
+		{"*3\r\n$17\r\n", "Protocol error: invalid bulk length"},
+		{"*5\r\n", "Protocol error: invalid multibulk length"},
+		{"*-2\r\n", "Protocol error: invalid multibulk length"},
+		{"*4\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\n", "Protocol error: query buffer limit exceeded"},
+	}
+
+	for _, c := range cases {
+		ch := ParseStreamWithLimits(bytes.NewReader([]byte(c.input)), limits)
+		var resps []*RedisResp
+		for resp := range ch {
+			resps = append(resps, resp)
+		}
+		// a protocol error is the last message of the stream
+		last := resps[len(resps)-1]
+		protoErr, ok := last.Err.(*ProtocolError)
+		if !ok || protoErr.Error() != c.expect {
+			t.Error(fmt.Sprintf("Stream error. input: %.32q, err: %v, expect: %s", c.input, last.Err, c.expect))
+		}
+	}
+
+	// a header line is bounded even when the query buffer is not
+	var last *RedisResp
+	for resp := range ParseStream(bytes.NewReader([]byte("*1\r\n$" + string(bytes.Repeat([]byte("1"), 2*maxInlineSize))))) {
+		last = resp
+	}
+	if last.Err == nil || last.Err.Error() != "Protocol error: too big bulk count string" {
+		t.Error(fmt.Sprintf("Stream error. err: %v", last.Err))
+	}
+
+	// requests within the limits are parsed
+	ch := ParseStreamWithLimits(bytes.NewReader([]byte("*2\r\n$3\r\nGET\r\n$16\r\naaaaaaaaaaaaaaaa\r\n")), limits)
+	resp := <-ch
+	if resp.Err != nil || resp.Data.String() != "GET aaaaaaaaaaaaaaaa" {
+		t.Error(fmt.Sprintf("Stream error. data: %v, err: %v", resp.Data, resp.Err))
+	}
+}
+
+func TestDecoderLargeBulk(t *testing.T) {
+	// payloads larger than the buffer are received in small reads and grow the buffer
+	b := bytes.Repeat([]byte("x"), 3*maxIdleBufferSize+7)
+	req := append([]byte(fmt.Sprintf("*2\r\n$3\r\nSET\r\n$%d\r\n", len(b))), b...)
+	req = append(req, "\r\n*1\r\n$4\r\nPING\r\n"...)
+	dec := NewDecoder(iotest.HalfReader(bytes.NewReader(req)))
+	args, err := dec.ReadCommand()
+	if err != nil || len(args) != 2 || !bytes.Equal(args[1], b) {
+		t.Fatal(fmt.Sprintf("read bulk error: %v", err))
+	}
+
+	// the grown buffer is released once drained
+	args, err = dec.ReadCommand()
+	if err != nil || string(args[0]) != "PING" {
+		t.Fatal(fmt.Sprintf("read command error: %q, %v", args, err))
+	}
+	dec.fill()
+	if len(dec.buf) != readBufferSize {
+		t.Error(fmt.Sprintf("buffer size %d expect %d", len(dec.buf), readBufferSize))
+	}
+
+	// a header claiming more bytes than sent fails without allocating all of them
+	dec = NewDecoder(bytes.NewReader([]byte("*1\r\n$536870912\r\nxxxxxxxxxx")))
+	_, err = dec.ReadCommand()
+	if err != io.ErrUnexpectedEOF || len(dec.buf) != readBufferSize {
+		t.Error(fmt.Sprintf("read bulk error: %v, buffer size %d", err, len(dec.buf)))
+	}
+}
+
+func TestCommandParser(t *testing.T) {
+	req := []byte("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$5\r\nhello\r\n")
+	expect := "[SET a hello]"
+
+	// whole request at once
+	p := NewCommandParser(DefaultProtoLimits)
+	args, n, err := p.Parse(req)
+	if err != nil || n != len(req) || fmt.Sprintf("%s", args) != expect {
+		t.Error(fmt.Sprintf("Parse error. args: %q, n: %d, err: %v", args, n, err))
+	}
+	// arguments alias the input
+	if &args[2][0] != &req[len(req)-7] {
+		t.Error("Parse error. args are copied")
+	}
+
+	// byte by byte, the request is complete only with the last byte
+	for i := 0; i < len(req); i++ {
+		args, n, err = p.Parse(req[:i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (n != 0) != (i == len(req)-1) {
+			t.Fatal(fmt.Sprintf("Parse error. byte %d, n: %d", i, n))
+		}
+	}
+	if fmt.Sprintf("%s", args) != expect {
+		t.Error(fmt.Sprintf("Parse error. args: %q", args))
+	}
+
+	// empty requests consume bytes without arguments
+	for _, b := range []string{"*0\r\n", "*-1\r\n", "\r\n"} {
+		args, n, err = p.Parse([]byte(b))
+		if err != nil || n != len(b) || len(args) != 0 {
+			t.Error(fmt.Sprintf("Parse error. input: %q, args: %q, n: %d, err: %v", b, args, n, err))
+		}
+	}
+
+	// null bulk strings are not arguments
+	_, _, err = p.Parse([]byte("*1\r\n$-1\r\n"))
+	if err == nil || err.Error() != "Protocol error: invalid bulk length" {
+		t.Error(fmt.Sprintf("Parse error. err: %v", err))
+	}
+
+	// limits are checked before the request is complete
+	p = NewCommandParser(ProtoLimits{MaxBulkLen: 16, MaxMultiBulkLen: 4, QueryBufferLimit: 64})
+	for _, c := range []struct {
+		input  string
+		expect string
+	}{
+		{"*3\r\n$17\r\n", "Protocol error: invalid bulk length"},
+		{"*5\r\n", "Protocol error: invalid multibulk length"},
+		{"*4\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\naaaaaaaaaaaaaaaa\r\n$16\r\n", "Protocol error: query buffer limit exceeded"},
+		{"*2\r\n$3\r\nGET\r\nfoo\r\n", "Protocol error: expected '$', got 'f'"},
+	} {
+		_, _, err = p.Parse([]byte(c.input))
+		if err == nil || err.Error() != c.expect {
+			t.Error(fmt.Sprintf("Parse error. input: %q, err: %v, expect: %s", c.input, err, c.expect))
+		}
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	b := []byte("PING\r\n\r\nSET a b\nset \"hello world\" 'it\\'s'\r\n  get   \"\\x41\\n\"  \r\n")
+	expect := [][]string{{"PING"}, {"SET", "a", "b"}, {"set", "hello world", "it's"}, {"get", "A\n"}}
+
+	ch := ParseStream(bytes.NewReader(b))
+	i := 0
+	for resp := range ch {
+		if resp.Err != nil {
+			if resp.Err != io.EOF {
+				t.Error(resp.Err)
+			}
+			break
+		}
+
+		cmd := resp.Data.(*RedisArray).ToStringCommand()
+		if fmt.Sprint(cmd) != fmt.Sprint(expect[i]) {
+			t.Error(fmt.Sprintf("Inline error. cmd: %q expect %q", cmd, expect[i]))
+		}
+		i++
+	}
+	if i != len(expect) {
+		t.Error(fmt.Sprintf("Inline error. got %d commands, expect %d", i, len(expect)))
+	}
+
+	// inline commands and RESP arrays can be mixed
+	ch = ParseStream(bytes.NewReader([]byte("PING\r\n*1\r\n$4\r\nPING\r\n")))
+	for i := 0; i < 2; i++ {
+		resp := <-ch
+		if resp.Err != nil || resp.Data.String() != "PING" {
+			t.Error(fmt.Sprintf("Inline error. data: %v, err: %v", resp.Data, resp.Err))
+		}
+	}
+}
+
+func TestParseInlineErrors(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{"SET a \"b\r\n", "Protocol error: unbalanced quotes in request"},
+		{"SET a 'b'c\r\n", "Protocol error: unbalanced quotes in request"},
+		{"*2\r\n$3\r\nGET\r\nfoo\r\n", "Protocol error: expected '$', got 'f'"},
+		{string(bytes.Repeat([]byte("a"), 2*maxInlineSize)), "Protocol error: too big inline request"},
+	}
+
+	for _, c := range cases {
+		var last *RedisResp
+		for resp := range ParseStream(bytes.NewReader([]byte(c.input))) {
+			last = resp
+		}
+		if last.Err == nil || last.Err.Error() != c.expect {
+			t.Error(fmt.Sprintf("Inline error. input: %.32q, err: %v, expect: %s", c.input, last.Err, c.expect))
+		}
+	}
+}
+
+func TestDecoderNextCommand(t *testing.T) {
+	reader, writer := io.Pipe()
+	dec := NewDecoder(reader)
+
+	// nothing is buffered before the first read
+	if _, ok, err := dec.NextCommand(); ok || err != nil {
+		t.Fatal(fmt.Sprintf("expected no command, ok: %v, err: %v", ok, err))
+	}
+
+	go writer.Write([]byte("PING\r\n*1\r\n$4\r\nPING\r\n*1\r\n$4\r"))
+	args, err := dec.ReadCommand()
+	if err != nil || string(args[0]) != "PING" {
+		t.Fatal(fmt.Sprintf("expected PING, got %q, %v", args, err))
+	}
+	// the second request is served from the buffer, the third one is incomplete
+	args, ok, err := dec.NextCommand()
+	if !ok || err != nil || string(args[0]) != "PING" {
+		t.Fatal(fmt.Sprintf("expected buffered PING, got %q, %v", args, err))
+	}
+	if _, ok, _ = dec.NextCommand(); ok {
+		t.Fatal("expected the split request to be incomplete")
+	}
+
+	go writer.Write([]byte("\nPING\r\n"))
+	args, err = dec.ReadCommand()
+	if err != nil || string(args[0]) != "PING" {
+		t.Fatal(fmt.Sprintf("expected the split request, got %q, %v", args, err))
+	}
+	writer.Close()
+	if _, err = dec.ReadCommand(); err != io.EOF {
+		t.Fatal(fmt.Sprintf("expected EOF, got %v", err))
+	}
+}
+
+func TestAppendTo(t *testing.T) {
+	arr := NewArray([]RedisData{
+		NewSimpleString("OK"),
+		NewSimpleError("ERR boom"),
+		NewInteger(-15),
+		NewBulkString([]byte("hello")),
+		NewBulkString(nil),
+		NewArray(nil),
+		NewArray([]RedisData{}),
+	})
+	expect := "*7\r\n+OK\r\n-ERR boom\r\n:-15\r\n$5\r\nhello\r\n$-1\r\n*-1\r\n*0\r\n"
+
+	if got := string(arr.ToRedisFormat()); got != expect {
+		t.Error(fmt.Sprintf("encode error. got %q expect %q", got, expect))
+	}
+	// appending keeps what is already in dst
+	if got := string(arr.AppendTo([]byte("x"))); got != "x"+expect {
+		t.Error(fmt.Sprintf("encode error. got %q", got))
+	}
+}
+
+// loopReader replays b forever, standing in for a connection that is never drained.
+type loopReader struct {
+	b   []byte
+	off int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.b[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.b)
+	}
+	return n, nil
+}
+
+var benchRequest = []byte("*3\r\n$3\r\nSET\r\n$16\r\nkey:000000000001\r\n$32\r\nxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx\r\n")
+
+func BenchmarkDecoderReadCommand(b *testing.B) {
+	dec := NewDecoder(&loopReader{b: benchRequest})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchRequest)))
+	for i := 0; i < b.N; i++ {
+		if _, err := dec.ReadCommand(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseStream measures the channel based API for comparison.
+func BenchmarkParseStream(b *testing.B) {
+	ch := ParseStream(&loopReader{b: benchRequest})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchRequest)))
+	for i := 0; i < b.N; i++ {
+		if r := <-ch; r.Err != nil {
+			b.Fatal(r.Err)
+		}
+	}
+}
+
+var benchReply = NewArray([]RedisData{
+	NewBulkString([]byte("value:000000000001")),
+	NewInteger(42),
+	NewBulkString(nil),
+	NewSimpleString("OK"),
+})
+
+func BenchmarkAppendTo(b *testing.B) {
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = benchReply.AppendTo(buf[:0])
+	}
+}
+
+func BenchmarkToRedisFormat(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = benchReply.ToRedisFormat()
+	}
 }
@@ -0,0 +1,465 @@
+//go:build linux
+
+package server
+
+import (
+	"errors"
+	"gRedis/logger"
+	"gRedis/memdb"
+	"gRedis/resp"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	epollReadBufferSize = 64 * 1024 // read buffer shared by all connections of a loop
+	epollMaxEvents      = 256
+)
+
+const epollSupported = true
+
+// epollConn is a connection served by an event loop.
+// An idle connection holds no buffer, only its parser.
+type epollConn struct {
+	fd      int
+	addr    string
+	parser  *resp.CommandParser
+	in      []byte // an incomplete request, waiting for more input, or the requests after a blocked command
+	out     []byte // replies the socket couldn't take yet
+	watched uint32 // the events the loop waits for
+
+	// a blocked command waits in its own goroutine, the loop goes on with other connections
+	blocked bool
+	cancel  chan struct{} // closed when the connection closes while blocked
+}
+
+// events returns the events the loop should wait for: the socket taking queued replies,
+// else requests, unless a blocked command holds them back; hang-ups are watched anyway.
+func (c *epollConn) events() uint32 {
+	switch {
+	case len(c.out) > 0:
+		return syscall.EPOLLOUT
+	case c.blocked:
+		return syscall.EPOLLRDHUP
+	}
+	return syscall.EPOLLIN | syscall.EPOLLRDHUP
+}
+
+// wokenConn is a connection whose blocked command is over, with its reply.
+type wokenConn struct {
+	c     *epollConn
+	reply resp.RedisData
+}
+
+// eventLoop reads, parses and executes the requests of many connections in one goroutine.
+type eventLoop struct {
+	mgr      *Manager
+	epfd     int
+	wakeR    int // a byte written to wakeW wakes the loop, to stop if stopping, else to resume woken connections
+	wakeW    int
+	stopping atomic.Bool
+	conns    map[int]*epollConn // only accessed by the loop goroutine
+	buf      []byte
+	out      []byte
+
+	mu       sync.Mutex
+	pending  []*epollConn // accepted, not yet known by the loop goroutine
+	woken    []wokenConn  // blocked commands over, not yet replied
+	released bool         // the pipe is closed
+}
+
+// serveEpoll accepts connections and spreads them over event loops, until the listener is closed.
+func serveEpoll(listener net.Listener, mgr *Manager, loopNum int) error {
+	if loopNum <= 0 {
+		loopNum = runtime.NumCPU()
+	}
+
+	loops := make([]*eventLoop, 0, loopNum)
+	defer func() {
+		for _, l := range loops {
+			l.stop()
+		}
+	}()
+	for i := 0; i < loopNum; i++ {
+		l, err := newEventLoop(mgr)
+		if err != nil {
+			return err
+		}
+		loops = append(loops, l)
+		go l.run()
+	}
+	logger.Info("Serve connections with ", loopNum, " epoll event loops")
+
+	for next := 0; ; next++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logger.Error(err)
+			continue
+		}
+		logger.Info(conn.RemoteAddr().String(), " connected")
+
+		c, err := detach(conn)
+		if err != nil {
+			logger.Error("Connection: ", conn.RemoteAddr().String(), ", Error: ", err)
+			continue
+		}
+		c.parser = resp.NewCommandParser(mgr.limits)
+		if err := loops[next%loopNum].add(c); err != nil {
+			logger.Error("Connection: ", c.addr, ", Error: ", err)
+			syscall.Close(c.fd)
+		}
+	}
+}
+
+// detach takes the socket out of the Go runtime poller, so an event loop owns it.
+func detach(conn net.Conn) (*epollConn, error) {
+	defer conn.Close()
+
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("connection has no file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fd := -1
+	var dupErr error
+	err = raw.Control(func(s uintptr) {
+		fd, dupErr = syscall.Dup(int(s))
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &epollConn{fd: fd, addr: conn.RemoteAddr().String()}, nil
+}
+
+func newEventLoop(mgr *Manager) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	l := &eventLoop{
+		mgr:   mgr,
+		epfd:  epfd,
+		wakeR: p[0],
+		wakeW: p[1],
+		conns: make(map[int]*epollConn),
+		buf:   make([]byte, epollReadBufferSize),
+	}
+	if err := l.ctl(syscall.EPOLL_CTL_ADD, l.wakeR, syscall.EPOLLIN); err != nil {
+		l.release()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *eventLoop) ctl(op int, fd int, events uint32) error {
+	return syscall.EpollCtl(l.epfd, op, fd, &syscall.EpollEvent{Events: events, Fd: int32(fd)})
+}
+
+// add hands a connection to the loop; it is called from the accept goroutine.
+func (l *eventLoop) add(c *epollConn) error {
+	c.watched = c.events()
+	events := c.watched
+	l.mu.Lock()
+	l.pending = append(l.pending, c)
+	l.mu.Unlock()
+	// the loop looks at pending when it sees an unknown fd
+	return l.ctl(syscall.EPOLL_CTL_ADD, c.fd, events)
+}
+
+func (l *eventLoop) stop() {
+	l.stopping.Store(true)
+	syscall.Write(l.wakeW, []byte{0})
+}
+
+func (l *eventLoop) release() {
+	l.mu.Lock()
+	l.released = true
+	syscall.Close(l.wakeR)
+	syscall.Close(l.wakeW)
+	l.mu.Unlock()
+	syscall.Close(l.epfd)
+}
+
+func (l *eventLoop) run() {
+	defer func() {
+		l.takePending()
+		for _, c := range l.conns {
+			l.close(c)
+		}
+		l.release()
+	}()
+
+	events := make([]syscall.EpollEvent, epollMaxEvents)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			logger.Error("epoll wait error: ", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wakeR {
+				if l.stopping.Load() {
+					return
+				}
+				l.resume()
+				continue
+			}
+			c, ok := l.conns[fd]
+			if !ok {
+				l.takePending()
+				if c, ok = l.conns[fd]; !ok {
+					continue
+				}
+			}
+
+			ev := events[i].Events
+			if ev&syscall.EPOLLOUT != 0 {
+				if !l.flush(c) {
+					continue
+				}
+			}
+			if ev&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				l.read(c)
+			}
+		}
+	}
+}
+
+func (l *eventLoop) takePending() {
+	l.mu.Lock()
+	for _, c := range l.pending {
+		l.conns[c.fd] = c
+	}
+	l.pending = l.pending[:0]
+	l.mu.Unlock()
+}
+
+// read executes the complete requests received, then writes all replies at once.
+func (l *eventLoop) read(c *epollConn) {
+	if c.blocked {
+		// only hang-ups are watched while blocked
+		logger.Info("Close connection: ", c.addr)
+		l.close(c)
+		return
+	}
+	n, err := syscall.Read(c.fd, l.buf)
+	for err == syscall.EINTR {
+		n, err = syscall.Read(c.fd, l.buf)
+	}
+	if err == syscall.EAGAIN {
+		return
+	}
+	if err != nil || n == 0 {
+		if err != nil && err != syscall.ECONNRESET {
+			logger.Error("Connection: ", c.addr, ", Error: ", err)
+		} else {
+			logger.Info("Close connection: ", c.addr)
+		}
+		l.close(c)
+		return
+	}
+
+	data := l.buf[:n]
+	if len(c.in) > 0 {
+		c.in = append(c.in, data...)
+		data = c.in
+	}
+	l.process(c, data, l.out[:0])
+}
+
+// process executes the complete requests of data, then writes all replies at once, after those already in out.
+// It stops after a blocked command, keeping the requests following it for when the command is over.
+func (l *eventLoop) process(c *epollConn, data, out []byte) {
+	var protoErr error
+	for len(data) > 0 {
+		cmd, used, err := c.parser.Parse(data)
+		if err != nil {
+			protoErr = err
+			// tell the client why, then close since the stream can't be resynchronized
+			out = resp.NewErrorReply(resp.ErrPrefix, err.Error()).AppendTo(out)
+			break
+		}
+		if used == 0 {
+			break
+		}
+		data = data[used:]
+		if len(cmd) == 0 {
+			continue
+		}
+
+		redisData := l.mgr.ExecCommand(cmd)
+		if redisData == nil {
+			redisData = resp.NewErrorReply(resp.ErrPrefix, "unknown error")
+		}
+		if blocked, ok := redisData.(*memdb.BlockedReply); ok {
+			l.block(c, blocked)
+			break
+		}
+		out = redisData.AppendTo(out)
+	}
+	l.out = out
+
+	// keep the incomplete request; the parser continues where it stopped
+	if len(data) == 0 || protoErr != nil {
+		c.in = nil
+	} else if len(c.in) > 0 {
+		c.in = c.in[:copy(c.in, data)]
+	} else {
+		c.in = append([]byte(nil), data...)
+	}
+
+	if !l.write(c, out) {
+		return
+	}
+	if protoErr != nil {
+		logger.Warning("Close connection: ", c.addr, ", ", protoErr.Error())
+		l.close(c)
+		return
+	}
+	l.watch(c)
+}
+
+// block waits for a blocked command in another goroutine, which hands its reply back to the loop.
+func (l *eventLoop) block(c *epollConn, blocked *memdb.BlockedReply) {
+	c.blocked = true
+	c.cancel = make(chan struct{})
+	cancel := c.cancel
+	go func() {
+		reply := blocked.Wait(l.mgr.exec, cancel)
+		l.mu.Lock()
+		if !l.released {
+			l.woken = append(l.woken, wokenConn{c, reply})
+			syscall.Write(l.wakeW, []byte{1})
+		}
+		l.mu.Unlock()
+	}()
+}
+
+// resume replies to the connections whose blocked command is over, and executes the requests they held back.
+func (l *eventLoop) resume() {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(l.wakeR, buf[:]); n < len(buf) {
+			break
+		}
+	}
+	l.mu.Lock()
+	woken := l.woken
+	l.woken = nil
+	l.mu.Unlock()
+
+	for _, w := range woken {
+		c := w.c
+		if l.conns[c.fd] != c {
+			// closed while blocked
+			continue
+		}
+		c.blocked, c.cancel = false, nil
+		l.process(c, c.in, w.reply.AppendTo(l.out[:0]))
+	}
+}
+
+// write sends replies, queueing what the socket can't take. It returns false if c was closed.
+func (l *eventLoop) write(c *epollConn, out []byte) bool {
+	if len(out) == 0 {
+		return true
+	}
+	// keep the order behind replies already queued
+	if len(c.out) > 0 {
+		c.out = append(c.out, out...)
+		return true
+	}
+	n, err := syscall.Write(c.fd, out)
+	for err == syscall.EINTR {
+		n, err = syscall.Write(c.fd, out)
+	}
+	if err != nil && err != syscall.EAGAIN {
+		logger.Error("write response to ", c.addr, " error: ", err.Error())
+		l.close(c)
+		return false
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n < len(out) {
+		c.out = append(c.out, out[n:]...)
+		// stop reading until the client takes its replies
+		return l.watch(c)
+	}
+	return true
+}
+
+// flush sends queued replies. It returns false if c was closed.
+func (l *eventLoop) flush(c *epollConn) bool {
+	n, err := syscall.Write(c.fd, c.out)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return true
+	}
+	if err != nil {
+		logger.Error("write response to ", c.addr, " error: ", err.Error())
+		l.close(c)
+		return false
+	}
+	if n < len(c.out) {
+		c.out = c.out[:copy(c.out, c.out[n:])]
+		return true
+	}
+
+	c.out = nil
+	return l.watch(c)
+}
+
+// watch makes the loop wait for the events c needs, if they changed. It returns false if c was closed.
+func (l *eventLoop) watch(c *epollConn) bool {
+	ev := c.events()
+	if ev == c.watched {
+		return true
+	}
+	if err := l.ctl(syscall.EPOLL_CTL_MOD, c.fd, ev); err != nil {
+		logger.Error("Connection: ", c.addr, ", Error: ", err)
+		l.close(c)
+		return false
+	}
+	c.watched = ev
+	return true
+}
+
+func (l *eventLoop) close(c *epollConn) {
+	if c.cancel != nil {
+		close(c.cancel)
+		c.cancel = nil
+	}
+	delete(l.conns, c.fd)
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	if err := syscall.Close(c.fd); err != nil {
+		logger.Error(err)
+	}
+}
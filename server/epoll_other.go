@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+const epollSupported = false
+
+func serveEpoll(listener net.Listener, mgr *Manager, loopNum int) error {
+	return errors.New("epoll io-model is only supported on linux")
+}
@@ -1,120 +1,227 @@
 package server
 
 import (
-	"fmt"
+	"bufio"
+	"errors"
 	"gRedis/config"
 	"gRedis/logger"
 	"gRedis/memdb"
 	"gRedis/resp"
 	"io"
 	"net"
+	"os"
+	"runtime"
 	"strconv"
-	"strings"
+	"time"
 )
 
+// replyBufferSize is the size of the per-connection reply buffer.
+// Replies are flushed when the client has no more pipelined commands in flight,
+// or when the buffer fills up, whichever comes first.
+const replyBufferSize = 16 * 1024
+
 type Manager struct {
-	db  *memdb.MemDb
-	dbs []*memdb.MemDb
+	db     *memdb.MemDb
+	dbs    []*memdb.MemDb
+	shards *memdb.ShardSet // nil with the locks exec-model
+	limits resp.ProtoLimits
 }
 
-func NewManager(config *config.Config) *Manager {
-	dbs := make([]*memdb.MemDb, config.DbNum)
-	for i := 0; i < len(dbs); i++ {
-		dbs[i] = memdb.NewMemDb()
+func NewManager(cfg *config.Config) *Manager {
+	var shards *memdb.ShardSet
+	if cfg.ExecModel == config.ExecModelShards {
+		n := cfg.Shards
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		// all databases share the shard goroutines
+		shards = memdb.NewShardSet(n)
+		logger.Info("Execute commands on ", n, " shards")
 	}
+
+	dbs := memdb.NewMemDbs(cfg.DbNum, shards)
 	return &Manager{
-		db:  dbs[0],
-		dbs: dbs,
+		db:     dbs[0],
+		dbs:    dbs,
+		shards: shards,
+		limits: resp.ProtoLimits{
+			MaxBulkLen:       cfg.ProtoMaxBulkLen,
+			MaxMultiBulkLen:  cfg.MaxMultiBulkLen,
+			QueryBufferLimit: cfg.ClientQueryBufferLimit,
+		},
 	}
 }
 
 func (m *Manager) Handle(conn net.Conn) {
+	client := &clientConn{Conn: conn}
 	// parse conn
-	ch := resp.ParseStream(conn)
+	dec := resp.NewDecoderWithLimits(client, m.limits)
+	writer := bufio.NewWriterSize(conn, replyBufferSize)
 
-	// close connection
+	// flush pending replies and close connection
 	defer func() {
+		if err := writer.Flush(); err != nil {
+			logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+		}
 		err := conn.Close()
 		if err != nil {
 			logger.Error(err)
 		}
 	}()
 
-	// read from client and pump redis to ch
-	for redisResp := range ch {
+	// pull commands from client
+	for {
+		cmd, ok, err := dec.NextCommand()
+		if err == nil && !ok {
+			// no complete command buffered, so every command received so far has been answered
+			if err := writer.Flush(); err != nil {
+				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+				return
+			}
+			cmd, err = dec.ReadCommand()
+		}
+
 		// hanle errs
-		if redisResp.Err != nil {
-			if redisResp.Err != io.EOF {
-				logger.Panic("Connection: ", conn.RemoteAddr().String(), ", Panic: ", redisResp.Err)
+		if err != nil {
+			var protoErr *resp.ProtocolError
+			if errors.As(err, &protoErr) {
+				// tell the client why, then close since the stream can't be resynchronized
+				errData := resp.NewErrorReply(resp.ErrPrefix, protoErr.Error())
+				if _, err := writer.Write(errData.ToRedisFormat()); err != nil {
+					logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+				}
+				logger.Warning("Close connection: ", conn.RemoteAddr().String(), ", ", protoErr.Error())
+			} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+				logger.Error("Connection: ", conn.RemoteAddr().String(), ", Error: ", err)
 			} else {
 				logger.Info("Close connection: ", conn.RemoteAddr().String())
 			}
 			return
 		}
 
-		// get empty response
-		if redisResp.Data == nil {
-			logger.Error("Get empty data from: ", conn.RemoteAddr().String())
-			continue
+		// excute parsed command
+		redisData := m.ExecCommand(cmd)
+		if redisData == nil {
+			redisData = resp.NewErrorReply(resp.ErrPrefix, "unknown error")
+		}
+		if blocked, ok := redisData.(*memdb.BlockedReply); ok {
+			// the client may be waiting for the replies before this one
+			if err := writer.Flush(); err != nil {
+				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+				return
+			}
+			// a client closing while blocked cancels the wait, so it leaves no registration and consumes no entry
+			closed, stop := client.watchClose(m.limits.QueryBufferLimit)
+			redisData = blocked.Wait(m.exec, closed)
+			stop()
 		}
 
-		// get parsed data
-		arrayData, ok := redisResp.Data.(*resp.RedisArray)
-		if !ok {
-			logger.Error("Data from connection: ", conn.RemoteAddr().String(), "is not a valid array")
-			continue
+		// encode the result straight into the writer's buffer;
+		// bufio.Writer flushes by itself once replyBufferSize is reached
+		if _, err := writer.Write(redisData.AppendTo(writer.AvailableBuffer())); err != nil {
+			logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+			return
 		}
+	}
+}
 
-		// excute parsed command
-		cmd := arrayData.ToCommand()
-		redisData := m.ExecCommand(cmd)
+// clientConn is a client connection whose reads return first what watchClose read while the client was blocked.
+type clientConn struct {
+	net.Conn
+	pending []byte
+}
 
-		// write result to connection
-		if redisData != nil {
-			_, err := conn.Write(redisData.ToRedisFormat())
-			if err != nil {
-				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
-			}
-		} else {
-			errData := resp.NewSimpleError("unknown error")
-			_, err := conn.Write(errData.ToRedisFormat())
+func (c *clientConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// watchClose reads the connection while its client is blocked, up to limit bytes if positive,
+// and closes closed if the client goes away. stop ends the watch; what was read is then read first.
+func (c *clientConn) watchClose(limit int64) (closed <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for limit <= 0 || int64(len(c.pending)) < limit {
+			n, err := c.Conn.Read(buf)
+			c.pending = append(c.pending, buf[:n]...)
 			if err != nil {
-				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					close(ch)
+				}
+				return
 			}
 		}
+	}()
+	return ch, func() {
+		// a past deadline interrupts the read
+		c.Conn.SetReadDeadline(time.Now())
+		<-done
+		c.Conn.SetReadDeadline(time.Time{})
 	}
 }
 
 func (m *Manager) ExecCommand(cmd [][]byte) resp.RedisData {
-	cmdName := strings.ToLower(string((cmd[0])))
-	command, ok := memdb.CmdTable[cmdName]
+	return m.exec(m.db, cmd)
+}
+
+// exec runs a command on db, the selected database or the one a blocked command waits in.
+func (m *Manager) exec(db *memdb.MemDb, cmd [][]byte) resp.RedisData {
+	// lower-case the name on the stack; map lookups with string(bytes) don't allocate
+	var nameBuf [32]byte
+	cmdName := appendLower(nameBuf[:0], cmd[0])
+	command, ok := memdb.CmdTable[string(cmdName)]
 
-	if cmdName == "select" {
+	if string(cmdName) == "select" {
 		return m.Select(cmd)
 	}
 
 	if ok {
-		return command.Executor(m.db, cmd)
+		command, errReply := command.Subcommand(cmd)
+		if errReply != nil {
+			return errReply
+		}
+		if m.shards != nil {
+			return m.shards.Exec(db, command, cmd)
+		}
+		return command.Executor(db, cmd)
 	} else {
-		return resp.NewSimpleError(fmt.Sprintf("unknown command '%s'", string((cmd[0]))))
+		return resp.NewUnknownCommandError(cmd)
 	}
 }
 
 func (m *Manager) Select(cmd [][]byte) resp.RedisData {
 	if len(cmd) != 2 {
-		return resp.NewSimpleError("wrong number of arguments for command")
+		return resp.NewWrongArgsError(cmd[0])
 	}
 
 	dbIdx, err := strconv.Atoi(string(cmd[1]))
 	if err != nil {
-		return resp.NewSimpleError("value is not an integer")
+		return resp.NewNotIntegerError()
 	}
 
 	if dbIdx >= len(m.dbs) || dbIdx < 0 {
-		return resp.NewSimpleString(fmt.Sprintf("ERR DB index is out of range with maximum %d", len(m.dbs)))
+		return resp.NewErrorReply(resp.ErrPrefix, "DB index is out of range")
 	}
 
 	m.db = m.dbs[dbIdx]
 
 	return resp.NewSimpleString("OK")
 }
+
+// appendLower appends the ASCII lower-case of name to dst.
+func appendLower(dst, name []byte) []byte {
+	for _, c := range name {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
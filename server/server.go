@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"gRedis/config"
 	"gRedis/logger"
 	"net"
@@ -13,8 +14,8 @@ import (
 )
 
 // start a redis server
-func Start(config *config.Config) error {
-	listener, err := net.Listen("tcp", config.Host+":"+strconv.Itoa(config.Port))
+func Start(cfg *config.Config) error {
+	listener, err := net.Listen("tcp", cfg.Host+":"+strconv.Itoa(cfg.Port))
 	if err != nil {
 		logger.Panic(err)
 		return err
@@ -33,39 +34,50 @@ func Start(config *config.Config) error {
 		}
 	}()
 
-	logger.Info("Server Listen at ", config.Host, ":", config.Port)
+	logger.Info("Server Listen at ", cfg.Host, ":", cfg.Port)
 
 	// handle signal termination
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	// client chan
-	clients := make(chan net.Conn)
-
 	// create a resource manager
-	mgr := NewManager(config)
+	mgr := NewManager(cfg)
 
-	// start a goroutine to accept client connection
+	// serve clients until the listener is closed
+	errs := make(chan error, 1)
 	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				logger.Error(err)
-				continue
-			}
-			clients <- conn
+		if cfg.IoModel == config.IoModelEpoll {
+			errs <- serveEpoll(listener, mgr, cfg.EventLoops)
+		} else {
+			errs <- serveGoroutines(listener, mgr)
 		}
 	}()
 
+	select {
+	case err := <-errs:
+		if err != nil {
+			logger.Error(err)
+		}
+		return err
+	// exit server
+	case <-osSignals:
+		return nil
+	}
+}
+
+// serveGoroutines starts a goroutine per client connection, until the listener is closed.
+func serveGoroutines(listener net.Listener, mgr *Manager) error {
 	for {
-		select {
-		// start a go routine to handle client request
-		case conn := <-clients:
-			logger.Info(conn.RemoteAddr().String(), " connected")
-			go mgr.Handle(conn)
-		// exit server
-		case <-osSignals:
-			return nil
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logger.Error(err)
+			continue
 		}
+		// start a go routine to handle client request
+		logger.Info(conn.RemoteAddr().String(), " connected")
+		go mgr.Handle(conn)
 	}
 }
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"gRedis/config"
+	"gRedis/logger"
+	"gRedis/memdb"
+	"gRedis/resp"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	ioModels   = []string{config.IoModelGoroutine}
+	execModels = []string{config.ExecModelLocks, config.ExecModelShards}
+)
+
+func init() {
+	config.Conf = &config.Config{
+		SegNum: 100, LogDir: os.TempDir(), LogLevel: "error",
+		HashMaxListpackEntries: 128, HashMaxListpackValue: 64,
+		SetMaxIntsetEntries: 512, SetMaxListpackEntries: 128, SetMaxListpackValue: 64,
+	}
+	if err := logger.Init(config.Conf); err != nil {
+		panic(err)
+	}
+	memdb.RegisterKeyCommands()
+	memdb.RegisterStringCommands()
+	memdb.RegisterHashCommands()
+	memdb.RegisterListCommands()
+	memdb.RegisterSetCommands()
+	memdb.RegisterZSetCommands()
+	memdb.RegisterHyperLogLogCommands()
+	memdb.RegisterGeoCommands()
+	memdb.RegisterStreamCommands()
+	memdb.RegisterMemoryCommands()
+	memdb.RegisterServerCommands()
+
+	if epollSupported {
+		ioModels = append(ioModels, config.IoModelEpoll)
+	}
+}
+
+// startServer serves a fresh database on a random port until the test ends.
+func startServer(tb testing.TB, ioModel string, execModel string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	mgr := NewManager(&config.Config{DbNum: 16, ExecModel: execModel, Shards: 4})
+	go func() {
+		if ioModel == config.IoModelEpoll {
+			serveEpoll(listener, mgr, 2)
+		} else {
+			serveGoroutines(listener, mgr)
+		}
+	}()
+	tb.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+// models returns every io-model and exec-model pair.
+func models() [][2]string {
+	var pairs [][2]string
+	for _, ioModel := range ioModels {
+		for _, execModel := range execModels {
+			pairs = append(pairs, [2]string{ioModel, execModel})
+		}
+	}
+	return pairs
+}
+
+func readReplies(tb testing.TB, dec *resp.Decoder, n int) []string {
+	replies := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := dec.ReadValue()
+		if err != nil {
+			tb.Fatal(fmt.Sprintf("reply %d error: %v", i, err))
+		}
+		replies = append(replies, string(data.ToRedisFormat()))
+	}
+	return replies
+}
+
+func TestServe(t *testing.T) {
+	for _, model := range models() {
+		ioModel, execModel := model[0], model[1]
+		t.Run(ioModel+"/"+execModel, func(t *testing.T) {
+			conn, err := net.Dial("tcp", startServer(t, ioModel, execModel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			dec := resp.NewDecoder(conn)
+
+			// pipelined commands are answered in order
+			conn.Write([]byte("SET a 1\r\n" + strings.Repeat("INCR a\r\n", 1000) + "GET a\r\n"))
+			replies := readReplies(t, dec, 1002)
+			if replies[1000] != ":1001\r\n" || replies[1001] != "$4\r\n1001\r\n" {
+				t.Error(fmt.Sprintf("pipeline replies: %q", replies[999:]))
+			}
+
+			// a request split across writes waits for the rest
+			conn.Write([]byte("*3\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r"))
+			time.Sleep(10 * time.Millisecond)
+			conn.Write([]byte("\nx\r\n"))
+			if r := readReplies(t, dec, 1)[0]; r != ":1\r\n" {
+				t.Error(fmt.Sprintf("split request reply: %q", r))
+			}
+
+			// values larger than any read buffer
+			big := bytes.Repeat([]byte("v"), 300*1024)
+			conn.Write([]byte(fmt.Sprintf("*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$%d\r\n%s\r\nSTRLEN b\r\n", len(big), big)))
+			if r := readReplies(t, dec, 2); r[1] != fmt.Sprintf(":%d\r\n", len(big)) {
+				t.Error(fmt.Sprintf("big value replies: %q", r))
+			}
+			conn.Write([]byte("GET b\r\n"))
+			if r := readReplies(t, dec, 1)[0]; r != fmt.Sprintf("$%d\r\n%s\r\n", len(big), big) {
+				t.Error(fmt.Sprintf("big value reply: %.32q", r))
+			}
+
+			// multi-key commands, spanning shards with the shards exec-model
+			conn.Write([]byte("MSET k1 1 k2 2 k3 3 k4 4\r\nRENAME k1 k9\r\nMGET k9 k2 k3 k4\r\nKEYS k*\r\n"))
+			if r := readReplies(t, dec, 4); r[2] != "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n" || !strings.HasPrefix(r[3], "*4\r\n") {
+				t.Error(fmt.Sprintf("multi-key replies: %q", r))
+			}
+
+			// FLUSHALL pauses every shard; the flushed memory is freed in the background
+			conn.Write([]byte("FLUSHALL ASYNC\r\nKEYS *\r\nUNLINK a\r\n"))
+			if r := readReplies(t, dec, 3); r[0] != "+OK\r\n" || r[1] != "*0\r\n" || r[2] != ":0\r\n" {
+				t.Error(fmt.Sprintf("flush replies: %q", r))
+			}
+
+			// MOVE and SWAPDB work across the databases
+			conn.Write([]byte("SET m 1\r\nMOVE m 1\r\nSWAPDB 0 1\r\nGET m\r\nDBSIZE\r\nRANDOMKEY\r\n"))
+			if r := readReplies(t, dec, 6); strings.Join(r[1:], "") != ":1\r\n+OK\r\n$1\r\n1\r\n:1\r\n$1\r\nm\r\n" {
+				t.Error(fmt.Sprintf("cross-database replies: %q", r))
+			}
+
+			// a protocol error is reported, then the connection is closed
+			conn.Write([]byte("PING\r\n*1\r\n$x\r\n"))
+			if r := readReplies(t, dec, 2); r[1] != "-ERR Protocol error: invalid bulk length\r\n" {
+				t.Error(fmt.Sprintf("protocol error replies: %q", r))
+			}
+			if _, err := dec.ReadValue(); err != io.EOF {
+				t.Error(fmt.Sprintf("expect closed connection, got %v", err))
+			}
+		})
+	}
+}
+
+// TestMigrate moves keys between two servers, over the connection MIGRATE caches.
+func TestMigrate(t *testing.T) {
+	for _, model := range models() {
+		ioModel, execModel := model[0], model[1]
+		t.Run(ioModel+"/"+execModel, func(t *testing.T) {
+			target := startServer(t, ioModel, execModel)
+			host, port, _ := net.SplitHostPort(target)
+			src, err := net.Dial("tcp", startServer(t, ioModel, execModel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			dst, err := net.Dial("tcp", target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+			src.SetDeadline(time.Now().Add(10 * time.Second))
+			dst.SetDeadline(time.Now().Add(10 * time.Second))
+			srcDec, dstDec := resp.NewDecoder(src), resp.NewDecoder(dst)
+			migrate := fmt.Sprintf("MIGRATE %s %s", host, port)
+
+			src.Write([]byte("SET a 1\r\nEXPIRE a 100\r\nRPUSH l x y\r\nSADD s 1 2\r\nHSET h f v\r\n" +
+				migrate + " a 0 1000\r\nEXISTS a\r\n" +
+				migrate + " \"\" 0 1000 KEYS l s h nosuchkey\r\nDBSIZE\r\n"))
+			if r := readReplies(t, srcDec, 9); strings.Join(r[5:], "") != "+OK\r\n:0\r\n+OK\r\n:0\r\n" {
+				t.Error(fmt.Sprintf("MIGRATE replies: %q", r))
+			}
+			dst.Write([]byte("GET a\r\nTTL a\r\nLRANGE l 0 -1\r\nSCARD s\r\nHGET h f\r\n"))
+			if r := readReplies(t, dstDec, 5); strings.Join(r, "") != "$1\r\n1\r\n:100\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n:2\r\n$1\r\nv\r\n" {
+				t.Error(fmt.Sprintf("migrated keys: %q", r))
+			}
+
+			// a refused key stays on the source and is reported; COPY keeps the key; REPLACE overwrites
+			src.Write([]byte("SET a 2\r\nSET b 2\r\n" + migrate + " \"\" 0 1000 KEYS a b\r\nEXISTS a b\r\n" +
+				migrate + " a 0 1000 COPY REPLACE\r\nGET a\r\n" + migrate + " nosuchkey 0 1000\r\n"))
+			r := readReplies(t, srcDec, 7)
+			if r[2] != "-ERR Target instance replied with error: 'a': BUSYKEY Target key name already exists.\r\n" || r[3] != ":1\r\n" {
+				t.Error(fmt.Sprintf("MIGRATE onto an existing key: %q", r[2:4]))
+			}
+			if strings.Join(r[4:], "") != "+OK\r\n$1\r\n2\r\n+NOKEY\r\n" {
+				t.Error(fmt.Sprintf("MIGRATE COPY REPLACE replies: %q", r[4:]))
+			}
+			dst.Write([]byte("GET a\r\nGET b\r\n"))
+			if r := readReplies(t, dstDec, 2); strings.Join(r, "") != "$1\r\n2\r\n$1\r\n2\r\n" {
+				t.Error(fmt.Sprintf("keys on the target: %q", r))
+			}
+
+			src.Write([]byte("SET c 1\r\nMIGRATE 127.0.0.1 1 c 0 100\r\n"))
+			if r := readReplies(t, srcDec, 2)[1]; !strings.HasPrefix(r, "-IOERR") {
+				t.Error(fmt.Sprintf("MIGRATE to a closed port: %q", r))
+			}
+		})
+	}
+}
+
+// TestBlockingRead wakes XREAD BLOCK and XREADGROUP BLOCK clients with XADD from another client.
+func TestBlockingRead(t *testing.T) {
+	for _, model := range models() {
+		ioModel, execModel := model[0], model[1]
+		t.Run(ioModel+"/"+execModel, func(t *testing.T) {
+			addr := startServer(t, ioModel, execModel)
+			var conns [3]net.Conn
+			for i := range conns {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				conns[i] = conn
+			}
+			reader, writer := conns[0], conns[1]
+			readerDec, writerDec := resp.NewDecoder(reader), resp.NewDecoder(writer)
+
+			// the requests after a blocked command wait for it, even those sent while it's blocked
+			reader.Write([]byte("XREAD BLOCK 0 STREAMS s $\r\nPI"))
+			time.Sleep(20 * time.Millisecond)
+			reader.Write([]byte("NG\r\nPING\r\n"))
+			time.Sleep(20 * time.Millisecond)
+			writer.Write([]byte("PING\r\nXADD s 1-1 f v\r\n"))
+			if r := readReplies(t, writerDec, 2); strings.Join(r, "") != "+PONG\r\n$3\r\n1-1\r\n" {
+				t.Error(fmt.Sprintf("replies while a client is blocked: %q", r))
+			}
+			if r := readReplies(t, readerDec, 3); strings.Join(r, "") != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-1\r\n"+
+				"*2\r\n$1\r\nf\r\n$1\r\nv\r\n+PONG\r\n+PONG\r\n" {
+				t.Error(fmt.Sprintf("XREAD BLOCK replies: %q", r))
+			}
+
+			// a timeout replies nil
+			reader.Write([]byte("XREAD BLOCK 50 STREAMS s $\r\n"))
+			if r := readReplies(t, readerDec, 1)[0]; r != "*-1\r\n" {
+				t.Error(fmt.Sprintf("XREAD BLOCK timeout reply: %q", r))
+			}
+
+			// a client closing while blocked is forgotten
+			conns[2].Write([]byte("XREAD BLOCK 0 STREAMS s $\r\n"))
+			time.Sleep(20 * time.Millisecond)
+			conns[2].Close()
+
+			writer.Write([]byte("XGROUP CREATE s g $\r\n"))
+			readReplies(t, writerDec, 1)
+			reader.Write([]byte("XREADGROUP GROUP g c BLOCK 0 STREAMS s >\r\n"))
+			time.Sleep(20 * time.Millisecond)
+			writer.Write([]byte("XADD s 2-1 f w\r\n"))
+			readReplies(t, writerDec, 1)
+			if r := readReplies(t, readerDec, 1)[0]; r != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$1\r\nw\r\n" {
+				t.Error(fmt.Sprintf("XREADGROUP BLOCK reply: %q", r))
+			}
+			writer.Write([]byte("XPENDING s g - + 10 c\r\n"))
+			if r := readReplies(t, writerDec, 1)[0]; !strings.HasPrefix(r, "*1\r\n*4\r\n$3\r\n2-1\r\n$1\r\nc\r\n") {
+				t.Error(fmt.Sprintf("pending entries: %q", r))
+			}
+
+			// a consumer closing while blocked reads nothing more, so no entry is left pending for it
+			dead, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write([]byte("XGROUP CREATE s g2 $\r\n"))
+			readReplies(t, writerDec, 1)
+			dead.Write([]byte("XREADGROUP GROUP g2 d BLOCK 0 STREAMS s >\r\n"))
+			time.Sleep(20 * time.Millisecond)
+			dead.Close()
+			time.Sleep(20 * time.Millisecond)
+			writer.Write([]byte("XADD s 3-1 f x\r\n"))
+			readReplies(t, writerDec, 1)
+			// give a waiter left behind the time to run again
+			time.Sleep(20 * time.Millisecond)
+			writer.Write([]byte("XPENDING s g2\r\n"))
+			if r := readReplies(t, writerDec, 1)[0]; r != "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n" {
+				t.Error(fmt.Sprintf("pending entries of a closed consumer: %q", r))
+			}
+		})
+	}
+}
+
+// BenchmarkThroughput sends SET commands from 50 clients, 16 commands per round trip.
+func BenchmarkThroughput(b *testing.B) {
+	const pipeline = 16
+	cmd := []byte("*3\r\n$3\r\nSET\r\n$8\r\nkey:0001\r\n$8\r\nval:0001\r\n")
+	for _, model := range models() {
+		ioModel, execModel := model[0], model[1]
+		b.Run(ioModel+"/"+execModel, func(b *testing.B) {
+			addr := startServer(b, ioModel, execModel)
+			b.SetParallelism((50 + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer conn.Close()
+				dec := resp.NewDecoder(conn)
+				batch := make([]byte, 0, pipeline*len(cmd))
+				for more := true; more; {
+					batch, n := batch[:0], 0
+					for n < pipeline {
+						if more = pb.Next(); !more {
+							break
+						}
+						batch = append(batch, cmd...)
+						n++
+					}
+					if n == 0 {
+						break
+					}
+					conn.Write(batch)
+					for i := 0; i < n; i++ {
+						if _, err := dec.ReadValue(); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkIdleConnections reports the memory held per idle connection, client side included.
+func BenchmarkIdleConnections(b *testing.B) {
+	const connNum = 1000
+	for _, ioModel := range ioModels {
+		b.Run(ioModel, func(b *testing.B) {
+			addr := startServer(b, ioModel, config.ExecModelLocks)
+			var perConn float64
+			for i := 0; i < b.N; i++ {
+				before := memInUse()
+				conns := make([]net.Conn, 0, connNum)
+				for j := 0; j < connNum; j++ {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Fatal(err)
+					}
+					conns = append(conns, conn)
+				}
+				// every connection has been served once, then stays idle
+				for _, conn := range conns {
+					conn.Write([]byte("PING\r\n"))
+				}
+				buf := make([]byte, 7)
+				for _, conn := range conns {
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						b.Fatal(err)
+					}
+				}
+				perConn += float64(memInUse()-before) / connNum
+
+				for _, conn := range conns {
+					conn.Close()
+				}
+			}
+			b.ReportMetric(perConn/float64(b.N), "B/conn")
+		})
+	}
+}
+
+func memInUse() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse + m.StackInuse)
+}
@@ -0,0 +1,14 @@
+package util
+
+import "hash/crc64"
+
+// CRC64 is redis's crc64, the checksum of RDB files and DUMP payloads:
+// the Jones polynomial, reflected, with no initial or final inversion, unlike hash/crc64.
+var crc64Jones = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func CRC64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Jones[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
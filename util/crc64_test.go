@@ -0,0 +1,17 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	// the check value of redis's crc64.c
+	if crc := CRC64(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Error(fmt.Sprintf("CRC64(123456789) == %x", crc))
+	}
+	// incremental updates
+	if crc := CRC64(CRC64(0, []byte("1234")), []byte("56789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Error(fmt.Sprintf("CRC64 in two parts == %x", crc))
+	}
+}
@@ -0,0 +1,126 @@
+package util
+
+import "errors"
+
+// LZF is the compression redis uses for RDB strings and quicklist nodes.
+// The stream is a sequence of literal runs and back references:
+//
+//	000LLLLL <L+1 bytes>             literal run of 1 to 32 bytes
+//	LLLooooo oooooooo                back reference of L+2 bytes at distance o+1, 1 <= L < 7
+//	111ooooo LLLLLLLL oooooooo       back reference of L+9 bytes at distance o+1
+const (
+	lzfHashLog = 14
+	lzfMaxLit  = 1 << 5
+	lzfMaxOff  = 1 << 13
+	lzfMaxRef  = (1 << 8) + (1 << 3)
+)
+
+var ErrLZFCorrupt = errors.New("lzf: corrupt input")
+
+func lzfHash(p []byte) uint32 {
+	v := uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+	return (v * 2654435761) >> (32 - lzfHashLog)
+}
+
+// LZFCompress compresses in. The output may be larger than in, when in doesn't compress.
+func LZFCompress(in []byte) []byte {
+	out := make([]byte, 0, len(in)+len(in)/lzfMaxLit+1)
+	// positions + 1 of the last 3-byte sequences seen, 0 for none
+	htab := make([]int32, 1<<lzfHashLog)
+
+	lit := 0 // start of the literals not written yet
+	flushLiterals := func(end int) {
+		for lit < end {
+			n := end - lit
+			if n > lzfMaxLit {
+				n = lzfMaxLit
+			}
+			out = append(out, byte(n-1))
+			out = append(out, in[lit:lit+n]...)
+			lit += n
+		}
+	}
+
+	ip := 0
+	for ip+2 < len(in) {
+		h := lzfHash(in[ip:])
+		ref := int(htab[h]) - 1
+		htab[h] = int32(ip + 1)
+		off := ip - ref - 1
+		if ref < 0 || off >= lzfMaxOff || in[ref] != in[ip] || in[ref+1] != in[ip+1] || in[ref+2] != in[ip+2] {
+			ip++
+			continue
+		}
+
+		maxLen := len(in) - ip
+		if maxLen > lzfMaxRef {
+			maxLen = lzfMaxRef
+		}
+		n := 3
+		for n < maxLen && in[ref+n] == in[ip+n] {
+			n++
+		}
+
+		flushLiterals(ip)
+		if l := n - 2; l < 7 {
+			out = append(out, byte(off>>8)|byte(l<<5))
+		} else {
+			out = append(out, byte(off>>8)|7<<5, byte(l-7))
+		}
+		out = append(out, byte(off))
+
+		// remember the sequences inside the match too
+		for j := ip + 1; j < ip+n && j+2 < len(in); j++ {
+			htab[lzfHash(in[j:])] = int32(j + 1)
+		}
+		ip += n
+		lit = ip
+	}
+	flushLiterals(len(in))
+	return out
+}
+
+// LZFDecompress decompresses in, which expands to exactly outLen bytes.
+func LZFDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < lzfMaxLit {
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > outLen {
+				return nil, ErrLZFCorrupt
+			}
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if ip >= len(in) {
+				return nil, ErrLZFCorrupt
+			}
+			n += int(in[ip])
+			ip++
+		}
+		n += 2
+		if ip >= len(in) {
+			return nil, ErrLZFCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+		if ref < 0 || len(out)+n > outLen {
+			return nil, ErrLZFCorrupt
+		}
+		// the reference may overlap the bytes it produces
+		for i := 0; i < n; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, ErrLZFCorrupt
+	}
+	return out, nil
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestLZF(t *testing.T) {
+	// a literal, then a 9 byte reference at distance 1
+	if z := LZFCompress([]byte("aaaaaaaaaa")); !bytes.Equal(z, []byte{0x00, 'a', 0xe0, 0x00, 0x00}) {
+		t.Error(fmt.Sprintf("LZFCompress(aaaaaaaaaa) == %x", z))
+	}
+
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("abcabcabcabcabcabcabcabcabcabc"),
+		bytes.Repeat([]byte("x"), 100000),
+		bytes.Repeat([]byte("hello world, "), 1000),
+		random,
+	}
+	for _, in := range inputs {
+		z := LZFCompress(in)
+		out, err := LZFDecompress(z, len(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Error(fmt.Sprintf("round trip of %d bytes: %v", len(in), err))
+		}
+	}
+	if z := LZFCompress(inputs[4]); len(z) > len(inputs[4])/10 {
+		t.Error(fmt.Sprintf("repeated text compresses to %d bytes", len(z)))
+	}
+
+	if _, err := LZFDecompress([]byte{0x00, 'a', 0xe0, 0x00, 0x05}, 10); err != ErrLZFCorrupt {
+		t.Error("reference before the start should fail")
+	}
+	if _, err := LZFDecompress([]byte{0x05, 'a'}, 6); err != ErrLZFCorrupt {
+		t.Error("truncated literal run should fail")
+	}
+}
@@ -1,14 +1,23 @@
 package util
 
-import (
-	"hash/fnv"
+// FNV-1a, see hash/fnv
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
 )
 
+// Hash returns the 32-bit FNV-1a hash of key, computed inline so it doesn't allocate.
 func Hash(key string) int {
-	h := fnv.New32a()
-	h.Write([]byte(key))
+	return int(Hash32(key))
+}
 
-	return int(h.Sum32())
+func Hash32(key string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return h
 }
 
 /*
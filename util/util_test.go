@@ -1,6 +1,9 @@
 package util
 
-import "testing"
+import (
+	"hash/fnv"
+	"testing"
+)
 
 func TestPatternMatch(t *testing.T) {
 	p1, s1 := "", "a"
@@ -68,3 +71,16 @@ func TestPatternMatch(t *testing.T) {
 	}
 
 }
+
+func TestHash(t *testing.T) {
+	for _, key := range []string{"", "a", "foobar", "@key&"} {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		if Hash32(key) != h.Sum32() {
+			t.Errorf("Hash32(%q) == %d, expect %d", key, Hash32(key), h.Sum32())
+		}
+	}
+	if n := testing.AllocsPerRun(100, func() { Hash32("some:key") }); n != 0 {
+		t.Errorf("Hash32 allocates %v times", n)
+	}
+}